@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/rpcdriver"
+)
+
+func init() {
+	commands["drivers"] = command{
+		usage: "list the drivers, or print the commands accepted by the named ones",
+		run:   runDrivers,
+	}
+}
+
+var phases = []string{etlx.PhaseExtract, etlx.PhaseTransform, etlx.PhaseLoad}
+
+func runDrivers(args []string) error {
+	fs := flag.NewFlagSet("drivers", flag.ContinueOnError)
+	phase := fs.String("phase", "", "only the drivers of the phase, extract, transform or load")
+	format := fs.String("format", "text", "output format, text or json")
+	caps := fs.String("cap", "", "only the drivers with the comma separated capabilities, e.g. batch,streaming")
+	plugins := fs.String("plugins", "", "directory of the driver plugins to load")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: etlx drivers [flags] [name...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unsupported format %s", *format)
+	}
+
+	if *plugins != "" {
+		loaded, err := rpcdriver.LoadDir(*plugins, nil)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for _, p := range loaded {
+				p.Close()
+			}
+		}()
+	}
+
+	selected := phases
+	if *phase != "" {
+		selected = []string{*phase}
+	}
+
+	filter := []driver.Capability{}
+	for _, c := range strings.Split(*caps, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			filter = append(filter, driver.Capability(c))
+		}
+	}
+
+	if fs.NArg() == 0 {
+		entries := []etlx.DriverEntry{}
+		for _, p := range selected {
+			entries = append(entries, etlx.ListDrivers(p, filter...)...)
+		}
+		if *format == "json" {
+			b, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Phase, e.Name, joinCapabilities(e.Info.Capabilities), e.Info.Description)
+		}
+		return w.Flush()
+	}
+
+	for _, name := range fs.Args() {
+		found := false
+		for _, p := range selected {
+			if !contains(etlx.DriverNames(p), name) {
+				continue
+			}
+			found = true
+
+			schema, ok := etlx.CommandSchemaOf(p, name)
+			info, _ := etlx.DriverInfoOf(p, name)
+			if *format == "json" {
+				out := map[string]interface{}{"phase": p, "name": name, "info": info}
+				if ok {
+					out["schema"] = schema
+				}
+				b, err := json.MarshalIndent(out, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(b))
+				continue
+			}
+
+			fmt.Printf("%s driver %s", p, name)
+			if info.Version != "" {
+				fmt.Printf(" %s", info.Version)
+			}
+			fmt.Println()
+			if info.Description != "" {
+				fmt.Printf("  %s\n", info.Description)
+			}
+			if len(info.Capabilities) > 0 {
+				fmt.Printf("  capabilities: %s\n", joinCapabilities(info.Capabilities))
+			}
+			if len(info.Config) > 0 {
+				fmt.Printf("  data source:\n")
+				config := driver.CommandSchema{Commands: info.Config}
+				for _, line := range strings.Split(strings.TrimRight(config.Help(), "\n"), "\n") {
+					fmt.Printf("    %s\n", line)
+				}
+			}
+			if !ok {
+				fmt.Printf("  the driver does not describe its commands\n\n")
+				continue
+			}
+			for _, line := range strings.Split(strings.TrimRight(schema.Help(), "\n"), "\n") {
+				if line == "" {
+					fmt.Println()
+				} else {
+					fmt.Printf("  %s\n", line)
+				}
+			}
+			fmt.Println()
+		}
+		if !found {
+			return fmt.Errorf("driver %s is not found", name)
+		}
+	}
+	return nil
+}
+
+func joinCapabilities(caps []driver.Capability) string {
+	names := make([]string, len(caps))
+	for i, c := range caps {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ",")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/transform"
+)
+
+func init() {
+	commands["infer"] = command{
+		usage: "infer the schema of a source and print the mapper commands",
+		run:   runInfer,
+	}
+}
+
+func runInfer(args []string) error {
+	fs := flag.NewFlagSet("infer", flag.ContinueOnError)
+	src := &sourceFlags{}
+	src.register(fs)
+	sample := fs.Int("sample", 1000, "number of rows sampled, all rows if <= 0")
+	schemaOnly := fs.Bool("schema", false, "print the schema instead of the mapper commands")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rows, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	schema, err := driver.InferSchema(rows, *sample)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if *schemaOnly {
+		return enc.Encode(schema)
+	}
+	return enc.Encode(transform.MapperCommands(schema))
+}
@@ -0,0 +1,50 @@
+//Command etlx is the command line tool of etlx. Only the drivers compiled into
+//the binary are available, build your own main package importing this one's
+//commands and your drivers if more are needed.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	_ "github.com/xingwangc/etlx/memory"
+	_ "github.com/xingwangc/etlx/transform"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: etlx <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "etlx: unknown command %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "etlx %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xingwangc/etlx/profile"
+)
+
+func init() {
+	commands["profile"] = command{
+		usage: "read a source once and print the profile of every column",
+		run:   runProfile,
+	}
+}
+
+func runProfile(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	src := &sourceFlags{}
+	src.register(fs)
+	format := fs.String("format", "table", "output format, table or json")
+	top := fs.Int("top", 10, "number of the most frequent values of every column, none if 0")
+	bins := fs.Int("bins", 10, "number of bins of the numeric histogram, none if 0")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unsupported format %s", *format)
+	}
+	if *top < 0 || *bins < 0 {
+		return fmt.Errorf("-top and -bins should not be negative")
+	}
+
+	rows, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	report, err := profile.Profile(rows, profile.TopK(*top), profile.Bins(*bins))
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.WriteTable(os.Stdout)
+}
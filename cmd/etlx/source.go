@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//sourceFlags are the flags to read rows from an extract driver or a local file.
+type sourceFlags struct {
+	driver string
+	name   string
+	source string
+	args   string
+	file   string
+}
+
+func (s *sourceFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&s.driver, "driver", "", "name of the extract driver")
+	fs.StringVar(&s.name, "name", "etlx", "name passed to the extract driver")
+	fs.StringVar(&s.source, "source", "", "data source of the extract driver")
+	fs.StringVar(&s.args, "args", "", "json file of the commands of the extract driver")
+	fs.StringVar(&s.file, "file", "", "csv, json or json lines file read without a driver")
+}
+
+//open returns the rows of the file or queried by the extract driver.
+func (s *sourceFlags) open() (driver.Rows, error) {
+	if s.file != "" {
+		return etlx.ReadFile(s.file)
+	}
+	if s.driver == "" {
+		return nil, fmt.Errorf("-driver or -file is required")
+	}
+
+	cmds := []driver.Command{}
+	if s.args != "" {
+		b, err := ioutil.ReadFile(s.args)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &cmds); err != nil {
+			return nil, fmt.Errorf("invalid commands in %s: %v", s.args, err)
+		}
+	}
+
+	handler, err := etlx.NewExtract(s.driver, s.name, s.source, cmds)
+	if err != nil {
+		return nil, err
+	}
+	return handler.Run()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/etlxtest"
+)
+
+func init() {
+	commands["test"] = command{
+		usage: "run a job on a fixture file and compare the output with a golden file",
+		run:   runTest,
+	}
+}
+
+func runTest(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	jobPath := fs.String("job", "", "json or yaml file of the job")
+	fixture := fs.String("fixture", "", "csv or json file read instead of the extract of the job")
+	golden := fs.String("golden", "", "csv or json file of the output expected")
+	update := fs.Bool("update", false, "write the output to the golden file")
+	ignoreOrder := fs.Bool("ignore-order", false, "compare the rows in any order")
+	tolerance := fs.Float64("tolerance", 0, "absolute difference allowed between two numbers")
+	maxDiffs := fs.Int("max-diffs", etlxtest.DefaultMaxDiffs, "number of differences printed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *jobPath == "" || *fixture == "" || *golden == "" {
+		return fmt.Errorf("-job, -fixture and -golden are required")
+	}
+
+	job, err := etlx.ReadJob(*jobPath)
+	if err != nil {
+		return err
+	}
+	rslt, err := etlxtest.RunGolden(etlxtest.GoldenCase{
+		Job:         job,
+		Fixture:     *fixture,
+		Golden:      *golden,
+		Update:      *update,
+		IgnoreOrder: *ignoreOrder,
+		Tolerance:   *tolerance,
+		MaxDiffs:    *maxDiffs,
+	})
+	if err != nil {
+		return err
+	}
+
+	if rslt.Updated {
+		fmt.Printf("updated %s with %d rows\n", *golden, len(rslt.Rows))
+		return nil
+	}
+	if !rslt.OK() {
+		for _, diff := range rslt.Diffs {
+			fmt.Println(diff)
+		}
+		return fmt.Errorf("the output differs from %s", *golden)
+	}
+	fmt.Printf("ok %s, %d rows\n", *golden, len(rslt.Rows))
+	return nil
+}
@@ -0,0 +1,362 @@
+package etlx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//DriftPolicy decides what to do when the schema of the extracted rows is
+//different from the previous run.
+type DriftPolicy string
+
+const (
+	//DriftFail fails the transaction before any row is transformed.
+	DriftFail DriftPolicy = "fail"
+	//DriftWarn logs the drift and goes on.
+	DriftWarn DriftPolicy = "warn"
+	//DriftAutoMap logs the drift and passes the added columns through if the
+	//transform handler implements driver.PassThroughTransform, otherwise it
+	//logs that the added columns are not passed through and goes on.
+	DriftAutoMap DriftPolicy = "automap"
+)
+
+//number of rows sampled to infer the schema if the rows do not declare it
+const driftSampleSize = 1000
+
+//SchemaStore keeps the schema of the extracted rows of the last run for every job.
+type SchemaStore interface {
+	//Load returns false if there is no schema saved for the job.
+	Load(job string) (driver.Schema, bool, error)
+	Save(job string, schema driver.Schema) error
+}
+
+//FileSchemaStore saves the schema of every job in a json file in the directory.
+type FileSchemaStore struct {
+	dir string
+}
+
+func NewFileSchemaStore(dir string) *FileSchemaStore {
+	return &FileSchemaStore{dir: dir}
+}
+
+func (s *FileSchemaStore) path(job string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(job)
+	return filepath.Join(s.dir, name+".schema.json")
+}
+
+func (s *FileSchemaStore) Load(job string) (driver.Schema, bool, error) {
+	b, err := ioutil.ReadFile(s.path(job))
+	if os.IsNotExist(err) {
+		return driver.Schema{}, false, nil
+	}
+	if err != nil {
+		return driver.Schema{}, false, err
+	}
+
+	schema := driver.Schema{}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return driver.Schema{}, false, fmt.Errorf("Invalid schema of job %s: %v", job, err)
+	}
+	return schema, true, nil
+}
+
+//Save writes the schema to a temporary file and renames it, so a crash never
+//leaves a broken file.
+func (s *FileSchemaStore) Save(job string, schema driver.Schema) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".schema-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(job))
+}
+
+//FieldChange is a column whose type is changed.
+type FieldChange struct {
+	Name string
+	From driver.LogicalType
+	To   driver.LogicalType
+}
+
+//SchemaDrift is the difference of the schema between two runs.
+type SchemaDrift struct {
+	Added   []driver.Field
+	Removed []driver.Field
+	Retyped []FieldChange
+}
+
+//DiffSchema compares the schema of the current run with the previous one.
+//Columns of TypeAny are never reported as retyped.
+func DiffSchema(prev, cur driver.Schema) SchemaDrift {
+	drift := SchemaDrift{}
+	for _, f := range cur.Fields {
+		old, ok := prev.Field(f.Name)
+		if !ok {
+			drift.Added = append(drift.Added, f)
+			continue
+		}
+		if old.Type != f.Type && old.Type != driver.TypeAny && f.Type != driver.TypeAny {
+			drift.Retyped = append(drift.Retyped, FieldChange{Name: f.Name, From: old.Type, To: f.Type})
+		}
+	}
+	for _, f := range prev.Fields {
+		if _, ok := cur.Field(f.Name); !ok {
+			drift.Removed = append(drift.Removed, f)
+		}
+	}
+	return drift
+}
+
+func (d SchemaDrift) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Retyped) == 0
+}
+
+func (d SchemaDrift) String() string {
+	parts := []string{}
+	if len(d.Added) > 0 {
+		names := make([]string, len(d.Added))
+		for i, f := range d.Added {
+			names[i] = fmt.Sprintf("%s(%s)", f.Name, f.Type)
+		}
+		parts = append(parts, "added "+strings.Join(names, ", "))
+	}
+	if len(d.Removed) > 0 {
+		names := make([]string, len(d.Removed))
+		for i, f := range d.Removed {
+			names[i] = f.Name
+		}
+		parts = append(parts, "removed "+strings.Join(names, ", "))
+	}
+	if len(d.Retyped) > 0 {
+		names := make([]string, len(d.Retyped))
+		for i, c := range d.Retyped {
+			names[i] = fmt.Sprintf("%s(%s -> %s)", c.Name, c.From, c.To)
+		}
+		parts = append(parts, "retyped "+strings.Join(names, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+//DetectDrift enables the schema drift detection of the transaction. The schema
+//of the extracted rows is compared with the one saved by the previous run of
+//the job in the store, and the policy decides what to do if they are different.
+//The check runs once the rows are extracted, the first batch in batch mode,
+//and before any row is transformed, so the extract driver is opened and
+//queried even if the transaction fails by DriftFail. The schema is inferred
+//from the first rows if the rows do not declare it. The new schema is saved
+//once the transaction is executed successfully.
+func DetectDrift(store SchemaStore, job string, policy DriftPolicy) func(*Transaction) {
+	return func(t *Transaction) {
+		t.driftStore = store
+		t.driftJob = job
+		t.driftPolicy = policy
+	}
+}
+
+//checkDrift compares the schema of rows with the previous run. It returns the
+//rows to use instead since some of them may be read to infer the schema, and
+//may append the commands of the transform to pass the added columns through.
+func (t *Transaction) checkDrift(rows driver.Rows, transArgs *[]driver.Command) (driver.Rows, error) {
+	if t.driftStore == nil {
+		return rows, nil
+	}
+
+	var schema driver.Schema
+	if sr, ok := rows.(driver.SchemaRows); ok && !sr.Schema().IsEmpty() {
+		schema = sr.Schema()
+	} else {
+		var err error
+		if schema, rows, err = peek(rows, driftSampleSize); err != nil {
+			return nil, err
+		}
+	}
+	t.driftSchema = &schema
+
+	prev, ok, err := t.driftStore.Load(t.driftJob)
+	if err != nil {
+		return nil, fmt.Errorf("etlx: load schema of job %s: %v", t.driftJob, err)
+	}
+	if !ok {
+		return rows, nil
+	}
+	drift := DiffSchema(prev, schema)
+	if drift.IsEmpty() {
+		return rows, nil
+	}
+
+	switch t.driftPolicy {
+	case DriftWarn:
+		log.Printf("etlx: schema of job %s drifted: %s", t.driftJob, drift)
+	case DriftAutoMap:
+		log.Printf("etlx: schema of job %s drifted: %s", t.driftJob, drift)
+		if len(drift.Added) == 0 {
+			break
+		}
+		names := make([]string, len(drift.Added))
+		for i, f := range drift.Added {
+			names[i] = f.Name
+		}
+		if pt, ok := t.transformHandler.(driver.PassThroughTransform); ok {
+			*transArgs = append(append([]driver.Command{}, *transArgs...), pt.PassThrough(names)...)
+		} else {
+			log.Printf("etlx: transform %s of job %s could not pass the added columns through, %s are not mapped",
+				t.driverNames[1], t.driftJob, strings.Join(names, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("etlx: schema of job %s drifted: %s", t.driftJob, drift)
+	}
+	return rows, nil
+}
+
+//saveDrift saves the schema checked by checkDrift for the next run.
+func (t *Transaction) saveDrift() error {
+	if t.driftStore == nil || t.driftSchema == nil {
+		return nil
+	}
+	if err := t.driftStore.Save(t.driftJob, *t.driftSchema); err != nil {
+		return fmt.Errorf("etlx: save schema of job %s: %v", t.driftJob, err)
+	}
+	return nil
+}
+
+//peek reads at most n rows to infer their schema. It returns the rows to read
+//instead, which return the rows peeked again before the rest, and implement
+//driver.ColumnarRows and driver.SchemaRows if rows do.
+func peek(rows driver.Rows, n int) (driver.Schema, driver.Rows, error) {
+	p := &peekRows{Rows: rows}
+	var schema driver.Schema
+	var err error
+	if cr, ok := rows.(driver.ColumnarRows); ok {
+		//peek a batch, so the batches are replayed as they are read
+		tbl := driver.NewTable(0)
+		tbl.SetColumns(rows.Columns())
+		if p.batch, err = cr.NextBatch(n); err == nil {
+			tbl = p.batch.Table()
+			for i := 0; i < p.batch.Len(); i++ {
+				p.buf = append(p.buf, p.batch.Row(i))
+			}
+		} else if err != driver.EOT {
+			return schema, nil, err
+		}
+		schema, err = driver.InferSchema(tbl, n)
+	} else {
+		schema, err = driver.InferSchema(p, n)
+	}
+	if err != nil {
+		return schema, nil, err
+	}
+	p.replay()
+
+	_, columnar := rows.(driver.ColumnarRows)
+	_, schemaRows := rows.(driver.SchemaRows)
+	switch {
+	case columnar && schemaRows:
+		return schema, peekColumnarSchemaRows{peekColumnarRows{p}}, nil
+	case columnar:
+		return schema, peekColumnarRows{p}, nil
+	case schemaRows:
+		return schema, peekSchemaRows{p}, nil
+	}
+	return schema, p, nil
+}
+
+//peekRows keeps the rows read before replay is called and returns them again
+//before reading the rest.
+type peekRows struct {
+	driver.Rows
+	buf       [][]interface{}
+	pos       int
+	replaying bool
+	//batch is the rows of buf read from driver.ColumnarRows
+	batch *driver.ColumnBatch
+}
+
+func (p *peekRows) Next(dst interface{}) error {
+	if p.replaying && p.pos < len(p.buf) {
+		row := p.buf[p.pos]
+		p.pos++
+		return driver.CopyRow(p.Columns(), row, dst)
+	}
+	if p.replaying {
+		return p.Rows.Next(dst)
+	}
+
+	row := make([]interface{}, len(p.Columns()))
+	if err := p.Rows.Next(row); err != nil {
+		return err
+	}
+	p.buf = append(p.buf, row)
+	return driver.CopyRow(p.Columns(), row, dst)
+}
+
+func (p *peekRows) replay() {
+	p.replaying = true
+	p.pos = 0
+}
+
+//peekColumnarRows is the peekRows of driver.ColumnarRows.
+type peekColumnarRows struct {
+	*peekRows
+}
+
+func (p peekColumnarRows) NextBatch(max int) (*driver.ColumnBatch, error) {
+	if p.batch == nil || p.pos >= len(p.buf) {
+		return p.Rows.(driver.ColumnarRows).NextBatch(max)
+	}
+
+	end := len(p.buf)
+	if max > 0 && end-p.pos > max {
+		end = p.pos + max
+	}
+	if p.pos == 0 && end == len(p.buf) {
+		p.pos = end
+		return p.batch, nil
+	}
+	sel := make([]int, 0, end-p.pos)
+	for i := p.pos; i < end; i++ {
+		sel = append(sel, i)
+	}
+	p.pos = end
+	return p.batch.Select(sel), nil
+}
+
+//peekSchemaRows is the peekRows of driver.SchemaRows.
+type peekSchemaRows struct {
+	*peekRows
+}
+
+func (p peekSchemaRows) Schema() driver.Schema {
+	return p.Rows.(driver.SchemaRows).Schema()
+}
+
+//peekColumnarSchemaRows is the peekRows of driver.ColumnarRows and driver.SchemaRows.
+type peekColumnarSchemaRows struct {
+	peekColumnarRows
+}
+
+func (p peekColumnarSchemaRows) Schema() driver.Schema {
+	return p.Rows.(driver.SchemaRows).Schema()
+}
@@ -0,0 +1,133 @@
+package etlx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func TestDiffSchema(t *testing.T) {
+	prev := driver.Schema{Fields: []driver.Field{
+		{Name: "id", Type: driver.TypeInt},
+		{Name: "name", Type: driver.TypeString},
+		{Name: "note", Type: driver.TypeAny},
+	}}
+	tests := []struct {
+		name string
+		cur  []driver.Field
+		want string
+	}{
+		{"same", prev.Fields, ""},
+		{"any", []driver.Field{{Name: "id", Type: driver.TypeInt}, {Name: "name", Type: driver.TypeString}, {Name: "note", Type: driver.TypeFloat}}, ""},
+		{"added", append(append([]driver.Field{}, prev.Fields...), driver.Field{Name: "extra", Type: driver.TypeFloat}), "added extra(float)"},
+		{"removed", prev.Fields[:2], "removed note"},
+		{"retyped", []driver.Field{{Name: "id", Type: driver.TypeString}, {Name: "name", Type: driver.TypeString}, {Name: "note", Type: driver.TypeAny}}, "retyped id(int -> string)"},
+	}
+	for _, tt := range tests {
+		drift := DiffSchema(prev, driver.Schema{Fields: tt.cur})
+		if got := drift.String(); got != tt.want || drift.IsEmpty() != (tt.want == "") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFileSchemaStore(t *testing.T) {
+	store := NewFileSchemaStore(t.TempDir())
+	if _, ok, err := store.Load("a/b"); ok || err != nil {
+		t.Fatalf("loaded %v, %v", ok, err)
+	}
+	schema := driver.Schema{Fields: []driver.Field{{Name: "id", Type: driver.TypeInt, Nullable: true}}}
+	if err := store.Save("a/b", schema); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := store.Load("a/b")
+	if !ok || err != nil || !reflect.DeepEqual(got, schema) {
+		t.Errorf("loaded %v, %v, %v", got, ok, err)
+	}
+}
+
+func peekData() [][]interface{} {
+	return [][]interface{}{{"1", "a"}, {"2", "b"}, {"3", "c"}}
+}
+
+func TestPeekRows(t *testing.T) {
+	tbl := driver.NewTable(0)
+	tbl.SetColumns([]string{"id", "name"})
+	tbl.SetData(peekData())
+	schema, rows, err := peek(tbl, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := schema.Field("id"); f.Type != driver.TypeInt {
+		t.Errorf("schema %v", schema)
+	}
+	if _, ok := rows.(driver.SchemaRows); !ok {
+		t.Error("driver.SchemaRows is hidden")
+	}
+	if _, ok := rows.(driver.ColumnarRows); ok {
+		t.Error("the table is not driver.ColumnarRows")
+	}
+	var got [][]interface{}
+	for {
+		row := make([]interface{}, 2)
+		if err := rows.Next(row); err == driver.EOT {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	if !reflect.DeepEqual(got, peekData()) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestPeekColumnarRows(t *testing.T) {
+	batches := []*driver.ColumnBatch{}
+	for _, data := range [][][]interface{}{peekData(), {{"4", "d"}}} {
+		tbl := driver.NewTable(0)
+		tbl.SetColumns([]string{"id", "name"})
+		tbl.SetData(data)
+		b, err := driver.BatchFromTable(tbl, driver.Schema{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, b)
+	}
+	src := driver.NewBatchRows(batches[0].Schema(), batches...)
+
+	_, rows, err := peek(src, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, ok := rows.(driver.ColumnarRows)
+	if !ok {
+		t.Fatal("driver.ColumnarRows is hidden")
+	}
+	if _, ok := rows.(driver.SchemaRows); !ok {
+		t.Error("driver.SchemaRows is hidden")
+	}
+
+	//the batch peeked is replayed by the size asked, then the rest
+	var lens []int
+	var got [][]interface{}
+	for {
+		b, err := cr.NextBatch(2)
+		if err == driver.EOT {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		lens = append(lens, b.Len())
+		for i := 0; i < b.Len(); i++ {
+			got = append(got, b.Row(i))
+		}
+	}
+	if want := []int{2, 1, 1}; !reflect.DeepEqual(lens, want) {
+		t.Errorf("batches of %v rows, want %v", lens, want)
+	}
+	if want := append(peekData(), []interface{}{"4", "d"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+//CommandSpec describes a command accepted by a driver.
+type CommandSpec struct {
+	//Name is the name of the command, "*" accepts any name, e.g. the column
+	//names of a mapper.
+	Name string `json:"name"`
+	//Type is the type of the value, it could be string, int, float, bool,
+	//time, decimal, list, json, jsonarray, raw, or complex and single whose
+	//commands are described by Items. Alternatives are separated by "|", and
+	//any value is accepted if it is empty.
+	Type     string        `json:"type,omitempty"`
+	Required bool          `json:"required,omitempty"`
+	Enum     []string      `json:"enum,omitempty"`
+	Default  interface{}   `json:"default,omitempty"`
+	Doc      string        `json:"doc,omitempty"`
+	Items    []CommandSpec `json:"items,omitempty"`
+}
+
+//CommandSchema describes the commands accepted by a driver, so the commands
+//of a job could be validated before the driver is opened.
+type CommandSchema struct {
+	Doc      string        `json:"doc,omitempty"`
+	Commands []CommandSpec `json:"commands"`
+}
+
+//CommandSchemaDriver is the interface of the extract, transform or load driver
+//publishing the schema of its commands. It is optional, the commands of the
+//drivers not implementing it are only checked by the Command of the handler.
+type CommandSchemaDriver interface {
+	CommandSchema() CommandSchema
+}
+
+//Validate checks the commands against the schema: unknown names, the type and
+//enum of the values, the required commands and the commands of complex and
+//single values. All the errors found are returned at once.
+func (s CommandSchema) Validate(cmds []Command) error {
+	errs := validateCommands(s.Commands, cmds, "")
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid commands: %s", strings.Join(errs, "; "))
+}
+
+func validateCommands(specs []CommandSpec, cmds []Command, path string) []string {
+	errs := []string{}
+	seen := map[string]bool{}
+	for _, cmd := range cmds {
+		name := path + cmd.Name
+		spec, ok := findSpec(specs, cmd.Name)
+		if !ok {
+			msg := fmt.Sprintf("unknown command %s", name)
+			if similar := similarSpec(specs, cmd.Name); similar != "" {
+				msg += fmt.Sprintf(", did you mean %s?", path+similar)
+			}
+			errs = append(errs, msg)
+			continue
+		}
+		seen[spec.Name] = true
+		errs = append(errs, validateValue(spec, cmd, name)...)
+	}
+
+	for _, spec := range specs {
+		if spec.Required && !seen[spec.Name] {
+			if spec.Name == "*" {
+				errs = append(errs, fmt.Sprintf("%sat least one command is required", path))
+			} else {
+				errs = append(errs, fmt.Sprintf("command %s is required", path+spec.Name))
+			}
+		}
+	}
+	return errs
+}
+
+func findSpec(specs []CommandSpec, name string) (CommandSpec, bool) {
+	for _, spec := range specs {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	for _, spec := range specs {
+		if spec.Name == "*" {
+			return spec, true
+		}
+	}
+	return CommandSpec{}, false
+}
+
+func validateValue(spec CommandSpec, cmd Command, name string) []string {
+	if spec.Type == "" {
+		return nil
+	}
+
+	var err error
+	for _, typ := range strings.Split(spec.Type, "|") {
+		typ = strings.TrimSpace(typ)
+		if err = checkValueType(typ, cmd.Value); err == nil {
+			if typ == "complex" || typ == "single" {
+				items, _ := cmd.Value.([]Command)
+				return validateCommands(spec.Items, items, name+".")
+			}
+			break
+		}
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("command %s should be %s, got %v", name, spec.Type, cmd.Value)}
+	}
+
+	if len(spec.Enum) > 0 {
+		str, _ := StringFromInterface(cmd.Value)
+		for _, item := range spec.Enum {
+			if str == item {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("command %s should be one of %s, got %v", name, strings.Join(spec.Enum, ", "), cmd.Value)}
+	}
+	return nil
+}
+
+func checkValueType(typ string, val interface{}) error {
+	var err error
+	switch {
+	case typ == "string":
+		_, err = StringFromInterface(val)
+	case typ == "int":
+		_, err = IntFromInterface(val)
+	case typ == "float":
+		_, err = FloatFromInterface(val)
+	case typ == "bool":
+		_, err = BoolFromInterface(val)
+	case typ == "time":
+		_, err = NewTimeParser().Parse(val)
+	case strings.HasPrefix(typ, "decimal"):
+		_, err = DecimalFromInterface(val)
+	case typ == "list":
+		_, err = ArrayFromInterface(val)
+	case typ == "json":
+		_, err = MapFromInterface(val)
+	case typ == "jsonarray":
+		if _, ok := val.([]map[string]interface{}); !ok {
+			_, err = ArrayFromInterface(val)
+		}
+	case typ == "raw":
+		if _, ok := val.(string); !ok {
+			err = fmt.Errorf("got %T", val)
+		}
+	case typ == "complex", typ == "single":
+		if _, ok := val.([]Command); !ok {
+			err = fmt.Errorf("got %T", val)
+		}
+	default:
+		err = fmt.Errorf("unknown type %s in the schema", typ)
+	}
+	return err
+}
+
+//similarSpec returns the name of the spec which is a likely typo of name.
+func similarSpec(specs []CommandSpec, name string) string {
+	best, bestDist := "", 3
+	for _, spec := range specs {
+		if spec.Name == "*" {
+			continue
+		}
+		if d := editDistance(strings.ToLower(name), strings.ToLower(spec.Name)); d < bestDist {
+			best, bestDist = spec.Name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//Help returns the schema as help text, a line for every command with its
+//type, flags and doc, the commands of complex and single values are indented.
+func (s CommandSchema) Help() string {
+	buf := &bytes.Buffer{}
+	if s.Doc != "" {
+		fmt.Fprintf(buf, "%s\n\n", s.Doc)
+	}
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	writeSpecs(w, s.Commands, "")
+	w.Flush()
+	return buf.String()
+}
+
+func writeSpecs(w *tabwriter.Writer, specs []CommandSpec, indent string) {
+	for _, spec := range specs {
+		typ := spec.Type
+		if typ == "" {
+			typ = "any"
+		}
+		flags := []string{}
+		if spec.Required {
+			flags = append(flags, "required")
+		}
+		if spec.Default != nil {
+			flags = append(flags, fmt.Sprintf("default %v", spec.Default))
+		}
+		if len(spec.Enum) > 0 {
+			flags = append(flags, "one of "+strings.Join(spec.Enum, ", "))
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\n", indent, spec.Name, typ, strings.Join(flags, "; "), spec.Doc)
+		writeSpecs(w, spec.Items, indent+"  ")
+	}
+}
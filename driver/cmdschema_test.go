@@ -0,0 +1,100 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+)
+
+var testCommandSchema = CommandSchema{
+	Doc: "test orders rows.",
+	Commands: []CommandSpec{
+		{Name: "table", Type: "string", Required: true, Doc: "name of the table"},
+		{Name: "limit", Type: "int", Default: 100},
+		{Name: "mode", Type: "string", Enum: []string{"asc", "desc"}},
+		{Name: "columns", Type: "list|string"},
+		{Name: "since", Type: "time"},
+		{Name: "price", Type: "decimal(10,2)"},
+		{Name: "options", Type: "json"},
+		{Name: "anything"},
+		{Name: "order_by", Type: "complex", Items: []CommandSpec{
+			{Name: "*", Type: "string", Required: true},
+		}},
+	},
+}
+
+func TestCommandSchemaValidate(t *testing.T) {
+	table := Command{Name: "table", Value: "t"}
+	tests := []struct {
+		name string
+		cmds []Command
+		errs []string
+	}{
+		{"valid", []Command{table, {Name: "limit", Value: "42"}, {Name: "mode", Value: "desc"},
+			{Name: "columns", Value: []interface{}{"a"}}, {Name: "since", Value: "2016-01-02"}, {Name: "price", Value: "1.5"},
+			{Name: "options", Value: map[string]interface{}{"a": 1}}, {Name: "anything", Value: []int{1}},
+			{Name: "order_by", Value: []Command{{Name: "a", Value: "asc"}}}}, nil},
+		{"alternative type", []Command{table, {Name: "columns", Value: "a"}}, nil},
+		{"required", []Command{{Name: "limit", Value: 1}}, []string{"command table is required"}},
+		{"typo", []Command{table, {Name: "limt", Value: 1}}, []string{"unknown command limt, did you mean limit?"}},
+		{"unknown", []Command{table, {Name: "bogus", Value: 1}}, []string{"unknown command bogus"}},
+		{"type", []Command{table, {Name: "limit", Value: "lots"}}, []string{"command limit should be int, got lots"}},
+		{"enum", []Command{table, {Name: "mode", Value: "up"}}, []string{"command mode should be one of asc, desc, got up"}},
+		{"items", []Command{table, {Name: "order_by", Value: []Command{{Name: "a", Value: []int{}}}}},
+			[]string{"command order_by.a should be string"}},
+		{"required items", []Command{table, {Name: "order_by", Value: []Command{}}},
+			[]string{"order_by.at least one command is required"}},
+		{"all errors", []Command{{Name: "limit", Value: "lots"}, {Name: "mode", Value: "up"}},
+			[]string{"command limit should be int", "command mode should be one of", "command table is required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testCommandSchema.Validate(tt.cmds)
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("Validate returned %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate should fail with %v", tt.errs)
+			}
+			for _, msg := range tt.errs {
+				if !strings.Contains(err.Error(), msg) {
+					t.Errorf("the error %q does not contain %q", err, msg)
+				}
+			}
+		})
+	}
+
+	bad := CommandSchema{Commands: []CommandSpec{{Name: "x", Type: "number"}}}
+	if err := bad.Validate([]Command{{Name: "x", Value: 1}}); err == nil {
+		t.Error("an unknown type of the schema should fail")
+	}
+}
+
+func TestCommandSchemaHelp(t *testing.T) {
+	help := testCommandSchema.Help()
+	for _, want := range []string{"test orders rows.\n\n", "table", "required", "default 100", "one of asc, desc", "\n  *"} {
+		if !strings.Contains(help, want) {
+			t.Errorf("the help does not contain %q:\n%s", want, help)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"limit", "limit", 0},
+		{"limt", "limit", 1},
+		{"oder_by", "order_by", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package driver
+
+import (
+	"fmt"
+	"time"
+)
+
+//Interface of rows which could be read a batch of columns at a time, it is
+//optional for drivers. Transforms supporting it process the values in the typed
+//vectors directly without boxing every value in an interface{}.
+type ColumnarRows interface {
+	Rows
+	//NextBatch returns at most max rows, all the rest if max <= 0. EOT is
+	//returned at the end.
+	NextBatch(max int) (*ColumnBatch, error)
+}
+
+//Bitmap marks the null values of a Vector, bit i is set if value i is null.
+type Bitmap []uint64
+
+func (b Bitmap) Get(i int) bool {
+	word := i / 64
+	if word >= len(b) {
+		return false
+	}
+	return b[word]&(1<<uint(i%64)) != 0
+}
+
+func (b *Bitmap) Set(i int) {
+	word := i / 64
+	for word >= len(*b) {
+		*b = append(*b, 0)
+	}
+	(*b)[word] |= 1 << uint(i%64)
+}
+
+//Vector is the values of a column. Only the slice of the type is used:
+//	TypeInt: Ints
+//	TypeFloat: Floats
+//	TypeString: Strings
+//	TypeBool: Bools
+//	TypeTime: Times
+//	others: Values, with the values as they are
+//Null values are marked in Nulls and hold the zero value in the slice.
+type Vector struct {
+	Type LogicalType
+	//Layout to parse the strings appended to a time vector
+	Layout string
+
+	Ints    []int64
+	Floats  []float64
+	Strings []string
+	Bools   []bool
+	Times   []time.Time
+	Values  []interface{}
+	Nulls   Bitmap
+
+	length int
+}
+
+//NewVector returns an empty vector of the type, the types which do not have a
+//typed slice are stored in Values.
+func NewVector(typ LogicalType, capacity int) *Vector {
+	v := &Vector{Type: typ}
+	switch typ {
+	case TypeInt:
+		v.Ints = make([]int64, 0, capacity)
+	case TypeFloat:
+		v.Floats = make([]float64, 0, capacity)
+	case TypeString:
+		v.Strings = make([]string, 0, capacity)
+	case TypeBool:
+		v.Bools = make([]bool, 0, capacity)
+	case TypeTime:
+		v.Times = make([]time.Time, 0, capacity)
+	default:
+		v.Values = make([]interface{}, 0, capacity)
+	}
+	return v
+}
+
+func (v *Vector) Len() int {
+	return v.length
+}
+
+func (v *Vector) IsNull(i int) bool {
+	return v.Nulls.Get(i)
+}
+
+//Value returns the value boxed in an interface{}, nil for null.
+func (v *Vector) Value(i int) interface{} {
+	if v.Nulls.Get(i) {
+		return nil
+	}
+	switch v.Type {
+	case TypeInt:
+		return v.Ints[i]
+	case TypeFloat:
+		return v.Floats[i]
+	case TypeString:
+		return v.Strings[i]
+	case TypeBool:
+		return v.Bools[i]
+	case TypeTime:
+		return v.Times[i]
+	}
+	return v.Values[i]
+}
+
+func (v *Vector) AppendNull() {
+	v.Nulls.Set(v.length)
+	switch v.Type {
+	case TypeInt:
+		v.Ints = append(v.Ints, 0)
+	case TypeFloat:
+		v.Floats = append(v.Floats, 0)
+	case TypeString:
+		v.Strings = append(v.Strings, "")
+	case TypeBool:
+		v.Bools = append(v.Bools, false)
+	case TypeTime:
+		v.Times = append(v.Times, time.Time{})
+	default:
+		v.Values = append(v.Values, nil)
+	}
+	v.length++
+}
+
+//Append converts the value to the type of the vector with the *FromInterface
+//converters and appends it, nil is appended as null.
+func (v *Vector) Append(val interface{}) error {
+	if val == nil {
+		v.AppendNull()
+		return nil
+	}
+
+	switch v.Type {
+	case TypeInt:
+		ival, err := IntFromInterface(val)
+		if err != nil {
+			return err
+		}
+		v.Ints = append(v.Ints, ival)
+	case TypeFloat:
+		fval, err := FloatFromInterface(val)
+		if err != nil {
+			return err
+		}
+		v.Floats = append(v.Floats, fval)
+	case TypeString:
+		sval, err := StringFromInterface(val)
+		if err != nil {
+			return err
+		}
+		v.Strings = append(v.Strings, sval)
+	case TypeBool:
+		bval, err := BoolFromInterface(val)
+		if err != nil {
+			return err
+		}
+		v.Bools = append(v.Bools, bval)
+	case TypeTime:
+		tval, err := TimeFromInterface(val, v.Layout)
+		if err != nil {
+			return err
+		}
+		v.Times = append(v.Times, tval)
+	default:
+		v.Values = append(v.Values, val)
+	}
+	v.length++
+	return nil
+}
+
+//AppendInt appends a value to a vector of TypeInt without boxing it, the
+//other typed Append* are the same for their types.
+func (v *Vector) AppendInt(val int64) {
+	v.Ints = append(v.Ints, val)
+	v.length++
+}
+
+func (v *Vector) AppendFloat(val float64) {
+	v.Floats = append(v.Floats, val)
+	v.length++
+}
+
+func (v *Vector) AppendString(val string) {
+	v.Strings = append(v.Strings, val)
+	v.length++
+}
+
+func (v *Vector) AppendBool(val bool) {
+	v.Bools = append(v.Bools, val)
+	v.length++
+}
+
+func (v *Vector) AppendTime(val time.Time) {
+	v.Times = append(v.Times, val)
+	v.length++
+}
+
+//truncate keeps the first n values.
+func (v *Vector) truncate(n int) {
+	for i := n; i < v.length; i++ {
+		if word := i / 64; word < len(v.Nulls) {
+			v.Nulls[word] &^= 1 << uint(i%64)
+		}
+	}
+	switch v.Type {
+	case TypeInt:
+		v.Ints = v.Ints[:n]
+	case TypeFloat:
+		v.Floats = v.Floats[:n]
+	case TypeString:
+		v.Strings = v.Strings[:n]
+	case TypeBool:
+		v.Bools = v.Bools[:n]
+	case TypeTime:
+		v.Times = v.Times[:n]
+	default:
+		v.Values = v.Values[:n]
+	}
+	v.length = n
+}
+
+//Select returns a new vector of the values at the positions in order.
+func (v *Vector) Select(sel []int) *Vector {
+	out := NewVector(v.Type, len(sel))
+	out.Layout = v.Layout
+	for j, i := range sel {
+		if v.Nulls.Get(i) {
+			out.Nulls.Set(j)
+		}
+		switch v.Type {
+		case TypeInt:
+			out.Ints = append(out.Ints, v.Ints[i])
+		case TypeFloat:
+			out.Floats = append(out.Floats, v.Floats[i])
+		case TypeString:
+			out.Strings = append(out.Strings, v.Strings[i])
+		case TypeBool:
+			out.Bools = append(out.Bools, v.Bools[i])
+		case TypeTime:
+			out.Times = append(out.Times, v.Times[i])
+		default:
+			out.Values = append(out.Values, v.Values[i])
+		}
+	}
+	out.length = len(sel)
+	return out
+}
+
+//ColumnBatch is a batch of rows stored by columns, all vectors have the same length.
+type ColumnBatch struct {
+	Columns []string
+	Vectors []*Vector
+}
+
+//NewColumnBatch returns an empty batch with a vector for every field. A time
+//field with layout parses the strings appended with it.
+func NewColumnBatch(schema Schema, capacity int) *ColumnBatch {
+	b := &ColumnBatch{
+		Columns: schema.Columns(),
+		Vectors: make([]*Vector, len(schema.Fields)),
+	}
+	for i, f := range schema.Fields {
+		b.Vectors[i] = NewVector(f.Type, capacity)
+		b.Vectors[i].Layout = f.Layout
+	}
+	return b
+}
+
+func (b *ColumnBatch) Len() int {
+	if len(b.Vectors) == 0 {
+		return 0
+	}
+	return b.Vectors[0].Len()
+}
+
+//Schema returns the schema of the vectors. All columns are nullable since a
+//batch does not know if the later ones have null.
+func (b *ColumnBatch) Schema() Schema {
+	fields := make([]Field, len(b.Columns))
+	for i, name := range b.Columns {
+		fields[i] = Field{Name: name, Type: b.Vectors[i].Type, Nullable: true, Layout: b.Vectors[i].Layout}
+	}
+	return Schema{Fields: fields}
+}
+
+//Vector returns the vector of the column.
+func (b *ColumnBatch) Vector(name string) (*Vector, bool) {
+	for i, col := range b.Columns {
+		if col == name {
+			return b.Vectors[i], true
+		}
+	}
+	return nil, false
+}
+
+//AppendRow appends the values of a row in the order of the columns.
+func (b *ColumnBatch) AppendRow(row []interface{}) error {
+	if len(row) != len(b.Vectors) {
+		return fmt.Errorf("Row has %d values but the batch has %d columns", len(row), len(b.Vectors))
+	}
+	for i, val := range row {
+		if err := b.Vectors[i].Append(DataPreProcess(val)); err != nil {
+			//remove the values appended to keep the vectors the same length
+			for j := 0; j < i; j++ {
+				b.Vectors[j].truncate(b.Vectors[j].Len() - 1)
+			}
+			return fmt.Errorf("Column %s: %v", b.Columns[i], err)
+		}
+	}
+	return nil
+}
+
+//Row returns the values of row i.
+func (b *ColumnBatch) Row(i int) []interface{} {
+	row := make([]interface{}, len(b.Vectors))
+	for j, vec := range b.Vectors {
+		row[j] = vec.Value(i)
+	}
+	return row
+}
+
+//Select returns a new batch of the rows at the positions in order.
+func (b *ColumnBatch) Select(sel []int) *ColumnBatch {
+	out := &ColumnBatch{Columns: b.Columns, Vectors: make([]*Vector, len(b.Vectors))}
+	for i, vec := range b.Vectors {
+		out.Vectors[i] = vec.Select(sel)
+	}
+	return out
+}
+
+//Table converts the batch to a Table with the schema of the batch.
+func (b *ColumnBatch) Table() *Table {
+	tbl := NewTable(b.Len())
+	tbl.SetSchema(b.Schema())
+	for i := 0; i < b.Len(); i++ {
+		tbl.AppendData(b.Row(i))
+	}
+	return tbl
+}
+
+//BatchFromTable converts the rows of the table to a batch. The columns of
+//TypeAny in the schema, or all columns if the schema is empty, are typed by the
+//Go type of the values if all of them have the same, otherwise they are stored
+//as they are.
+func BatchFromTable(t *Table, schema Schema) (*ColumnBatch, error) {
+	if schema.IsEmpty() {
+		schema = t.Schema()
+	}
+	if schema.IsEmpty() {
+		schema = NewSchema(t.Columns())
+	}
+
+	data := t.GetData()
+	fields := append([]Field{}, schema.Fields...)
+	for i := range fields {
+		if fields[i].Type == TypeAny {
+			fields[i].Type = goType(data, i)
+		}
+	}
+
+	b := NewColumnBatch(Schema{Fields: fields}, len(data))
+	for _, row := range data {
+		if err := b.AppendRow(row); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+//goType returns the type of column i by the Go type of the values, TypeAny if
+//they are different or unknown.
+func goType(data [][]interface{}, i int) LogicalType {
+	typ := TypeAny
+	for _, row := range data {
+		if i >= len(row) || row[i] == nil {
+			continue
+		}
+		var t LogicalType
+		switch row[i].(type) {
+		case int, int64:
+			t = TypeInt
+		case float64:
+			t = TypeFloat
+		case string, []uint8:
+			t = TypeString
+		case bool:
+			t = TypeBool
+		case time.Time:
+			t = TypeTime
+		default:
+			return TypeAny
+		}
+		if typ != TypeAny && typ != t {
+			return TypeAny
+		}
+		typ = t
+	}
+	return typ
+}
+
+//ReadBatch reads at most max rows from rows as a batch of the schema. It uses
+//NextBatch if rows is ColumnarRows, the schema is ignored in this case. EOT is
+//returned if there is no more row.
+func ReadBatch(rows Rows, schema Schema, max int) (*ColumnBatch, error) {
+	if cr, ok := rows.(ColumnarRows); ok {
+		return cr.NextBatch(max)
+	}
+
+	b := NewColumnBatch(schema, max)
+	for max <= 0 || b.Len() < max {
+		row := make([]interface{}, len(schema.Fields))
+		if err := rows.Next(row); err != nil {
+			if err == EOT {
+				break
+			}
+			return nil, err
+		}
+		if err := b.AppendRow(row); err != nil {
+			return nil, err
+		}
+	}
+	if b.Len() == 0 {
+		return nil, EOT
+	}
+	return b, nil
+}
+
+//BatchRows is the Rows and Results of batches in memory, it is ColumnarRows
+//as well so the batches could be passed to the next stage as they are.
+type BatchRows struct {
+	schema  Schema
+	batches []*ColumnBatch
+	//position of the next row
+	batch int
+	row   int
+}
+
+//NewBatchRows returns the rows of the batches, all of them should have the
+//columns of the schema.
+func NewBatchRows(schema Schema, batches ...*ColumnBatch) *BatchRows {
+	return &BatchRows{schema: schema, batches: batches}
+}
+
+func (r *BatchRows) Append(b *ColumnBatch) {
+	if b.Len() > 0 {
+		r.batches = append(r.batches, b)
+	}
+}
+
+func (r *BatchRows) Columns() []string {
+	return r.schema.Columns()
+}
+
+func (r *BatchRows) Schema() Schema {
+	return r.schema
+}
+
+//skip moves to the next batch which has rows.
+func (r *BatchRows) skip() bool {
+	for r.batch < len(r.batches) && r.row >= r.batches[r.batch].Len() {
+		r.batch++
+		r.row = 0
+	}
+	return r.batch < len(r.batches)
+}
+
+func (r *BatchRows) Next(dst interface{}) error {
+	if !r.skip() {
+		return EOT
+	}
+	row := r.batches[r.batch].Row(r.row)
+	r.row++
+	return CopyRow(r.schema.Columns(), row, dst)
+}
+
+func (r *BatchRows) NextRsltAndIndex(rslt interface{}, index *map[string]interface{}) error {
+	return r.Next(rslt)
+}
+
+func (r *BatchRows) NextBatch(max int) (*ColumnBatch, error) {
+	if !r.skip() {
+		return nil, EOT
+	}
+
+	b := r.batches[r.batch]
+	end := b.Len()
+	if max > 0 && end-r.row > max {
+		end = r.row + max
+	}
+	if r.row == 0 && end == b.Len() {
+		r.batch++
+		return b, nil
+	}
+
+	sel := make([]int, 0, end-r.row)
+	for i := r.row; i < end; i++ {
+		sel = append(sel, i)
+	}
+	r.row = end
+	return b.Select(sel), nil
+}
+
+//Reset moves to the first row.
+func (r *BatchRows) Reset() {
+	r.batch, r.row = 0, 0
+}
+
+func (r *BatchRows) Close() error {
+	return nil
+}
@@ -0,0 +1,193 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBitmap(t *testing.T) {
+	var b Bitmap
+	for _, i := range []int{0, 63, 64, 130} {
+		b.Set(i)
+	}
+	for i := 0; i < 200; i++ {
+		want := i == 0 || i == 63 || i == 64 || i == 130
+		if b.Get(i) != want {
+			t.Errorf("Get(%d) = %v, want %v", i, b.Get(i), want)
+		}
+	}
+}
+
+func TestVector(t *testing.T) {
+	day := time.Date(2016, 1, 2, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		typ    LogicalType
+		layout string
+		in     []interface{}
+		want   []interface{}
+	}{
+		{TypeInt, "", []interface{}{1, nil, "3"}, []interface{}{int64(1), nil, int64(3)}},
+		{TypeFloat, "", []interface{}{1.5, "2", nil}, []interface{}{1.5, 2.0, nil}},
+		{TypeString, "", []interface{}{"a", nil, 3}, []interface{}{"a", nil, "3"}},
+		{TypeBool, "", []interface{}{true, "false", nil}, []interface{}{true, false, nil}},
+		{TypeTime, "2006-01-02", []interface{}{"2016-01-02", nil, day}, []interface{}{day, nil, day}},
+		{TypeAny, "", []interface{}{1, "a", nil}, []interface{}{1, "a", nil}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.typ), func(t *testing.T) {
+			v := NewVector(tt.typ, 0)
+			v.Layout = tt.layout
+			for _, val := range tt.in {
+				if err := v.Append(val); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if v.Len() != len(tt.want) {
+				t.Fatalf("Len() = %d, want %d", v.Len(), len(tt.want))
+			}
+			got := []interface{}{}
+			for i := 0; i < v.Len(); i++ {
+				got = append(got, v.Value(i))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values %v, want %v", got, tt.want)
+			}
+
+			sel := v.Select([]int{2, 1})
+			if sel.Value(0) != v.Value(2) || sel.IsNull(1) != v.IsNull(1) {
+				t.Errorf("Select([2 1]) = %v %v", sel.Value(0), sel.Value(1))
+			}
+		})
+	}
+
+	v := NewVector(TypeInt, 0)
+	if err := v.Append("x"); err == nil || v.Len() != 0 {
+		t.Errorf("Append(x) to an int vector returned %v with %d values", err, v.Len())
+	}
+}
+
+func TestColumnBatch(t *testing.T) {
+	schema := Schema{Fields: []Field{{Name: "id", Type: TypeInt}, {Name: "name", Type: TypeString}}}
+	b := NewColumnBatch(schema, 0)
+	if err := b.AppendRow([]interface{}{1, "a"}); err != nil {
+		t.Fatal(err)
+	}
+	//the values appended before the failed one are removed
+	if err := b.AppendRow([]interface{}{2, []int{}}); err == nil {
+		t.Error("AppendRow of a slice to a string column should fail")
+	}
+	if err := b.AppendRow([]interface{}{3}); err == nil {
+		t.Error("AppendRow of a short row should fail")
+	}
+	if err := b.AppendRow([]interface{}{nil, "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", b.Len())
+	}
+	if got := b.Row(1); !reflect.DeepEqual(got, []interface{}{nil, "c"}) {
+		t.Errorf("Row(1) = %v", got)
+	}
+	if vec, ok := b.Vector("name"); !ok || vec.Value(0) != "a" {
+		t.Errorf("Vector(name) = %v, %v", vec, ok)
+	}
+	if _, ok := b.Vector("missing"); ok {
+		t.Error("Vector(missing) is found")
+	}
+	if got := b.Select([]int{1}).Row(0); !reflect.DeepEqual(got, []interface{}{nil, "c"}) {
+		t.Errorf("Select([1]) = %v", got)
+	}
+}
+
+func TestBatchFromTable(t *testing.T) {
+	tbl := NewTable(0)
+	tbl.SetColumns([]string{"i", "f", "mixed"})
+	tbl.AppendData([]interface{}{1, 1.5, "a"})
+	tbl.AppendData([]interface{}{nil, 2.5, 2})
+
+	b, err := BatchFromTable(tbl, Schema{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []LogicalType{}
+	for _, f := range b.Schema().Fields {
+		types = append(types, f.Type)
+	}
+	if want := []LogicalType{TypeInt, TypeFloat, TypeAny}; !reflect.DeepEqual(types, want) {
+		t.Errorf("types %v, want %v", types, want)
+	}
+
+	want := [][]interface{}{{int64(1), 1.5, "a"}, {nil, 2.5, 2}}
+	if got := b.Table().GetData(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Table() = %v, want %v", got, want)
+	}
+}
+
+func TestBatchRows(t *testing.T) {
+	schema := Schema{Fields: []Field{{Name: "n", Type: TypeInt}}}
+	batch := func(from, to int) *ColumnBatch {
+		b := NewColumnBatch(schema, 0)
+		for i := from; i < to; i++ {
+			b.AppendRow([]interface{}{i})
+		}
+		return b
+	}
+	rows := NewBatchRows(schema, batch(0, 5))
+	rows.Append(NewColumnBatch(schema, 0))
+	rows.Append(batch(5, 8))
+
+	lens := []int{}
+	for {
+		b, err := rows.NextBatch(3)
+		if err == EOT {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		lens = append(lens, b.Len())
+	}
+	if want := []int{3, 2, 3}; !reflect.DeepEqual(lens, want) {
+		t.Errorf("NextBatch(3) lengths %v, want %v", lens, want)
+	}
+
+	rows.Reset()
+	row := make([]interface{}, 1)
+	for i := 0; i < 8; i++ {
+		if err := rows.Next(row); err != nil || row[0] != int64(i) {
+			t.Fatalf("Next() = %v, %v, want %d", row, err, i)
+		}
+	}
+	if err := rows.Next(row); err != EOT {
+		t.Errorf("Next() at the end returned %v", err)
+	}
+
+	rows.Reset()
+	b, err := ReadBatch(rows, Schema{}, 0)
+	if err != nil || b.Len() != 5 {
+		t.Errorf("ReadBatch of ColumnarRows returned %v rows, %v", b, err)
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	tbl := NewTable(0)
+	tbl.SetColumns([]string{"n"})
+	for i := 0; i < 5; i++ {
+		tbl.AppendData([]interface{}{i})
+	}
+	schema := Schema{Fields: []Field{{Name: "n", Type: TypeInt}}}
+	lens := []int{}
+	for {
+		b, err := ReadBatch(tbl, schema, 2)
+		if err == EOT {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		lens = append(lens, b.Len())
+	}
+	if want := []int{2, 2, 1}; !reflect.DeepEqual(lens, want) {
+		t.Errorf("ReadBatch(2) lengths %v, want %v", lens, want)
+	}
+}
@@ -0,0 +1,533 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	decimalType  = reflect.TypeOf(Decimal{})
+	geometryType = reflect.TypeOf(Geometry{})
+	rawType      = reflect.TypeOf(json.RawMessage{})
+)
+
+type fieldTag struct {
+	name       string
+	omitEmpty  bool
+	required   bool
+	hasDefault bool
+	def        string
+	layout     string
+}
+
+func parseFieldTag(f reflect.StructField) (fieldTag, bool) {
+	tag := fieldTag{name: f.Name}
+	if f.PkgPath != "" {
+		return tag, false
+	}
+
+	jsonTag := f.Tag.Get("json")
+	if jsonTag == "-" {
+		return tag, false
+	}
+	parts := strings.Split(jsonTag, ",")
+	if parts[0] != "" {
+		tag.name = parts[0]
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			tag.omitEmpty = true
+		}
+	}
+
+	opts := f.Tag.Get("etlx")
+	for opts != "" {
+		if strings.HasPrefix(opts, "default=") {
+			tag.hasDefault, tag.def = true, strings.TrimPrefix(opts, "default=")
+			break
+		}
+		opt := opts
+		if pos := strings.Index(opts, ","); pos >= 0 {
+			opt, opts = opts[:pos], opts[pos+1:]
+		} else {
+			opts = ""
+		}
+		switch {
+		case opt == "required":
+			tag.required = true
+		case strings.HasPrefix(opt, "layout="):
+			tag.layout = strings.TrimPrefix(opt, "layout=")
+		}
+	}
+	return tag, true
+}
+
+//Construction is the Construction function of Command.
+//It connvert the src structure to a the ETLX defined command args format. The
+//name of the command is kept, the type is chosen by src: a struct is single
+//with the commands of its fields, a slice or map of structs is complex with a
+//single command for every item named by the index or key, other maps are json,
+//a slice of maps is jsonarray, other slices are list, json.RawMessage is raw,
+//and scalars are string, int, float, bool or decimal. Time is a RFC3339 string.
+func (cmd *Command) Construction(src interface{}) error {
+	val := reflect.ValueOf(src)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return fmt.Errorf("Command(%s) could not be constructed from nil", cmd.Name)
+		}
+		val = val.Elem()
+	}
+	if !val.IsValid() {
+		return fmt.Errorf("Command(%s) could not be constructed from nil", cmd.Name)
+	}
+
+	typ, value, err := commandValue(val)
+	if err != nil {
+		return fmt.Errorf("Command(%s): %v", cmd.Name, err)
+	}
+	cmd.Type, cmd.Value = typ, value
+	return nil
+}
+
+//CommandsFromStruct converts every exported field of the struct to a command
+//as Construction. The name of the command is the json tag of the field, or the
+//field name if there is no tag, and "-" skips the field. nil pointers and the
+//empty fields tagged omitempty are skipped.
+func CommandsFromStruct(src interface{}) ([]Command, error) {
+	val := reflect.ValueOf(src)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil, fmt.Errorf("CommandsFromStruct: src is nil")
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("CommandsFromStruct: src should be a struct, got %v", val.Type())
+	}
+	return structCommands(val)
+}
+
+func structCommands(val reflect.Value) ([]Command, error) {
+	cmds := []Command{}
+	for i := 0; i < val.NumField(); i++ {
+		tag, ok := parseFieldTag(val.Type().Field(i))
+		if !ok {
+			continue
+		}
+
+		field := val.Field(i)
+		if (field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface) && field.IsNil() {
+			continue
+		}
+		if tag.omitEmpty && isEmptyValue(field) {
+			continue
+		}
+
+		cmd := Command{Name: tag.name}
+		if err := cmd.Construction(field.Interface()); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+//isStructValue reports whether the type is converted as a struct of commands.
+func isStructValue(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && t != decimalType && t != geometryType
+}
+
+func commandValue(val reflect.Value) (string, interface{}, error) {
+	switch val.Type() {
+	case timeType:
+		return "string", val.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	case durationType:
+		return "string", val.Interface().(time.Duration).String(), nil
+	case decimalType:
+		return "decimal", val.Interface(), nil
+	case geometryType:
+		return "geometry", val.Interface(), nil
+	case rawType:
+		return "raw", string(val.Bytes()), nil
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		return "string", val.String(), nil
+	case reflect.Bool:
+		return "bool", val.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int", val.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int", int64(val.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return "float", val.Float(), nil
+	case reflect.Struct:
+		cmds, err := structCommands(val)
+		return "single", cmds, err
+	case reflect.Slice, reflect.Array:
+		elem := val.Type().Elem()
+		if isStructValue(elem) {
+			cmds := make([]Command, 0, val.Len())
+			for i := 0; i < val.Len(); i++ {
+				cmd := Command{Name: strconv.Itoa(i)}
+				if err := cmd.Construction(val.Index(i).Interface()); err != nil {
+					return "", nil, err
+				}
+				cmds = append(cmds, cmd)
+			}
+			return "complex", cmds, nil
+		}
+		if elem.Kind() == reflect.Map {
+			items := []map[string]interface{}{}
+			err := jsonConvert(val.Interface(), &items)
+			return "jsonarray", items, err
+		}
+		items := []interface{}{}
+		err := jsonConvert(val.Interface(), &items)
+		return "list", items, err
+	case reflect.Map:
+		if isStructValue(val.Type().Elem()) {
+			keys := make([]string, 0, val.Len())
+			byKey := map[string]reflect.Value{}
+			for _, k := range val.MapKeys() {
+				key := fmt.Sprint(k.Interface())
+				keys = append(keys, key)
+				byKey[key] = val.MapIndex(k)
+			}
+			sort.Strings(keys)
+			cmds := make([]Command, 0, len(keys))
+			for _, key := range keys {
+				cmd := Command{Name: key}
+				if err := cmd.Construction(byKey[key].Interface()); err != nil {
+					return "", nil, err
+				}
+				cmds = append(cmds, cmd)
+			}
+			return "complex", cmds, nil
+		}
+		items := map[string]interface{}{}
+		err := jsonConvert(val.Interface(), &items)
+		return "json", items, err
+	}
+	return "", nil, fmt.Errorf("type %v could not be converted to a command", val.Type())
+}
+
+//jsonConvert converts src to dst through json.
+func jsonConvert(src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+//DecodeCommands sets the fields of the struct dst points to by the commands,
+//it is the reverse of CommandsFromStruct. The values are converted to the
+//types of the fields, e.g. a string to int, a duration is a string as
+//time.ParseDuration or a number of seconds. The etlx tag holds the options:
+//	Table string    `json:"table" etlx:"required"`
+//	Limit int       `json:"limit" etlx:"default=100"`
+//	Since time.Time `json:"since" etlx:"layout=2006/01/02,default=2016/01/02"`
+//The default is set to the field without command, it is converted as a value
+//of command, and it is json for a list or map. It must be the last option since
+//it may contain commas. An error is returned for an unknown command, a value
+//could not be converted or any required field without command.
+func DecodeCommands(cmds []Command, dst interface{}) error {
+	val := reflect.ValueOf(dst)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("DecodeCommands: dst should be a pointer to struct, got %T", dst)
+	}
+	return decodeStruct(cmds, val.Elem())
+}
+
+func decodeStruct(cmds []Command, val reflect.Value) error {
+	type boundField struct {
+		index int
+		tag   fieldTag
+	}
+	fields := []boundField{}
+	for i := 0; i < val.NumField(); i++ {
+		if tag, ok := parseFieldTag(val.Type().Field(i)); ok {
+			fields = append(fields, boundField{index: i, tag: tag})
+		}
+	}
+	lookup := func(name string) (boundField, bool) {
+		for _, f := range fields {
+			if f.tag.name == name {
+				return f, true
+			}
+		}
+		for _, f := range fields {
+			if strings.EqualFold(f.tag.name, name) {
+				return f, true
+			}
+		}
+		return boundField{}, false
+	}
+
+	set := map[int]bool{}
+	for _, cmd := range cmds {
+		f, ok := lookup(cmd.Name)
+		if !ok {
+			return fmt.Errorf("Command(%s) is not supported", cmd.Name)
+		}
+		if err := setField(val.Field(f.index), cmd.Value, f.tag.layout); err != nil {
+			return fmt.Errorf("Command(%s): %v", cmd.Name, err)
+		}
+		set[f.index] = true
+	}
+
+	missing := []string{}
+	for _, f := range fields {
+		if set[f.index] {
+			continue
+		}
+		switch {
+		case f.tag.hasDefault:
+			if err := setDefault(val.Field(f.index), f.tag.def, f.tag.layout); err != nil {
+				return fmt.Errorf("Command(%s): invalid default %q: %v", f.tag.name, f.tag.def, err)
+			}
+		case f.tag.required:
+			missing = append(missing, f.tag.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Command(%s) is required", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func setDefault(field reflect.Value, def, layout string) error {
+	t := field.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if (t.Kind() == reflect.Slice || t.Kind() == reflect.Map || t.Kind() == reflect.Array) && t != rawType {
+		var src interface{}
+		if err := json.Unmarshal([]byte(def), &src); err != nil {
+			return err
+		}
+		return setField(field, src, layout)
+	}
+	return setField(field, def, layout)
+}
+
+//setField converts src to the type of the field and sets it.
+func setField(field reflect.Value, src interface{}, layout string) error {
+	src = DataPreProcess(src)
+	if field.Kind() == reflect.Ptr {
+		if src == nil {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), src, layout); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+	if src == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	switch field.Type() {
+	case timeType:
+		parser := NewTimeParser()
+		if layout != "" {
+			parser.Layouts = []string{layout}
+		}
+		t, err := parser.Parse(src)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		var d time.Duration
+		if str, ok := src.(string); ok {
+			var err error
+			if d, err = time.ParseDuration(strings.TrimSpace(str)); err != nil {
+				return err
+			}
+		} else {
+			//a number is seconds
+			secs, err := FloatFromInterface(src)
+			if err != nil {
+				return err
+			}
+			d = time.Duration(secs * float64(time.Second))
+		}
+		field.SetInt(int64(d))
+		return nil
+	case decimalType:
+		d, err := DecimalFromInterface(src)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(d))
+		return nil
+	case geometryType:
+		geom, err := GeometryFromInterface(src)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(geom))
+		return nil
+	case rawType:
+		if str, ok := src.(string); ok {
+			field.SetBytes([]byte(str))
+			return nil
+		}
+		b, err := json.Marshal(src)
+		if err != nil {
+			return err
+		}
+		field.SetBytes(b)
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.Interface:
+		field.Set(reflect.ValueOf(src))
+	case reflect.String:
+		str, err := StringFromInterface(src)
+		if err != nil {
+			return err
+		}
+		field.SetString(str)
+	case reflect.Bool:
+		b, err := BoolFromInterface(src)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := IntFromInterface(src)
+		if err != nil {
+			return err
+		}
+		if field.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %v", i, field.Type())
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := IntFromInterface(src)
+		if err != nil {
+			return err
+		}
+		if i < 0 || field.OverflowUint(uint64(i)) {
+			return fmt.Errorf("%d overflows %v", i, field.Type())
+		}
+		field.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, err := FloatFromInterface(src)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Struct:
+		cmds, ok := src.([]Command)
+		if !ok {
+			return fmt.Errorf("the value should be single commands, got %T", src)
+		}
+		return decodeStruct(cmds, field)
+	case reflect.Slice:
+		return setSlice(field, src, layout)
+	case reflect.Map:
+		return setMap(field, src, layout)
+	default:
+		return fmt.Errorf("type %v is not supported", field.Type())
+	}
+	return nil
+}
+
+func setSlice(field reflect.Value, src interface{}, layout string) error {
+	var items []interface{}
+	if cmds, ok := src.([]Command); ok {
+		for _, cmd := range cmds {
+			items = append(items, cmd.Value)
+		}
+	} else if maps, ok := src.([]map[string]interface{}); ok {
+		for _, m := range maps {
+			items = append(items, m)
+		}
+	} else {
+		var err error
+		if items, err = ArrayFromInterface(src); err != nil {
+			return err
+		}
+	}
+
+	slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+	for i, item := range items {
+		if err := setField(slice.Index(i), item, layout); err != nil {
+			return fmt.Errorf("item %d: %v", i, err)
+		}
+	}
+	field.Set(slice)
+	return nil
+}
+
+func setMap(field reflect.Value, src interface{}, layout string) error {
+	if field.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("type %v is not supported, the key should be string", field.Type())
+	}
+
+	items := map[string]interface{}{}
+	if cmds, ok := src.([]Command); ok {
+		for _, cmd := range cmds {
+			items[cmd.Name] = cmd.Value
+		}
+	} else {
+		var err error
+		if items, err = MapFromInterface(src); err != nil {
+			return err
+		}
+	}
+
+	m := reflect.MakeMapWithSize(field.Type(), len(items))
+	for key, item := range items {
+		elem := reflect.New(field.Type().Elem()).Elem()
+		if err := setField(elem, item, layout); err != nil {
+			return fmt.Errorf("item %s: %v", key, err)
+		}
+		m.SetMapIndex(reflect.ValueOf(key).Convert(field.Type().Key()), elem)
+	}
+	field.Set(m)
+	return nil
+}
@@ -0,0 +1,176 @@
+package driver
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testRule struct {
+	Column string  `json:"column" etlx:"required"`
+	Min    float64 `json:"min"`
+}
+
+type testConfig struct {
+	Table    string              `json:"table" etlx:"required"`
+	Limit    int                 `json:"limit" etlx:"default=100"`
+	Keys     []string            `json:"keys" etlx:"default=[\"id\"]"`
+	Since    time.Time           `json:"since" etlx:"layout=2006/01/02,default=2016/01/02"`
+	Timeout  time.Duration       `json:"timeout,omitempty"`
+	Price    Decimal             `json:"price"`
+	Opts     map[string]string   `json:"opts,omitempty"`
+	Rules    []testRule          `json:"rules"`
+	Named    map[string]testRule `json:"named,omitempty"`
+	Inner    testRule            `json:"inner"`
+	Raw      json.RawMessage     `json:"raw,omitempty"`
+	Ptr      *int                `json:"ptr"`
+	Ignored  string              `json:"-"`
+	internal int
+}
+
+func TestCommandConstruction(t *testing.T) {
+	for _, c := range []struct {
+		src  interface{}
+		typ  string
+		want interface{}
+	}{
+		{"a", "string", "a"},
+		{7, "int", int64(7)},
+		{1.5, "float", 1.5},
+		{true, "bool", true},
+		{[]string{"a", "b"}, "list", []interface{}{"a", "b"}},
+		{[]int{1}, "list", []interface{}{float64(1)}},
+		{map[string]interface{}{"a": 1}, "json", map[string]interface{}{"a": float64(1)}},
+		{[]map[string]interface{}{{"a": 1}}, "jsonarray", nil},
+		{json.RawMessage(`{"q":1}`), "raw", `{"q":1}`},
+		{time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC), "string", "2017-03-04T05:06:07Z"},
+		{testRule{Column: "a", Min: 1}, "single", nil},
+		{[]testRule{{Column: "a"}}, "complex", nil},
+	} {
+		cmd := Command{Name: "x"}
+		if err := cmd.Construction(c.src); err != nil {
+			t.Errorf("Construction(%v): %v", c.src, err)
+			continue
+		}
+		if cmd.Name != "x" || cmd.Type != c.typ {
+			t.Errorf("Construction(%v) = %s of %s, want x of %s", c.src, cmd.Name, cmd.Type, c.typ)
+		}
+		if c.want != nil && !reflect.DeepEqual(cmd.Value, c.want) {
+			t.Errorf("Construction(%v) value = %#v, want %#v", c.src, cmd.Value, c.want)
+		}
+	}
+
+	var p *int
+	cmd := Command{Name: "x"}
+	if err := cmd.Construction(p); err == nil {
+		t.Error("Construction(nil) succeeded")
+	}
+}
+
+func TestCommandsRoundTrip(t *testing.T) {
+	p := 7
+	price, err := ParseDecimal("12.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := testConfig{
+		Table:   "t",
+		Limit:   5,
+		Keys:    []string{"a"},
+		Since:   time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC),
+		Timeout: 90 * time.Second,
+		Price:   price,
+		Opts:    map[string]string{"x": "y"},
+		Rules:   []testRule{{"a", 1}, {"b", 2}},
+		Named:   map[string]testRule{"n": {"c", 3}},
+		Inner:   testRule{"i", 4},
+		Raw:     json.RawMessage(`{"q":1}`),
+		Ptr:     &p,
+		Ignored: "ignored",
+	}
+	cmds, err := CommandsFromStruct(&src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range cmds {
+		if cmd.Name == "Ignored" || cmd.Name == "internal" {
+			t.Errorf("the field %s is a command", cmd.Name)
+		}
+	}
+
+	//the commands are read from a job file
+	b, err := json.Marshal(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back []Command
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	var dst testConfig
+	if err := DecodeCommands(back, &dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if !dst.Since.Equal(src.Since) {
+		t.Errorf("Since = %v, want %v", dst.Since, src.Since)
+	}
+	if dst.Price.Cmp(src.Price) != 0 {
+		t.Errorf("Price = %v, want %v", dst.Price, src.Price)
+	}
+	if dst.Ptr == nil || *dst.Ptr != p {
+		t.Errorf("Ptr = %v, want %d", dst.Ptr, p)
+	}
+	if string(dst.Raw) != string(src.Raw) {
+		t.Errorf("Raw = %s, want %s", dst.Raw, src.Raw)
+	}
+	dst.Since, dst.Price, dst.Ptr, dst.Raw = src.Since, src.Price, src.Ptr, src.Raw
+	src.Ignored = ""
+	if !reflect.DeepEqual(dst, src) {
+		t.Errorf("DecodeCommands = %+v, want %+v", dst, src)
+	}
+}
+
+func TestDecodeCommandsDefaults(t *testing.T) {
+	var cfg testConfig
+	err := DecodeCommands([]Command{{Name: "table", Value: "x"}, {Name: "limit", Value: "42"}, {Name: "timeout", Value: 30}}, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Table != "x" || cfg.Limit != 42 || cfg.Timeout != 30*time.Second {
+		t.Errorf("DecodeCommands = %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Keys, []string{"id"}) {
+		t.Errorf("default Keys = %v, want [id]", cfg.Keys)
+	}
+	if want := time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC); !cfg.Since.Equal(want) {
+		t.Errorf("default Since = %v, want %v", cfg.Since, want)
+	}
+}
+
+func TestDecodeCommandsErrors(t *testing.T) {
+	for _, c := range []struct {
+		cmds []Command
+		want string
+	}{
+		{[]Command{{Name: "table", Value: "x"}, {Name: "limit", Value: "x"}}, "Command(limit)"},
+		{[]Command{{Name: "table", Value: "x"}, {Name: "bogus", Value: "x"}}, "Command(bogus) is not supported"},
+		{[]Command{{Name: "limit", Value: 1}}, "Command(table) is required"},
+		{[]Command{{Name: "table", Value: "x"}, {Name: "rules", Type: "complex", Value: []Command{
+			{Name: "0", Type: "single", Value: []Command{{Name: "min", Value: 1}}},
+		}}}, "item 0: Command(column) is required"},
+	} {
+		var cfg testConfig
+		err := DecodeCommands(c.cmds, &cfg)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("DecodeCommands(%v) error = %v, want %q", c.cmds, err, c.want)
+		}
+	}
+
+	var cfg testConfig
+	if err := DecodeCommands(nil, cfg); err == nil {
+		t.Error("DecodeCommands to a struct value succeeded")
+	}
+}
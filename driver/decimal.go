@@ -0,0 +1,437 @@
+package driver
+
+import (
+	sqldriver "database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//RoundingMode decides how a Decimal is rounded when digits are dropped.
+type RoundingMode int
+
+const (
+	//RoundHalfUp rounds to the nearest, ties away from zero: 2.5 -> 3, -2.5 -> -3
+	RoundHalfUp RoundingMode = iota
+	//RoundHalfEven rounds to the nearest, ties to the even digit: 2.5 -> 2, 3.5 -> 4
+	RoundHalfEven
+	//RoundHalfDown rounds to the nearest, ties toward zero: 2.5 -> 2, -2.5 -> -2
+	RoundHalfDown
+	//RoundDown truncates toward zero: 2.9 -> 2, -2.9 -> -2
+	RoundDown
+	//RoundUp rounds away from zero: 2.1 -> 3, -2.1 -> -3
+	RoundUp
+	//RoundCeiling rounds toward positive infinity: 2.1 -> 3, -2.9 -> -2
+	RoundCeiling
+	//RoundFloor rounds toward negative infinity: 2.9 -> 2, -2.1 -> -3
+	RoundFloor
+)
+
+var roundingModes = map[string]RoundingMode{
+	"half_up":   RoundHalfUp,
+	"half_even": RoundHalfEven,
+	"half_down": RoundHalfDown,
+	"down":      RoundDown,
+	"up":        RoundUp,
+	"ceiling":   RoundCeiling,
+	"floor":     RoundFloor,
+}
+
+//ParseRoundingMode converts the name of a mode, e.g. "half_even", to RoundingMode.
+func ParseRoundingMode(name string) (RoundingMode, error) {
+	mode, ok := roundingModes[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return RoundHalfUp, fmt.Errorf("The rounding mode(%s) is not supported", name)
+	}
+	return mode, nil
+}
+
+func (m RoundingMode) String() string {
+	for name, mode := range roundingModes {
+		if mode == m {
+			return name
+		}
+	}
+	return strconv.Itoa(int(m))
+}
+
+//Decimal is a fixed-point decimal number, the value is unscaled * 10^-scale.
+//It is immutable, all operations return a new Decimal. The zero value is 0.
+type Decimal struct {
+	unscaled *big.Int
+	scale    int32
+}
+
+var (
+	bigTen        = big.NewInt(10)
+	decimalDigits = regexp.MustCompile(`^\d*$`)
+)
+
+//NewDecimal returns unscaled * 10^-scale, e.g. NewDecimal(12345, 2) is 123.45.
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	return newDecimal(big.NewInt(unscaled), scale)
+}
+
+func newDecimal(unscaled *big.Int, scale int32) Decimal {
+	if scale < 0 {
+		unscaled = new(big.Int).Mul(unscaled, pow10(-scale))
+		scale = 0
+	}
+	return Decimal{unscaled: unscaled, scale: scale}
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+//ParseDecimal parses a decimal string. Comma grouped and scientific notation
+//are supported as ParseFloat, e.g. "1,234.50", "-1.5e3", ".5".
+func ParseDecimal(str string) (Decimal, error) {
+	orig := str
+	str = strings.Replace(strings.TrimSpace(str), ",", "", -1)
+
+	neg := false
+	if str != "" && (str[0] == '+' || str[0] == '-') {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+
+	var exp int64
+	if pos := strings.IndexAny(str, "eE"); pos >= 0 {
+		var err error
+		exp, err = strconv.ParseInt(str[pos+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("%q is not a decimal", orig)
+		}
+		str = str[:pos]
+	}
+
+	intPart, fracPart := str, ""
+	if pos := strings.Index(str, "."); pos >= 0 {
+		intPart, fracPart = str[:pos], str[pos+1:]
+	}
+	if intPart+fracPart == "" || !decimalDigits.MatchString(intPart) || !decimalDigits.MatchString(fracPart) {
+		return Decimal{}, fmt.Errorf("%q is not a decimal", orig)
+	}
+
+	unscaled, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("%q is not a decimal", orig)
+	}
+	if neg {
+		unscaled.Neg(unscaled)
+	}
+	return newDecimal(unscaled, int32(int64(len(fracPart))-exp)), nil
+}
+
+//DecimalFromFloat converts a float by its shortest representation, so 0.1 is
+//exactly 0.1 rather than the binary value.
+func DecimalFromFloat(val float64) (Decimal, error) {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return Decimal{}, fmt.Errorf("%v could not be converted to Decimal", val)
+	}
+	return ParseDecimal(strconv.FormatFloat(val, 'f', -1, 64))
+}
+
+//DecimalFromInterface converts int, float, string, json.Number and []uint8
+//read from sql to Decimal. Strings are parsed as ParseDecimal.
+func DecimalFromInterface(val interface{}) (Decimal, error) {
+	switch v := val.(type) {
+	case Decimal:
+		return v, nil
+	case *Decimal:
+		if v != nil {
+			return *v, nil
+		}
+	case int:
+		return NewDecimal(int64(v), 0), nil
+	case int64:
+		return NewDecimal(v, 0), nil
+	case int32:
+		return NewDecimal(int64(v), 0), nil
+	case float64:
+		return DecimalFromFloat(v)
+	case float32:
+		return ParseDecimal(strconv.FormatFloat(float64(v), 'f', -1, 32))
+	case string:
+		return ParseDecimal(v)
+	case []uint8:
+		return ParseDecimal(string(v))
+	case json.Number:
+		return ParseDecimal(string(v))
+	}
+	return Decimal{}, fmt.Errorf("Interface(%v) could not be converted to Decimal!", val)
+}
+
+func (d Decimal) value() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return d.unscaled
+}
+
+//Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+//Unscaled returns a copy of the unscaled value.
+func (d Decimal) Unscaled() *big.Int {
+	return new(big.Int).Set(d.value())
+}
+
+//Precision returns the number of significant digits of the unscaled value.
+func (d Decimal) Precision() int {
+	str := new(big.Int).Abs(d.value()).String()
+	return len(str)
+}
+
+func (d Decimal) Sign() int {
+	return d.value().Sign()
+}
+
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{unscaled: new(big.Int).Neg(d.value()), scale: d.scale}
+}
+
+//align returns the unscaled values of d and e with the same scale.
+func (d Decimal) align(e Decimal) (*big.Int, *big.Int, int32) {
+	switch {
+	case d.scale > e.scale:
+		return d.value(), new(big.Int).Mul(e.value(), pow10(d.scale-e.scale)), d.scale
+	case d.scale < e.scale:
+		return new(big.Int).Mul(d.value(), pow10(e.scale-d.scale)), e.value(), e.scale
+	}
+	return d.value(), e.value(), d.scale
+}
+
+func (d Decimal) Add(e Decimal) Decimal {
+	a, b, scale := d.align(e)
+	return Decimal{unscaled: new(big.Int).Add(a, b), scale: scale}
+}
+
+func (d Decimal) Sub(e Decimal) Decimal {
+	a, b, scale := d.align(e)
+	return Decimal{unscaled: new(big.Int).Sub(a, b), scale: scale}
+}
+
+func (d Decimal) Mul(e Decimal) Decimal {
+	return Decimal{unscaled: new(big.Int).Mul(d.value(), e.value()), scale: d.scale + e.scale}
+}
+
+//Cmp returns -1, 0 or 1 if d is less than, equal to or greater than e.
+func (d Decimal) Cmp(e Decimal) int {
+	a, b, _ := d.align(e)
+	return a.Cmp(b)
+}
+
+//Rescale returns the decimal with the scale, digits dropped are rounded with the
+//mode. A negative scale rounds to tens, hundreds and so on, the result has scale 0.
+func (d Decimal) Rescale(scale int32, mode RoundingMode) Decimal {
+	if scale >= d.scale {
+		return Decimal{unscaled: new(big.Int).Mul(d.value(), pow10(scale-d.scale)), scale: scale}
+	}
+
+	divisor := pow10(d.scale - scale)
+	neg := d.Sign() < 0
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(d.value()), divisor, new(big.Int))
+
+	inc := false
+	if r.Sign() != 0 {
+		half := new(big.Int).Lsh(r, 1).Cmp(divisor)
+		switch mode {
+		case RoundHalfUp:
+			inc = half >= 0
+		case RoundHalfEven:
+			inc = half > 0 || (half == 0 && q.Bit(0) == 1)
+		case RoundHalfDown:
+			inc = half > 0
+		case RoundDown:
+		case RoundUp:
+			inc = true
+		case RoundCeiling:
+			inc = !neg
+		case RoundFloor:
+			inc = neg
+		}
+	}
+	if inc {
+		q.Add(q, big.NewInt(1))
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return newDecimal(q, scale)
+}
+
+//Round is Rescale to n digits after the decimal point, it never increases the scale.
+func (d Decimal) Round(n int32, mode RoundingMode) Decimal {
+	if n >= d.scale {
+		return d
+	}
+	return d.Rescale(n, mode)
+}
+
+func (d Decimal) String() string {
+	str := new(big.Int).Abs(d.value()).String()
+	if d.scale > 0 {
+		if len(str) <= int(d.scale) {
+			str = strings.Repeat("0", int(d.scale)-len(str)+1) + str
+		}
+		pos := len(str) - int(d.scale)
+		str = str[:pos] + "." + str[pos:]
+	}
+	if d.Sign() < 0 {
+		str = "-" + str
+	}
+	return str
+}
+
+//Float64 returns the nearest float, precision may be lost.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+//Int64 returns the integer rounded with the mode, an error is returned if it
+//overflows int64.
+func (d Decimal) Int64(mode RoundingMode) (int64, error) {
+	v := d.Rescale(0, mode).value()
+	if !v.IsInt64() {
+		return 0, fmt.Errorf("Decimal(%s) overflows int64", d)
+	}
+	return v.Int64(), nil
+}
+
+//MarshalJSON writes the decimal as a json number with all its digits.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+//UnmarshalJSON reads a json number or string.
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	str := strings.TrimSpace(string(b))
+	if str == "null" {
+		*d = Decimal{}
+		return nil
+	}
+	if strings.HasPrefix(str, `"`) {
+		if err := json.Unmarshal(b, &str); err != nil {
+			return err
+		}
+	}
+	dec, err := ParseDecimal(str)
+	if err != nil {
+		return err
+	}
+	*d = dec
+	return nil
+}
+
+//GobEncode and GobDecode let a Decimal be spilled to files by the transforms.
+func (d Decimal) GobEncode() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) GobDecode(b []byte) error {
+	dec, err := ParseDecimal(string(b))
+	if err != nil {
+		return err
+	}
+	*d = dec
+	return nil
+}
+
+//Scan implements sql.Scanner, numeric columns are returned as []uint8 by most
+//sql drivers so no precision is lost.
+func (d *Decimal) Scan(src interface{}) error {
+	if src == nil {
+		return fmt.Errorf("Could not scan NULL to Decimal")
+	}
+	dec, err := DecimalFromInterface(src)
+	if err != nil {
+		return err
+	}
+	*d = dec
+	return nil
+}
+
+//Value implements sql/driver.Valuer, the decimal is sent as a string.
+func (d Decimal) Value() (sqldriver.Value, error) {
+	return d.String(), nil
+}
+
+var decimalTypePattern = regexp.MustCompile(`^decimal\s*(\(\s*(\d+)\s*(,\s*(\d+)\s*)?(,\s*(\w+)\s*)?\))?$`)
+
+//DecimalType is the decimal type string of StrToType: "decimal", "decimal(p)",
+//"decimal(p,s)" or "decimal(p,s,mode)", e.g. "decimal(12,2,half_even)". The
+//precision is the max number of digits, the scale is the number of digits
+//after the point, and the mode is the name of the RoundingMode, half_up by default.
+type DecimalType struct {
+	Precision int
+	Scale     int32
+	HasScale  bool
+	Mode      RoundingMode
+}
+
+//ParseDecimalType parses the decimal type string.
+func ParseDecimalType(typeStr string) (DecimalType, error) {
+	match := decimalTypePattern.FindStringSubmatch(strings.TrimSpace(typeStr))
+	if match == nil {
+		return DecimalType{}, fmt.Errorf("The type(%s) is not a decimal type", typeStr)
+	}
+
+	t := DecimalType{Mode: RoundHalfUp}
+	if match[2] != "" {
+		t.Precision, _ = strconv.Atoi(match[2])
+	}
+	if match[4] != "" {
+		scale, _ := strconv.Atoi(match[4])
+		t.Scale, t.HasScale = int32(scale), true
+		if t.Precision > 0 && scale > t.Precision {
+			return DecimalType{}, fmt.Errorf("Scale of %s is greater than the precision", typeStr)
+		}
+	}
+	if match[6] != "" {
+		mode, err := ParseRoundingMode(match[6])
+		if err != nil {
+			return DecimalType{}, err
+		}
+		t.Mode = mode
+	}
+	return t, nil
+}
+
+//Convert converts the value to a Decimal of the type, an error is returned if
+//it has more digits than the precision after rounded to the scale.
+func (t DecimalType) Convert(val interface{}) (Decimal, error) {
+	d, err := DecimalFromInterface(val)
+	if err != nil {
+		return Decimal{}, err
+	}
+	if t.HasScale {
+		d = d.Rescale(t.Scale, t.Mode)
+	}
+	if t.Precision > 0 && d.Precision() > t.Precision {
+		return Decimal{}, fmt.Errorf("Decimal(%s) overflows precision %d", d, t.Precision)
+	}
+	return d, nil
+}
+
+//RoundFloat rounds the decimal value of src to n digits after the point with the
+//mode. Unlike Round it rounds 2.675 to 2.68 as written rather than its binary value.
+func RoundFloat(src float64, n int, mode RoundingMode) float64 {
+	d, err := DecimalFromFloat(src)
+	if err != nil {
+		return src
+	}
+	return d.Round(int32(n), mode).Float64()
+}
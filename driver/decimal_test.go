@@ -0,0 +1,223 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+)
+
+func mustDecimal(t *testing.T, str string) Decimal {
+	d, err := ParseDecimal(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		scale int32
+	}{
+		{"123.45", "123.45", 2},
+		{"-12.30", "-12.30", 2},
+		{"+7", "7", 0},
+		{".5", "0.5", 1},
+		{"1,234.50", "1234.50", 2},
+		{"1.5e3", "1500", 0},
+		{"-1.5E-3", "-0.0015", 4},
+		{" 0.001 ", "0.001", 3},
+	}
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.in)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q) returned %v", tt.in, err)
+			continue
+		}
+		if d.String() != tt.want || d.Scale() != tt.scale {
+			t.Errorf("ParseDecimal(%q) = %s scale %d, want %s scale %d", tt.in, d, d.Scale(), tt.want, tt.scale)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "1.2.3", "1e", "--1", "."} {
+		if _, err := ParseDecimal(in); err == nil {
+			t.Errorf("ParseDecimal(%q) should fail", in)
+		}
+	}
+}
+
+func TestDecimalRescale(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int32
+		mode  RoundingMode
+		want  string
+	}{
+		{"2.5", 0, RoundHalfUp, "3"},
+		{"-2.5", 0, RoundHalfUp, "-3"},
+		{"2.5", 0, RoundHalfEven, "2"},
+		{"3.5", 0, RoundHalfEven, "4"},
+		{"2.5", 0, RoundHalfDown, "2"},
+		{"2.51", 0, RoundHalfDown, "3"},
+		{"-2.9", 0, RoundDown, "-2"},
+		{"2.1", 0, RoundUp, "3"},
+		{"-2.9", 0, RoundCeiling, "-2"},
+		{"2.1", 0, RoundCeiling, "3"},
+		{"-2.1", 0, RoundFloor, "-3"},
+		{".005", 2, RoundHalfUp, "0.01"},
+		{"-0.004", 2, RoundHalfUp, "0.00"},
+		{"1.5", 3, RoundHalfUp, "1.500"},
+		{"1255.5", -1, RoundHalfUp, "1260"},
+		{"1255.5", -2, RoundDown, "1200"},
+	}
+	for _, tt := range tests {
+		if got := mustDecimal(t, tt.in).Rescale(tt.scale, tt.mode); got.String() != tt.want {
+			t.Errorf("%s.Rescale(%d, %s) = %s, want %s", tt.in, tt.scale, tt.mode, got, tt.want)
+		}
+	}
+
+	if got := mustDecimal(t, "1.5").Round(3, RoundHalfUp); got.String() != "1.5" {
+		t.Errorf("Round never increases the scale, got %s", got)
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	a, b := mustDecimal(t, "0.1"), mustDecimal(t, "1.25")
+	if got := a.Add(a).Add(a); got.Cmp(NewDecimal(3, 1)) != 0 {
+		t.Errorf("0.1 + 0.1 + 0.1 = %s, want 0.3", got)
+	}
+	if got := b.Sub(a); got.String() != "1.15" {
+		t.Errorf("1.25 - 0.1 = %s", got)
+	}
+	if got := b.Mul(b); got.String() != "1.5625" {
+		t.Errorf("1.25 * 1.25 = %s", got)
+	}
+	if a.Cmp(b) != -1 || b.Cmp(a) != 1 || a.Neg().Sign() != -1 {
+		t.Error("Cmp or Neg is wrong")
+	}
+	if p := mustDecimal(t, "-12.30").Precision(); p != 4 {
+		t.Errorf("Precision(-12.30) = %d, want 4", p)
+	}
+	var zero Decimal
+	if !zero.IsZero() || zero.String() != "0" {
+		t.Errorf("the zero value is %s", zero)
+	}
+	if i, err := b.Int64(RoundHalfUp); err != nil || i != 1 {
+		t.Errorf("Int64(1.25) = %d, %v", i, err)
+	}
+	if _, err := mustDecimal(t, "1e30").Int64(RoundHalfUp); err == nil {
+		t.Error("Int64(1e30) should overflow")
+	}
+}
+
+func TestDecimalFromInterface(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{1, "1"},
+		{int64(2), "2"},
+		{1.5, "1.5"},
+		{0.1, "0.1"},
+		{"3.25", "3.25"},
+		{json.Number("4.5"), "4.5"},
+		{[]uint8("7.1"), "7.1"},
+		{NewDecimal(12345, 2), "123.45"},
+	}
+	for _, tt := range tests {
+		d, err := DecimalFromInterface(tt.in)
+		if err != nil || d.String() != tt.want {
+			t.Errorf("DecimalFromInterface(%v) = %s, %v, want %s", tt.in, d, err, tt.want)
+		}
+	}
+	for _, in := range []interface{}{nil, true, "x"} {
+		if _, err := DecimalFromInterface(in); err == nil {
+			t.Errorf("DecimalFromInterface(%v) should fail", in)
+		}
+	}
+}
+
+func TestDecimalType(t *testing.T) {
+	tests := []struct {
+		typ  string
+		in   interface{}
+		want string
+		err  bool
+	}{
+		{"decimal", "1.005", "1.005", false},
+		{"decimal(5,2)", "123.456", "123.46", false},
+		{"decimal(5,2,half_even)", "12.345", "12.34", false},
+		{"decimal(5,2,floor)", -1.001, "-1.01", false},
+		{"decimal(4,2)", "123.4", "", true},
+		{"decimal(2,3)", "1", "", true},
+		{"decimal(5,2,sideways)", "1", "", true},
+	}
+	for _, tt := range tests {
+		got, err := StrToType(tt.typ, tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("StrToType(%s, %v) should fail, got %v", tt.typ, tt.in, got)
+			}
+			continue
+		}
+		if d, ok := got.(Decimal); err != nil || !ok || d.String() != tt.want {
+			t.Errorf("StrToType(%s, %v) = %v, %v, want %s", tt.typ, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecimalEncoding(t *testing.T) {
+	d := mustDecimal(t, "-12.30")
+	b, err := json.Marshal(map[string]interface{}{"d": d})
+	if err != nil || string(b) != `{"d":-12.30}` {
+		t.Errorf("json.Marshal = %s, %v", b, err)
+	}
+	var x struct{ D, E, N Decimal }
+	if err := json.Unmarshal([]byte(`{"D":"99.01","E":1.10,"N":null}`), &x); err != nil {
+		t.Fatal(err)
+	}
+	if x.D.String() != "99.01" || x.E.String() != "1.10" || !x.N.IsZero() {
+		t.Errorf("json.Unmarshal = %v", x)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(d); err != nil {
+		t.Fatal(err)
+	}
+	var back Decimal
+	if err := gob.NewDecoder(&buf).Decode(&back); err != nil || back.String() != d.String() {
+		t.Errorf("gob decoded %s, %v", back, err)
+	}
+
+	var cmds []Command
+	if err := json.Unmarshal([]byte(`[{"name":"a","type":"decimal(30,2)","value":12345678901234567890.125}]`), &cmds); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := cmds[0].Value.(Decimal); !ok || d.String() != "12345678901234567890.13" {
+		t.Errorf("command value %v (%T) lost the precision", cmds[0].Value, cmds[0].Value)
+	}
+}
+
+func TestRoundFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		n    int
+		mode RoundingMode
+		want float64
+	}{
+		{2.675, 2, RoundHalfUp, 2.68},
+		{2.665, 2, RoundHalfEven, 2.66},
+		{1255.5, -1, RoundHalfUp, 1260},
+		{-1.5, 0, RoundDown, -1},
+	}
+	for _, tt := range tests {
+		if got := RoundFloat(tt.in, tt.n, tt.mode); got != tt.want {
+			t.Errorf("RoundFloat(%v, %d, %s) = %v, want %v", tt.in, tt.n, tt.mode, got, tt.want)
+		}
+	}
+	if _, err := ParseRoundingMode("sideways"); err == nil {
+		t.Error("ParseRoundingMode(sideways) should fail")
+	}
+}
@@ -48,6 +48,25 @@ type Transform interface {
 	Close() error
 }
 
+//Interface of transform handler which needs to see all batches before it
+//could produce the results, e.g. aggregation. In batch mode Transaction calls
+//Accumulate for every batch and loads the results if it is not nil, then calls
+//Flush once all batches are extracted and loads the final results.
+//When batch is disabled, Exec is used as usual.
+type Accumulator interface {
+	Accumulate(src Rows, cmd interface{}) (Results, error)
+	Flush(cmd interface{}) (Results, error)
+}
+
+//Interface of Accumulator whose results depend on the order of the rows, e.g.
+//first and last. The batches are accumulated concurrently in any order, so
+//Transaction passes the offset of the batch, the number of the rows extracted
+//before it, to order the rows as they are in the source.
+type OrderedAccumulator interface {
+	Accumulator
+	AccumulateAt(src Rows, cmd interface{}, offset int64) (Results, error)
+}
+
 //Interface of load handler
 type Load interface {
 	Command(args []Command) (cmd interface{}, _ error)
@@ -0,0 +1,344 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//Expander expands the variables and templates in command values and data
+//sources, so a job could run with different parameters without generating
+//the json for every run.
+//
+//${name} is replaced by the parameter, ${env.NAME} by the environment
+//variable, ${secret.name} by the secret and ${run_date} by the run date. A
+//time value could be shifted and formatted, e.g. ${run_date - 1d | 20060102},
+//the units are s, m, h, d, w, M (month) and y. ${name:-value} is value if name
+//is not defined, $${ is a literal ${. It is an error if a variable is not
+//defined and has no default.
+//
+//A string containing {{ is executed as Go text/template firstly, the data is
+//the parameters with run_date, and the funcs are env, secret, add and date:
+//	{{ .run_date | add "-1d" | date "2006/01/02" }} {{ env "HOME" }}
+type Expander struct {
+	Params map[string]string
+	//RunDate is the value of run_date, formatted as 2006-01-02 by default.
+	RunDate time.Time
+	//LookupEnv returns the environment variable, os.LookupEnv by default.
+	LookupEnv func(name string) (string, bool)
+	//Secret returns the secret by the name, secrets are not supported if nil.
+	Secret func(name string) (string, error)
+}
+
+//NewExpander returns an expander of the parameters, the run date is today.
+func NewExpander(params map[string]string) *Expander {
+	now := time.Now()
+	return &Expander{
+		Params:    params,
+		RunDate:   time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()),
+		LookupEnv: os.LookupEnv,
+	}
+}
+
+//HasPlaceholder reports whether the string contains any variable or template.
+func HasPlaceholder(str string) bool {
+	return strings.Contains(str, "${") || strings.Contains(str, "{{")
+}
+
+var (
+	varExpr   = regexp.MustCompile(`^([A-Za-z_][\w.]*)\s*((?:[+-]\s*\d+\s*[A-Za-z]+\s*)*)$`)
+	timeShift = regexp.MustCompile(`([+-])\s*(\d+)\s*([A-Za-z]+)`)
+)
+
+//ShiftTime adds the deltas to t, e.g. "-1d", "+2M -3h". The units are s, m,
+//h, d, w, M (month) and y.
+func ShiftTime(t time.Time, deltas string) (time.Time, error) {
+	rest := strings.TrimSpace(deltas)
+	if rest != "" && rest[0] != '+' && rest[0] != '-' {
+		rest = "+" + rest
+	}
+	for _, m := range timeShift.FindAllStringSubmatch(rest, -1) {
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return t, err
+		}
+		if m[1] == "-" {
+			n = -n
+		}
+		switch m[3] {
+		case "s":
+			t = t.Add(time.Duration(n) * time.Second)
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		case "M":
+			t = t.AddDate(0, n, 0)
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		default:
+			return t, fmt.Errorf("unknown time unit %s", m[3])
+		}
+		rest = strings.TrimSpace(strings.Replace(rest, m[0], "", 1))
+	}
+	if rest != "" {
+		return t, fmt.Errorf("invalid time delta %q", deltas)
+	}
+	return t, nil
+}
+
+//Expand expands the templates and then the variables of the string. The
+//values of variables are not expanded again.
+func (e *Expander) Expand(str string) (string, error) {
+	if strings.Contains(str, "{{") {
+		var err error
+		if str, err = e.execTemplate(str); err != nil {
+			return "", err
+		}
+	}
+	if !strings.Contains(str, "${") {
+		return str, nil
+	}
+
+	var b strings.Builder
+	for {
+		pos := strings.Index(str, "${")
+		if pos < 0 {
+			b.WriteString(str)
+			break
+		}
+		if pos > 0 && str[pos-1] == '$' {
+			b.WriteString(str[:pos-1] + "${")
+			str = str[pos+2:]
+			continue
+		}
+		end := strings.Index(str[pos:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unclosed ${ in %q", str)
+		}
+		val, err := e.resolve(str[pos+2 : pos+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(str[:pos])
+		b.WriteString(val)
+		str = str[pos+end+1:]
+	}
+	return b.String(), nil
+}
+
+//resolve returns the value of the expression in ${}.
+func (e *Expander) resolve(src string) (string, error) {
+	expr, def, hasDef := src, "", false
+	if pos := strings.Index(src, ":-"); pos >= 0 {
+		expr, def, hasDef = src[:pos], src[pos+2:], true
+	}
+	layout := ""
+	if pos := strings.Index(expr, "|"); pos >= 0 {
+		expr, layout = expr[:pos], strings.TrimSpace(expr[pos+1:])
+	}
+
+	m := varExpr.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return "", fmt.Errorf("invalid variable ${%s}", src)
+	}
+	name, deltas := m[1], strings.TrimSpace(m[2])
+
+	val, ok, err := e.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		if hasDef {
+			return def, nil
+		}
+		return "", fmt.Errorf("variable %s is not defined", name)
+	}
+	if deltas == "" && layout == "" {
+		return val, nil
+	}
+
+	//the value is a time to shift or format
+	t := e.RunDate
+	if name != "run_date" {
+		if t, err = NewTimeParser().Parse(val); err != nil {
+			return "", fmt.Errorf("variable %s is not a time: %v", name, err)
+		}
+	}
+	if t, err = ShiftTime(t, deltas); err != nil {
+		return "", fmt.Errorf("${%s}: %v", src, err)
+	}
+	if layout == "" {
+		layout = defaultLayout(t)
+	}
+	return t.Format(layout), nil
+}
+
+func defaultLayout(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		return "2006-01-02"
+	}
+	return time.RFC3339
+}
+
+func (e *Expander) lookup(name string) (string, bool, error) {
+	switch {
+	case name == "run_date":
+		return e.RunDate.Format(defaultLayout(e.RunDate)), true, nil
+	case strings.HasPrefix(name, "env."):
+		lookupEnv := e.LookupEnv
+		if lookupEnv == nil {
+			lookupEnv = os.LookupEnv
+		}
+		val, ok := lookupEnv(strings.TrimPrefix(name, "env."))
+		return val, ok, nil
+	case strings.HasPrefix(name, "secret."):
+		val, err := e.secret(strings.TrimPrefix(name, "secret."))
+		return val, err == nil, err
+	}
+	val, ok := e.Params[name]
+	return val, ok, nil
+}
+
+func (e *Expander) secret(name string) (string, error) {
+	if e.Secret == nil {
+		return "", fmt.Errorf("secret %s: no secret provider", name)
+	}
+	return e.Secret(name)
+}
+
+func (e *Expander) execTemplate(str string) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Funcs(template.FuncMap{
+		"env": func(name string) string {
+			val, _, _ := e.lookup("env." + name)
+			return val
+		},
+		"secret": e.secret,
+		"add": func(deltas string, t time.Time) (time.Time, error) {
+			return ShiftTime(t, deltas)
+		},
+		"date": func(layout string, t time.Time) string {
+			return t.Format(layout)
+		},
+	}).Parse(str)
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]interface{}{"run_date": e.RunDate}
+	for k, v := range e.Params {
+		data[k] = v
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+//ExpandCommands returns a copy of the commands with the strings expanded in
+//the values, including the ones in complex and json values, and in the args.
+//A string value of an int, float, bool, time or decimal command is converted
+//to the type after expansion.
+func (e *Expander) ExpandCommands(cmds []Command) ([]Command, error) {
+	rslt := make([]Command, len(cmds))
+	for i, cmd := range cmds {
+		val, err := e.expandValue(cmd.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Command(%s): %v", cmd.Name, err)
+		}
+		if str, ok := cmd.Value.(string); ok && HasPlaceholder(str) && isScalarType(cmd.Type) {
+			convert, err := converterOf(cmd.Locale)
+			if err != nil {
+				return nil, fmt.Errorf("Command(%s): %v", cmd.Name, err)
+			}
+			if val, err = convert(cmd.Type, val); err != nil {
+				return nil, fmt.Errorf("Command(%s): %v", cmd.Name, err)
+			}
+		}
+		cmd.Value = val
+
+		if cmd.Arg != nil {
+			arg, err := e.expandRaw(*cmd.Arg)
+			if err != nil {
+				return nil, fmt.Errorf("Command(%s): arg: %v", cmd.Name, err)
+			}
+			cmd.Arg = &arg
+		}
+		rslt[i] = cmd
+	}
+	return rslt, nil
+}
+
+func isScalarType(typ string) bool {
+	switch typ {
+	case "int", "float", "bool", "time":
+		return true
+	}
+	return strings.HasPrefix(typ, "decimal")
+}
+
+func (e *Expander) expandValue(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case string:
+		return e.Expand(v)
+	case []Command:
+		return e.ExpandCommands(v)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if items[i], err = e.expandValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case map[string]interface{}:
+		items := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			var err error
+			if items[k], err = e.expandValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case []map[string]interface{}:
+		items := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			m, err := e.expandValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = m.(map[string]interface{})
+		}
+		return items, nil
+	}
+	return val, nil
+}
+
+//expandRaw expands the strings in the json, or the whole text if it is not json.
+func (e *Expander) expandRaw(raw json.RawMessage) (json.RawMessage, error) {
+	if !HasPlaceholder(string(raw)) {
+		return raw, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		str, err := e.Expand(string(raw))
+		return json.RawMessage(str), err
+	}
+	doc, err := e.expandValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
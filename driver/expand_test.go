@@ -0,0 +1,132 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testExpander() *Expander {
+	e := NewExpander(map[string]string{"region": "cn", "day": "2024-03-01", "n": "10"})
+	e.RunDate = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	e.LookupEnv = func(name string) (string, bool) { return "/home/x", name == "HOME" }
+	e.Secret = func(name string) (string, error) {
+		if name != "db" {
+			return "", fmt.Errorf("secret %s not found", name)
+		}
+		return "p{{w}}", nil
+	}
+	return e
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"${region}/${run_date}", "cn/2024-03-01"},
+		{"${run_date - 1d}", "2024-02-29"},
+		{"${run_date - 1M | 20060102}", "20240201"},
+		{"${run_date + 1y -2h | 2006-01-02 15:04}", "2025-02-28 22:00"},
+		{"${day + 1w}", "2024-03-08"},
+		{"${missing:-x}", "x"},
+		{"${region:-x}", "cn"},
+		{"$${region}", "${region}"},
+		{"${env.HOME}", "/home/x"},
+		//the values are not expanded again
+		{"pw=${secret.db}", "pw=p{{w}}"},
+		{`{{ .run_date | add "-1d" | date "2006/01/02" }}-{{ .region }}`, "2024/02/29-cn"},
+		{`{{ env "HOME" }}`, "/home/x"},
+	}
+	e := testExpander()
+	for _, tt := range tests {
+		got, err := e.Expand(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("Expand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	e := testExpander()
+	for _, in := range []string{"${nope}", "${region", "${secret.other}", "${region - 1d}", "${run_date - 1q}", "{{ .region"} {
+		if got, err := e.Expand(in); err == nil {
+			t.Errorf("Expand(%q) = %q, should fail", in, got)
+		}
+	}
+
+	e.Secret = nil
+	if _, err := e.Expand("${secret.db}"); err == nil {
+		t.Error("secrets should not be supported without Secret")
+	}
+}
+
+func TestShiftTime(t *testing.T) {
+	base := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		deltas string
+		want   time.Time
+	}{
+		{"", base},
+		{"-1d", base.AddDate(0, 0, -1)},
+		{"1w", base.AddDate(0, 0, 7)},
+		{"+1M", base.AddDate(0, 1, 0)},
+		{"-1y +2h -30m +15s", base.AddDate(-1, 0, 0).Add(2*time.Hour - 30*time.Minute + 15*time.Second)},
+	}
+	for _, tt := range tests {
+		got, err := ShiftTime(base, tt.deltas)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ShiftTime(%q) = %v, %v, want %v", tt.deltas, got, err, tt.want)
+		}
+	}
+	for _, deltas := range []string{"1q", "-1d x", "tomorrow"} {
+		if _, err := ShiftTime(base, deltas); err == nil {
+			t.Errorf("ShiftTime(%q) should fail", deltas)
+		}
+	}
+}
+
+func TestExpandCommands(t *testing.T) {
+	var cmds []Command
+	src := `[{"name":"limit","type":"int","value":"${n}"},
+	{"name":"where","type":"string","value":"d='${run_date}'"},
+	{"name":"rules","type":"complex","value":[{"name":"a","type":"json","value":{"k":"${region}","l":["${n}"]}}]},
+	{"name":"x","type":"string","value":"v","arg":{"p":"${region}"}}]`
+	if err := json.Unmarshal([]byte(src), &cmds); err != nil {
+		t.Fatal(err)
+	}
+	out, err := testExpander().ExpandCommands(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limit, err := IntFromInterface(out[0].Value); err != nil || limit != 10 {
+		t.Errorf("limit is %v (%T), want 10", out[0].Value, out[0].Value)
+	}
+	if out[1].Value != "d='2024-03-01'" {
+		t.Errorf("where is %v", out[1].Value)
+	}
+	rule := out[2].Value.([]Command)[0].Value.(map[string]interface{})
+	if rule["k"] != "cn" || rule["l"].([]interface{})[0] != "10" {
+		t.Errorf("rule is %v", rule)
+	}
+	if string(*out[3].Arg) != `{"p":"cn"}` {
+		t.Errorf("arg is %s", *out[3].Arg)
+	}
+	if cmds[1].Value != "d='${run_date}'" {
+		t.Error("the original commands are changed")
+	}
+
+	if _, err := testExpander().ExpandCommands([]Command{{Name: "x", Value: "${nope}"}}); err == nil {
+		t.Error("an undefined variable of a command should fail")
+	}
+}
+
+func TestHasPlaceholder(t *testing.T) {
+	for in, want := range map[string]bool{"a": false, "${a}": true, "{{ .a }}": true, "$a": false} {
+		if HasPlaceholder(in) != want {
+			t.Errorf("HasPlaceholder(%q) = %v", in, !want)
+		}
+	}
+}
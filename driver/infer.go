@@ -0,0 +1,229 @@
+package driver
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	intPattern   = regexp.MustCompile(`^[+-]?(\d{1,3}(,\d{3})+|\d+)$`)
+	floatPattern = regexp.MustCompile(`^[+-]?((\d{1,3}(,\d{3})+|\d+)(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+	//numbers with leading zeros are codes, e.g. zip codes and ids
+	leadingZeroPattern = regexp.MustCompile(`^[+-]?0\d[\d,]*(\.\d*)?$`)
+)
+
+//InferLayouts are the time layouts tried by GuessType in order.
+var InferLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02 15:04",
+	"01/02/2006",
+	"2006年1月2日",
+	"2006年01月02日",
+	"15:04:05",
+}
+
+//GuessType guesses the logical type of a value. A string is checked as int,
+//float (comma grouped and scientific notation as ParseFloat, numbers with
+//leading zeros such as "007" are strings so the zeros are kept), bool (the values
+//BoolFromInterface accepts except "0" and "1"), time with the layout returned,
+//geometry, map and array in order, and it is string if none of them matched.
+//TypeAny is returned for nil and empty string.
+func GuessType(val interface{}) (typ LogicalType, layout string) {
+	switch v := val.(type) {
+	case nil:
+		return TypeAny, ""
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return TypeInt, ""
+	case float32, float64:
+		return TypeFloat, ""
+	case Decimal, *Decimal:
+		return TypeDecimal, ""
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return TypeInt, ""
+		}
+		return TypeFloat, ""
+	case bool:
+		return TypeBool, ""
+	case time.Time:
+		return TypeTime, ""
+	case Geometry, *Geometry:
+		return TypeGeometry, ""
+	case map[string]interface{}:
+		if _, err := GeometryFromInterface(v); err == nil {
+			return TypeGeometry, ""
+		}
+		return TypeMap, ""
+	case []interface{}:
+		return TypeArray, ""
+	case []uint8:
+		return GuessType(string(v))
+	case string:
+		return guessString(v)
+	}
+	return TypeAny, ""
+}
+
+func guessString(val string) (LogicalType, string) {
+	str := strings.TrimSpace(val)
+	if str == "" {
+		return TypeAny, ""
+	}
+
+	if leadingZeroPattern.MatchString(str) {
+		return TypeString, ""
+	}
+	if intPattern.MatchString(str) {
+		return TypeInt, ""
+	}
+	if floatPattern.MatchString(str) {
+		if _, err := ParseFloat(str); err == nil {
+			return TypeFloat, ""
+		}
+	}
+	if _, err := BoolFromInterface(str); err == nil {
+		return TypeBool, ""
+	}
+	if layout := guessLayout(str); layout != "" {
+		return TypeTime, layout
+	}
+
+	switch str[0] {
+	case '{':
+		if strings.Contains(str, "coordinates") {
+			if _, err := GeometryFromInterface(str); err == nil {
+				return TypeGeometry, ""
+			}
+		}
+		if _, err := MapFromInterface(str); err == nil {
+			return TypeMap, ""
+		}
+	case '[':
+		var arr []interface{}
+		if err := json.Unmarshal([]byte(str), &arr); err == nil {
+			return TypeArray, ""
+		}
+	}
+	return TypeString, ""
+}
+
+func guessLayout(str string) string {
+	for _, layout := range InferLayouts {
+		if _, err := TimeFromInterface(str, layout); err == nil {
+			return layout
+		}
+	}
+	return ""
+}
+
+type columnGuess struct {
+	nullable bool
+	types    map[LogicalType]int
+	times    []string
+	layouts  map[string]int
+}
+
+//InferSchema reads at most sampleSize rows (all rows if sampleSize <= 0) and
+//guesses the schema. A column is:
+//	float if it has both int and float values;
+//	time if all its values could be parsed with the same layout;
+//	string if it has values of different types;
+//	any if it has no value at all, its type is unknown and it is nullable.
+//A column is nullable if any null or empty value is found.
+func InferSchema(rows Rows, sampleSize int) (Schema, error) {
+	columns := rows.Columns()
+	guesses := make([]*columnGuess, len(columns))
+	for i := range guesses {
+		guesses[i] = &columnGuess{types: make(map[LogicalType]int), layouts: make(map[string]int)}
+	}
+
+	for n := 0; sampleSize <= 0 || n < sampleSize; n++ {
+		row := make([]interface{}, len(columns))
+		if err := rows.Next(row); err != nil {
+			if err == EOT {
+				break
+			}
+			return Schema{}, err
+		}
+
+		for i, val := range row {
+			g := guesses[i]
+			typ, layout := GuessType(val)
+			if typ == TypeAny {
+				g.nullable = true
+				continue
+			}
+			g.types[typ]++
+			if layout != "" {
+				str, _ := StringFromInterface(val)
+				g.times = append(g.times, strings.TrimSpace(str))
+				g.layouts[layout]++
+			}
+		}
+	}
+
+	schema := Schema{Fields: make([]Field, len(columns))}
+	for i, name := range columns {
+		typ, layout := guesses[i].result()
+		nullable := guesses[i].nullable || typ == TypeAny
+		schema.Fields[i] = Field{Name: name, Type: typ, Nullable: nullable, Layout: layout}
+	}
+	return schema, nil
+}
+
+func (g *columnGuess) result() (LogicalType, string) {
+	switch len(g.types) {
+	case 0:
+		return TypeAny, ""
+	case 1:
+		for typ := range g.types {
+			if typ != TypeTime {
+				return typ, ""
+			}
+		}
+		return g.timeLayout()
+	case 2:
+		if g.types[TypeInt] > 0 && g.types[TypeFloat] > 0 {
+			return TypeFloat, ""
+		}
+	}
+	return TypeString, ""
+}
+
+//timeLayout returns the layout which could parse all the values, the one
+//guessed most is tried firstly.
+func (g *columnGuess) timeLayout() (LogicalType, string) {
+	candidates := []string{}
+	for _, layout := range InferLayouts {
+		if g.layouts[layout] > 0 {
+			candidates = append(candidates, layout)
+		}
+	}
+	for i := 1; i < len(candidates); i++ {
+		for j := i; j > 0 && g.layouts[candidates[j]] > g.layouts[candidates[j-1]]; j-- {
+			candidates[j], candidates[j-1] = candidates[j-1], candidates[j]
+		}
+	}
+
+	for _, layout := range candidates {
+		ok := true
+		for _, str := range g.times {
+			if _, err := TimeFromInterface(str, layout); err != nil {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return TypeTime, layout
+		}
+	}
+	return TypeString, ""
+}
@@ -0,0 +1,75 @@
+package driver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGuessType(t *testing.T) {
+	tests := []struct {
+		val    interface{}
+		typ    LogicalType
+		layout string
+	}{
+		{nil, TypeAny, ""},
+		{"  ", TypeAny, ""},
+		{42, TypeInt, ""},
+		{1.5, TypeFloat, ""},
+		{json.Number("12"), TypeInt, ""},
+		{json.Number("1.2"), TypeFloat, ""},
+		{"123", TypeInt, ""},
+		{"-7", TypeInt, ""},
+		{"0", TypeInt, ""},
+		{"1,234,567", TypeInt, ""},
+		{"0.5", TypeFloat, ""},
+		{"-0.5", TypeFloat, ""},
+		{"1.5e3", TypeFloat, ""},
+		{".5", TypeFloat, ""},
+		{"007", TypeString, ""},
+		{"-01", TypeString, ""},
+		{"00.50", TypeString, ""},
+		{"0123,456", TypeString, ""},
+		{"true", TypeBool, ""},
+		{"1", TypeInt, ""},
+		{"2016-01-02", TypeTime, "2006-01-02"},
+		{"01/02/2016", TypeTime, "01/02/2006"},
+		{`{"a": 1}`, TypeMap, ""},
+		{`[1, 2]`, TypeArray, ""},
+		{"hello", TypeString, ""},
+	}
+	for _, tt := range tests {
+		typ, layout := GuessType(tt.val)
+		if typ != tt.typ || layout != tt.layout {
+			t.Errorf("%#v: got %v %q, want %v %q", tt.val, typ, layout, tt.typ, tt.layout)
+		}
+	}
+}
+
+func TestInferSchema(t *testing.T) {
+	tbl := NewTable(0)
+	tbl.SetColumns([]string{"id", "zip", "price", "day", "note", "empty"})
+	tbl.SetData([][]interface{}{
+		{"1", "02134", "1", "2016-01-02", nil, nil},
+		{"2", "10001", "2.5", "2016-01-03", "x", ""},
+		{"3", "", "3", "2016-1-4", "1", nil},
+	})
+	schema, err := InferSchema(tbl, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Field{
+		{Name: "id", Type: TypeInt},
+		//the zip codes with leading zeros are kept as strings
+		{Name: "zip", Type: TypeString, Nullable: true},
+		{Name: "price", Type: TypeFloat},
+		{Name: "day", Type: TypeTime, Layout: "2006-01-02"},
+		{Name: "note", Type: TypeString, Nullable: true},
+		//the type of a column without value is unknown
+		{Name: "empty", Type: TypeAny, Nullable: true},
+	}
+	for i, f := range schema.Fields {
+		if f.Name != want[i].Name || f.Type != want[i].Type || f.Nullable != want[i].Nullable || f.Layout != want[i].Layout {
+			t.Errorf("got %+v, want %+v", f, want[i])
+		}
+	}
+}
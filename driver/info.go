@@ -0,0 +1,50 @@
+package driver
+
+//Capability is a feature a driver supports, see DriverInfo.
+type Capability string
+
+const (
+	//CapBatch: an extract driver reads the pages set by SetBatch, a transform
+	//or load driver could be called once per batch.
+	CapBatch Capability = "batch"
+	//CapTransaction: a load driver loads the rows atomically.
+	CapTransaction Capability = "transaction"
+	//CapUpsert: a load driver updates the existing rows by keys.
+	CapUpsert Capability = "upsert"
+	//CapSchema: the rows or the handlers declare their schema, see SchemaRows,
+	//SchemaTransform and SchemaLoad.
+	CapSchema Capability = "schema"
+	//CapStreaming: the rows are processed without holding all of them in memory.
+	CapStreaming Capability = "streaming"
+)
+
+//DriverInfo is the metadata of a driver, given when it is registered or by
+//the driver implementing InfoDriver.
+type DriverInfo struct {
+	Description  string       `json:"description,omitempty"`
+	Version      string       `json:"version,omitempty"`
+	Capabilities []Capability `json:"capabilities,omitempty"`
+	//Config describes the data source of the driver, e.g. the keys of a dsn.
+	Config []CommandSpec `json:"config,omitempty"`
+}
+
+//InfoDriver is the interface of the drivers describing themselves. The info
+//given at registration takes precedence.
+type InfoDriver interface {
+	DriverInfo() DriverInfo
+}
+
+//Has reports whether the driver declares the capability.
+func (info DriverInfo) Has(c Capability) bool {
+	for _, item := range info.Capabilities {
+		if item == c {
+			return true
+		}
+	}
+	return false
+}
+
+//IsEmpty reports whether nothing is declared, so the capabilities are unknown.
+func (info DriverInfo) IsEmpty() bool {
+	return info.Description == "" && info.Version == "" && len(info.Capabilities) == 0 && len(info.Config) == 0
+}
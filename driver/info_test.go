@@ -0,0 +1,16 @@
+package driver
+
+import "testing"
+
+func TestDriverInfo(t *testing.T) {
+	info := DriverInfo{Capabilities: []Capability{CapBatch, CapSchema}}
+	if !info.Has(CapBatch) || !info.Has(CapSchema) || info.Has(CapUpsert) {
+		t.Errorf("Has mismatched the capabilities %v", info.Capabilities)
+	}
+	if info.IsEmpty() {
+		t.Error("the info of capabilities is empty")
+	}
+	if !(DriverInfo{}).IsEmpty() || (DriverInfo{Version: "1"}).IsEmpty() {
+		t.Error("IsEmpty mismatched")
+	}
+}
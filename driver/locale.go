@@ -0,0 +1,465 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//Locale describes how numbers and dates are written in a region, so the values
+//of a column could be parsed as the region writes them, e.g. "1.234,56" in de.
+type Locale struct {
+	Name string
+	//DecimalSep is the decimal separator, GroupSeps are the separators of the
+	//digit groups which are removed before parsing.
+	DecimalSep string
+	GroupSeps  []string
+	//DateOrder is the order of day, month and year of a numeric date like
+	//"02/01/2016", "dmy", "mdy" or "ymd". A date starting with a 4-digit year
+	//is always ymd.
+	DateOrder string
+	//Months are the names and abbreviations of every month in lower case,
+	//January is the first.
+	Months [12][]string
+	//Numerals enables the Chinese numerals and units, e.g. "一万二千", "1.5亿",
+	//and the dates like "二〇一六年一月二日", the months are written as numbers.
+	Numerals bool
+}
+
+var (
+	localeMu sync.RWMutex
+	locales  = map[string]*Locale{}
+)
+
+func init() {
+	RegisterLocale(&Locale{
+		Name:       "en",
+		DecimalSep: ".",
+		GroupSeps:  []string{","},
+		DateOrder:  "mdy",
+		Months: [12][]string{
+			{"january", "jan"}, {"february", "feb"}, {"march", "mar"}, {"april", "apr"},
+			{"may"}, {"june", "jun"}, {"july", "jul"}, {"august", "aug"},
+			{"september", "sep", "sept"}, {"october", "oct"}, {"november", "nov"}, {"december", "dec"},
+		},
+	})
+	RegisterLocale(&Locale{
+		Name:       "de",
+		DecimalSep: ",",
+		GroupSeps:  []string{".", " ", "\u00a0", "\u202f", "'"},
+		DateOrder:  "dmy",
+		Months: [12][]string{
+			{"januar", "jänner", "jan"}, {"februar", "feb"}, {"märz", "maerz", "mär", "mrz"}, {"april", "apr"},
+			{"mai"}, {"juni", "jun"}, {"juli", "jul"}, {"august", "aug"},
+			{"september", "sep", "sept"}, {"oktober", "okt"}, {"november", "nov"}, {"dezember", "dez"},
+		},
+	})
+	RegisterLocale(&Locale{
+		Name:       "fr",
+		DecimalSep: ",",
+		GroupSeps:  []string{" ", "\u00a0", "\u202f", "."},
+		DateOrder:  "dmy",
+		Months: [12][]string{
+			{"janvier", "janv"}, {"février", "fevrier", "févr", "fevr", "fév"}, {"mars"}, {"avril", "avr"},
+			{"mai"}, {"juin"}, {"juillet", "juil"}, {"août", "aout"},
+			{"septembre", "sept"}, {"octobre", "oct"}, {"novembre", "nov"}, {"décembre", "decembre", "déc", "dec"},
+		},
+	})
+	RegisterLocale(&Locale{
+		Name:       "zh-CN",
+		DecimalSep: ".",
+		GroupSeps:  []string{",", "\uff0c"},
+		DateOrder:  "ymd",
+		Numerals:   true,
+	})
+}
+
+func localeKey(name string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(name)), "_", "-", -1)
+}
+
+//RegisterLocale makes a locale available by its name, a locale registered
+//with the same name is replaced. Names are case insensitive and "_" is the
+//same as "-".
+func RegisterLocale(l *Locale) {
+	localeMu.Lock()
+	defer localeMu.Unlock()
+	locales[localeKey(l.Name)] = l
+}
+
+//LookupLocale returns the locale by its name. A region is optional if the
+//language is registered without it, e.g. "de-AT" is de, and "zh" is zh-CN.
+func LookupLocale(name string) (*Locale, error) {
+	localeMu.RLock()
+	defer localeMu.RUnlock()
+
+	key := localeKey(name)
+	if l, ok := locales[key]; ok {
+		return l, nil
+	}
+	lang := strings.SplitN(key, "-", 2)[0]
+	if l, ok := locales[lang]; ok {
+		return l, nil
+	}
+	for k, l := range locales {
+		if strings.SplitN(k, "-", 2)[0] == lang {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("The locale(%s) is not supported", name)
+}
+
+//normalizeNumber removes the group separators and replaces the decimal
+//separator, a group separator after the decimal separator is an error.
+func (l *Locale) normalizeNumber(str string) (string, error) {
+	str = strings.TrimSpace(str)
+	if l.Numerals && hasChineseNumeral(str) {
+		d, err := parseChineseNumber(str)
+		if err != nil {
+			return "", err
+		}
+		return d.String(), nil
+	}
+
+	dec := strings.LastIndex(str, l.DecimalSep)
+	for _, sep := range l.GroupSeps {
+		if dec >= 0 && strings.Contains(str[dec+len(l.DecimalSep):], sep) {
+			return "", fmt.Errorf("%q is not a number of locale %s", str, l.Name)
+		}
+	}
+	for _, sep := range l.GroupSeps {
+		str = strings.Replace(str, sep, "", -1)
+	}
+	if l.DecimalSep != "." {
+		if strings.Contains(str, ".") {
+			return "", fmt.Errorf("%q is not a number of locale %s", str, l.Name)
+		}
+		str = strings.Replace(str, l.DecimalSep, ".", 1)
+	}
+	return str, nil
+}
+
+//ParseDecimal parses a number written in the locale to Decimal.
+func (l *Locale) ParseDecimal(str string) (Decimal, error) {
+	norm, err := l.normalizeNumber(str)
+	if err != nil {
+		return Decimal{}, err
+	}
+	return ParseDecimal(norm)
+}
+
+//ParseFloat parses a number written in the locale to float.
+func (l *Locale) ParseFloat(str string) (float64, error) {
+	norm, err := l.normalizeNumber(str)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(norm, 64)
+}
+
+//ParseInt parses a number written in the locale to int, it is an error if the
+//number has fraction digits, e.g. "1,5" in de.
+func (l *Locale) ParseInt(str string) (int64, error) {
+	d, err := l.ParseDecimal(str)
+	if err != nil {
+		return 0, err
+	}
+	if d.Rescale(0, RoundDown).Cmp(d) != 0 {
+		return 0, fmt.Errorf("%q is not an integer", str)
+	}
+	return d.Int64(RoundDown)
+}
+
+var (
+	localeClock  = regexp.MustCompile(`(\d{1,2}):(\d{2})(?::(\d{2})(?:[.,](\d{1,9}))?)?`)
+	localeDigits = regexp.MustCompile(`\d+`)
+	zhDateMarks  = strings.NewReplacer("年", "-", "月", "-", "日", " ", "号", " ", "时", ":", "分", ":", "秒", " ")
+)
+
+//ParseTime parses a date and an optional time of day written in the locale,
+//e.g. "2. Januar 2016", "02/01/2016 15:04" in fr or "2016年1月2日". The value
+//is parsed with the layout firstly as TimeFromInterface if it is provided.
+func (l *Locale) ParseTime(str, layout string) (time.Time, error) {
+	if layout != "" {
+		if t, err := TimeFromInterface(str, layout); err == nil {
+			return t, nil
+		}
+	}
+
+	s := strings.ToLower(strings.TrimSpace(str))
+	if l.Numerals {
+		s = replaceChineseNumerals(s)
+		s = zhDateMarks.Replace(s)
+	}
+
+	var hour, min, sec, nsec int
+	if m := localeClock.FindStringSubmatchIndex(s); m != nil {
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return s[m[2*i]:m[2*i+1]]
+		}
+		hour, _ = strconv.Atoi(group(1))
+		min, _ = strconv.Atoi(group(2))
+		sec, _ = strconv.Atoi(group(3))
+		if frac := group(4); frac != "" {
+			nsec, _ = strconv.Atoi((frac + "00000000")[:9])
+		}
+		s = s[:m[0]] + " " + s[m[1]:]
+	}
+
+	month := 0
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if m := l.month(word); m > 0 {
+			month = m
+			break
+		}
+	}
+
+	nums := localeDigits.FindAllString(s, -1)
+	var year, day int
+	var err error
+	switch {
+	case month > 0 && len(nums) == 2:
+		//the month is written as name, the year is the 4-digit one
+		if len(nums[0]) == 4 {
+			year, day, err = atoi2(nums[0], nums[1])
+		} else {
+			day, year, err = atoi2(nums[0], nums[1])
+		}
+	case month == 0 && len(nums) == 3:
+		order := l.DateOrder
+		if len(nums[0]) == 4 {
+			order = "ymd"
+		}
+		vals := map[byte]*int{'y': &year, 'm': &month, 'd': &day}
+		for i := 0; i < 3 && i < len(order); i++ {
+			if *vals[order[i]], err = strconv.Atoi(nums[i]); err != nil {
+				break
+			}
+		}
+	default:
+		err = fmt.Errorf("no date found")
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a time of locale %s", str, l.Name)
+	}
+
+	if year < 100 {
+		year += 2000
+	}
+	t := time.Date(year, time.Month(month), day, hour, min, sec, nsec, time.Local)
+	if t.Year() != year || int(t.Month()) != month || t.Day() != day || hour > 23 || min > 59 || sec > 59 {
+		return time.Time{}, fmt.Errorf("%q is not a valid time", str)
+	}
+	return t, nil
+}
+
+func atoi2(a, b string) (int, int, error) {
+	x, err := strconv.Atoi(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	y, err := strconv.Atoi(b)
+	return x, y, err
+}
+
+//month returns the month of the name, or 0 if it is not a month name.
+func (l *Locale) month(word string) int {
+	word = strings.TrimSuffix(word, ".")
+	for i, names := range l.Months {
+		for _, name := range names {
+			if word == name {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+//Convert is the same as StrToType, but int, float, decimal and time strings
+//are parsed as written in the locale.
+func (l *Locale) Convert(typeStr string, src interface{}, layout ...string) (interface{}, error) {
+	str, ok := src.(string)
+	if b, isBytes := src.([]uint8); isBytes {
+		str, ok = string(b), true
+	}
+	if !ok {
+		return StrToType(typeStr, src, layout...)
+	}
+
+	switch {
+	case typeStr == "int":
+		return l.ParseInt(str)
+	case typeStr == "float":
+		return l.ParseFloat(str)
+	case strings.HasPrefix(typeStr, "decimal"):
+		decType, err := ParseDecimalType(typeStr)
+		if err != nil {
+			return nil, err
+		}
+		d, err := l.ParseDecimal(str)
+		if err != nil {
+			return nil, err
+		}
+		return decType.Convert(d)
+	case typeStr == "time":
+		if len(layout) > 0 {
+			return l.ParseTime(str, layout[0])
+		}
+		return l.ParseTime(str, "")
+	}
+	return StrToType(typeStr, src, layout...)
+}
+
+var (
+	zhDigits = map[rune]int64{
+		'零': 0, '〇': 0, '○': 0, '一': 1, '壹': 1, '二': 2, '贰': 2, '两': 2, '三': 3, '叁': 3,
+		'四': 4, '肆': 4, '五': 5, '伍': 5, '六': 6, '陆': 6, '七': 7, '柒': 7, '八': 8, '捌': 8, '九': 9, '玖': 9,
+	}
+	zhUnits = map[rune]int64{'十': 10, '拾': 10, '百': 100, '佰': 100, '千': 1000, '仟': 1000}
+	zhBig   = map[rune]int64{'万': 10000, '萬': 10000, '亿': 100000000, '億': 100000000}
+)
+
+func isChineseNumeral(r rune) bool {
+	_, digit := zhDigits[r]
+	_, unit := zhUnits[r]
+	_, big := zhBig[r]
+	return digit || unit || big
+}
+
+func hasChineseNumeral(str string) bool {
+	for _, r := range str {
+		if isChineseNumeral(r) {
+			return true
+		}
+	}
+	return false
+}
+
+//parseChineseNumber parses Chinese numerals, e.g. "一千二百三十四", "二〇一六",
+//"负三点一四", and arabic numbers with units, e.g. "1.5万", "3亿2000万".
+func parseChineseNumber(str string) (Decimal, error) {
+	runes := []rune(strings.TrimSpace(str))
+	neg := false
+	if len(runes) > 0 && (runes[0] == '负' || runes[0] == '-') {
+		neg, runes = true, runes[1:]
+	}
+	if len(runes) == 0 {
+		return Decimal{}, fmt.Errorf("%q is not a number", str)
+	}
+
+	//digits only, e.g. 二〇一六, are positional
+	positional := true
+	for _, r := range runes {
+		if _, ok := zhDigits[r]; !ok && r != '点' {
+			positional = false
+			break
+		}
+	}
+	if positional {
+		var b strings.Builder
+		for _, r := range runes {
+			if r == '点' {
+				b.WriteByte('.')
+			} else {
+				b.WriteByte(byte('0' + zhDigits[r]))
+			}
+		}
+		d, err := ParseDecimal(b.String())
+		if err == nil && neg {
+			d = d.Neg()
+		}
+		return d, err
+	}
+
+	total, section, num := Decimal{}, Decimal{}, Decimal{}
+	hasNum := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r >= '0' && r <= '9' || r == '.':
+			j := i
+			for j < len(runes) && (runes[j] >= '0' && runes[j] <= '9' || runes[j] == '.' || runes[j] == ',') {
+				j++
+			}
+			d, err := ParseDecimal(string(runes[i:j]))
+			if err != nil {
+				return Decimal{}, fmt.Errorf("%q is not a number", str)
+			}
+			num, hasNum = d, true
+			i = j - 1
+		case r == '点':
+			//the fraction digits are positional
+			frac := "0."
+			for i++; i < len(runes); i++ {
+				v, ok := zhDigits[runes[i]]
+				if !ok {
+					break
+				}
+				frac += strconv.FormatInt(v, 10)
+			}
+			i--
+			d, err := ParseDecimal(frac)
+			if err != nil {
+				return Decimal{}, fmt.Errorf("%q is not a number", str)
+			}
+			num, hasNum = num.Add(d), true
+		default:
+			if v, ok := zhDigits[r]; ok {
+				num, hasNum = NewDecimal(v, 0), true
+			} else if v, ok := zhUnits[r]; ok {
+				if !hasNum {
+					//十二 is 12
+					num = NewDecimal(1, 0)
+				}
+				section = section.Add(num.Mul(NewDecimal(v, 0)))
+				num, hasNum = Decimal{}, false
+			} else if v, ok := zhBig[r]; ok {
+				if v == 100000000 {
+					total = total.Add(section).Add(num).Mul(NewDecimal(v, 0))
+				} else {
+					total = total.Add(section.Add(num).Mul(NewDecimal(v, 0)))
+				}
+				section, num, hasNum = Decimal{}, Decimal{}, false
+			} else {
+				return Decimal{}, fmt.Errorf("%q is not a number", str)
+			}
+		}
+	}
+
+	rslt := total.Add(section).Add(num)
+	if neg {
+		rslt = rslt.Neg()
+	}
+	return rslt, nil
+}
+
+//replaceChineseNumerals replaces every run of Chinese numerals by the number.
+func replaceChineseNumerals(str string) string {
+	var b strings.Builder
+	runes := []rune(str)
+	for i := 0; i < len(runes); i++ {
+		if !isChineseNumeral(runes[i]) {
+			b.WriteRune(runes[i])
+			continue
+		}
+		j := i
+		for j < len(runes) && isChineseNumeral(runes[j]) {
+			j++
+		}
+		d, err := parseChineseNumber(string(runes[i:j]))
+		if err != nil {
+			b.WriteString(string(runes[i:j]))
+		} else {
+			b.WriteString(d.String())
+		}
+		i = j - 1
+	}
+	return b.String()
+}
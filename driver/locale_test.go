@@ -0,0 +1,159 @@
+package driver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func mustLocale(t *testing.T, name string) *Locale {
+	l, err := LookupLocale(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLookupLocale(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"de", "de"},
+		{"de_DE", "de"},
+		{"DE-at", "de"},
+		{"EN-us", "en"},
+		{"zh", "zh-CN"},
+		{"zh_cn", "zh-CN"},
+	}
+	for _, tt := range tests {
+		if l := mustLocale(t, tt.name); l.Name != tt.want {
+			t.Errorf("LookupLocale(%s) = %s, want %s", tt.name, l.Name, tt.want)
+		}
+	}
+	if _, err := LookupLocale("xx"); err == nil {
+		t.Error("LookupLocale(xx) should fail")
+	}
+}
+
+func TestLocaleParseNumber(t *testing.T) {
+	tests := []struct {
+		locale, in string
+		want       string
+		err        bool
+	}{
+		{"de", "1.234,56", "1234.56", false},
+		{"de", "-1 234,5", "-1234.5", false},
+		{"de", "1,234.56", "", true},
+		{"fr", "1 234,56", "1234.56", false},
+		{"fr", "1 234,5", "1234.5", false},
+		{"en", "1,234.56", "1234.56", false},
+		{"en", "1.234,56", "", true},
+		{"zh", "1,234.5", "1234.5", false},
+		{"zh", "1.5万", "15000.0", false},
+		{"zh", "一千零五", "1005", false},
+		{"zh", "3亿2000万", "320000000", false},
+		{"zh", "一亿五千万", "150000000", false},
+		{"zh", "十二", "12", false},
+		{"zh", "负三点一四", "-3.14", false},
+		{"zh", "二〇一六", "2016", false},
+	}
+	for _, tt := range tests {
+		l := mustLocale(t, tt.locale)
+		d, err := l.ParseDecimal(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s ParseDecimal(%q) = %s, should fail", tt.locale, tt.in, d)
+			}
+			if _, err := l.ParseFloat(tt.in); err == nil {
+				t.Errorf("%s ParseFloat(%q) should fail", tt.locale, tt.in)
+			}
+			continue
+		}
+		if err != nil || d.String() != tt.want {
+			t.Errorf("%s ParseDecimal(%q) = %s, %v, want %s", tt.locale, tt.in, d, err, tt.want)
+		}
+		if f, err := l.ParseFloat(tt.in); err != nil || f != d.Float64() {
+			t.Errorf("%s ParseFloat(%q) = %v, %v, want %v", tt.locale, tt.in, f, err, d.Float64())
+		}
+	}
+
+	de := mustLocale(t, "de")
+	if i, err := de.ParseInt("1.234"); err != nil || i != 1234 {
+		t.Errorf("de ParseInt(1.234) = %d, %v", i, err)
+	}
+	if _, err := de.ParseInt("1,5"); err == nil {
+		t.Error("de ParseInt(1,5) should fail")
+	}
+	//without a locale a comma after the point is not a group separator
+	if _, err := ParseFloat("1.234,56"); err == nil {
+		t.Error("ParseFloat(1.234,56) should fail")
+	}
+}
+
+func TestLocaleParseTime(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.Local)
+	}
+	tests := []struct {
+		locale, in string
+		want       time.Time
+	}{
+		{"de", "2. Januar 2016", date(2016, 1, 2, 0, 0, 0)},
+		{"de", "02.01.2016 15:04", date(2016, 1, 2, 15, 4, 0)},
+		{"de", "3. März 2016", date(2016, 3, 3, 0, 0, 0)},
+		{"fr", "2 févr. 2016", date(2016, 2, 2, 0, 0, 0)},
+		{"fr", "02/01/16", date(2016, 1, 2, 0, 0, 0)},
+		{"en", "January 2, 2016", date(2016, 1, 2, 0, 0, 0)},
+		{"en", "1/2/2016 3:04:05", date(2016, 1, 2, 3, 4, 5)},
+		{"en", "2016-01-02", date(2016, 1, 2, 0, 0, 0)},
+		{"zh", "2016年1月2日", date(2016, 1, 2, 0, 0, 0)},
+		{"zh", "二〇一六年十二月二十五日 15时04分", date(2016, 12, 25, 15, 4, 0)},
+	}
+	for _, tt := range tests {
+		got, err := mustLocale(t, tt.locale).ParseTime(tt.in, "")
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("%s ParseTime(%q) = %v, %v, want %v", tt.locale, tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"2016-02-30", "foo", "1/2", "25:00 1/2/2016"} {
+		if got, err := mustLocale(t, "en").ParseTime(in, ""); err == nil {
+			t.Errorf("en ParseTime(%q) = %v, should fail", in, got)
+		}
+	}
+}
+
+func TestLocaleConvert(t *testing.T) {
+	de := mustLocale(t, "de")
+	tests := []struct {
+		typ  string
+		in   interface{}
+		want interface{}
+	}{
+		{"int", "1.234", int64(1234)},
+		{"float", "1.234,5", 1234.5},
+		{"float", []uint8("2,5"), 2.5},
+		{"float", 3, 3.0},
+		{"string", "1,5", "1,5"},
+	}
+	for _, tt := range tests {
+		got, err := de.Convert(tt.typ, tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("de Convert(%s, %v) = %v (%T), %v, want %v", tt.typ, tt.in, got, got, err, tt.want)
+		}
+	}
+	if got, err := de.Convert("decimal(6,1)", "1.234,56"); err != nil || got.(Decimal).String() != "1234.6" {
+		t.Errorf("de Convert(decimal(6,1)) = %v, %v", got, err)
+	}
+	if _, err := de.Convert("decimal(3,1)", "1.234,56"); err == nil {
+		t.Error("de Convert(decimal(3,1)) should overflow")
+	}
+
+	var cmd Command
+	if err := json.Unmarshal([]byte(`{"name":"x","type":"float","value":"1.234,5","locale":"de"}`), &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Value != 1234.5 {
+		t.Errorf("the command of locale de has the value %v", cmd.Value)
+	}
+}
@@ -0,0 +1,197 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//LogicalType is the type of a column, it is independent of how the value is
+//stored by a driver.
+type LogicalType string
+
+const (
+	TypeAny      LogicalType = "any"
+	TypeInt      LogicalType = "int"
+	TypeFloat    LogicalType = "float"
+	TypeDecimal  LogicalType = "decimal"
+	TypeString   LogicalType = "string"
+	TypeBool     LogicalType = "bool"
+	TypeTime     LogicalType = "time"
+	TypeGeometry LogicalType = "geometry"
+	TypeMap      LogicalType = "map"
+	TypeArray    LogicalType = "array"
+)
+
+//ParseLogicalType converts the type string used by StrToType to a LogicalType.
+func ParseLogicalType(typeStr string) (LogicalType, error) {
+	switch typeStr {
+	case "", "any":
+		return TypeAny, nil
+	case "int":
+		return TypeInt, nil
+	case "float":
+		return TypeFloat, nil
+	case "string":
+		return TypeString, nil
+	case "bool":
+		return TypeBool, nil
+	case "time":
+		return TypeTime, nil
+	case "geometry":
+		return TypeGeometry, nil
+	case "map", "json":
+		return TypeMap, nil
+	case "list", "array", "jsonarray":
+		return TypeArray, nil
+	}
+	if strings.HasPrefix(typeStr, "decimal") {
+		return TypeDecimal, nil
+	}
+	return TypeAny, fmt.Errorf("The type(%s) is not a logical type", typeStr)
+}
+
+//Field describes a column.
+type Field struct {
+	Name     string      `json:"name"`
+	Type     LogicalType `json:"type"`
+	Nullable bool        `json:"nullable"`
+	//Layout of the time column if it is stored as string
+	Layout string `json:"layout,omitempty"`
+}
+
+//Schema describes the columns of Rows or Results in order.
+type Schema struct {
+	Fields []Field `json:"fields"`
+}
+
+//NewSchema returns a schema with all columns nullable and of TypeAny.
+func NewSchema(columns []string) Schema {
+	fields := make([]Field, len(columns))
+	for i, name := range columns {
+		fields[i] = Field{Name: name, Type: TypeAny, Nullable: true}
+	}
+	return Schema{Fields: fields}
+}
+
+//IsEmpty returns true if the schema does not describe any column, it means unknown.
+func (s Schema) IsEmpty() bool {
+	return len(s.Fields) == 0
+}
+
+//Columns returns the name of the columns in order.
+func (s Schema) Columns() []string {
+	cols := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		cols[i] = f.Name
+	}
+	return cols
+}
+
+//Field returns the field of the column.
+func (s Schema) Field(name string) (Field, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+//Assignable returns true if the values of type src could be stored in a column
+//of type dst. float is not assignable to decimal since the value is rounded to
+//the scale of the column, see LossyAssignable.
+func Assignable(src, dst LogicalType) bool {
+	if src == dst || src == TypeAny || dst == TypeAny || dst == TypeString {
+		return true
+	}
+	switch dst {
+	case TypeFloat:
+		return src == TypeInt || src == TypeDecimal
+	case TypeDecimal:
+		return src == TypeInt
+	}
+	return false
+}
+
+//LossyAssignable returns true if the values of type src could only be stored in
+//a column of type dst with loss of precision, i.e. float to decimal.
+func LossyAssignable(src, dst LogicalType) bool {
+	return src == TypeFloat && dst == TypeDecimal
+}
+
+//Compatible checks if the rows of s could be consumed by a stage expecting target.
+//Every column of target should be in s unless it is nullable, with an assignable
+//type, and a nullable column could not be consumed as not nullable.
+func (s Schema) Compatible(target Schema) error {
+	_, err := s.compatible(target, false)
+	return err
+}
+
+//CompatibleLossy is Compatible but accepts the columns which are only lossy
+//assignable, see LossyAssignable. A warning is returned for each of them.
+func (s Schema) CompatibleLossy(target Schema) ([]string, error) {
+	return s.compatible(target, true)
+}
+
+func (s Schema) compatible(target Schema, lossy bool) ([]string, error) {
+	warnings := []string{}
+	errs := []string{}
+	for _, want := range target.Fields {
+		have, ok := s.Field(want.Name)
+		if !ok {
+			if !want.Nullable {
+				errs = append(errs, fmt.Sprintf("column %s is missing", want.Name))
+			}
+			continue
+		}
+		if LossyAssignable(have.Type, want.Type) {
+			msg := fmt.Sprintf("column %s is %s but %s expected, the precision could be lost", want.Name, have.Type, want.Type)
+			if lossy {
+				warnings = append(warnings, msg)
+			} else {
+				errs = append(errs, msg)
+			}
+		} else if !Assignable(have.Type, want.Type) {
+			errs = append(errs, fmt.Sprintf("column %s is %s but %s expected", want.Name, have.Type, want.Type))
+		}
+		if have.Nullable && !want.Nullable {
+			errs = append(errs, fmt.Sprintf("column %s is nullable but not null expected", want.Name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return warnings, fmt.Errorf("Schema is not compatible: %s", strings.Join(errs, "; "))
+	}
+	return warnings, nil
+}
+
+//Interface of rows which knows the schema of the columns, it is optional for
+//drivers. The schema should be available before the first Next.
+type SchemaRows interface {
+	Rows
+	Schema() Schema
+}
+
+//ErrSchemaUnknown is returned by OutputSchema if the columns of the results
+//depend on the data, e.g. the values pivoted into columns.
+var ErrSchemaUnknown = errors.New("The schema is unknown until the rows are transformed")
+
+//Interface of transform handler which could declare the schema of the results.
+//It should return an error if the input could not be handled, e.g. a column
+//required is missing, or ErrSchemaUnknown if the columns depend on the data.
+type SchemaTransform interface {
+	OutputSchema(in Schema, cmd interface{}) (Schema, error)
+}
+
+//Interface of load handler which could declare the schema it expects.
+type SchemaLoad interface {
+	InputSchema(cmd interface{}) (Schema, error)
+}
+
+//Interface of transform handler which outputs only the columns in its commands.
+//PassThrough returns the commands to copy the columns to the results as they
+//are, it is used to map the new columns found by the schema drift detection.
+type PassThroughTransform interface {
+	PassThrough(columns []string) []Command
+}
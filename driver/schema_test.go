@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLogicalType(t *testing.T) {
+	for in, want := range map[string]LogicalType{
+		"":             TypeAny,
+		"int":          TypeInt,
+		"decimal(9,2)": TypeDecimal,
+		"json":         TypeMap,
+		"jsonarray":    TypeArray,
+		"list":         TypeArray,
+		"time":         TypeTime,
+	} {
+		if got, err := ParseLogicalType(in); err != nil || got != want {
+			t.Errorf("ParseLogicalType(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLogicalType("blob"); err == nil {
+		t.Error("ParseLogicalType(blob) succeeded")
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	for _, c := range []struct {
+		src, dst LogicalType
+		want     bool
+	}{
+		{TypeInt, TypeInt, true},
+		{TypeInt, TypeFloat, true},
+		{TypeInt, TypeDecimal, true},
+		{TypeFloat, TypeDecimal, false},
+		{TypeTime, TypeString, true},
+		{TypeAny, TypeBool, true},
+		{TypeFloat, TypeInt, false},
+		{TypeString, TypeTime, false},
+	} {
+		if got := Assignable(c.src, c.dst); got != c.want {
+			t.Errorf("Assignable(%s, %s) = %v, want %v", c.src, c.dst, got, c.want)
+		}
+	}
+	if !LossyAssignable(TypeFloat, TypeDecimal) || LossyAssignable(TypeInt, TypeDecimal) {
+		t.Error("only float to decimal is lossy")
+	}
+}
+
+func TestSchemaCompatibleLossy(t *testing.T) {
+	src := Schema{Fields: []Field{{Name: "price", Type: TypeFloat}}}
+	target := Schema{Fields: []Field{{Name: "price", Type: TypeDecimal}}}
+	if err := src.Compatible(target); err == nil || !strings.Contains(err.Error(), "precision could be lost") {
+		t.Errorf("Compatible(float to decimal) = %v", err)
+	}
+	warnings, err := src.CompatibleLossy(target)
+	if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "column price is float but decimal expected") {
+		t.Errorf("CompatibleLossy = %q, %v", warnings, err)
+	}
+}
+
+func TestSchemaCompatible(t *testing.T) {
+	src := Schema{Fields: []Field{
+		{Name: "id", Type: TypeInt},
+		{Name: "price", Type: TypeFloat, Nullable: true},
+		{Name: "day", Type: TypeString},
+	}}
+	ok := Schema{Fields: []Field{
+		{Name: "id", Type: TypeDecimal},
+		{Name: "price", Type: TypeFloat, Nullable: true},
+		//a nullable column could be missing
+		{Name: "note", Type: TypeString, Nullable: true},
+	}}
+	if err := src.Compatible(ok); err != nil {
+		t.Error(err)
+	}
+
+	bad := Schema{Fields: []Field{
+		{Name: "id", Type: TypeInt},
+		{Name: "price", Type: TypeFloat},
+		{Name: "day", Type: TypeTime},
+		{Name: "qty", Type: TypeInt},
+	}}
+	err := src.Compatible(bad)
+	if err == nil {
+		t.Fatal("the incompatible schema is compatible")
+	}
+	for _, want := range []string{
+		"column price is nullable but not null expected",
+		"column day is string but time expected",
+		"column qty is missing",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Compatible error %q does not report %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package driver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//SecretNotFoundError is returned by the secret providers if the secret is not
+//found, so SecretChain tries the next provider.
+type SecretNotFoundError struct {
+	Name string
+}
+
+func (e SecretNotFoundError) Error() string {
+	return fmt.Sprintf("secret %s is not found", e.Name)
+}
+
+//SecretProvider returns the secrets referenced as secret://name in the data
+//sources, or ${secret.name} by Expander.
+type SecretProvider interface {
+	Secret(name string) (string, error)
+}
+
+//EnvSecrets reads the secret from the environment variable of Prefix and the
+//name in upper case, the characters other than letters and digits are
+//replaced by "_", e.g. db.password is ETLX_DB_PASSWORD with prefix ETLX_.
+type EnvSecrets struct {
+	Prefix string
+}
+
+var envNameReplacer = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+func (s EnvSecrets) Secret(name string) (string, error) {
+	key := s.Prefix + strings.ToUpper(envNameReplacer.ReplaceAllString(name, "_"))
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return "", SecretNotFoundError{Name: name}
+	}
+	return val, nil
+}
+
+//FileSecrets reads the secret from the file of the name in Dir, the trailing
+//newline is trimmed, e.g. the secrets mounted by docker or kubernetes.
+type FileSecrets struct {
+	Dir string
+}
+
+func (s FileSecrets) Secret(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("secret %s: invalid name", name)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return "", SecretNotFoundError{Name: name}
+	} else if err != nil {
+		return "", fmt.Errorf("secret %s: %v", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+//EncryptedFileSecrets reads the secrets from a json object of names and
+//values encrypted by EncryptSecrets with AES-GCM. The key is 16, 24 or 32
+//bytes, the file is decrypted once at the first lookup.
+type EncryptedFileSecrets struct {
+	Path string
+	Key  []byte
+
+	once    sync.Once
+	secrets map[string]string
+	err     error
+}
+
+//NewEncryptedFileSecrets returns the provider of the encrypted file.
+func NewEncryptedFileSecrets(path string, key []byte) *EncryptedFileSecrets {
+	return &EncryptedFileSecrets{Path: path, Key: key}
+}
+
+func (s *EncryptedFileSecrets) Secret(name string) (string, error) {
+	s.once.Do(func() {
+		b, err := ioutil.ReadFile(s.Path)
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.secrets, s.err = DecryptSecrets(b, s.Key)
+	})
+	if s.err != nil {
+		return "", fmt.Errorf("secret %s: %v", name, s.err)
+	}
+	val, ok := s.secrets[name]
+	if !ok {
+		return "", SecretNotFoundError{Name: name}
+	}
+	return val, nil
+}
+
+func secretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//EncryptSecrets encrypts the secrets for EncryptedFileSecrets, the result is
+//the nonce followed by the sealed json.
+func EncryptSecrets(secrets map[string]string, key []byte) ([]byte, error) {
+	aead, err := secretCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+//DecryptSecrets decrypts the secrets encrypted by EncryptSecrets.
+func DecryptSecrets(data, key []byte) (map[string]string, error) {
+	aead, err := secretCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("the encrypted secrets are truncated")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt the secrets, wrong key or corrupted file")
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+//SecretChain tries the providers in order until the secret is found.
+type SecretChain []SecretProvider
+
+func (c SecretChain) Secret(name string) (string, error) {
+	for _, p := range c {
+		val, err := p.Secret(name)
+		if err == nil {
+			return val, nil
+		}
+		if _, ok := err.(SecretNotFoundError); !ok {
+			return "", err
+		}
+	}
+	return "", SecretNotFoundError{Name: name}
+}
+
+//secretRef matches secret://name, or secret://{name} when the name is followed
+//by the characters of names.
+var secretRef = regexp.MustCompile(`secret://(?:\{([^{}\s]+)\}|([\w-]+(?:\.[\w-]+)*))`)
+
+//HasSecretRef reports whether the string references any secret.
+func HasSecretRef(str string) bool {
+	return secretRef.MatchString(str)
+}
+
+//ResolveSecrets replaces the secret://name references in the string by the
+//secrets of the provider. It returns the values resolved as well, so they
+//could be redacted by RedactSecrets.
+func ResolveSecrets(str string, p SecretProvider) (string, []string, error) {
+	if !HasSecretRef(str) {
+		return str, nil, nil
+	}
+	if p == nil {
+		return "", nil, fmt.Errorf("secrets are referenced but no secret provider is set")
+	}
+
+	var values []string
+	var rerr error
+	rslt := secretRef.ReplaceAllStringFunc(str, func(ref string) string {
+		m := secretRef.FindStringSubmatch(ref)
+		name := m[1] + m[2]
+		val, err := p.Secret(name)
+		if err != nil {
+			if rerr == nil {
+				rerr = err
+			}
+			return ref
+		}
+		values = append(values, val)
+		return val
+	})
+	if rerr != nil {
+		return "", nil, rerr
+	}
+	return rslt, values, nil
+}
+
+var (
+	//user:password@ in urls and dsn of mysql, the password does not start with
+	//"/" to not take the scheme of urls as the user
+	dsnUserinfo = regexp.MustCompile(`(^|://|\s)([^:/@\s]*):([^/@\s][^@\s]*)@`)
+	//password=..., pwd=... of key value dsn, quoted or ended by ; & or space
+	dsnPassword = regexp.MustCompile(`(?i)\b(password|passwd|pwd|secret|token|api_?key|access_?key|secret_?key)(\s*=\s*)('[^']*'|"[^"]*"|[^;&\s]*)`)
+)
+
+//RedactDSN masks the credentials in a data source, the password of urls and
+//user:password@host, and the values of password, pwd, token, secret and
+//keys, e.g. "postgres://u:p@h/db" is "postgres://u:***@h/db".
+func RedactDSN(dsn string) string {
+	dsn = dsnUserinfo.ReplaceAllString(dsn, "$1$2:***@")
+	return dsnPassword.ReplaceAllString(dsn, "$1$2***")
+}
+
+//RedactSecrets masks the values of the secrets and the credentials of the
+//data source in str, for the errors and logs mentioning a data source.
+func RedactSecrets(str string, secrets ...string) string {
+	//the longest firstly, so a secret containing another is masked entirely
+	sorted := append([]string(nil), secrets...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, s := range sorted {
+		if s != "" {
+			str = strings.Replace(str, s, "***", -1)
+		}
+	}
+	return RedactDSN(str)
+}
@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testSecretKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testSecretChain(t *testing.T) SecretChain {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "db_pass"), []byte("s3cr@t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncryptSecrets(map[string]string{"token": "tk1"}, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "vault"), enc, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ETLX_TEST_API_KEY", "envkey")
+	t.Cleanup(func() { os.Unsetenv("ETLX_TEST_API_KEY") })
+
+	return SecretChain{
+		EnvSecrets{Prefix: "ETLX_TEST_"},
+		FileSecrets{Dir: dir},
+		NewEncryptedFileSecrets(filepath.Join(dir, "vault"), testSecretKey),
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	p := testSecretChain(t)
+	dsn, vals, err := ResolveSecrets("postgres://u:secret://db_pass@h/db?token=secret://{token}&k=secret://api.key", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "postgres://u:s3cr@t@h/db?token=tk1&k=envkey"; dsn != want {
+		t.Errorf("ResolveSecrets = %s, want %s", dsn, want)
+	}
+	if len(vals) != 3 {
+		t.Errorf("ResolveSecrets resolved %v, want 3 values", vals)
+	}
+	if got, want := RedactSecrets(dsn, vals...), "postgres://u:***@h/db?token=***&k=***"; got != want {
+		t.Errorf("RedactSecrets = %s, want %s", got, want)
+	}
+
+	if !HasSecretRef("a secret://b") || HasSecretRef("no secret") {
+		t.Error("HasSecretRef mismatched")
+	}
+	if _, _, err := ResolveSecrets("secret://missing", p); err == nil {
+		t.Error("the missing secret is resolved")
+	}
+}
+
+func TestSecretChainNotFound(t *testing.T) {
+	p := testSecretChain(t)
+	_, err := p.Secret("missing")
+	if _, ok := err.(SecretNotFoundError); !ok {
+		t.Errorf("Secret(missing) error = %v, want SecretNotFoundError", err)
+	}
+}
+
+func TestEncryptedFileSecrets(t *testing.T) {
+	enc, err := EncryptSecrets(map[string]string{"a": "1", "b": "2"}, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := DecryptSecrets(enc, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 2 || secrets["a"] != "1" || secrets["b"] != "2" {
+		t.Errorf("DecryptSecrets = %v", secrets)
+	}
+
+	wrong := []byte("0123456789abcdef0123456789abcdeX")
+	if _, err := DecryptSecrets(enc, wrong); err == nil {
+		t.Error("decrypted by the wrong key")
+	}
+	path := filepath.Join(t.TempDir(), "vault")
+	if err := ioutil.WriteFile(path, enc, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedFileSecrets(path, wrong).Secret("a"); err == nil {
+		t.Error("the secret is read by the wrong key")
+	}
+	if _, err := EncryptSecrets(nil, []byte("short")); err == nil {
+		t.Error("encrypted by a key of 5 bytes")
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	for in, want := range map[string]string{
+		"root:pw@tcp(localhost:3306)/db":            "root:***@tcp(localhost:3306)/db",
+		"host=h user=u password='a b' dbname=d":     "host=h user=u password=*** dbname=d",
+		"Server=s;Database=d;User Id=u;Password=p;": "Server=s;Database=d;User Id=u;Password=***;",
+		"/data/file.csv":                            "/data/file.csv",
+		"mongodb://u:p@h1:27017,h2:27017/db":        "mongodb://u:***@h1:27017,h2:27017/db",
+	} {
+		if got := RedactDSN(in); got != want {
+			t.Errorf("RedactDSN(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
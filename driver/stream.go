@@ -0,0 +1,122 @@
+package driver
+
+import (
+	"errors"
+	"sync"
+)
+
+//ErrStreamClosed is returned by Stream.Send once the consumer closed the stream.
+var ErrStreamClosed = errors.New("Stream is closed by the consumer")
+
+//Stream is the Rows and Results passing the rows from a producer to a consumer
+//through a channel, so the rows are never buffered all in memory as Table.
+//
+//The producer calls Send for every row and CloseSend once done, the consumer
+//calls Next until EOT or the error passed to CloseSend is returned, and Close
+//if it stops early so the producer is not blocked forever.
+type Stream struct {
+	columns []string
+	schema  Schema
+
+	rows chan []interface{}
+	done chan struct{}
+	err  error
+
+	sendOnce  sync.Once
+	closeOnce sync.Once
+}
+
+//NewStream returns a stream buffering at most buffer rows.
+func NewStream(columns []string, buffer int) *Stream {
+	if buffer < 0 {
+		buffer = 0
+	}
+	return &Stream{
+		columns: columns,
+		rows:    make(chan []interface{}, buffer),
+		done:    make(chan struct{}),
+	}
+}
+
+//NewStreamFunc runs produce in a goroutine with the Send of the stream, and
+//calls CloseSend with the error returned by produce.
+func NewStreamFunc(columns []string, buffer int, produce func(send func(row []interface{}) error) error) *Stream {
+	s := NewStream(columns, buffer)
+	go func() {
+		s.CloseSend(produce(s.Send))
+	}()
+	return s
+}
+
+//SetSchema sets the schema of the stream, the columns are set as well. It
+//should be called before the stream is passed to the consumer.
+func (s *Stream) SetSchema(schema Schema) {
+	s.schema = schema
+	s.columns = schema.Columns()
+}
+
+//Schema returns the schema set by SetSchema, it is empty if not set.
+func (s *Stream) Schema() Schema {
+	return s.schema
+}
+
+func (s *Stream) Columns() []string {
+	return s.columns
+}
+
+//Send passes a row to the consumer, it blocks if the buffer is full. It should
+//not be called after CloseSend.
+func (s *Stream) Send(row []interface{}) error {
+	select {
+	case <-s.done:
+		return ErrStreamClosed
+	default:
+	}
+
+	select {
+	case s.rows <- row:
+		return nil
+	case <-s.done:
+		return ErrStreamClosed
+	}
+}
+
+//CloseSend tells the consumer there are no more rows. The consumer gets err
+//after all rows sent if it is not nil, otherwise EOT.
+func (s *Stream) CloseSend(err error) {
+	s.sendOnce.Do(func() {
+		s.err = err
+		close(s.rows)
+	})
+}
+
+//Next copies the next row to dst, see CopyRow for the types of dst supported.
+func (s *Stream) Next(dst interface{}) error {
+	select {
+	case <-s.done:
+		return EOT
+	default:
+	}
+
+	row, ok := <-s.rows
+	if !ok {
+		if s.err != nil && s.err != ErrStreamClosed {
+			return s.err
+		}
+		return EOT
+	}
+	return CopyRow(s.columns, row, dst)
+}
+
+//NextRsltAndIndex is the same as Next, Stream does not build any index.
+func (s *Stream) NextRsltAndIndex(rslt interface{}, index *map[string]interface{}) error {
+	return s.Next(rslt)
+}
+
+//Close is called by the consumer to stop, Send returns ErrStreamClosed after it.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
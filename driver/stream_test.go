@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+type testPerson struct {
+	Name string `map:"name"`
+	Age  int64  `map:"age"`
+	Skip string
+}
+
+func countStream(n int) *Stream {
+	return NewStreamFunc([]string{"name", "age"}, 2, func(send func([]interface{}) error) error {
+		for i := 0; i < n; i++ {
+			if err := send([]interface{}{"p", int64(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestStreamDestinations(t *testing.T) {
+	s := countStream(5)
+	defer s.Close()
+
+	var p testPerson
+	if err := s.Next(&p); err != nil || p.Name != "p" || p.Age != 0 {
+		t.Errorf("Next(*struct) = %+v, %v", p, err)
+	}
+	m := map[string]interface{}{}
+	if err := s.Next(&m); err != nil || m["name"] != "p" || m["age"] != int64(1) {
+		t.Errorf("Next(*map) = %v, %v", m, err)
+	}
+	var arr []interface{}
+	if err := s.Next(&arr); err != nil || len(arr) != 2 || arr[1] != int64(2) {
+		t.Errorf("Next(*slice) = %v, %v", arr, err)
+	}
+
+	n := 0
+	for {
+		row := make([]interface{}, 2)
+		err := s.Next(row)
+		if err == EOT {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("got %d rows after the 3 read, want 2", n)
+	}
+}
+
+func TestStreamProducerError(t *testing.T) {
+	boom := errors.New("boom")
+	s := NewStreamFunc([]string{"a"}, 0, func(send func([]interface{}) error) error {
+		if err := send([]interface{}{1}); err != nil {
+			return err
+		}
+		return boom
+	})
+	row := make([]interface{}, 1)
+	if err := s.Next(row); err != nil {
+		t.Fatal(err)
+	}
+	//the error follows the rows sent
+	if err := s.Next(row); err != boom {
+		t.Errorf("Next error = %v, want %v", err, boom)
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	done := make(chan error, 1)
+	s := NewStreamFunc([]string{"a"}, 0, func(send func([]interface{}) error) error {
+		for {
+			if err := send([]interface{}{1}); err != nil {
+				done <- err
+				return err
+			}
+		}
+	})
+	row := make([]interface{}, 1)
+	if err := s.Next(row); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if err := <-done; err != ErrStreamClosed {
+		t.Errorf("Send error after Close = %v, want ErrStreamClosed", err)
+	}
+	if err := s.Next(row); err != EOT {
+		t.Errorf("Next after Close = %v, want EOT", err)
+	}
+	//Close is idempotent
+	s.Close()
+}
+
+func TestStreamSchema(t *testing.T) {
+	s := NewStream(nil, 1)
+	s.SetSchema(NewSchema([]string{"a", "b"}))
+	if cols := s.Columns(); len(cols) != 2 || cols[0] != "a" || cols[1] != "b" {
+		t.Errorf("Columns = %v, want [a b]", cols)
+	}
+	if err := s.Send([]interface{}{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	s.CloseSend(nil)
+	m := map[string]interface{}{}
+	if err := s.Next(&m); err != nil || m["b"] != 2 {
+		t.Errorf("Next = %v, %v", m, err)
+	}
+	if err := s.Next(&m); err != EOT {
+		t.Errorf("Next = %v, want EOT", err)
+	}
+}
+
+func TestTableDestinations(t *testing.T) {
+	tbl := NewTable(0)
+	tbl.SetColumns([]string{"name", "age"})
+	tbl.AppendData([]interface{}{"x", nil})
+
+	var p testPerson
+	if err := tbl.Next(&p); err != nil || p.Name != "x" || p.Age != 0 {
+		t.Errorf("Next(*struct) = %+v, %v", p, err)
+	}
+	if err := tbl.Next(&p); err != EOT {
+		t.Errorf("Next = %v, want EOT", err)
+	}
+
+	tbl.ResetCurosr()
+	var bad int
+	if err := tbl.Next(&bad); err == nil {
+		t.Error("Next(*int) succeeded")
+	}
+}
@@ -0,0 +1,255 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Epoch decides how TimeParser converts a number to time.
+type Epoch int
+
+const (
+	//EpochAuto guesses by the magnitude: less than 100000 is an Excel serial
+	//date (before 2173), less than 1e11 is unix seconds (before 5138) and unix
+	//milliseconds otherwise.
+	EpochAuto Epoch = iota
+	//EpochUnix is seconds since 1970-01-01 UTC, the fraction is kept.
+	EpochUnix
+	//EpochUnixMilli is milliseconds since 1970-01-01 UTC.
+	EpochUnixMilli
+	//EpochExcel is the days since 1899-12-30 in the source timezone, the
+	//fraction is the time of day. Serials before 61 are shifted for the
+	//1900-02-29 which Excel counts but does not exist.
+	EpochExcel
+)
+
+var epochs = map[string]Epoch{
+	"auto":    EpochAuto,
+	"unix":    EpochUnix,
+	"unix_ms": EpochUnixMilli,
+	"excel":   EpochExcel,
+}
+
+//ParseEpoch converts the name of an epoch, "auto", "unix", "unix_ms" or "excel".
+func ParseEpoch(name string) (Epoch, error) {
+	epoch, ok := epochs[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return EpochAuto, fmt.Errorf("The epoch(%s) is not supported", name)
+	}
+	return epoch, nil
+}
+
+//AutoLayouts are the layouts tried by TimeParser after its own layouts if Auto
+//is enabled.
+var AutoLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.RFC822Z,
+	time.RFC822,
+	time.ANSIC,
+	"2006年1月2日 15:04:05",
+	"2006年1月2日",
+}
+
+var (
+	timeNumber  = regexp.MustCompile(`^[+-]?\d+(\.\d+)?$`)
+	fixedOffset = regexp.MustCompile(`^(?:UTC|GMT)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+)
+
+//TimeParser converts values to time with a list of candidate layouts tried in
+//order, unlike TimeFromInterface which takes one layout.
+type TimeParser struct {
+	Layouts []string
+	//Auto enables the detection of AutoLayouts, e.g. RFC3339, and numbers as
+	//Epoch once none of the layouts matched.
+	Auto  bool
+	Epoch Epoch
+	//Location is the timezone of the values without zone, time.Local if nil.
+	Location *time.Location
+	//Output is the timezone the results are converted to, they are kept in
+	//the zone parsed if nil.
+	Output *time.Location
+	//Locale parses the values written in a region if nothing else matched.
+	Locale *Locale
+}
+
+//NewTimeParser returns a parser trying the layouts in order and then auto
+//detection, the values without zone are in time.Local.
+func NewTimeParser(layouts ...string) *TimeParser {
+	return &TimeParser{Layouts: layouts, Auto: true}
+}
+
+//LoadTimezone returns the location by the IANA name, e.g. "Asia/Shanghai",
+//"Local", "UTC", or a fixed offset, e.g. "+08:00", "UTC-5".
+func LoadTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	switch strings.ToUpper(name) {
+	case "", "LOCAL":
+		return time.Local, nil
+	case "UTC", "Z", "GMT":
+		return time.UTC, nil
+	}
+	if m := fixedOffset.FindStringSubmatch(strings.ToUpper(name)); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		mins, _ := strconv.Atoi(m[3])
+		offset := hours*3600 + mins*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	return time.LoadLocation(name)
+}
+
+func (p *TimeParser) location() *time.Location {
+	if p.Location == nil {
+		return time.Local
+	}
+	return p.Location
+}
+
+func (p *TimeParser) output(t time.Time) time.Time {
+	if p.Output != nil {
+		return t.In(p.Output)
+	}
+	return t
+}
+
+//Parse converts val to time. Strings are parsed with the embedded layout of
+//"value::layout", the layouts, AutoLayouts and numbers if Auto is enabled and
+//the locale in order. Numbers, including json.Number and Decimal, are
+//converted as the epoch.
+func (p *TimeParser) Parse(val interface{}) (time.Time, error) {
+	switch v := val.(type) {
+	case time.Time:
+		return p.output(v), nil
+	case *time.Time:
+		if v != nil {
+			return p.output(*v), nil
+		}
+	case string:
+		return p.parseString(v)
+	case []uint8:
+		return p.parseString(string(v))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number, Decimal:
+		num, err := FloatFromInterface(v)
+		if err == nil {
+			return p.parseNumber(num)
+		}
+	}
+	return time.Time{}, fmt.Errorf("Interface(%v) could not be converted to Time!", val)
+}
+
+func (p *TimeParser) parseString(val string) (time.Time, error) {
+	str := strings.TrimSpace(val)
+	loc := p.location()
+
+	if s, layout := splitTimeValueLayout(str); layout != "" {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return p.output(t), nil
+	}
+
+	for _, layout := range p.Layouts {
+		if t, ok := parseLayout(layout, str, loc); ok {
+			return p.output(t), nil
+		}
+	}
+
+	if p.Auto && str != "" {
+		if timeNumber.MatchString(str) {
+			//compact dates are digits as well
+			for _, layout := range []string{"20060102", "20060102150405"} {
+				if len(str) == len(layout) {
+					if t, err := time.ParseInLocation(layout, str, loc); err == nil {
+						return p.output(t), nil
+					}
+				}
+			}
+			if num, err := strconv.ParseFloat(str, 64); err == nil {
+				return p.parseNumber(num)
+			}
+		}
+		for _, layout := range AutoLayouts {
+			if t, ok := parseLayout(layout, str, loc); ok {
+				return p.output(t), nil
+			}
+		}
+	}
+
+	if p.Locale != nil {
+		if t, err := p.Locale.ParseTime(str, ""); err == nil {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+			return p.output(t), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Interface(%v) could not be converted to Time!", val)
+}
+
+//parseLayout parses the value as it is and with the date normalized as
+//TimeFromInterface, e.g. 2006-1-2 to 2006-01-02.
+func parseLayout(layout, str string, loc *time.Location) (time.Time, bool) {
+	if t, err := time.ParseInLocation(layout, str, loc); err == nil {
+		return t, true
+	}
+	if formatted, err := formatTime(str, layout); err == nil && formatted != str {
+		if t, err := time.ParseInLocation(layout, formatted, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (p *TimeParser) parseNumber(num float64) (time.Time, error) {
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		return time.Time{}, fmt.Errorf("%v could not be converted to Time!", num)
+	}
+
+	epoch := p.Epoch
+	if epoch == EpochAuto {
+		switch abs := math.Abs(num); {
+		case abs < 100000:
+			epoch = EpochExcel
+		case abs < 1e11:
+			epoch = EpochUnix
+		default:
+			epoch = EpochUnixMilli
+		}
+	}
+
+	var t time.Time
+	switch epoch {
+	case EpochUnix:
+		sec := math.Floor(num)
+		t = time.Unix(int64(sec), int64(math.Round((num-sec)*1e9))).In(p.location())
+	case EpochUnixMilli:
+		sec := math.Floor(num / 1000)
+		t = time.Unix(int64(sec), int64(math.Round((num-sec*1000)*1e6))).In(p.location())
+	case EpochExcel:
+		days := math.Floor(num)
+		if days < 61 {
+			days++
+		}
+		millis := math.Round((num - math.Floor(num)) * 86400000)
+		t = time.Date(1899, 12, 30, 0, 0, 0, 0, p.location()).AddDate(0, 0, int(days)).Add(time.Duration(millis) * time.Millisecond)
+	}
+	return p.output(t), nil
+}
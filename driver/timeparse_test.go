@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTimeParser(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min, sec, nsec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, nsec, time.UTC)
+	}
+	shanghai := time.FixedZone("+08:00", 8*3600)
+	tests := []struct {
+		layouts  []string
+		epoch    Epoch
+		location *time.Location
+		val      interface{}
+		want     time.Time
+	}{
+		{[]string{"02/01/2006 15:04"}, EpochAuto, nil, "03/04/2016 10:00", utc(2016, 4, 3, 10, 0, 0, 0)},
+		{nil, EpochAuto, nil, "2016-01-02T15:04:05+02:00", utc(2016, 1, 2, 13, 4, 5, 0)},
+		{nil, EpochAuto, nil, "2016-1-2", utc(2016, 1, 2, 0, 0, 0, 0)},
+		{nil, EpochAuto, nil, "20160102", utc(2016, 1, 2, 0, 0, 0, 0)},
+		{nil, EpochAuto, nil, "2016年1月2日", utc(2016, 1, 2, 0, 0, 0, 0)},
+		{nil, EpochAuto, nil, "2016|01|02::2006|01|02", utc(2016, 1, 2, 0, 0, 0, 0)},
+		{nil, EpochAuto, shanghai, "2016-01-02 10:00:00", utc(2016, 1, 2, 2, 0, 0, 0)},
+		{nil, EpochAuto, nil, 1451606400, utc(2016, 1, 1, 0, 0, 0, 0)},
+		{nil, EpochAuto, nil, int64(1451606400123), utc(2016, 1, 1, 0, 0, 0, 123000000)},
+		{nil, EpochAuto, nil, 1451606400.5, utc(2016, 1, 1, 0, 0, 0, 500000000)},
+		{nil, EpochAuto, nil, json.Number("1451606400"), utc(2016, 1, 1, 0, 0, 0, 0)},
+		{nil, EpochAuto, nil, 42370.75, utc(2016, 1, 1, 18, 0, 0, 0)},
+		{nil, EpochAuto, nil, "42370.5", utc(2016, 1, 1, 12, 0, 0, 0)},
+		{nil, EpochAuto, nil, 59, utc(1900, 2, 28, 0, 0, 0, 0)},
+		{nil, EpochAuto, nil, 61, utc(1900, 3, 1, 0, 0, 0, 0)},
+		{nil, EpochUnix, nil, 60, utc(1970, 1, 1, 0, 1, 0, 0)},
+		{nil, EpochUnixMilli, nil, int64(1500), utc(1970, 1, 1, 0, 0, 1, 500000000)},
+	}
+	for _, tt := range tests {
+		p := NewTimeParser(tt.layouts...)
+		p.Epoch = tt.epoch
+		p.Location = time.UTC
+		if tt.location != nil {
+			p.Location = tt.location
+		}
+		p.Output = time.UTC
+
+		got, err := p.Parse(tt.val)
+		if err != nil {
+			t.Errorf("%v: %v", tt.val, err)
+		} else if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("%v: got %v, want %v", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestTimeParserErrors(t *testing.T) {
+	p := NewTimeParser()
+	for _, val := range []interface{}{"junk", "", nil, math.NaN(), true} {
+		if got, err := p.Parse(val); err == nil {
+			t.Errorf("%v: got %v", val, got)
+		}
+	}
+	//numbers are only epochs if Auto is enabled
+	p = &TimeParser{Layouts: []string{"2006-01-02"}}
+	if got, err := p.Parse("1451606400"); err == nil {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestLoadTimezone(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"UTC", 0},
+		{"Z", 0},
+		{"+08:00", 8 * 3600},
+		{"UTC+8", 8 * 3600},
+		{"GMT-0530", -(5*3600 + 30*60)},
+	}
+	for _, tt := range tests {
+		loc, err := LoadTimezone(tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if _, offset := time.Date(2016, 1, 1, 0, 0, 0, 0, loc).Zone(); offset != tt.offset {
+			t.Errorf("%s: offset %d, want %d", tt.name, offset, tt.offset)
+		}
+	}
+	if loc, err := LoadTimezone("Local"); err != nil || loc != time.Local {
+		t.Errorf("Local: %v, %v", loc, err)
+	}
+	if _, err := LoadTimezone("No/Such_Zone"); err == nil {
+		t.Error("unknown zone is loaded")
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	for name, want := range map[string]Epoch{"auto": EpochAuto, "UNIX": EpochUnix, " unix_ms ": EpochUnixMilli, "excel": EpochExcel} {
+		if got, err := ParseEpoch(name); err != nil || got != want {
+			t.Errorf("%q: got %v, %v", name, got, err)
+		}
+	}
+	if _, err := ParseEpoch("julian"); err == nil {
+		t.Error("julian is parsed")
+	}
+}
+
+func TestTimeFromInterface(t *testing.T) {
+	got, err := TimeFromInterface("2016-1-2", "2006-01-02")
+	if want := time.Date(2016, 1, 2, 0, 0, 0, 0, time.Local); err != nil || !got.Equal(want) {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+	got, err = TimeFromInterface(1451606400, "")
+	if err != nil || got.Unix() != 1451606400 {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	//the current time is returned with the error
+	before := time.Now()
+	for _, val := range []interface{}{nil, "junk", 1.5} {
+		got, err := TimeFromInterface(val, "2006-01-02")
+		if err == nil || got.Before(before) || got.After(time.Now()) {
+			t.Errorf("%v: got %v, %v", val, got, err)
+		}
+	}
+}
@@ -90,6 +90,10 @@ func StringFromInterface(val interface{}) (string, error) {
 	case time.Time:
 		valt := val.(time.Time)
 		return valt.String(), nil
+	case Decimal:
+		return val.(Decimal).String(), nil
+	case json.Number:
+		return string(val.(json.Number)), nil
 	}
 	return "", fmt.Errorf("Interface(%v) could not be converted to String!\n", val)
 }
@@ -120,7 +124,11 @@ func ParseFloat(str string) (float64, error) {
 		return val, nil
 	}
 
-	//Some number may be seperated by comma, for example, 23,120,123, so remove the comma firstly
+	//Some number may be seperated by comma, for example, 23,120,123, so remove the comma firstly.
+	//A comma after the point is not a group separator, e.g. 1.234,56 of de, use Locale for it.
+	if pos := strings.Index(str, "."); pos >= 0 && strings.Contains(str[pos:], ",") {
+		return 0, fmt.Errorf("%q is not a number, the comma is after the point", str)
+	}
 	str = strings.Replace(str, ",", "", -1)
 
 	//Some number is specifed in scientific notation
@@ -170,6 +178,15 @@ func IntFromInterface(val interface{}) (int64, error) {
 		if fval, err := ParseFloat(val.(string)); err == nil {
 			return int64(fval), nil
 		}
+	case Decimal:
+		return val.(Decimal).Int64(RoundHalfUp)
+	case json.Number:
+		if ival, err := val.(json.Number).Int64(); err == nil {
+			return ival, nil
+		}
+		if fval, err := ParseFloat(string(val.(json.Number))); err == nil {
+			return int64(fval), nil
+		}
 	}
 	return 0, fmt.Errorf("Interface(value=%v, type=%v) could not be converted to Int!", val, reflect.TypeOf(val))
 }
@@ -191,6 +208,10 @@ func FloatFromInterface(val interface{}) (float64, error) {
 		if fval, err := ParseFloat(val.(string)); err == nil {
 			return fval, nil
 		}
+	case Decimal:
+		return val.(Decimal).Float64(), nil
+	case json.Number:
+		return val.(json.Number).Float64()
 	}
 	return 0.0, fmt.Errorf("Interface(value=%v, type=%v) Could not be converted to Float!\n", val, reflect.TypeOf(val))
 }
@@ -248,6 +269,7 @@ func splitTimeValueLayout(input string) (val, layout string) {
 //	layout: "20160102"
 //		"2006-01-02"
 //		"2006/01/02"
+//Use TimeParser for several layouts, timezones and epoch numbers.
 func TimeFromInterface(val interface{}, layout string) (time.Time, error) {
 	var err error
 	if nil == val {
@@ -518,16 +540,45 @@ func StrToType(typeStr string, src interface{}, layout ...string) (dst interface
 	case "jsonarray":
 		return json.Marshal(src)
 	default:
+		if strings.HasPrefix(typeStr, "decimal") {
+			decType, err := ParseDecimalType(typeStr)
+			if err != nil {
+				return nil, err
+			}
+			return decType.Convert(src)
+		}
 		return nil, fmt.Errorf("The type(%s) is not supported right now", typeStr)
 	}
 }
 
 //Define a common comman arguments for any objects.
+//Locale is the name of the Locale the value is written in, see LookupLocale.
 type Command struct {
-	Name  string           `json:"name"`
-	Type  string           `json:"type"`
-	Value interface{}      `json:"value"`
-	Arg   *json.RawMessage `json:"arg"`
+	Name   string           `json:"name"`
+	Type   string           `json:"type"`
+	Value  interface{}      `json:"value"`
+	Arg    *json.RawMessage `json:"arg"`
+	Locale string           `json:"locale,omitempty"`
+}
+
+//unmarshalCommand decodes json firstly and yaml if it is not json. yaml
+//converts the numbers to float, so the raw value of decimal types is lost.
+func unmarshalCommand(b []byte, v interface{}) error {
+	if err := json.Unmarshal(b, v); err == nil {
+		return nil
+	}
+	return yaml.Unmarshal(b, v)
+}
+
+//MarshalJSON writes the value of a raw command as the json it holds, so the
+//command is read back by UnmarshalJSON as it is.
+func (cmds Command) MarshalJSON() ([]byte, error) {
+	type command Command
+	tmp := command(cmds)
+	if str, ok := cmds.Value.(string); ok && "raw" == cmds.Type && json.Valid([]byte(str)) {
+		tmp.Value = json.RawMessage(str)
+	}
+	return json.Marshal(tmp)
 }
 
 //unmarshal the json string to command
@@ -538,9 +589,10 @@ func (cmds *Command) UnmarshalJSON(b []byte) error {
 		ItemType string           `json:"type"`
 		Items    *json.RawMessage `json:"value"`
 		Arg      *json.RawMessage `json:"arg"`
+		Locale   string           `json:"locale"`
 	}
 
-	err := yaml.Unmarshal(b, &tmp)
+	err := unmarshalCommand(b, &tmp)
 	if err != nil {
 		return err
 	}
@@ -548,10 +600,16 @@ func (cmds *Command) UnmarshalJSON(b []byte) error {
 	cmds.Name = tmp.CmdType
 	cmds.Type = tmp.ItemType
 	cmds.Arg = tmp.Arg
+	cmds.Locale = tmp.Locale
+
+	convert, err := converterOf(tmp.Locale)
+	if err != nil {
+		return err
+	}
 
 	if "complex" == tmp.ItemType {
 		items := []Command{}
-		err := yaml.Unmarshal(*(tmp.Items), &items)
+		err := unmarshalCommand(*(tmp.Items), &items)
 		if err != nil {
 			return err
 		}
@@ -559,7 +617,7 @@ func (cmds *Command) UnmarshalJSON(b []byte) error {
 		return nil
 	} else if "single" == tmp.ItemType {
 		items := []Command{}
-		err := yaml.Unmarshal(*(tmp.Items), &items)
+		err := unmarshalCommand(*(tmp.Items), &items)
 		if err != nil {
 			return err
 		}
@@ -567,7 +625,7 @@ func (cmds *Command) UnmarshalJSON(b []byte) error {
 		return nil
 	} else if "json" == tmp.ItemType {
 		items := map[string]interface{}{}
-		err := yaml.Unmarshal(*(tmp.Items), &items)
+		err := unmarshalCommand(*(tmp.Items), &items)
 		if err != nil {
 			return err
 		}
@@ -575,7 +633,7 @@ func (cmds *Command) UnmarshalJSON(b []byte) error {
 		return nil
 	} else if "jsonarray" == tmp.ItemType {
 		var items []map[string]interface{}
-		err := yaml.Unmarshal(*(tmp.Items), &items)
+		err := unmarshalCommand(*(tmp.Items), &items)
 		if err != nil {
 			return err
 		}
@@ -584,14 +642,36 @@ func (cmds *Command) UnmarshalJSON(b []byte) error {
 	} else if "raw" == tmp.ItemType {
 		cmds.Value = string(*tmp.Items)
 		return nil
+	} else if strings.HasPrefix(tmp.ItemType, "decimal") && tmp.Items != nil {
+		//parse the number from the raw json to not lose the precision by float
+		src := strings.TrimSpace(string(*tmp.Items))
+		if strings.HasPrefix(src, `"`) {
+			if err := json.Unmarshal(*tmp.Items, &src); err != nil {
+				return err
+			}
+		}
+		item, err := convert(tmp.ItemType, src)
+		if err != nil {
+			if HasPlaceholder(src) {
+				//converted once the variables are expanded, see Expander
+				cmds.Value = src
+				return nil
+			}
+			return err
+		}
+		cmds.Value = item
 	} else if tmp.ItemType != "" && tmp.Items != nil {
 		var src interface{}
-		err := yaml.Unmarshal(*(tmp.Items), &src)
+		err := unmarshalCommand(*(tmp.Items), &src)
 		if err != nil {
 			return err
 		}
-		item, err := StrToType(tmp.ItemType, src)
+		item, err := convert(tmp.ItemType, src)
 		if err != nil {
+			if str, ok := src.(string); ok && HasPlaceholder(str) {
+				cmds.Value = str
+				return nil
+			}
 			return err
 		}
 		cmds.Value = item
@@ -599,12 +679,17 @@ func (cmds *Command) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-//Construction is the Construction function of Command.
-//It connvert the src structure to a the ETLX defined command args format.
-//The src structure which could be connvert to command should have the silimar structure
-// and should has the item with the same json tag name with command
-func (cmd *Command) Construction(src interface{}) error {
-	return nil
+//converterOf returns the function converting the values written in the
+//locale, StrToType if the locale is empty.
+func converterOf(locale string) (func(string, interface{}, ...string) (interface{}, error), error) {
+	if locale == "" {
+		return StrToType, nil
+	}
+	l, err := LookupLocale(locale)
+	if err != nil {
+		return nil, err
+	}
+	return l.Convert, nil
 }
 
 type BatchStruct struct {
@@ -612,6 +697,10 @@ type BatchStruct struct {
 	BatchCtl  string `json:"batch_control"`
 }
 
+//Round adds half of the last digit to the binary value of src and truncates it
+//to n digits after the point, so 1.005 is 1 since it is 1.00499... as float and
+//-2.5 is -2.49. Use RoundFloat or Decimal to round the value as written with a
+//RoundingMode.
 func Round(src float64, n int) float64 {
 	pow10 := math.Pow10(n)
 
@@ -707,9 +796,20 @@ func MapToStructure(src map[string]interface{}, obj interface{}) error {
 			return fmt.Errorf("Field: [%s] is not setable!", key)
 		}
 
+		if key == "" {
+			continue
+		}
+
 		if value, ok := src[key]; ok {
+			if value == nil {
+				fieldToSet.Set(reflect.Zero(fieldToSet.Type()))
+				continue
+			}
 			rfValue := reflect.ValueOf(value)
 			if fieldToSet.Type() != rfValue.Type() {
+				if !rfValue.Type().ConvertibleTo(fieldToSet.Type()) {
+					return fmt.Errorf("Field: [%s] of %s could not be set with %T", key, fieldToSet.Type(), value)
+				}
 				rfValue = rfValue.Convert(fieldToSet.Type())
 			}
 			fieldToSet.Set(rfValue)
@@ -899,6 +999,7 @@ func (bt *Batch) SetBatch(limit, offset int64) {
 type Table struct {
 	data    [][]interface{}
 	columns []string
+	schema  Schema
 	cursor  int
 }
 
@@ -946,6 +1047,17 @@ func (t *Table) SetColumns(cols []string) {
 	t.columns = cols
 }
 
+//SetSchema sets the schema of the table, the columns are set as well.
+func (t *Table) SetSchema(schema Schema) {
+	t.schema = schema
+	t.columns = schema.Columns()
+}
+
+//Schema returns the schema set by SetSchema, it is empty if not set.
+func (t *Table) Schema() Schema {
+	return t.schema
+}
+
 func (t *Table) SetData(data [][]interface{}) {
 	t.data = data
 }
@@ -966,21 +1078,63 @@ func (t *Table) Columns() []string {
 	return t.columns
 }
 
+//Next copies the next row to dst, see CopyRow for the types of dst supported.
+//EOT is returned at the end of the table.
 func (t *Table) Next(dst interface{}) error {
 	if t.cursor >= len(t.data) {
-		return fmt.Errorf("Reach the end of table")
+		return EOT
 	}
-	if value, ok := dst.(**[]interface{}); ok {
-		*value = &t.data[t.cursor]
-	} else if value, ok := dst.(*interface{}); ok {
-		*value = t.data[t.cursor]
-	} else if value, ok := dst.([]interface{}); ok {
-		for i, k := range t.data[t.cursor] {
-			value[i] = k
-		}
-	} else {
-		fmt.Println("could not copy next in sql,", value)
+	if err := CopyRow(t.columns, t.data[t.cursor], dst); err != nil {
+		return err
 	}
 	t.cursor++
 	return nil
 }
+
+//NextRsltAndIndex is the same as Next, Table does not build any index.
+func (t *Table) NextRsltAndIndex(rslt interface{}, index *map[string]interface{}) error {
+	return t.Next(rslt)
+}
+
+//CopyRow copies row to dst, it could be used by the drivers whose rows are in
+//memory. dst could be:
+//	**[]interface{}, *interface{}: set to the row itself without copy;
+//	[]interface{}: the values are copied, it should be long enough;
+//	*[]interface{}: set to a copy of the row;
+//	*map[string]interface{}: the values are set by the columns;
+//	pointer to a struct: the fields are set by the map tag as MapToStructure.
+func CopyRow(columns []string, row []interface{}, dst interface{}) error {
+	switch value := dst.(type) {
+	case **[]interface{}:
+		*value = &row
+	case *interface{}:
+		*value = row
+	case []interface{}:
+		if len(value) < len(row) {
+			return fmt.Errorf("Destination has %d values but the row has %d", len(value), len(row))
+		}
+		copy(value, row)
+	case *[]interface{}:
+		*value = append((*value)[:0], row...)
+	case *map[string]interface{}:
+		if *value == nil {
+			*value = make(map[string]interface{}, len(columns))
+		}
+		for i, name := range columns {
+			if i < len(row) {
+				(*value)[name] = row[i]
+			}
+		}
+	default:
+		rv := reflect.ValueOf(dst)
+		if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("Unsupported type of destination %T", dst)
+		}
+		m, err := ArrayToMap(columns, row)
+		if err != nil {
+			return err
+		}
+		return MapToStructure(m, dst)
+	}
+	return nil
+}
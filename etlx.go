@@ -14,89 +14,128 @@ type EtlDriver struct {
 	Extract   map[string]driver.ExtractDriver
 	Transform map[string]driver.TransformDriver
 	Load      map[string]driver.LoadDriver
+	//Info is the metadata given at registration by phase and name.
+	Info map[string]map[string]driver.DriverInfo
 }
 
-var (
-	drivers  = EtlDriver{}
-	driverMu sync.Mutex
-)
+//ExtractRegister makes an extract driver available by the provided name in
+//DefaultRegistry, the metadata of the driver could be given optionally, see
+//DriverInfoOf. If registered twice with the same name of if driver is nil, it panics.
+func ExtractRegister(name string, drv driver.ExtractDriver, info ...driver.DriverInfo) {
+	DefaultRegistry.ExtractRegister(name, drv, info...)
+}
 
-func init() {
-	drivers.Extract = make(map[string]driver.ExtractDriver)
-	drivers.Transform = make(map[string]driver.TransformDriver)
-	drivers.Load = make(map[string]driver.LoadDriver)
+//TransformRegister makes a transform driver available by the provided name.
+func TransformRegister(name string, drv driver.TransformDriver, info ...driver.DriverInfo) {
+	DefaultRegistry.TransformRegister(name, drv, info...)
 }
 
-//ExtractRegister makes an extract driver available by the provided name.
-//If registered twice with the same name of if driver is nil, it panics.
-func ExtractRegister(name string, driver driver.ExtractDriver) {
-	driverMu.Lock()
-	defer driverMu.Unlock()
+//Load makes a load driver available by the provided name.
+func LoadRegister(name string, drv driver.LoadDriver, info ...driver.DriverInfo) {
+	DefaultRegistry.LoadRegister(name, drv, info...)
+}
 
-	if driver == nil {
-		panic("exlx: Register driver is nil")
-	}
-	if _, ok := drivers.Extract[name]; ok {
-		panic("etlx: duplicated register extract driver:" + name)
-	}
-	drivers.Extract[name] = driver
+//FindExtract is to find an extractor driver specify by its name, return nil if not found
+func FindExtract(name string) driver.ExtractDriver {
+	return DefaultRegistry.FindExtract(name)
 }
 
-//TransformRegister makes a transform driver available by the provided name.
-func TransformRegister(name string, driver driver.TransformDriver) {
-	driverMu.Lock()
-	defer driverMu.Unlock()
+//FindTransform is to find a transformer driver specify by its name, return nil if not found
+func FindTransform(name string) driver.TransformDriver {
+	return DefaultRegistry.FindTransform(name)
+}
 
-	if driver == nil {
-		panic("exlx: Register driver is nil")
-	}
+//FindLoad is to find a loader driver specify by its name, return nil if not found
+func FindLoad(name string) driver.LoadDriver {
+	return DefaultRegistry.FindLoad(name)
+}
 
-	if _, ok := drivers.Transform[name]; ok {
-		panic("etlx: duplicated register transform driver:" + name)
-	}
-	drivers.Transform[name] = driver
+//driver phases, see CommandSchemaOf and DriverNames
+const (
+	PhaseExtract   = "extract"
+	PhaseTransform = "transform"
+	PhaseLoad      = "load"
+)
+
+//DriverNames returns the sorted names of the drivers registered for the phase.
+func DriverNames(phase string) []string {
+	return DefaultRegistry.DriverNames(phase)
 }
 
-//Load makes a load driver available by the provided name.
-func LoadRegister(name string, driver driver.LoadDriver) {
-	driverMu.Lock()
-	defer driverMu.Unlock()
+//DriverInfoOf returns the metadata of the driver, see Registry.DriverInfoOf.
+func DriverInfoOf(phase, name string) (driver.DriverInfo, bool) {
+	return DefaultRegistry.DriverInfoOf(phase, name)
+}
 
-	if driver == nil {
-		panic("load: Register driver is nil")
-	}
+//ListDrivers returns the drivers of the phase declaring the capabilities, see
+//Registry.ListDrivers.
+func ListDrivers(phase string, caps ...driver.Capability) []DriverEntry {
+	return DefaultRegistry.ListDrivers(phase, caps...)
+}
 
-	if _, ok := drivers.Load[name]; ok {
-		panic("load: duplicated register Load driver:" + name)
-	}
-	drivers.Load[name] = driver
+//CommandSchemaOf returns the command schema of the driver, false if the driver
+//is not found or does not implement driver.CommandSchemaDriver.
+func CommandSchemaOf(phase, name string) (driver.CommandSchema, bool) {
+	return DefaultRegistry.CommandSchemaOf(phase, name)
 }
 
-//FindExtract is to find an extractor driver specify by its name, return nil if not found
-func FindExtract(name string) driver.ExtractDriver {
-	drv, ok := drivers.Extract[name]
+//ValidateCommands validates the commands against the schema of the driver, it
+//returns nil if the driver does not publish its schema.
+func ValidateCommands(phase, name string, args []driver.Command) error {
+	return DefaultRegistry.ValidateCommands(phase, name, args)
+}
+
+func validateCommands(drv interface{}, phase, name string, args []driver.Command) error {
+	sd, ok := drv.(driver.CommandSchemaDriver)
 	if !ok {
 		return nil
 	}
-	return drv
+	if err := sd.CommandSchema().Validate(args); err != nil {
+		return errors.Errorf("%s driver %s: %v", phase, name, err)
+	}
+	return nil
 }
 
-//FindTransform is to find a transformer driver specify by its name, return nil if not found
-func FindTransform(name string) driver.TransformDriver {
-	drv, ok := drivers.Transform[name]
-	if !ok {
-		return nil
+var (
+	secrets  driver.SecretProvider
+	secretMu sync.RWMutex
+)
+
+//SetSecretProvider sets the provider of the secret://name references in the
+//data sources of NewExtract, NewTransform, NewLoad and the transactions
+//without the Secrets option.
+func SetSecretProvider(p driver.SecretProvider) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secrets = p
+}
+
+func secretProvider() driver.SecretProvider {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return secrets
+}
+
+//openDriver resolves the secrets of the data source and opens it by open with
+//the redacted data source to be kept or logged. The credentials are redacted
+//from the errors as well.
+func openDriver(phase, name, dataSource string, p driver.SecretProvider, open func(dsn, redacted string) error) error {
+	dsn, values, err := driver.ResolveSecrets(dataSource, p)
+	if err != nil {
+		return errors.Errorf("etlx: %s %s: %v", phase, name, err)
 	}
-	return drv
+	return redactError(open(dsn, driver.RedactSecrets(dsn, values...)), values)
 }
 
-//FindLoad is to find a loader driver specify by its name, return nil if not found
-func FindLoad(name string) driver.LoadDriver {
-	drv, ok := drivers.Load[name]
-	if !ok {
+//redactError masks the secrets and the credentials of data sources in err.
+func redactError(err error, secrets []string) error {
+	if err == nil {
 		return nil
 	}
-	return drv
+	if msg := driver.RedactSecrets(err.Error(), secrets...); msg != err.Error() {
+		return errors.New(msg)
+	}
+	return err
 }
 
 type ExtractHandler struct {
@@ -105,12 +144,24 @@ type ExtractHandler struct {
 }
 
 func NewExtract(driverName, name, dataSource string, rawArg []driver.Command) (*ExtractHandler, error) {
-	drv := FindExtract(driverName)
+	return DefaultRegistry.NewExtract(driverName, name, dataSource, rawArg)
+}
+
+//NewExtract opens the extract driver of the registry and builds the command.
+func (r *Registry) NewExtract(driverName, name, dataSource string, rawArg []driver.Command) (*ExtractHandler, error) {
+	drv := r.FindExtract(driverName)
 	if drv == nil {
 		return nil, errors.Errorf("Could not find the extract driver from name %s", driverName)
 	}
+	if err := validateCommands(drv, PhaseExtract, driverName, rawArg); err != nil {
+		return nil, err
+	}
 
-	handler, err := drv.Open(name, dataSource)
+	var handler driver.Extract
+	err := openDriver(PhaseExtract, name, dataSource, secretProvider(), func(dsn, _ string) (err error) {
+		handler, err = drv.Open(name, dsn)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -134,12 +185,24 @@ type TransformHandler struct {
 }
 
 func NewTransform(driverName, name, dataSource string, rawArg []driver.Command) (*TransformHandler, error) {
-	drv := FindTransform(driverName)
+	return DefaultRegistry.NewTransform(driverName, name, dataSource, rawArg)
+}
+
+//NewTransform opens the transform driver of the registry and builds the command.
+func (r *Registry) NewTransform(driverName, name, dataSource string, rawArg []driver.Command) (*TransformHandler, error) {
+	drv := r.FindTransform(driverName)
 	if drv == nil {
 		return nil, errors.Errorf("Could not find the transform driver from name %s", driverName)
 	}
+	if err := validateCommands(drv, PhaseTransform, driverName, rawArg); err != nil {
+		return nil, err
+	}
 
-	handler, err := drv.Open(name, dataSource)
+	var handler driver.Transform
+	err := openDriver(PhaseTransform, name, dataSource, secretProvider(), func(dsn, _ string) (err error) {
+		handler, err = drv.Open(name, dsn)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -163,12 +226,28 @@ type LoadHandler struct {
 }
 
 func NewLoad(driverName string, name string, dataSource string, rawArg []driver.Command) (*LoadHandler, error) {
-	drv := FindLoad(driverName)
+	return DefaultRegistry.NewLoad(driverName, name, dataSource, rawArg)
+}
+
+//NewLoad opens the load driver of the registry and builds the command.
+func (r *Registry) NewLoad(driverName string, name string, dataSource string, rawArg []driver.Command) (*LoadHandler, error) {
+	return r.newLoad(driverName, name, dataSource, rawArg, secretProvider())
+}
+
+func (r *Registry) newLoad(driverName string, name string, dataSource string, rawArg []driver.Command, p driver.SecretProvider) (*LoadHandler, error) {
+	drv := r.FindLoad(driverName)
 	if drv == nil {
 		return nil, errors.Errorf("Could not find the load driver from name %s", driverName)
 	}
+	if err := validateCommands(drv, PhaseLoad, driverName, rawArg); err != nil {
+		return nil, err
+	}
 
-	handler, err := drv.Open(name, dataSource)
+	var handler driver.Load
+	err := openDriver(PhaseLoad, name, dataSource, p, func(dsn, _ string) (err error) {
+		handler, err = drv.Open(name, dsn)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -185,3 +264,14 @@ func NewLoad(driverName string, name string, dataSource string, rawArg []driver.
 func (ctx *LoadHandler) Run(result driver.Results) error {
 	return ctx.Handler.Load(result, ctx.Arg)
 }
+
+//LoadOpener opens a load handler, e.g. NewLoad or Transaction.NewLoad.
+type LoadOpener func(driverName, name, dataSource string, rawArg []driver.Command) (*LoadHandler, error)
+
+//LoadOpenerSetter is implemented by the transform handlers which open load
+//handlers themselves, e.g. the dead letter of validate. TransformOpen sets
+//Transaction.NewLoad to them, so the loaders are opened as the transaction
+//opens its own. The handlers opened without a transaction should use NewLoad.
+type LoadOpenerSetter interface {
+	SetLoadOpener(open LoadOpener)
+}
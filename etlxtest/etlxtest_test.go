@@ -0,0 +1,289 @@
+package etlxtest_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/etlxtest"
+)
+
+var columns = []string{"id", "name", "score"}
+
+func data() [][]interface{} {
+	return [][]interface{}{
+		{int64(1), "a", 1.5},
+		{int64(2), "b", 2.5},
+		{int64(3), "a", 3.5},
+		{int64(4), "c", 4.5},
+		{int64(5), "b", 5.5},
+		{int64(6), "d", 6.5},
+		{int64(7), "a", 7.5},
+		{int64(8), "e", 8.5},
+	}
+}
+
+func input() driver.Rows {
+	return etlxtest.NewRows(columns, data())
+}
+
+func results() driver.Results {
+	return etlxtest.NewRows(columns, data())
+}
+
+func TestMockExtract(t *testing.T) {
+	etlxtest.TestExtract(t, etlxtest.ExtractCase{
+		Driver:     &etlxtest.MockExtract{Columns: columns, Rows: data()},
+		DataSource: "mock",
+		Batch:      true,
+		Rows:       8,
+	})
+}
+
+func TestMockTransform(t *testing.T) {
+	etlxtest.TestTransform(t, etlxtest.TransformCase{
+		Driver:  &etlxtest.MockTransform{},
+		Input:   input,
+		Ordered: true,
+	})
+	etlxtest.TestTransform(t, etlxtest.TransformCase{
+		Driver: &etlxtest.MockTransform{Fn: func(row []interface{}) ([]interface{}, error) {
+			if row[1] == "a" {
+				return nil, nil
+			}
+			return row, nil
+		}},
+		Input: input,
+	})
+}
+
+func TestDecimalRows(t *testing.T) {
+	decimals := func() driver.Rows {
+		rows := [][]interface{}{}
+		for _, s := range []string{"1.10", "2.25", "-3"} {
+			d, err := driver.ParseDecimal(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, []interface{}{d})
+		}
+		return etlxtest.NewRows([]string{"dec"}, rows)
+	}
+	//every row gets a new decimal, equal but of another big.Int
+	etlxtest.TestTransform(t, etlxtest.TransformCase{
+		Driver: &etlxtest.MockTransform{Fn: func(row []interface{}) ([]interface{}, error) {
+			d, err := driver.ParseDecimal(row[0].(driver.Decimal).String())
+			return []interface{}{d}, err
+		}},
+		Input:   decimals,
+		Ordered: true,
+	})
+}
+
+func TestMockLoad(t *testing.T) {
+	load := &etlxtest.MockLoad{}
+	etlxtest.TestLoad(t, etlxtest.LoadCase{
+		Driver:      load,
+		DataSource:  "mock",
+		Input:       results,
+		Concurrency: 8,
+	})
+	//Load, Empty and Concurrent
+	if _, rows := load.Loaded(); len(rows) != 8*9 {
+		t.Errorf("loaded %d rows", len(rows))
+	}
+
+	load = &etlxtest.MockLoad{CommandErr: errors.New("bad command"), Err: errors.New("load failed")}
+	handler, _ := load.Open("mock", "mock")
+	if _, err := handler.Command(nil); err != load.CommandErr {
+		t.Errorf("Command returned %v", err)
+	}
+	if err := handler.Load(results(), nil); err != load.Err {
+		t.Errorf("Load returned %v", err)
+	}
+}
+
+func TestTransactionWithMocks(t *testing.T) {
+	for _, batch := range []int64{0, 3} {
+		ext := &etlxtest.MockExtract{Columns: columns, Rows: data()}
+		load := &etlxtest.MockLoad{}
+		r := etlx.NewRegistry()
+		r.ExtractRegister("mock", ext)
+		r.TransformRegister("mock", &etlxtest.MockTransform{})
+		r.LoadRegister("mock", load)
+
+		job := &etlx.Job{
+			Name:      "mock",
+			Extract:   etlx.Stage{Driver: "mock", DataSource: "in"},
+			Transform: etlx.Stage{Driver: "mock"},
+			Load:      etlx.Stage{Driver: "mock", DataSource: "out"},
+			Batch:     batch,
+		}
+		if err := job.Run(etlx.UseRegistry(r)); err != nil {
+			t.Fatalf("batch %d: %v", batch, err)
+		}
+
+		got, rows := load.Loaded()
+		if len(got) != len(columns) || len(rows) != 8 {
+			t.Errorf("batch %d: loaded %v %d rows", batch, got, len(rows))
+		}
+		if batch > 0 && load.Loads() != 3 {
+			t.Errorf("batch %d: loaded %d times", batch, load.Loads())
+		}
+		if handlers := ext.Opened(); len(handlers) != 1 || handlers[0].DataSource != "in" || !handlers[0].Closed {
+			t.Errorf("batch %d: handlers %+v", batch, handlers)
+		}
+	}
+}
+
+//brokenEnv names the broken driver TestBroken runs the suite with.
+const brokenEnv = "ETLXTEST_BROKEN"
+
+//TestSuitesCatchBugs runs TestBroken in a new process for every broken driver
+//and expects it to fail in the subtest checking the bug.
+func TestSuitesCatchBugs(t *testing.T) {
+	if os.Getenv(brokenEnv) != "" {
+		t.Skip("running a broken driver")
+	}
+	for name, subtest := range map[string]string{
+		"offset":  "Batch",
+		"eot":     "query",
+		"close":   "Close",
+		"command": "BadCommands",
+		"shared":  "Concurrent",
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestBroken$", "-test.v")
+		cmd.Env = append(os.Environ(), brokenEnv+"="+name)
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			t.Errorf("%s: the suite passed the broken driver:\n%s", name, out)
+		} else if !strings.Contains(string(out), subtest) {
+			t.Errorf("%s: %s did not fail:\n%s", name, subtest, out)
+		}
+	}
+}
+
+func TestBroken(t *testing.T) {
+	switch os.Getenv(brokenEnv) {
+	case "":
+		t.Skip("run by TestSuitesCatchBugs")
+	case "offset", "eot", "close", "command":
+		etlxtest.TestExtract(t, etlxtest.ExtractCase{
+			Driver:     &brokenExtract{bug: os.Getenv(brokenEnv)},
+			DataSource: "broken",
+			Batch:      true,
+			BadArgs:    []driver.Command{{Name: "no_such_command"}},
+		})
+	case "shared":
+		etlxtest.TestTransform(t, etlxtest.TransformCase{Driver: &sharedTransform{}, Input: input})
+	}
+}
+
+//brokenExtract is the mock extract driver with one bug.
+type brokenExtract struct {
+	bug string
+}
+
+func (d *brokenExtract) Open(name, dataSource string) (driver.Extract, error) {
+	return &brokenExtractor{bug: d.bug}, nil
+}
+
+type brokenExtractor struct {
+	bug           string
+	limit, offset int64
+	closed        bool
+}
+
+func (e *brokenExtractor) SetBatch(limit int64, offset int64) {
+	e.limit, e.offset = limit, offset
+	if e.bug == "offset" {
+		e.offset = 0
+	}
+}
+
+func (e *brokenExtractor) Command(args []driver.Command) (interface{}, error) {
+	if len(args) > 0 && e.bug != "command" {
+		return nil, errors.New("unsupported command")
+	}
+	return nil, nil
+}
+
+func (e *brokenExtractor) Query(cmd interface{}) (driver.Rows, error) {
+	rows := data()
+	if e.limit > 0 {
+		if e.offset >= int64(len(rows)) {
+			return nil, driver.EOT
+		}
+		rows = rows[e.offset:]
+		if e.limit < int64(len(rows)) {
+			rows = rows[:e.limit]
+		}
+	}
+	if e.bug == "eot" {
+		return &restartRows{Table: etlxtest.NewRows(columns, rows)}, nil
+	}
+	return etlxtest.NewRows(columns, rows), nil
+}
+
+func (e *brokenExtractor) Close() error {
+	if e.closed && e.bug == "close" {
+		return errors.New("closed twice")
+	}
+	e.closed = true
+	return nil
+}
+
+//restartRows starts from the first row again after EOT.
+type restartRows struct {
+	*driver.Table
+}
+
+func (r *restartRows) Next(dst interface{}) error {
+	err := r.Table.Next(dst)
+	if err == driver.EOT {
+		r.Table.ResetCurosr()
+	}
+	return err
+}
+
+//sharedTransform keeps the input of Exec in the handler, so the batches
+//executed at once by one handler are mixed.
+type sharedTransform struct{}
+
+func (d *sharedTransform) Open(name, dataSource string) (driver.Transform, error) {
+	return &sharedTransformer{}, nil
+}
+
+type sharedTransformer struct {
+	mu  sync.Mutex
+	src driver.Rows
+}
+
+func (h *sharedTransformer) Command(args []driver.Command) (interface{}, error) {
+	return nil, nil
+}
+
+func (h *sharedTransformer) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	h.mu.Lock()
+	h.src = src
+	h.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rows, err := etlxtest.ReadAll(h.src)
+	if err != nil {
+		return nil, err
+	}
+	return etlxtest.NewRows(h.src.Columns(), rows), nil
+}
+
+func (h *sharedTransformer) Close() error {
+	return nil
+}
@@ -0,0 +1,369 @@
+package etlxtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//DefaultMaxDiffs is the number of differences reported if MaxDiffs is 0.
+const DefaultMaxDiffs = 20
+
+const (
+	fixtureDriver = "etlxtest.fixture"
+	captureDriver = "etlxtest.capture"
+)
+
+//GoldenCase runs the job with its extract swapped for the fixture file and
+//its load swapped for a capture sink, and compares the output with the golden
+//file. The fixture and the golden file are csv or json as etlx.ReadFile.
+type GoldenCase struct {
+	Job     *etlx.Job
+	Fixture string
+	Golden  string
+	//Update writes the output to the golden file instead of comparing.
+	Update bool
+	//IgnoreOrder compares the rows sorted by their text, for the transforms
+	//whose order is not defined, e.g. in batch mode.
+	IgnoreOrder bool
+	//Tolerance is the absolute difference allowed between two numbers.
+	Tolerance float64
+	MaxDiffs  int
+	//Registry is where the transform driver of the job is found,
+	//etlx.DefaultRegistry if nil.
+	Registry *etlx.Registry
+	//Options of the transaction, e.g. etlx.Expand.
+	Options []func(*etlx.Transaction)
+}
+
+//GoldenResult is the output of the job and its differences from the golden file.
+type GoldenResult struct {
+	Columns []string
+	Rows    [][]interface{}
+	Diffs   []string
+	//Updated is true if the golden file is written.
+	Updated bool
+}
+
+//OK returns true if the output is the same as the golden file.
+func (r *GoldenResult) OK() bool {
+	return len(r.Diffs) == 0
+}
+
+//RunGolden runs the case, the error is returned if the job could not run,
+//not if the output differs.
+func RunGolden(c GoldenCase) (*GoldenResult, error) {
+	if c.Job == nil {
+		return nil, errorf("the job is nil")
+	}
+	src := c.Registry
+	if src == nil {
+		src = etlx.DefaultRegistry
+	}
+	trans := src.FindTransform(c.Job.Transform.Driver)
+	if trans == nil {
+		return nil, errorf("transform driver %s not found", c.Job.Transform.Driver)
+	}
+
+	input, err := etlx.ReadFile(c.Fixture)
+	if err != nil {
+		return nil, errorf("fixture %s: %v", c.Fixture, err)
+	}
+	data, err := ReadAll(input)
+	input.Close()
+	if err != nil {
+		return nil, errorf("fixture %s: %v", c.Fixture, err)
+	}
+
+	load := &MockLoad{}
+	r := etlx.NewRegistry()
+	r.ExtractRegister(fixtureDriver, &MockExtract{Columns: input.Columns(), Rows: data})
+	info, _ := src.DriverInfoOf(etlx.PhaseTransform, c.Job.Transform.Driver)
+	r.TransformRegister(c.Job.Transform.Driver, trans, info)
+	r.LoadRegister(captureDriver, load)
+
+	job := *c.Job
+	job.Extract = etlx.Stage{Driver: fixtureDriver, Name: c.Job.Extract.Name, DataSource: c.Fixture}
+	job.Load = etlx.Stage{Driver: captureDriver, Name: c.Job.Load.Name, DataSource: c.Golden}
+	if err := job.Run(append(c.Options, etlx.UseRegistry(r))...); err != nil {
+		return nil, err
+	}
+
+	rslt := &GoldenResult{}
+	rslt.Columns, rslt.Rows = load.Loaded()
+	if c.Update {
+		if err := WriteFile(c.Golden, rslt.Columns, rslt.Rows); err != nil {
+			return nil, err
+		}
+		rslt.Updated = true
+		return rslt, nil
+	}
+
+	golden, err := etlx.ReadFile(c.Golden)
+	if os.IsNotExist(err) {
+		return nil, errorf("golden file %s not found, run with Update (-update of etlx test) to create it", c.Golden)
+	} else if err != nil {
+		return nil, errorf("golden file %s: %v", c.Golden, err)
+	}
+	defer golden.Close()
+	want, err := ReadAll(golden)
+	if err != nil {
+		return nil, errorf("golden file %s: %v", c.Golden, err)
+	}
+
+	rslt.Diffs = Compare(rslt.Columns, rslt.Rows, golden.Columns(), want, c.IgnoreOrder, c.Tolerance, c.MaxDiffs)
+	return rslt, nil
+}
+
+//TestGolden runs the case and reports the differences as the failure of t.
+func TestGolden(t *testing.T, c GoldenCase) {
+	rslt, err := RunGolden(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rslt.Updated {
+		t.Logf("updated %s", c.Golden)
+	} else if !rslt.OK() {
+		t.Errorf("the output differs from %s:\n%s", c.Golden, strings.Join(rslt.Diffs, "\n"))
+	}
+}
+
+//Compare returns the differences of the rows got from the rows wanted, at most
+//maxDiffs of them. The columns are matched by name, in any order. The values
+//are compared as text, nil is the same as "", and two numbers are the same if
+//they differ by tolerance at most. The rows are sorted by their text first if
+//ignoreOrder is true, the row numbers are then of the sorted rows.
+func Compare(gotColumns []string, got [][]interface{}, wantColumns []string, want [][]interface{}, ignoreOrder bool, tolerance float64, maxDiffs int) []string {
+	if maxDiffs <= 0 {
+		maxDiffs = DefaultMaxDiffs
+	}
+	diffs := []string{}
+	//a json file of no rows has no columns
+	if len(wantColumns) == 0 && len(want) == 0 {
+		wantColumns = gotColumns
+	}
+
+	gotIndex := map[string]int{}
+	for i, col := range gotColumns {
+		gotIndex[col] = i
+	}
+	wantIndex := map[string]int{}
+	columns := []string{}
+	for i, col := range wantColumns {
+		wantIndex[col] = i
+		if _, ok := gotIndex[col]; ok {
+			columns = append(columns, col)
+		} else {
+			diffs = append(diffs, fmt.Sprintf("missing column %s", col))
+		}
+	}
+	for _, col := range gotColumns {
+		if _, ok := wantIndex[col]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected column %s", col))
+		}
+	}
+
+	gotText := rowsText(got, columns, gotIndex)
+	wantText := rowsText(want, columns, wantIndex)
+	if ignoreOrder {
+		sortText(gotText)
+		sortText(wantText)
+	}
+	if len(gotText) != len(wantText) {
+		diffs = append(diffs, fmt.Sprintf("got %d rows, want %d", len(gotText), len(wantText)))
+	}
+
+	for i := 0; i < len(gotText) || i < len(wantText); i++ {
+		switch {
+		case i >= len(wantText):
+			diffs = append(diffs, fmt.Sprintf("row %d: unexpected %s", i+1, formatRow(columns, gotText[i])))
+		case i >= len(gotText):
+			diffs = append(diffs, fmt.Sprintf("row %d: missing %s", i+1, formatRow(columns, wantText[i])))
+		default:
+			for j, col := range columns {
+				if !sameText(gotText[i][j], wantText[i][j], tolerance) {
+					diffs = append(diffs, fmt.Sprintf("row %d, column %s: got %q, want %q", i+1, col, gotText[i][j], wantText[i][j]))
+				}
+			}
+		}
+	}
+	if len(diffs) > maxDiffs {
+		diffs = append(diffs[:maxDiffs], fmt.Sprintf("... and %d more differences", len(diffs)-maxDiffs))
+	}
+	return diffs
+}
+
+func rowsText(rows [][]interface{}, columns []string, index map[string]int) [][]string {
+	text := make([][]string, len(rows))
+	for i, row := range rows {
+		text[i] = make([]string, len(columns))
+		for j, col := range columns {
+			if k := index[col]; k < len(row) {
+				text[i][j] = Text(row[k])
+			}
+		}
+	}
+	return text
+}
+
+func sortText(rows [][]string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k := range rows[i] {
+			if rows[i][k] != rows[j][k] {
+				return rows[i][k] < rows[j][k]
+			}
+		}
+		return false
+	})
+}
+
+func sameText(got, want string, tolerance float64) bool {
+	if got == want {
+		return true
+	}
+	g, gerr := strconv.ParseFloat(got, 64)
+	w, werr := strconv.ParseFloat(want, 64)
+	return gerr == nil && werr == nil && math.Abs(g-w) <= tolerance
+}
+
+func formatRow(columns []string, row []string) string {
+	fields := make([]string, len(columns))
+	for i, col := range columns {
+		fields[i] = col + "=" + row[i]
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+//Text returns the value as it is written to the golden csv files.
+func Text(val interface{}) string {
+	switch v := driver.DataPreProcess(val).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(val)
+}
+
+//jsonValue returns the value as it is written to the golden json files, the
+//values json could not keep are written as Text.
+func jsonValue(val interface{}) interface{} {
+	switch v := driver.DataPreProcess(val).(type) {
+	case nil, bool, string, json.Number, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Text(v)
+		}
+		return v
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return Text(v)
+		}
+		return v
+	case driver.Decimal:
+		return json.Number(v.String())
+	case map[string]interface{}, []interface{}:
+		return v
+	}
+	return Text(val)
+}
+
+//WriteFile writes the rows to a csv file with header, or a json file of an
+//array of objects, one object per line so the changes are easy to review.
+func WriteFile(path string, columns []string, rows [][]interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		err = writeCSV(f, columns, rows)
+	case ".json":
+		err = writeJSON(f, columns, rows)
+	default:
+		err = fmt.Errorf("unsupported file type of %s", path)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeCSV(f *os.File, columns []string, rows [][]interface{}) error {
+	w := csv.NewWriter(f)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i := range columns {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = Text(row[i])
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeJSON(f *os.File, columns []string, rows [][]interface{}) error {
+	var b strings.Builder
+	b.WriteString("[")
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n{")
+		for j, col := range columns {
+			var val interface{}
+			if j < len(row) {
+				val = jsonValue(row[j])
+			}
+			key, _ := json.Marshal(col)
+			v, err := json.Marshal(val)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", col, err)
+			}
+			if j > 0 {
+				b.WriteString(",")
+			}
+			b.Write(key)
+			b.WriteString(":")
+			b.Write(v)
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("\n]\n")
+	_, err := f.WriteString(b.String())
+	return err
+}
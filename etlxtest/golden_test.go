@@ -0,0 +1,128 @@
+package etlxtest_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/etlxtest"
+	_ "github.com/xingwangc/etlx/transform"
+)
+
+func writeFixture(t *testing.T, dir string) string {
+	fixture := filepath.Join(dir, "in.csv")
+	if err := ioutil.WriteFile(fixture, []byte("id,name,price\n1,a,1.5\n2,b,2.25\n3,c,10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+func computeJob(expr string) *etlx.Job {
+	return &etlx.Job{
+		Name:      "compute",
+		Extract:   etlx.Stage{Driver: "postgres", DataSource: "db"},
+		Transform: etlx.Stage{Driver: "compute", Args: []driver.Command{{Name: "total", Value: expr}}},
+		Load:      etlx.Stage{Driver: "postgres", DataSource: "db"},
+	}
+}
+
+func TestGoldenUpdate(t *testing.T) {
+	for _, ext := range []string{".csv", ".json"} {
+		dir := t.TempDir()
+		c := etlxtest.GoldenCase{Job: computeJob("price * 3"), Fixture: writeFixture(t, dir), Golden: filepath.Join(dir, "out"+ext)}
+		if _, err := etlxtest.RunGolden(c); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Fatalf("%s: RunGolden without the golden file returned %v", ext, err)
+		}
+
+		c.Update = true
+		r, err := etlxtest.RunGolden(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Updated || len(r.Rows) != 3 {
+			t.Errorf("%s: the update wrote %d rows, updated %v", ext, len(r.Rows), r.Updated)
+		}
+		c.Update = false
+		etlxtest.TestGolden(t, c)
+
+		//a job changed slightly differs beyond the tolerance only
+		c.Job = computeJob("price * 3 + 0.0001")
+		if r, err = etlxtest.RunGolden(c); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Diffs) != 3 || r.Diffs[0] != `row 1, column total: got "4.5001", want "4.5"` {
+			t.Errorf("%s: Diffs = %q", ext, r.Diffs)
+		}
+		c.Tolerance = 0.001
+		etlxtest.TestGolden(t, c)
+	}
+}
+
+func TestGoldenDiffs(t *testing.T) {
+	dir := t.TempDir()
+	golden := filepath.Join(dir, "rev.csv")
+	if err := ioutil.WriteFile(golden, []byte("name,id,extra\nc,3,\nb,2,\na,1,\nz,9,\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job := computeJob("")
+	job.Transform = etlx.Stage{Driver: "filter", Args: []driver.Command{{Name: "filter", Value: "id > 0"}}}
+
+	r, err := etlxtest.RunGolden(etlxtest.GoldenCase{Job: job, Fixture: writeFixture(t, dir), Golden: golden, IgnoreOrder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"missing column extra",
+		"unexpected column price",
+		"got 3 rows, want 4",
+		"row 4: missing {name=z, id=9}",
+	}
+	if strings.Join(r.Diffs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Diffs = %q, want %q", r.Diffs, want)
+	}
+	if r.OK() {
+		t.Error("OK with differences")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	columns := []string{"id", "v"}
+	got := [][]interface{}{{int64(2), nil}, {int64(1), 1.0}}
+	want := [][]interface{}{{"1", "1"}, {"2", ""}}
+	if diffs := etlxtest.Compare(columns, got, columns, want, true, 0, 0); len(diffs) != 0 {
+		t.Errorf("Compare ignoring the order = %q", diffs)
+	}
+	//the rest of the differences are counted
+	diffs := etlxtest.Compare(columns, got, columns, want, false, 0, 1)
+	if len(diffs) != 2 || diffs[1] != "... and 3 more differences" {
+		t.Errorf("Compare of 1 difference at most = %q", diffs)
+	}
+}
+
+func TestGoldenJobFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "job.yaml")
+	yml := "name: y\nextract: {driver: x, data_source: d}\ntransform:\n  driver: filter\n  args:\n  - {name: filter, type: string, value: id > 1}\nload: {driver: x, data_source: d}\nbatch: 1\n"
+	if err := ioutil.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job, err := etlx.ReadJob(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the rows of the batches are in any order
+	c := etlxtest.GoldenCase{Job: job, Fixture: writeFixture(t, dir), Golden: filepath.Join(dir, "y.json"), Update: true}
+	etlxtest.TestGolden(t, c)
+	c.Update, c.IgnoreOrder = false, true
+	r, err := etlxtest.RunGolden(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || len(r.Rows) != 2 {
+		t.Errorf("the job file filtered %d rows: %q", len(r.Rows), r.Diffs)
+	}
+}
@@ -0,0 +1,253 @@
+package etlxtest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//MockExtract is an extract driver serving Rows in memory, it honors SetBatch.
+//Every handler opened is recorded, so the tests could check the commands and
+//whether the handlers are closed.
+type MockExtract struct {
+	Columns []string
+	Rows    [][]interface{}
+	//Err is returned by Query, CommandErr by Command.
+	Err        error
+	CommandErr error
+
+	mu       sync.Mutex
+	Handlers []*MockExtractHandler
+}
+
+func (m *MockExtract) Open(name, dataSource string) (driver.Extract, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := &MockExtractHandler{mock: m, Name: name, DataSource: dataSource}
+	m.Handlers = append(m.Handlers, h)
+	return h, nil
+}
+
+//Opened returns the handlers opened, safe to call while they are used.
+func (m *MockExtract) Opened() []*MockExtractHandler {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*MockExtractHandler(nil), m.Handlers...)
+}
+
+type MockExtractHandler struct {
+	mock       *MockExtract
+	Name       string
+	DataSource string
+
+	mu     sync.Mutex
+	Args   []driver.Command
+	Limit  int64
+	Offset int64
+	Closed bool
+}
+
+func (h *MockExtractHandler) SetBatch(limit int64, offset int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Limit, h.Offset = limit, offset
+}
+
+func (h *MockExtractHandler) Command(args []driver.Command) (interface{}, error) {
+	if h.mock.CommandErr != nil {
+		return nil, h.mock.CommandErr
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Args = args
+	return args, nil
+}
+
+//Query returns the rows of the batch set by SetBatch, all if the limit is 0.
+//It returns driver.EOT once the batch is past the end, which stops Transaction
+//in batch mode.
+func (h *MockExtractHandler) Query(cmd interface{}) (driver.Rows, error) {
+	if h.mock.Err != nil {
+		return nil, h.mock.Err
+	}
+	h.mu.Lock()
+	limit, offset := h.Limit, h.Offset
+	h.mu.Unlock()
+
+	rows := h.mock.Rows
+	if limit > 0 && offset >= int64(len(rows)) {
+		return nil, driver.EOT
+	} else if offset > int64(len(rows)) {
+		offset = int64(len(rows))
+	}
+	rows = rows[offset:]
+	if limit > 0 && limit < int64(len(rows)) {
+		rows = rows[:limit]
+	}
+	return NewRows(h.mock.Columns, rows), nil
+}
+
+func (h *MockExtractHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Closed = true
+	return nil
+}
+
+//MockTransform is a transform driver applying Fn to every row, the rows are
+//passed through if Fn is nil. Fn returns nil to drop the row.
+type MockTransform struct {
+	//Columns of the results, the columns of the input if empty.
+	Columns []string
+	Fn      func(row []interface{}) ([]interface{}, error)
+	//CommandErr is returned by Command.
+	CommandErr error
+}
+
+func (m *MockTransform) Open(name, dataSource string) (driver.Transform, error) {
+	return &mockTransform{mock: m}, nil
+}
+
+type mockTransform struct {
+	mock *MockTransform
+}
+
+func (t *mockTransform) Command(args []driver.Command) (interface{}, error) {
+	if t.mock.CommandErr != nil {
+		return nil, t.mock.CommandErr
+	}
+	return args, nil
+}
+
+func (t *mockTransform) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	columns := t.mock.Columns
+	if len(columns) == 0 {
+		columns = src.Columns()
+	}
+	data, err := ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	rslt := [][]interface{}{}
+	for _, row := range data {
+		if t.mock.Fn != nil {
+			if row, err = t.mock.Fn(row); err != nil {
+				return nil, err
+			}
+		}
+		if row != nil {
+			rslt = append(rslt, row)
+		}
+	}
+	return NewRows(columns, rslt), nil
+}
+
+func (t *mockTransform) Close() error {
+	return nil
+}
+
+//MockLoad is a load driver keeping the rows loaded by all its handlers.
+type MockLoad struct {
+	//Err is returned by Load, CommandErr by Command.
+	Err        error
+	CommandErr error
+
+	mu      sync.Mutex
+	columns []string
+	rows    [][]interface{}
+	loads   int
+}
+
+func (m *MockLoad) Open(name, dataSource string) (driver.Load, error) {
+	return &mockLoad{mock: m}, nil
+}
+
+//Loaded returns the columns and the rows loaded so far.
+func (m *MockLoad) Loaded() ([]string, [][]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.columns, append([][]interface{}(nil), m.rows...)
+}
+
+//Loads returns the number of the calls of Load, e.g. once per batch.
+func (m *MockLoad) Loads() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.loads
+}
+
+//Reset drops the rows loaded.
+func (m *MockLoad) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.columns, m.rows, m.loads = nil, nil, 0
+}
+
+type mockLoad struct {
+	mock *MockLoad
+}
+
+func (l *mockLoad) Command(args []driver.Command) (interface{}, error) {
+	if l.mock.CommandErr != nil {
+		return nil, l.mock.CommandErr
+	}
+	return args, nil
+}
+
+func (l *mockLoad) Load(src driver.Results, cmd interface{}) error {
+	if l.mock.Err != nil {
+		return l.mock.Err
+	}
+	data, err := ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	m := l.mock
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.columns == nil {
+		m.columns = src.Columns()
+	}
+	m.rows = append(m.rows, data...)
+	m.loads++
+	return nil
+}
+
+func (l *mockLoad) QueryFromNextStep() (driver.Rows, error) {
+	columns, rows := l.mock.Loaded()
+	return NewRows(columns, rows), nil
+}
+
+func (l *mockLoad) Close() error {
+	return nil
+}
+
+//NewRows returns the rows in memory as driver.Results.
+func NewRows(columns []string, rows [][]interface{}) *driver.Table {
+	t := driver.NewTable(len(rows))
+	t.SetColumns(columns)
+	t.SetData(rows)
+	return t
+}
+
+//ReadAll reads the rows until EOT, every row is a copy.
+func ReadAll(rows driver.Rows) ([][]interface{}, error) {
+	data := [][]interface{}{}
+	for {
+		row := make([]interface{}, len(rows.Columns()))
+		if err := rows.Next(row); err == driver.EOT {
+			return data, nil
+		} else if err != nil {
+			return data, err
+		}
+		data = append(data, row)
+	}
+}
+
+//errorf is the error of the suites.
+func errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("etlxtest: "+format, args...)
+}
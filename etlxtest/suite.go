@@ -0,0 +1,597 @@
+//Package etlxtest checks that custom drivers behave as etlx expects, and
+//provides drivers in memory for the tests of the code using Transaction.
+//
+//A driver package runs the suite in its own tests:
+//	func TestConformance(t *testing.T) {
+//		etlxtest.TestExtract(t, etlxtest.ExtractCase{
+//			Driver:     &myDriver{},
+//			DataSource: "testdata/users.csv",
+//			BadArgs:    []driver.Command{{Name: "no_such_command"}},
+//		})
+//	}
+package etlxtest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//DefaultConcurrency is the number of goroutines sharing a handler in the suites.
+const DefaultConcurrency = 4
+
+//ExtractCase is an extract driver and the commands the suite queries it with.
+type ExtractCase struct {
+	Driver     driver.ExtractDriver
+	Name       string
+	DataSource string
+	Args       []driver.Command
+	//BadArgs are the commands Command should reject, the check is skipped if nil.
+	BadArgs []driver.Command
+	//Batch checks SetBatch, it is true if the driver declares driver.CapBatch
+	//by driver.InfoDriver. Query should return driver.EOT past the last batch.
+	Batch bool
+	//Rows is the number of rows expected, not checked if 0.
+	Rows int
+	//Concurrency is the number of goroutines querying one handler at once.
+	Concurrency int
+}
+
+//TransformCase is a transform driver, its commands and the input.
+type TransformCase struct {
+	Driver     driver.TransformDriver
+	Name       string
+	DataSource string
+	Args       []driver.Command
+	BadArgs    []driver.Command
+	//Input returns a new copy of the input every time it is called.
+	Input func() driver.Rows
+	//Ordered checks the order of the rows when the results of the batches
+	//fed to driver.Accumulator are compared with Exec.
+	Ordered bool
+	//Concurrency is the number of goroutines executing the batches of the
+	//input on one handler at once, as Transaction does in batch mode.
+	Concurrency int
+}
+
+//LoadCase is a load driver, its commands and the input.
+type LoadCase struct {
+	Driver     driver.LoadDriver
+	Name       string
+	DataSource string
+	Args       []driver.Command
+	BadArgs    []driver.Command
+	//Input returns a new copy of the input every time it is called.
+	Input func() driver.Results
+	//Concurrency is the number of goroutines loading by one handler at once,
+	//as Transaction does in batch mode. The check is skipped if it is negative.
+	Concurrency int
+}
+
+//safe calls fn and returns the panic as an error.
+func safe(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errorf("%s panicked: %v", name, r)
+		}
+	}()
+	return fn()
+}
+
+//readRows reads the rows to EOT, checking that Columns does not change and
+//that Next keeps returning EOT at the end.
+func readRows(rows driver.Rows) ([]string, [][]interface{}, error) {
+	if rows == nil {
+		return nil, nil, errorf("the rows are nil")
+	}
+	var columns []string
+	var data [][]interface{}
+	err := safe("Next", func() error {
+		columns = append([]string(nil), rows.Columns()...)
+		for {
+			row := make([]interface{}, len(columns))
+			err := rows.Next(row)
+			if !reflect.DeepEqual(rows.Columns(), columns) && len(columns) > 0 {
+				return errorf("Columns changed from %v to %v", columns, rows.Columns())
+			}
+			if err == driver.EOT {
+				break
+			} else if err != nil {
+				return err
+			}
+			data = append(data, row)
+		}
+		for i := 0; i < 2; i++ {
+			if err := rows.Next(make([]interface{}, len(columns))); err != driver.EOT {
+				return errorf("Next after the end returned %v instead of EOT", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return columns, data, err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := safe("Close of the rows", rows.Close); err != nil {
+			return columns, data, errorf("Close of the rows #%d: %v", i+1, err)
+		}
+	}
+	return columns, data, nil
+}
+
+//closeTwice checks that Close of the handler is idempotent.
+func closeTwice(t *testing.T, closer interface{ Close() error }) {
+	for i := 0; i < 2; i++ {
+		if err := safe("Close", closer.Close); err != nil {
+			t.Errorf("Close #%d: %v", i+1, err)
+		}
+	}
+}
+
+//checkBadArgs checks that the commands are rejected by an error, not a panic.
+func checkBadArgs(t *testing.T, command func([]driver.Command) (interface{}, error), args []driver.Command) {
+	if args == nil {
+		t.Skip("no bad commands given")
+	}
+	err := safe("Command", func() error {
+		_, err := command(args)
+		return err
+	})
+	if err == nil {
+		t.Errorf("Command accepted the bad commands %v", args)
+	}
+}
+
+func concurrency(n int) int {
+	if n <= 0 {
+		return DefaultConcurrency
+	}
+	return n
+}
+
+//concurrently runs fn n times at once and reports the errors.
+func concurrently(t *testing.T, n int, fn func(i int) error) {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = safe("handler", func() error { return fn(i) })
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("handler #%d: %v", i, err)
+		}
+	}
+}
+
+//sameRows compares the rows, in order or as multisets.
+func sameRows(a, b [][]interface{}, ordered bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as, bs := rowStrings(a), rowStrings(b)
+	if !ordered {
+		sort.Strings(as)
+		sort.Strings(bs)
+	}
+	return reflect.DeepEqual(as, bs)
+}
+
+//split splits the rows into n batches at most, in order.
+func split(rows [][]interface{}, n int) [][][]interface{} {
+	size := (len(rows) + n - 1) / n
+	if size == 0 {
+		return [][][]interface{}{rows}
+	}
+	batches := [][][]interface{}{}
+	for start := 0; start < len(rows); start += size {
+		end := start + size
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batches = append(batches, rows[start:end])
+	}
+	return batches
+}
+
+//rowStrings formats the values with their types, not by %#v which prints the
+//pointers inside the values, e.g. of driver.Decimal.
+func rowStrings(rows [][]interface{}) []string {
+	strs := make([]string, len(rows))
+	for i, row := range rows {
+		vals := make([]string, len(row))
+		for j, val := range row {
+			vals[j] = fmt.Sprintf("%T(%v)", val, val)
+		}
+		strs[i] = strings.Join(vals, ", ")
+	}
+	return strs
+}
+
+//TestExtract runs the suite of the extract driver: the rows of a query,
+//Columns stability, EOT at the end, idempotent Close, the errors of bad
+//commands, the pages of SetBatch and one handler queried concurrently.
+func TestExtract(t *testing.T, c ExtractCase) {
+	if info, ok := c.Driver.(driver.InfoDriver); ok && info.DriverInfo().Has(driver.CapBatch) {
+		c.Batch = true
+	}
+
+	query := func(limit, offset int64) ([]string, [][]interface{}, error) {
+		var handler driver.Extract
+		var rows driver.Rows
+		err := safe("Open", func() (err error) {
+			if handler, err = c.Driver.Open(c.Name, c.DataSource); err != nil {
+				return err
+			}
+			if limit > 0 || offset > 0 {
+				handler.SetBatch(limit, offset)
+			}
+			cmd, err := handler.Command(c.Args)
+			if err != nil {
+				return err
+			}
+			rows, err = handler.Query(cmd)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		defer handler.Close()
+		return readRows(rows)
+	}
+
+	columns, all, err := query(0, 0)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+
+	t.Run("Query", func(t *testing.T) {
+		if len(columns) == 0 {
+			t.Error("Columns is empty")
+		}
+		if c.Rows > 0 && c.Rows != len(all) {
+			t.Errorf("got %d rows, expected %d", len(all), c.Rows)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeTwice(t, handler)
+	})
+
+	t.Run("BadCommands", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		checkBadArgs(t, handler.Command, c.BadArgs)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		if !c.Batch {
+			t.Skip("the driver does not support batch")
+		}
+		for _, limit := range []int64{1, 2, int64(len(all)/2 + 1), int64(len(all) + 1)} {
+			pages := [][]interface{}{}
+			end := false
+			for offset := int64(0); offset <= int64(len(all))+limit; offset += limit {
+				_, page, err := query(limit, offset)
+				if err == driver.EOT {
+					end = true
+					break
+				} else if err != nil {
+					t.Fatalf("limit %d offset %d: %v", limit, offset, err)
+				}
+				if int64(len(page)) > limit {
+					t.Fatalf("limit %d offset %d: got %d rows", limit, offset, len(page))
+				}
+				pages = append(pages, page...)
+			}
+			//Transaction in batch mode extracts until Query returns an error
+			if !end {
+				t.Errorf("limit %d: Query past the end did not return driver.EOT", limit)
+			}
+			if !sameRows(pages, all, true) {
+				t.Errorf("limit %d: the pages have %d rows, not the same as the %d rows of the query", limit, len(pages), len(all))
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		concurrently(t, concurrency(c.Concurrency), func(int) error {
+			cmd, err := handler.Command(c.Args)
+			if err != nil {
+				return err
+			}
+			rows, err := handler.Query(cmd)
+			if err != nil {
+				return err
+			}
+			_, data, err := readRows(rows)
+			if err == nil && !sameRows(data, all, true) {
+				err = errorf("got %d rows, not the same as the %d rows queried alone", len(data), len(all))
+			}
+			return err
+		})
+	})
+}
+
+//TestTransform runs the suite of the transform driver: the results of Exec,
+//Columns stability, EOT at the end, idempotent Close, the errors of bad
+//commands, the batches fed to driver.Accumulator compared with Exec and the
+//batches executed concurrently by one handler.
+func TestTransform(t *testing.T, c TransformCase) {
+	exec := func(src driver.Rows) ([]string, [][]interface{}, error) {
+		var handler driver.Transform
+		var rslt driver.Results
+		err := safe("Exec", func() (err error) {
+			if handler, err = c.Driver.Open(c.Name, c.DataSource); err != nil {
+				return err
+			}
+			cmd, err := handler.Command(c.Args)
+			if err != nil {
+				return err
+			}
+			rslt, err = handler.Exec(src, cmd)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		defer handler.Close()
+		return readRows(rslt)
+	}
+
+	_, all, err := exec(c.Input())
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+
+	t.Run("Close", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeTwice(t, handler)
+	})
+
+	t.Run("BadCommands", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		checkBadArgs(t, handler.Command, c.BadArgs)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		cmd, err := handler.Command(c.Args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input, err := ReadAll(c.Input())
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns := c.Input().Columns()
+
+		//batches of 1, 2 and the rest of the rows
+		rows := [][]interface{}{}
+		for start, size := 0, 1; start < len(input) || start == 0; size++ {
+			end := start + size
+			if end > len(input) || size > 2 {
+				end = len(input)
+			}
+			var rslt driver.Results
+			err := safe("batch", func() (err error) {
+				if acc, ok := handler.(driver.Accumulator); ok {
+					rslt, err = acc.Accumulate(NewRows(columns, input[start:end]), cmd)
+				} else {
+					rslt, err = handler.Exec(NewRows(columns, input[start:end]), cmd)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatalf("batch of rows %d-%d: %v", start, end, err)
+			}
+			if rslt != nil {
+				_, data, err := readRows(rslt)
+				if err != nil {
+					t.Fatalf("batch of rows %d-%d: %v", start, end, err)
+				}
+				rows = append(rows, data...)
+			}
+			if start = end; end == len(input) {
+				break
+			}
+		}
+
+		if acc, ok := handler.(driver.Accumulator); ok {
+			rslt, err := acc.Flush(cmd)
+			if err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			if rslt != nil {
+				_, data, err := readRows(rslt)
+				if err != nil {
+					t.Fatalf("Flush: %v", err)
+				}
+				rows = append(rows, data...)
+			}
+		} else {
+			//the results of a stateless transform are per batch
+			t.Logf("the handler is not driver.Accumulator, the batches are executed one by one")
+		}
+		if !sameRows(rows, all, c.Ordered) {
+			t.Errorf("the batches have %d rows, not the same as the %d rows of Exec", len(rows), len(all))
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		input, err := ReadAll(c.Input())
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns := c.Input().Columns()
+		batches := split(input, concurrency(c.Concurrency))
+
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		acc, isAcc := handler.(driver.Accumulator)
+
+		//the results of every batch executed alone by a new handler
+		want := make([][][]interface{}, len(batches))
+		if !isAcc {
+			for i, batch := range batches {
+				if _, want[i], err = exec(NewRows(columns, batch)); err != nil {
+					t.Fatalf("batch %d: %v", i, err)
+				}
+			}
+		}
+
+		got := make([][][]interface{}, len(batches))
+		concurrently(t, len(batches), func(i int) error {
+			cmd, err := handler.Command(c.Args)
+			if err != nil {
+				return err
+			}
+			var rslt driver.Results
+			if isAcc {
+				if rslt, err = acc.Accumulate(NewRows(columns, batches[i]), cmd); err != nil || rslt == nil {
+					return err
+				}
+			} else if rslt, err = handler.Exec(NewRows(columns, batches[i]), cmd); err != nil {
+				return err
+			}
+			if _, got[i], err = readRows(rslt); err != nil {
+				return err
+			}
+			if !isAcc && !sameRows(got[i], want[i], c.Ordered) {
+				return errorf("batch %d: got %d rows, not the same as the %d rows executed alone", i, len(got[i]), len(want[i]))
+			}
+			return nil
+		})
+		if !isAcc || t.Failed() {
+			return
+		}
+
+		//the batches are accumulated in any order, as in Transaction
+		cmd, err := handler.Command(c.Args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rslt, err := acc.Flush(cmd)
+		if err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		rows := [][]interface{}{}
+		for _, data := range got {
+			rows = append(rows, data...)
+		}
+		if rslt != nil {
+			_, data, err := readRows(rslt)
+			if err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			rows = append(rows, data...)
+		}
+		if !sameRows(rows, all, false) {
+			t.Errorf("the batches accumulated concurrently have %d rows, not the same as the %d rows of Exec", len(rows), len(all))
+		}
+	})
+}
+
+//TestLoad runs the suite of the load driver: loading the input and no row,
+//idempotent Close, the errors of bad commands and one handler loading
+//concurrently. The rows loaded are checked by the tests of the driver.
+func TestLoad(t *testing.T, c LoadCase) {
+	load := func(src driver.Results) error {
+		return safe("Load", func() error {
+			handler, err := c.Driver.Open(c.Name, c.DataSource)
+			if err != nil {
+				return err
+			}
+			defer handler.Close()
+			cmd, err := handler.Command(c.Args)
+			if err != nil {
+				return err
+			}
+			return handler.Load(src, cmd)
+		})
+	}
+
+	t.Run("Load", func(t *testing.T) {
+		if err := load(c.Input()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if err := load(NewRows(c.Input().Columns(), nil)); err != nil {
+			t.Errorf("loading no row: %v", err)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeTwice(t, handler)
+	})
+
+	t.Run("BadCommands", func(t *testing.T) {
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		checkBadArgs(t, handler.Command, c.BadArgs)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		if c.Concurrency < 0 {
+			t.Skip("concurrent loads are disabled")
+		}
+		handler, err := c.Driver.Open(c.Name, c.DataSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		concurrently(t, concurrency(c.Concurrency), func(int) error {
+			cmd, err := handler.Command(c.Args)
+			if err != nil {
+				return err
+			}
+			return handler.Load(c.Input(), cmd)
+		})
+	})
+}
@@ -0,0 +1,500 @@
+//Package expr implements a small typed expression language which is evaluated
+//against a row map, e.g. one built by driver.ArrayToMap.
+//
+//Expressions support int, float, decimal, string, bool, time and null values, the
+//operators + - * / % == != < <= > >= && || ! and cond ? a : b, column
+//references (`quoted column` for names with spaces) and function calls such
+//as concat(first, ' ', last). Operands of different types are coerced through
+//the driver *FromInterface converters.
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//Program is a compiled expression which could be evaluated many times.
+type Program struct {
+	src  string
+	root node
+}
+
+//Compile parses the expression and checks the function calls in it.
+func Compile(src string) (*Program, error) {
+	root, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{src: src, root: root}, nil
+}
+
+//MustCompile is like Compile but panics if the expression could not be parsed.
+func MustCompile(src string) *Program {
+	p, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *Program) String() string {
+	return p.src
+}
+
+//Env provides the values of the columns referenced by an expression, it lets
+//the callers evaluate expressions without building a map for every row.
+type Env interface {
+	//Lookup returns false if there is no such column.
+	Lookup(name string) (interface{}, bool)
+}
+
+//MapEnv is the Env of a row map.
+type MapEnv map[string]interface{}
+
+func (m MapEnv) Lookup(name string) (interface{}, bool) {
+	val, ok := m[name]
+	return val, ok
+}
+
+//Eval evaluates the expression with the row. Result is one of nil, int64,
+//float64, string, bool or time.Time.
+func (p *Program) Eval(row map[string]interface{}) (interface{}, error) {
+	return p.root.eval(MapEnv(row))
+}
+
+//EvalBool evaluates the expression and converts the result to a bool, null is false.
+func (p *Program) EvalBool(row map[string]interface{}) (bool, error) {
+	return p.EvalBoolEnv(MapEnv(row))
+}
+
+//EvalEnv is the same as Eval but gets the values of the columns from env.
+func (p *Program) EvalEnv(env Env) (interface{}, error) {
+	return p.root.eval(env)
+}
+
+//EvalBoolEnv is the same as EvalBool but gets the values of the columns from env.
+func (p *Program) EvalBoolEnv(env Env) (bool, error) {
+	val, err := p.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return truth(val)
+}
+
+//Columns returns the name of the columns referenced by the expression.
+func (p *Program) Columns() []string {
+	set := map[string]bool{}
+	collectColumns(p.root, set)
+
+	cols := make([]string, 0, len(set))
+	for name := range set {
+		cols = append(cols, name)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+func collectColumns(n node, set map[string]bool) {
+	switch v := n.(type) {
+	case *ident:
+		set[v.name] = true
+	case *unary:
+		collectColumns(v.operand, set)
+	case *binary:
+		collectColumns(v.left, set)
+		collectColumns(v.right, set)
+	case *ternary:
+		collectColumns(v.cond, set)
+		collectColumns(v.then, set)
+		collectColumns(v.other, set)
+	case *call:
+		for _, arg := range v.args {
+			collectColumns(arg, set)
+		}
+	}
+}
+
+//normalize converts the value read from a driver to the types used by expressions.
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []uint8:
+		return string(v)
+	case *driver.UnquotedString:
+		return v.Value
+	case driver.UnquotedString:
+		return v.Value
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return val
+}
+
+func isNumber(val interface{}) bool {
+	switch val.(type) {
+	case int64, float64, driver.Decimal:
+		return true
+	}
+	return false
+}
+
+func truth(val interface{}) (bool, error) {
+	switch v := val.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case driver.Decimal:
+		return !v.IsZero(), nil
+	}
+	b, err := driver.BoolFromInterface(val)
+	if err != nil {
+		return false, fmt.Errorf("expr: value(%v) is not a bool", val)
+	}
+	return b, nil
+}
+
+func (n *literal) eval(env Env) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *ident) eval(env Env) (interface{}, error) {
+	val, ok := env.Lookup(n.name)
+	if !ok {
+		return nil, fmt.Errorf("expr: unknown column %s", n.name)
+	}
+	return normalize(val), nil
+}
+
+func (n *unary) eval(env Env) (interface{}, error) {
+	val, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "!" {
+		b, err := truth(val)
+		return !b, err
+	}
+
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		return -v, nil
+	case float64:
+		return -v, nil
+	}
+	fval, err := driver.FloatFromInterface(val)
+	if err != nil {
+		return nil, fmt.Errorf("expr: could not negate %v", val)
+	}
+	return -fval, nil
+}
+
+func (n *ternary) eval(env Env) (interface{}, error) {
+	cond, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := truth(cond)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return n.then.eval(env)
+	}
+	return n.other.eval(env)
+}
+
+func (n *call) eval(env Env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		val, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
+
+	val, err := n.fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %s: %v", n.name, err)
+	}
+	return val, nil
+}
+
+func (n *binary) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	//&& and || are short circuited
+	switch n.op {
+	case "&&", "||":
+		lb, err := truth(left)
+		if err != nil {
+			return nil, err
+		}
+		if (n.op == "&&") != lb {
+			return lb, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return truth(right)
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		eq, err := equal(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return eq == (n.op == "=="), nil
+	case "<", "<=", ">", ">=":
+		if left == nil || right == nil {
+			return false, nil
+		}
+		c, err := compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+	return arithmetic(n.op, left, right)
+}
+
+func equal(left, right interface{}) (bool, error) {
+	if left == nil || right == nil {
+		return left == nil && right == nil, nil
+	}
+	if lb, ok := left.(bool); ok {
+		rb, err := truth(right)
+		return lb == rb, err
+	}
+	if rb, ok := right.(bool); ok {
+		lb, err := truth(left)
+		return lb == rb, err
+	}
+	c, err := compare(left, right)
+	if err != nil {
+		return false, err
+	}
+	return c == 0, nil
+}
+
+//compare returns -1, 0 or 1. Strings are converted to numbers or time when
+//compared with them.
+func compare(left, right interface{}) (int, error) {
+	switch {
+	case isNumber(left) || isNumber(right):
+		li, lok := left.(int64)
+		ri, rok := right.(int64)
+		if lok && rok {
+			return compareInt(li, ri), nil
+		}
+		if ld, rd, ok := decimals(left, right); ok {
+			return ld.Cmp(rd), nil
+		}
+		lf, err := driver.FloatFromInterface(left)
+		if err != nil {
+			return 0, fmt.Errorf("expr: could not compare %v with %v", left, right)
+		}
+		rf, err := driver.FloatFromInterface(right)
+		if err != nil {
+			return 0, fmt.Errorf("expr: could not compare %v with %v", left, right)
+		}
+		return compareFloat(lf, rf), nil
+	}
+
+	lt, lok := left.(time.Time)
+	rt, rok := right.(time.Time)
+	if lok || rok {
+		var err error
+		if !lok {
+			lt, err = toTime(left)
+		} else if !rok {
+			rt, err = toTime(right)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("expr: could not compare %v with %v", left, right)
+		}
+		switch {
+		case lt.Before(rt):
+			return -1, nil
+		case lt.After(rt):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	ls, lerr := driver.StringFromInterface(left)
+	rs, rerr := driver.StringFromInterface(right)
+	if lerr != nil || rerr != nil {
+		return 0, fmt.Errorf("expr: could not compare %v with %v", left, right)
+	}
+	return strings.Compare(ls, rs), nil
+}
+
+func compareInt(l, r int64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func toTime(val interface{}) (time.Time, error) {
+	return driver.TimeFromInterface(val, "2006-01-02")
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	if op == "+" {
+		ls, lok := left.(string)
+		rs, rok := right.(string)
+		if lok && rok {
+			return ls + rs, nil
+		}
+	}
+
+	li, lok := left.(int64)
+	ri, rok := right.(int64)
+	if lok && rok && op != "/" {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "%":
+			if ri == 0 {
+				return nil, fmt.Errorf("expr: modulo by zero")
+			}
+			return li % ri, nil
+		}
+	}
+
+	if ld, rd, ok := decimals(left, right); ok {
+		switch op {
+		case "+":
+			return ld.Add(rd), nil
+		case "-":
+			return ld.Sub(rd), nil
+		case "*":
+			return ld.Mul(rd), nil
+		}
+	}
+
+	lf, err := driver.FloatFromInterface(left)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %v is not a number for %s", left, op)
+	}
+	rf, err := driver.FloatFromInterface(right)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %v is not a number for %s", right, op)
+	}
+
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("expr: division by zero")
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("expr: modulo by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("expr: unsupported operator %s", op)
+}
+
+//decimals converts the operands to decimal if one of them is a decimal and the
+//other is a decimal or an int, so + - * and comparison keep the precision.
+func decimals(left, right interface{}) (driver.Decimal, driver.Decimal, bool) {
+	_, lok := left.(driver.Decimal)
+	_, rok := right.(driver.Decimal)
+	if !lok && !rok {
+		return driver.Decimal{}, driver.Decimal{}, false
+	}
+	for _, val := range []interface{}{left, right} {
+		switch val.(type) {
+		case driver.Decimal, int64:
+		default:
+			return driver.Decimal{}, driver.Decimal{}, false
+		}
+	}
+	ld, _ := driver.DecimalFromInterface(left)
+	rd, _ := driver.DecimalFromInterface(right)
+	return ld, rd, true
+}
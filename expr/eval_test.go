@@ -0,0 +1,146 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func TestEval(t *testing.T) {
+	row := map[string]interface{}{
+		"a":          3,
+		"b":          2.5,
+		"s":          "Hello",
+		"n":          nil,
+		"d":          "2024-01-02",
+		"first name": "x",
+		"pattern":    "^H",
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"7 / 2", 3.5},
+		{"7 % 3", int64(1)},
+		{"a + b", 5.5},
+		{"-a", int64(-3)},
+		{"!true", false},
+		{"1 < 2 && 2 < 3", true},
+		{"n == null", true},
+		{"s == 'Hello'", true},
+		{"a > 2 ? 'big' : 'small'", "big"},
+		{"concat(s, ' ', `first name`, n)", "Hello x"},
+		{"upper(s)", "HELLO"},
+		{"len(s)", int64(5)},
+		{"substr(s, 1, 3)", "ell"},
+		{"replace(s, 'l', 'L')", "HeLLo"},
+		{"matches(s, '^H.*o$')", true},
+		{"matches(s, pattern)", true},
+		{"matches(n, '^H')", false},
+		{"round(2.675, 2)", 2.68},
+		{"min(3, 1, 2)", int64(1)},
+		{"max(a, b)", int64(3)},
+		{"coalesce(n, 5)", int64(5)},
+		{"isnull(n)", true},
+		{"int('42')", int64(42)},
+		{"string(42)", "42"},
+		{"year(time(d))", int64(2024)},
+		{"abs(-2)", int64(2)},
+		{"if(a > 2, 'yes', 'no')", "yes"},
+		//only the argument chosen is evaluated
+		{"if(a > 2, 'yes', 1 / 0)", "yes"},
+		{"if(a > 5, 1 / 0, 'no')", "no"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		got, err := p.Eval(row)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalDecimal(t *testing.T) {
+	d, err := driver.ParseDecimal("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"a + a + a", "0.3"},
+		{"a * 3", "0.3"},
+		{"a - 1", "-0.9"},
+		{"round(a * 5, 0, 'half_even')", "0"},
+		{"round(a * 15, 0)", "2"},
+		{"a > 0.05", true},
+		{"a == 0.1", true},
+		{"a + 1.5", 1.6},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Fatalf("Compile(%q) returned %v", tt.src, err)
+		}
+		got, err := p.Eval(map[string]interface{}{"a": d})
+		if err != nil {
+			t.Errorf("Eval(%q) returned %v", tt.src, err)
+			continue
+		}
+		if dec, ok := got.(driver.Decimal); ok {
+			got = dec.String()
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %v (%T), want %v", tt.src, got, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	row := map[string]interface{}{"a": 3, "pattern": "("}
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"1 / 0", "division by zero"},
+		{"missing + 1", "unknown column missing"},
+		{"if(a > 2, 1 / 0, 0)", "division by zero"},
+		{"matches('x', pattern)", "matches"},
+	}
+	for _, tt := range tests {
+		_, err := MustCompile(tt.src).Eval(row)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	got := MustCompile("if(a > 1, concat(b, `c d`), e) + f").Columns()
+	if want := []string{"a", "b", "c d", "e", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCompileRegexp(t *testing.T) {
+	re, err := compileRegexp("^a+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := compileRegexp("^a+$"); again != re {
+		t.Error("the pattern is compiled again")
+	}
+	if _, err := compileRegexp("("); err == nil {
+		t.Error("the invalid pattern is compiled")
+	}
+}
@@ -0,0 +1,383 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+type function struct {
+	minArgs int
+	//-1 for variadic function
+	maxArgs int
+	call    func(args []interface{}) (interface{}, error)
+}
+
+var functions map[string]*function
+
+func init() {
+	functions = map[string]*function{
+		"concat":     {1, -1, fnConcat},
+		"upper":      {1, 1, stringFunc(strings.ToUpper)},
+		"lower":      {1, 1, stringFunc(strings.ToLower)},
+		"trim":       {1, 1, stringFunc(strings.TrimSpace)},
+		"len":        {1, 1, fnLen},
+		"substr":     {2, 3, fnSubstr},
+		"replace":    {3, 3, fnReplace},
+		"contains":   {2, 2, stringPredicate(strings.Contains)},
+		"startswith": {2, 2, stringPredicate(strings.HasPrefix)},
+		"endswith":   {2, 2, stringPredicate(strings.HasSuffix)},
+		"matches":    {2, 2, fnMatches},
+		"abs":        {1, 1, floatFunc(math.Abs)},
+		"floor":      {1, 1, floatFunc(math.Floor)},
+		"ceil":       {1, 1, floatFunc(math.Ceil)},
+		"round":      {1, 3, fnRound},
+		"min":        {1, -1, extremeFunc(-1)},
+		"max":        {1, -1, extremeFunc(1)},
+		"if":         {3, 3, nil}, //parsed as cond ? a : b by parseCall
+		"coalesce":   {1, -1, fnCoalesce},
+		"isnull":     {1, 1, fnIsNull},
+		"int":        {1, 1, fnInt},
+		"float":      {1, 1, fnFloat},
+		"string":     {1, 1, fnString},
+		"bool":       {1, 1, fnBool},
+		"time":       {1, 2, fnTime},
+		"year":       {1, 1, timePart(func(t time.Time) int { return t.Year() })},
+		"month":      {1, 1, timePart(func(t time.Time) int { return int(t.Month()) })},
+		"day":        {1, 1, timePart(func(t time.Time) int { return t.Day() })},
+	}
+}
+
+//toString converts values to string, time is formatted as RFC3339 rather than time.Time.String()
+func toString(val interface{}) (string, error) {
+	if t, ok := val.(time.Time); ok {
+		return t.Format(time.RFC3339), nil
+	}
+	if b, ok := val.(bool); ok {
+		if b {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return driver.StringFromInterface(val)
+}
+
+func fnConcat(args []interface{}) (interface{}, error) {
+	var buf strings.Builder
+	for _, arg := range args {
+		if arg == nil {
+			continue
+		}
+		str, err := toString(arg)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(str)
+	}
+	return buf.String(), nil
+}
+
+func stringFunc(fn func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		str, err := toString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return fn(str), nil
+	}
+}
+
+func stringPredicate(fn func(string, string) bool) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil || args[1] == nil {
+			return false, nil
+		}
+		str, err := toString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		sub, err := toString(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return fn(str, sub), nil
+	}
+}
+
+func fnLen(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return int64(0), nil
+	}
+	str, err := toString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return int64(utf8.RuneCountInString(str)), nil
+}
+
+//substr(str, start[, length]), start is 0 based and counted in characters
+func fnSubstr(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	str, err := toString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(str)
+
+	start, err := driver.IntFromInterface(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > int64(len(runes)) {
+		return "", nil
+	}
+
+	end := int64(len(runes))
+	if len(args) > 2 {
+		length, err := driver.IntFromInterface(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if length >= 0 && start+length < end {
+			end = start + length
+		}
+	}
+	return string(runes[start:end]), nil
+}
+
+func fnReplace(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	strs := make([]string, 3)
+	for i, arg := range args {
+		str, err := toString(arg)
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = str
+	}
+	return strings.Replace(strs[0], strs[1], strs[2], -1), nil
+}
+
+func fnMatches(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return false, nil
+	}
+	str, err := toString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := toString(args[1])
+	if err != nil {
+		return nil, err
+	}
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(str), nil
+}
+
+//matchesRegexp is matches of the pattern compiled by the parser.
+func matchesRegexp(re *regexp.Regexp) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return false, nil
+		}
+		str, err := toString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(str), nil
+	}
+}
+
+//maxRegexps is the number of the patterns computed by the expressions which
+//are kept compiled, the cache is cleared once it is full.
+const maxRegexps = 256
+
+var regexps = struct {
+	sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: map[string]*regexp.Regexp{}}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexps.Lock()
+	defer regexps.Unlock()
+	if re, ok := regexps.compiled[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(regexps.compiled) >= maxRegexps {
+		regexps.compiled = map[string]*regexp.Regexp{}
+	}
+	regexps.compiled[pattern] = re
+	return re, nil
+}
+
+func floatFunc(fn func(float64) float64) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, nil
+		case int64:
+			return int64(fn(float64(v))), nil
+		}
+		fval, err := driver.FloatFromInterface(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return fn(fval), nil
+	}
+}
+
+//round(value[, digits[, mode]]), mode is the name of driver.RoundingMode and
+//half_up by default. Decimals are rounded as decimal.
+func fnRound(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	var n int64
+	var err error
+	if len(args) > 1 {
+		n, err = driver.IntFromInterface(args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	mode := driver.RoundHalfUp
+	if len(args) > 2 {
+		name, err := driver.StringFromInterface(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if mode, err = driver.ParseRoundingMode(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if d, ok := args[0].(driver.Decimal); ok {
+		return d.Round(int32(n), mode), nil
+	}
+	fval, err := driver.FloatFromInterface(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return driver.RoundFloat(fval, int(n), mode), nil
+}
+
+//extremeFunc returns min (sign=-1) or max (sign=1) of the arguments, nulls are skipped.
+func extremeFunc(sign int) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		var rslt interface{}
+		for _, arg := range args {
+			if arg == nil {
+				continue
+			}
+			if rslt == nil {
+				rslt = arg
+				continue
+			}
+			c, err := compare(arg, rslt)
+			if err != nil {
+				return nil, err
+			}
+			if c*sign > 0 {
+				rslt = arg
+			}
+		}
+		return rslt, nil
+	}
+}
+
+func fnCoalesce(args []interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+func fnIsNull(args []interface{}) (interface{}, error) {
+	return args[0] == nil, nil
+}
+
+func fnInt(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	return driver.IntFromInterface(args[0])
+}
+
+func fnFloat(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	return driver.FloatFromInterface(args[0])
+}
+
+func fnString(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	return toString(args[0])
+}
+
+func fnBool(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	return truth(args[0])
+}
+
+//time(value[, layout]), layout is "2006-01-02" by default
+func fnTime(args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	layout := "2006-01-02"
+	if len(args) > 1 {
+		str, err := toString(args[1])
+		if err != nil {
+			return nil, err
+		}
+		layout = str
+	}
+	return driver.TimeFromInterface(args[0], layout)
+}
+
+func timePart(fn func(time.Time) int) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		t, ok := args[0].(time.Time)
+		if !ok {
+			var err error
+			if t, err = toTime(args[0]); err != nil {
+				return nil, fmt.Errorf("%v is not a time", args[0])
+			}
+		}
+		return int64(fn(t)), nil
+	}
+}
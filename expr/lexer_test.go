@@ -0,0 +1,40 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []token
+	}{
+		{"", []token{{tokEOF, "", 0}}},
+		{"a+1", []token{{tokIdent, "a", 0}, {tokOp, "+", 1}, {tokNumber, "1", 2}, {tokEOF, "", 3}}},
+		{" x >= 2.5e-3 ", []token{{tokIdent, "x", 1}, {tokOp, ">=", 3}, {tokNumber, "2.5e-3", 6}, {tokEOF, "", 13}}},
+		{".5", []token{{tokNumber, ".5", 0}, {tokEOF, "", 2}}},
+		{`'it\'s' "a\tb"`, []token{{tokString, "it's", 0}, {tokString, "a\tb", 8}, {tokEOF, "", 14}}},
+		{"`first name` != b.c", []token{{tokIdent, "first name", 0}, {tokOp, "!=", 13}, {tokIdent, "b.c", 16}, {tokEOF, "", 19}}},
+		{"f(a, !b) ? x : y", []token{{tokIdent, "f", 0}, {tokOp, "(", 1}, {tokIdent, "a", 2}, {tokOp, ",", 3},
+			{tokOp, "!", 5}, {tokIdent, "b", 6}, {tokOp, ")", 7}, {tokOp, "?", 9}, {tokIdent, "x", 11},
+			{tokOp, ":", 13}, {tokIdent, "y", 15}, {tokEOF, "", 16}}},
+		{"a&&b||c", []token{{tokIdent, "a", 0}, {tokOp, "&&", 1}, {tokIdent, "b", 3}, {tokOp, "||", 4}, {tokIdent, "c", 6}, {tokEOF, "", 7}}},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	for _, src := range []string{"'abc", "`col", "a # b", "a & b", "a = b"} {
+		if _, err := tokenize(src); err == nil {
+			t.Errorf("%q should not be tokenized", src)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type node interface {
+	eval(env Env) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+type ident struct {
+	name string
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+type ternary struct {
+	cond, then, other node
+}
+
+type call struct {
+	name string
+	fn   *function
+	args []node
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		tok := p.peek()
+		return fmt.Errorf("expr: expected %q at %d, got %q", op, tok.pos, tok.text)
+	}
+	p.advance()
+	return nil
+}
+
+//parse builds the syntax tree with the precedence (from low to high):
+//	?:, ||, &&, == !=, < <= > >=, + -, * / %, unary ! -
+func parse(src string) (node, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	n, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("expr: unexpected %q at %d", tok.text, tok.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) parseTernary() (node, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("?") {
+		return cond, nil
+	}
+	p.advance()
+
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	other, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &ternary{cond: cond, then: then, other: other}, nil
+}
+
+var precedences = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level >= len(precedences) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(precedences[level]...) {
+		op := p.advance().text
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!", "-") {
+		op := p.advance().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case tokNumber:
+		if !strings.ContainsAny(tok.text, ".eE") {
+			if ival, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+				return &literal{value: ival}, nil
+			}
+		}
+		fval, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expr: invalid number %q at %d", tok.text, tok.pos)
+		}
+		return &literal{value: fval}, nil
+	case tokString:
+		return &literal{value: tok.text}, nil
+	case tokIdent:
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null", "nil":
+			return &literal{value: nil}, nil
+		}
+		if p.isOp("(") {
+			return p.parseCall(tok)
+		}
+		return &ident{name: tok.text}, nil
+	case tokOp:
+		if tok.text == "(" {
+			n, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("expr: unexpected end of expression")
+	}
+	return nil, fmt.Errorf("expr: unexpected %q at %d", tok.text, tok.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("expr: unknown function %s at %d", name.text, name.pos)
+	}
+	p.advance()
+
+	args := []node{}
+	for !p.isOp(")") {
+		arg, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.advance()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("expr: wrong number of arguments(%d) for function %s", len(args), name.text)
+	}
+
+	switch strings.ToLower(name.text) {
+	case "if":
+		//only the argument chosen is evaluated
+		return &ternary{cond: args[0], then: args[1], other: args[2]}, nil
+	case "matches":
+		if lit, ok := args[1].(*literal); ok {
+			pattern, err := toString(lit.value)
+			if err != nil {
+				return nil, fmt.Errorf("expr: invalid pattern %v of function %s", lit.value, name.text)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("expr: invalid pattern of function %s: %v", name.text, err)
+			}
+			fn = &function{minArgs: fn.minArgs, maxArgs: fn.maxArgs, call: matchesRegexp(re)}
+		}
+	}
+	return &call{name: name.text, fn: fn, args: args}, nil
+}
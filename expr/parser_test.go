@@ -0,0 +1,84 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//format writes the tree with the parentheses of the precedence.
+func format(n node) string {
+	switch v := n.(type) {
+	case *literal:
+		if s, ok := v.value.(string); ok {
+			return fmt.Sprintf("%q", s)
+		}
+		return fmt.Sprint(v.value)
+	case *ident:
+		return v.name
+	case *unary:
+		return "(" + v.op + format(v.operand) + ")"
+	case *binary:
+		return "(" + format(v.left) + " " + v.op + " " + format(v.right) + ")"
+	case *ternary:
+		return "(" + format(v.cond) + " ? " + format(v.then) + " : " + format(v.other) + ")"
+	case *call:
+		args := make([]string, len(v.args))
+		for i, arg := range v.args {
+			args[i] = format(arg)
+		}
+		return v.name + "(" + strings.Join(args, ", ") + ")"
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"a - b - c", "((a - b) - c)"},
+		{"-a * !b", "((-a) * (!b))"},
+		{"a < 1 || b == 2 && c", "((a < 1) || ((b == 2) && c))"},
+		{"a ? b : c ? d : e", "(a ? b : (c ? d : e))"},
+		{"1.5 + 2", "(1.5 + 2)"},
+		{"TRUE && null", "(true && <nil>)"},
+		{"concat(a, ' ', upper(b))", `concat(a, " ", upper(b))`},
+		{"if(a > 1, b, c)", "((a > 1) ? b : c)"},
+		{"IF(a, 1 / 0, 2)", "(a ? (1 / 0) : 2)"},
+		{"matches(a, '^x')", `matches(a, "^x")`},
+	}
+	for _, tt := range tests {
+		n, err := parse(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+		} else if got := format(n); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"", "unexpected end"},
+		{"1 +", "unexpected end"},
+		{"(1 + 2", `expected ")"`},
+		{"a b", `unexpected "b"`},
+		{"a ? b", `expected ":"`},
+		{"nofunc(a)", "unknown function nofunc"},
+		{"upper(a, b)", "wrong number of arguments(2)"},
+		{"if(a, b)", "wrong number of arguments(2)"},
+		{"matches(a, '(')", "invalid pattern"},
+	}
+	for _, tt := range tests {
+		_, err := parse(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package etlx
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//ReadFile reads a csv file with header, or a json file of an array of objects
+//or an object per line. csv is read while iterating and all values are strings,
+//json is read into memory to get the keys of all objects as the columns.
+func ReadFile(path string) (driver.Rows, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err := newCSVRows(f)
+		if err != nil {
+			return nil, err
+		}
+		return rows, nil
+	case ".json", ".jsonl", ".ndjson":
+		defer f.Close()
+		return readJSON(f)
+	}
+	f.Close()
+	return nil, fmt.Errorf("etlx: unsupported file type of %s", path)
+}
+
+type csvRows struct {
+	file    *os.File
+	reader  *csv.Reader
+	columns []string
+}
+
+func newCSVRows(f *os.File) (*csvRows, error) {
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &csvRows{file: f, reader: reader, columns: header}, nil
+}
+
+func (r *csvRows) Columns() []string {
+	return r.columns
+}
+
+func (r *csvRows) Next(dst interface{}) error {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return driver.EOT
+	}
+	if err != nil {
+		return err
+	}
+
+	row := make([]interface{}, len(r.columns))
+	for i := range r.columns {
+		if i < len(record) {
+			row[i] = record[i]
+		}
+	}
+	return driver.CopyRow(r.columns, row, dst)
+}
+
+func (r *csvRows) Close() error {
+	return r.file.Close()
+}
+
+func readJSON(r io.Reader) (driver.Rows, error) {
+	objs := []map[string]interface{}{}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for {
+		var val interface{}
+		if err := dec.Decode(&val); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch v := val.(type) {
+		case map[string]interface{}:
+			objs = append(objs, v)
+		case []interface{}:
+			for _, item := range v {
+				obj, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("etlx: json should be objects, got %v", item)
+				}
+				objs = append(objs, obj)
+			}
+		default:
+			return nil, fmt.Errorf("etlx: json should be objects, got %v", v)
+		}
+	}
+
+	//the columns are the keys of all objects, in the order they appear
+	columns := []string{}
+	seen := map[string]bool{}
+	for _, obj := range objs {
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			if !seen[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			seen[key] = true
+		}
+		columns = append(columns, keys...)
+	}
+
+	tbl := driver.NewTable(len(objs))
+	tbl.SetColumns(columns)
+	if len(columns) == 0 {
+		return tbl, nil
+	}
+	for _, obj := range objs {
+		row, err := driver.MapToArray(columns, obj)
+		if err != nil {
+			return nil, err
+		}
+		tbl.AppendData(row)
+	}
+	return tbl, nil
+}
@@ -0,0 +1,106 @@
+package etlx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//Stage is the driver of a phase of a job and its commands.
+type Stage struct {
+	Driver     string           `json:"driver"`
+	Name       string           `json:"name,omitempty"`
+	DataSource string           `json:"data_source"`
+	Args       []driver.Command `json:"args,omitempty"`
+}
+
+//Job describes a transaction in a file, e.g.
+//	{
+//		"name": "active_users",
+//		"extract": {"driver": "memory", "data_source": "users"},
+//		"transform": {"driver": "filter", "args": [{"name": "filter", "type": "string", "value": "active"}]},
+//		"load": {"driver": "memory", "data_source": "active_users"},
+//		"batch": 1000
+//	}
+//The name of a stage is the name of the job if it is empty, batch is disabled
+//if Batch is 0.
+type Job struct {
+	Name      string `json:"name"`
+	Extract   Stage  `json:"extract"`
+	Transform Stage  `json:"transform"`
+	Load      Stage  `json:"load"`
+	Batch     int64  `json:"batch,omitempty"`
+}
+
+//ReadJob reads the job from a json or yaml file.
+func ReadJob(path string) (*Job, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err := json.Unmarshal(b, job); err != nil {
+		if yerr := yaml.Unmarshal(b, job); yerr != nil {
+			return nil, fmt.Errorf("etlx: invalid job %s: %v", path, err)
+		}
+	}
+	if job.Extract.Driver == "" || job.Transform.Driver == "" || job.Load.Driver == "" {
+		return nil, fmt.Errorf("etlx: job %s should provide the drivers of extract, transform and load", path)
+	}
+	return job, nil
+}
+
+func (j *Job) stageName(s Stage) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if j.Name != "" {
+		return j.Name
+	}
+	return s.Driver
+}
+
+//Open opens the transaction of the job and the handlers of its stages.
+func (j *Job) Open(options ...func(*Transaction)) (*Transaction, error) {
+	if j.Batch > 0 {
+		options = append([]func(*Transaction){BatchEnable("enable", j.Batch)}, options...)
+	}
+	t, err := Open(j.Extract.Driver, j.Transform.Driver, j.Load.Driver, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.ExtractOpen(j.Extract.Driver, j.stageName(j.Extract), j.Extract.DataSource); err != nil {
+		return nil, err
+	}
+	if err := t.TransformOpen(j.Transform.Driver, j.stageName(j.Transform), j.Transform.DataSource); err != nil {
+		t.extractClose()
+		return nil, err
+	}
+	if err := t.LoadOpen(j.Load.Driver, j.stageName(j.Load), j.Load.DataSource); err != nil {
+		t.extractClose()
+		t.transformClose()
+		return nil, err
+	}
+	return t, nil
+}
+
+//Run opens the transaction of the job, executes it with the commands of the
+//stages and closes it.
+func (j *Job) Run(options ...func(*Transaction)) error {
+	t, err := j.Open(options...)
+	if err != nil {
+		return err
+	}
+
+	err = t.Exec(j.Extract.Args, j.Transform.Args, j.Load.Args)
+	for _, cerr := range t.Close() {
+		if err == nil && cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package etlx_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+func TestReadJob(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"job.json": `{"name": "j", "extract": {"driver": "e", "data_source": "in"}, "transform": {"driver": "filter", "args": [{"name": "filter", "type": "string", "value": "id > 1"}]}, "load": {"driver": "l", "data_source": "out"}, "batch": 10}`,
+		"job.yaml": "name: j\nextract: {driver: e, data_source: in}\ntransform:\n  driver: filter\n  args:\n  - {name: filter, type: string, value: id > 1}\nload: {driver: l, data_source: out}\nbatch: 10\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		job, err := etlx.ReadJob(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if job.Name != "j" || job.Extract.DataSource != "in" || job.Load.Driver != "l" || job.Batch != 10 {
+			t.Errorf("%s: ReadJob = %+v", name, job)
+		}
+		if len(job.Transform.Args) != 1 || job.Transform.Args[0].Value != "id > 1" {
+			t.Errorf("%s: the args of transform = %v", name, job.Transform.Args)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"nodriver.json": `{"name": "j", "extract": {"driver": "e"}, "transform": {"driver": "filter"}}`,
+		"invalid.json":  `{"name": [`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := etlx.ReadJob(path); err == nil {
+			t.Errorf("%s: ReadJob succeeded", name)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"in.csv":   "id,name\n1,a\n2,b\n",
+		"in.json":  `[{"id": 1, "name": "a"}, {"id": 2, "name": "b"}]`,
+		"in.jsonl": "{\"id\": 1, \"name\": \"a\"}\n{\"id\": 2, \"name\": \"b\"}\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := etlx.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cols := rows.Columns(); len(cols) != 2 || cols[0] != "id" || cols[1] != "name" {
+			t.Errorf("%s: columns = %v", name, cols)
+		}
+		n := 0
+		for {
+			row := []interface{}{}
+			if err := rows.Next(&row); err == driver.EOT {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			n++
+		}
+		rows.Close()
+		if n != 2 {
+			t.Errorf("%s: read %d rows, want 2", name, n)
+		}
+	}
+
+	path := filepath.Join(dir, "in.txt")
+	if err := ioutil.WriteFile(path, []byte("id\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := etlx.ReadFile(path); err == nil {
+		t.Errorf("ReadFile of a txt file succeeded")
+	}
+}
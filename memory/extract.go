@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+type extractDriver struct {
+	store *Store
+}
+
+//ExtractDriver returns the extract driver reading the datasets of the store.
+func (s *Store) ExtractDriver() driver.ExtractDriver {
+	return &extractDriver{store: s}
+}
+
+func (d *extractDriver) Open(name, dataSource string) (driver.Extract, error) {
+	if dataSource == "" {
+		return nil, fmt.Errorf("memory: the data source should be the name of a dataset")
+	}
+	return &extractor{store: d.store, dataset: dataSource}, nil
+}
+
+func (d *extractDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "memory reads the rows of the dataset named by the data source.",
+		Commands: []driver.CommandSpec{
+			{Name: "columns", Type: "list", Doc: "columns read in order, all if empty"},
+		},
+	}
+}
+
+type extractor struct {
+	store   *Store
+	dataset string
+
+	batch driver.Batch
+	mu    sync.Mutex
+}
+
+func (e *extractor) SetBatch(limit int64, offset int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.batch.SetBatch(limit, offset)
+}
+
+//Command returns the columns to read, nil for all.
+func (e *extractor) Command(args []driver.Command) (interface{}, error) {
+	var columns []string
+	for _, arg := range args {
+		switch arg.Name {
+		case "columns":
+			items, err := driver.ArrayFromInterface(arg.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Command(%s) should have a list value", arg.Name)
+			}
+			for _, item := range items {
+				col, err := driver.StringFromInterface(item)
+				if err != nil {
+					return nil, fmt.Errorf("Command(%s) should be a list of strings", arg.Name)
+				}
+				columns = append(columns, col)
+			}
+		default:
+			return nil, fmt.Errorf("memory: unsupported command %s", arg.Name)
+		}
+	}
+	return columns, nil
+}
+
+//Query returns the rows of the batch set by SetBatch. driver.EOT is returned
+//once the batch is past the end, which stops the transaction in batch mode.
+func (e *extractor) Query(cmd interface{}) (driver.Rows, error) {
+	d, ok := e.store.get(e.dataset)
+	if !ok {
+		return nil, notFound(e.dataset)
+	}
+
+	e.mu.Lock()
+	batch := e.batch
+	e.mu.Unlock()
+	if batch.Flag && batch.Limit > 0 && batch.Offset >= int64(len(d.data)) {
+		return nil, driver.EOT
+	}
+
+	tbl := d.table(batch.Limit, batch.Offset)
+	columns, _ := cmd.([]string)
+	if len(columns) == 0 {
+		return tbl, nil
+	}
+	return project(tbl, columns)
+}
+
+//project returns the table of the columns only.
+func project(tbl *driver.Table, columns []string) (*driver.Table, error) {
+	index := make([]int, len(columns))
+	for i, col := range columns {
+		index[i] = -1
+		for j, name := range tbl.Columns() {
+			if name == col {
+				index[i] = j
+			}
+		}
+		if index[i] < 0 {
+			return nil, fmt.Errorf("memory: column %s not found", col)
+		}
+	}
+
+	rslt := driver.NewTable(len(tbl.GetData()))
+	if schema := tbl.Schema(); len(schema.Fields) > 0 {
+		fields := make([]driver.Field, len(index))
+		for i, j := range index {
+			fields[i] = schema.Fields[j]
+		}
+		rslt.SetSchema(driver.Schema{Fields: fields})
+	} else {
+		rslt.SetColumns(columns)
+	}
+	for _, row := range tbl.GetData() {
+		data := make([]interface{}, len(index))
+		for i, j := range index {
+			data[i] = row[j]
+		}
+		rslt.AppendData(data)
+	}
+	return rslt, nil
+}
+
+func (e *extractor) Close() error {
+	return nil
+}
@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+const (
+	modeReplace = "replace"
+	modeAppend  = "append"
+)
+
+type loadDriver struct {
+	store *Store
+}
+
+//LoadDriver returns the load driver collecting the results into the datasets
+//of the store.
+func (s *Store) LoadDriver() driver.LoadDriver {
+	return &loadDriver{store: s}
+}
+
+func (d *loadDriver) Open(name, dataSource string) (driver.Load, error) {
+	if dataSource == "" {
+		return nil, fmt.Errorf("memory: the data source should be the name of a dataset")
+	}
+	return &loader{store: d.store, dataset: dataSource}, nil
+}
+
+func (d *loadDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "memory collects the results into the dataset named by the data source.",
+		Commands: []driver.CommandSpec{
+			{Name: "mode", Type: "string", Enum: []string{modeReplace, modeAppend}, Default: modeReplace,
+				Doc: "replace the dataset by the rows loaded by the handler, or append to it"},
+		},
+	}
+}
+
+type loader struct {
+	store   *Store
+	dataset string
+
+	mu sync.Mutex
+	//loaded is set once the handler loaded the first batch, the batches after
+	//it are appended even in replace mode.
+	loaded bool
+}
+
+func (l *loader) Command(args []driver.Command) (interface{}, error) {
+	mode := modeReplace
+	for _, arg := range args {
+		switch arg.Name {
+		case "mode":
+			str, err := driver.StringFromInterface(arg.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Command(%s) should have a string value", arg.Name)
+			}
+			if str != modeReplace && str != modeAppend {
+				return nil, fmt.Errorf("memory: unsupported mode %s", str)
+			}
+			mode = str
+		default:
+			return nil, fmt.Errorf("memory: unsupported command %s", arg.Name)
+		}
+	}
+	return mode, nil
+}
+
+//Load reads all the rows before adding them, so a failed batch adds nothing.
+func (l *loader) Load(src driver.Results, cmd interface{}) error {
+	mode, _ := cmd.(string)
+	columns := append([]string(nil), src.Columns()...)
+	var schema driver.Schema
+	if rows, ok := src.(driver.SchemaRows); ok {
+		schema = rows.Schema()
+	}
+
+	data := [][]interface{}{}
+	for {
+		row := make([]interface{}, len(columns))
+		if err := src.Next(row); err == driver.EOT {
+			break
+		} else if err != nil {
+			return err
+		}
+		for i := range row {
+			row[i] = driver.DataPreProcess(row[i])
+		}
+		data = append(data, row)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.datasets[l.dataset]
+	if !ok || (mode != modeAppend && !l.loaded) {
+		s.datasets[l.dataset] = &dataset{columns: columns, schema: schema, data: data}
+		l.loaded = true
+		return nil
+	}
+	if len(old.columns) > 0 && !reflect.DeepEqual(old.columns, columns) {
+		return fmt.Errorf("memory: the columns of dataset %s are %v, not %v", l.dataset, old.columns, columns)
+	}
+
+	//the old dataset keeps its slice header, so the readers holding it never
+	//see the rows appended past its length and the rows are not copied again
+	d := &dataset{columns: old.columns, schema: old.schema, data: append(old.data, data...)}
+	if len(d.columns) == 0 {
+		d.columns, d.schema = columns, schema
+	}
+	s.datasets[l.dataset] = d
+	l.loaded = true
+	return nil
+}
+
+//QueryFromNextStep returns the rows of the dataset, so the next transaction
+//could read what is loaded.
+func (l *loader) QueryFromNextStep() (driver.Rows, error) {
+	d, ok := l.store.get(l.dataset)
+	if !ok {
+		return nil, notFound(l.dataset)
+	}
+	return d.table(0, 0), nil
+}
+
+func (l *loader) Close() error {
+	return nil
+}
@@ -0,0 +1,213 @@
+//Package memory provides the "memory" extract and load drivers, which keep
+//named datasets in memory. The data source of the drivers is the name of the
+//dataset, so a transaction loading into "users" could be followed by another
+//extracting from "users", and the tests could set the input and inspect the
+//output without any database:
+//
+//	import "github.com/xingwangc/etlx/memory"
+//
+//	memory.SetMaps("users", []map[string]interface{}{{"id": 1, "name": "a"}})
+//	//run a transaction from memory:users to memory:out
+//	out := memory.Table("out")
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//DriverName is the name the drivers are registered by.
+const DriverName = "memory"
+
+func init() {
+	etlx.ExtractRegister(DriverName, DefaultStore.ExtractDriver(), driver.DriverInfo{
+		Description:  "read the rows of a dataset in memory",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema},
+	})
+	etlx.LoadRegister(DriverName, DefaultStore.LoadDriver(), driver.DriverInfo{
+		Description:  "collect the results into a dataset in memory",
+		Capabilities: []driver.Capability{driver.CapBatch},
+	})
+}
+
+//dataset is a table kept by Store, the rows are never modified once added.
+type dataset struct {
+	columns []string
+	schema  driver.Schema
+	data    [][]interface{}
+}
+
+//table returns a new table of the rows from offset, at most limit rows if
+//the limit is not 0.
+func (d *dataset) table(limit, offset int64) *driver.Table {
+	data := d.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if limit > 0 && limit < int64(len(data)) {
+		data = data[:limit]
+	}
+
+	tbl := driver.NewTable(0)
+	if len(d.schema.Fields) > 0 {
+		tbl.SetSchema(d.schema)
+	} else {
+		tbl.SetColumns(d.columns)
+	}
+	tbl.SetData(append([][]interface{}(nil), data...))
+	return tbl
+}
+
+//Store is a set of named datasets, it is safe for concurrent use. The drivers
+//registered read and write DefaultStore, another store could be registered
+//in an etlx.Registry by its ExtractDriver and LoadDriver.
+type Store struct {
+	mu       sync.RWMutex
+	datasets map[string]*dataset
+}
+
+func NewStore() *Store {
+	return &Store{datasets: map[string]*dataset{}}
+}
+
+//DefaultStore is the store of the drivers registered as "memory".
+var DefaultStore = NewStore()
+
+//SetMaps replaces the dataset by the rows of maps. The columns are the sorted
+//keys of all the rows, a key missing in a row is nil.
+func (s *Store) SetMaps(name string, rows []map[string]interface{}) {
+	keys := map[string]bool{}
+	columns := []string{}
+	for _, row := range rows {
+		for key := range row {
+			if !keys[key] {
+				keys[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	data := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		data[i] = make([]interface{}, len(columns))
+		for j, col := range columns {
+			data[i][j] = row[col]
+		}
+	}
+	s.set(name, &dataset{columns: columns, data: data})
+}
+
+//SetTable replaces the dataset by the rows of the table, its schema is kept if
+//it is set. The rows are copied, the table could be reused.
+func (s *Store) SetTable(name string, tbl *driver.Table) {
+	data := make([][]interface{}, len(tbl.GetData()))
+	for i, row := range tbl.GetData() {
+		data[i] = append([]interface{}(nil), row...)
+	}
+	s.set(name, &dataset{columns: append([]string(nil), tbl.Columns()...), schema: tbl.Schema(), data: data})
+}
+
+func (s *Store) set(name string, d *dataset) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.datasets[name] = d
+}
+
+func (s *Store) get(name string) (*dataset, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.datasets[name]
+	return d, ok
+}
+
+//Table returns a new table of the rows of the dataset, nil if it is not found.
+func (s *Store) Table(name string) *driver.Table {
+	d, ok := s.get(name)
+	if !ok {
+		return nil
+	}
+	return d.table(0, 0)
+}
+
+//Maps returns the rows of the dataset as maps of the columns, nil if it is
+//not found.
+func (s *Store) Maps(name string) []map[string]interface{} {
+	d, ok := s.get(name)
+	if !ok {
+		return nil
+	}
+	maps := make([]map[string]interface{}, len(d.data))
+	for i, row := range d.data {
+		maps[i] = make(map[string]interface{}, len(d.columns))
+		for j, col := range d.columns {
+			maps[i][col] = row[j]
+		}
+	}
+	return maps
+}
+
+//Names returns the names of the datasets in order.
+func (s *Store) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.datasets))
+	for name := range s.datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Delete drops the dataset.
+func (s *Store) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.datasets, name)
+}
+
+//Reset drops all the datasets.
+func (s *Store) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.datasets = map[string]*dataset{}
+}
+
+//SetMaps replaces the dataset of DefaultStore, see Store.SetMaps.
+func SetMaps(name string, rows []map[string]interface{}) {
+	DefaultStore.SetMaps(name, rows)
+}
+
+//SetTable replaces the dataset of DefaultStore, see Store.SetTable.
+func SetTable(name string, tbl *driver.Table) {
+	DefaultStore.SetTable(name, tbl)
+}
+
+//Table returns the rows of the dataset of DefaultStore.
+func Table(name string) *driver.Table {
+	return DefaultStore.Table(name)
+}
+
+//Maps returns the rows of the dataset of DefaultStore as maps.
+func Maps(name string) []map[string]interface{} {
+	return DefaultStore.Maps(name)
+}
+
+//Delete drops the dataset of DefaultStore.
+func Delete(name string) {
+	DefaultStore.Delete(name)
+}
+
+//Reset drops all the datasets of DefaultStore.
+func Reset() {
+	DefaultStore.Reset()
+}
+
+func notFound(name string) error {
+	return fmt.Errorf("memory: dataset %s not found", name)
+}
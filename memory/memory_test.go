@@ -0,0 +1,130 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/etlxtest"
+	"github.com/xingwangc/etlx/memory"
+	_ "github.com/xingwangc/etlx/transform"
+)
+
+func users() []map[string]interface{} {
+	return []map[string]interface{}{
+		{"id": int64(1), "name": "a", "age": int64(30)},
+		{"id": int64(2), "name": "b", "age": int64(17)},
+		{"id": int64(3), "name": "c"},
+		{"id": int64(4), "name": "d", "age": int64(40)},
+		{"id": int64(5), "name": "e", "age": int64(50)},
+	}
+}
+
+func TestConformance(t *testing.T) {
+	memory.SetMaps("users", users())
+	etlxtest.TestExtract(t, etlxtest.ExtractCase{Driver: etlx.FindExtract("memory"), DataSource: "users", Rows: 5, Batch: true,
+		BadArgs: []driver.Command{{Name: "nope"}}})
+	etlxtest.TestExtract(t, etlxtest.ExtractCase{Driver: etlx.FindExtract("memory"), DataSource: "users", Rows: 5, Batch: true,
+		Args: []driver.Command{{Name: "columns", Value: []interface{}{"name", "id"}}}})
+	etlxtest.TestLoad(t, etlxtest.LoadCase{Driver: etlx.FindLoad("memory"), DataSource: "sink",
+		BadArgs: []driver.Command{{Name: "mode", Value: "upsert"}},
+		Input:   func() driver.Results { return memory.Table("users") }})
+}
+
+func run(t *testing.T, from, to string, batch int64, trans, load []driver.Command) {
+	opts := []func(*etlx.Transaction){}
+	if batch > 0 {
+		opts = append(opts, etlx.BatchEnable("enable", batch))
+	}
+	tx, err := etlx.Open("memory", "filter", "memory", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.ExtractOpen("memory", "e", from); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.TransformOpen("filter", "t", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.LoadOpen("memory", "l", to); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Exec(nil, trans, load); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	memory.Reset()
+	memory.SetMaps("users", users())
+	if cols := memory.Table("users").Columns(); len(cols) != 3 || cols[0] != "age" {
+		t.Fatal(cols)
+	}
+	for _, batch := range []int64{0, 2} {
+		run(t, "users", "adults", batch, []driver.Command{{Name: "filter", Value: "age >= 18"}}, nil)
+		if got := memory.Maps("adults"); len(got) != 3 {
+			t.Fatalf("batch %d: %v", batch, got)
+		}
+		run(t, "adults", "old", batch, []driver.Command{{Name: "filter", Value: "age > 35"}}, nil)
+		if got := memory.Maps("old"); len(got) != 2 {
+			t.Fatalf("batch %d: %v", batch, got)
+		}
+	}
+	run(t, "users", "old", 0, []driver.Command{{Name: "filter", Value: "id == 1"}}, []driver.Command{{Name: "mode", Value: "append"}})
+	if got := memory.Maps("old"); len(got) != 3 {
+		t.Fatal(got)
+	}
+
+	//QueryFromNextStep
+	h, _ := etlx.FindLoad("memory").Open("l", "old")
+	rows, err := h.QueryFromNextStep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := etlxtest.ReadAll(rows)
+	if len(data) != 3 {
+		t.Fatal(data)
+	}
+
+	//schema kept
+	tbl := driver.NewTable(0)
+	tbl.SetSchema(driver.NewSchema([]string{"x", "y"}))
+	tbl.AppendData([]interface{}{1, 2})
+	memory.SetTable("s", tbl)
+	e, _ := etlx.FindExtract("memory").Open("e", "s")
+	r, err := e.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr, ok := r.(driver.SchemaRows); !ok || len(sr.Schema().Fields) != 2 {
+		t.Fatal("schema")
+	}
+	if _, err := etlx.FindExtract("memory").Open("e", ""); err == nil {
+		t.Error("empty data source")
+	}
+	e, _ = etlx.FindExtract("memory").Open("e", "missing")
+	if _, err := e.Query(nil); err == nil {
+		t.Error("missing dataset")
+	}
+}
+
+func TestAppendKeepsTables(t *testing.T) {
+	memory.Reset()
+	memory.SetMaps("users", users())
+	run(t, "users", "log", 0, nil, nil)
+	before := memory.Table("log")
+	for i := 0; i < 3; i++ {
+		run(t, "users", "log", 2, nil, []driver.Command{{Name: "mode", Value: "append"}})
+	}
+	if got := len(memory.Maps("log")); got != 20 {
+		t.Fatalf("%d rows appended, want 20", got)
+	}
+	if got := len(before.GetData()); got != 5 {
+		t.Errorf("the table returned before has %d rows, want 5", got)
+	}
+
+	run(t, "users", "log", 0, nil, nil)
+	if got := len(memory.Maps("log")); got != 5 {
+		t.Errorf("%d rows replaced, want 5", got)
+	}
+}
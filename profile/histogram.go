@@ -0,0 +1,90 @@
+package profile
+
+import (
+	"math"
+	"sort"
+)
+
+//number of centroids kept by the streaming histogram
+const maxCentroids = 128
+
+//histogram is the streaming histogram of Ben-Haim and Tom-Tov. It keeps at most
+//maxCentroids centroids and merges the closest two when a new one exceeds the
+//limit, so the range does not need to be known before the values are seen.
+type histogram struct {
+	centroids []centroid
+}
+
+type centroid struct {
+	value float64
+	count float64
+}
+
+//Bucket is a bin of the numeric histogram, the count is estimated.
+type Bucket struct {
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+	Count int64   `json:"count"`
+}
+
+func (h *histogram) add(val float64) {
+	i := sort.Search(len(h.centroids), func(i int) bool { return h.centroids[i].value >= val })
+	if i < len(h.centroids) && h.centroids[i].value == val {
+		h.centroids[i].count++
+		return
+	}
+
+	h.centroids = append(h.centroids, centroid{})
+	copy(h.centroids[i+1:], h.centroids[i:])
+	h.centroids[i] = centroid{value: val, count: 1}
+	if len(h.centroids) <= maxCentroids {
+		return
+	}
+
+	closest := 0
+	minGap := math.Inf(1)
+	for j := 0; j < len(h.centroids)-1; j++ {
+		if gap := h.centroids[j+1].value - h.centroids[j].value; gap < minGap {
+			closest, minGap = j, gap
+		}
+	}
+	a, b := h.centroids[closest], h.centroids[closest+1]
+	count := a.count + b.count
+	h.centroids[closest] = centroid{value: (a.value*a.count + b.value*b.count) / count, count: count}
+	h.centroids = append(h.centroids[:closest+1], h.centroids[closest+2:]...)
+}
+
+//buckets splits [min, max] into n bins of the same width. The count of every
+//centroid goes to the bin it falls in.
+func (h *histogram) buckets(n int, min, max float64) []Bucket {
+	if len(h.centroids) == 0 || n <= 0 {
+		return nil
+	}
+	if min == max {
+		total := 0.0
+		for _, c := range h.centroids {
+			total += c.count
+		}
+		return []Bucket{{Low: min, High: max, Count: int64(total)}}
+	}
+
+	width := (max - min) / float64(n)
+	rslt := make([]Bucket, n)
+	for i := range rslt {
+		rslt[i].Low = min + width*float64(i)
+		rslt[i].High = min + width*float64(i+1)
+	}
+	rslt[n-1].High = max
+
+	for _, c := range h.centroids {
+		i := int((c.value - min) / width)
+		if i >= n {
+			i = n - 1
+		}
+		if i < 0 {
+			i = 0
+		}
+		rslt[i].Count += int64(c.count)
+	}
+	return rslt
+}
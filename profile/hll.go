@@ -0,0 +1,63 @@
+package profile
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+//precision of the HyperLogLog, 2^14 registers give about 0.8% standard error
+const hllPrecision = 14
+
+//hyperLogLog estimates the number of distinct values with fixed memory.
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(val string) {
+	x := hash64(val)
+	idx := x >> (64 - hllPrecision)
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	rho := uint8(bits.LeadingZeros64(w)) + 1
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+	//linear counting is more accurate for small cardinalities
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+//hash64 is fnv-1a with the finalizer of splitmix64, fnv alone does not spread
+//the short strings well enough for the leading zeros.
+func hash64(val string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(val))
+	x := f.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,257 @@
+//Package profile reads rows once and reports the statistics of every column,
+//which helps to understand a source before writing the transforms.
+package profile
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//Options of Profile.
+type Options struct {
+	//number of the most frequent values reported, 10 by default, none if <= 0
+	TopK int
+	//number of bins of the numeric histogram, 10 by default, none if <= 0
+	Bins int
+}
+
+func TopK(k int) func(*Options) {
+	return func(o *Options) {
+		o.TopK = k
+	}
+}
+
+func Bins(n int) func(*Options) {
+	return func(o *Options) {
+		o.Bins = n
+	}
+}
+
+//Report is the profile of rows.
+type Report struct {
+	Rows    int64            `json:"rows"`
+	Columns []*ColumnProfile `json:"columns"`
+}
+
+//ColumnProfile is the profile of a column. Empty strings are counted as null.
+type ColumnProfile struct {
+	Name     string `json:"name"`
+	Nulls    int64  `json:"nulls"`
+	Distinct uint64 `json:"distinct"`
+
+	//Type is the type of most values as driver.GuessType, int is counted as
+	//float if both of them are found. Confidence is the rate of the not null
+	//values of the type.
+	Type       driver.LogicalType `json:"type"`
+	Layout     string             `json:"layout,omitempty"`
+	Confidence float64            `json:"confidence"`
+	Types      map[string]int64   `json:"types"`
+
+	//Min and Max are compared as number, time or string by the Type
+	Min interface{} `json:"min,omitempty"`
+	Max interface{} `json:"max,omitempty"`
+
+	Mean      *float64 `json:"mean,omitempty"`
+	Histogram []Bucket `json:"histogram,omitempty"`
+
+	TopValues []ValueCount `json:"top_values,omitempty"`
+	Length    *LengthStats `json:"length,omitempty"`
+}
+
+//LengthStats are the number of characters of the values as strings.
+type LengthStats struct {
+	Min  int     `json:"min"`
+	Max  int     `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+type columnStats struct {
+	profile *ColumnProfile
+	hll     *hyperLogLog
+	top     *topK
+	hist    histogram
+	layouts map[string]int64
+
+	nums       int64
+	sum        float64
+	numMin     float64
+	numMax     float64
+	times      int64
+	timeMin    time.Time
+	timeMax    time.Time
+	strs       int64
+	strMin     string
+	strMax     string
+	lenSum     int64
+	lenMin     int
+	lenMax     int
+	lenStarted bool
+}
+
+//Profile reads all the rows and returns the profile. Memory used is fixed for
+//every column regardless of the number of rows.
+func Profile(rows driver.Rows, options ...func(*Options)) (*Report, error) {
+	opts := Options{TopK: 10, Bins: 10}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	columns := rows.Columns()
+	stats := make([]*columnStats, len(columns))
+	for i, name := range columns {
+		stats[i] = &columnStats{
+			profile: &ColumnProfile{Name: name, Types: make(map[string]int64)},
+			hll:     newHyperLogLog(),
+			top:     newTopK(opts.TopK),
+			layouts: make(map[string]int64),
+		}
+	}
+
+	report := &Report{}
+	for {
+		row := make([]interface{}, len(columns))
+		if err := rows.Next(row); err != nil {
+			if err == driver.EOT {
+				break
+			}
+			return nil, err
+		}
+		report.Rows++
+		for i, val := range row {
+			stats[i].add(driver.DataPreProcess(val))
+		}
+	}
+
+	report.Columns = make([]*ColumnProfile, len(columns))
+	for i, s := range stats {
+		report.Columns[i] = s.result(opts)
+	}
+	return report, nil
+}
+
+func valueString(val interface{}) string {
+	switch v := val.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	if str, err := driver.StringFromInterface(val); err == nil {
+		return str
+	}
+	return fmt.Sprint(val)
+}
+
+func (s *columnStats) add(val interface{}) {
+	typ, layout := driver.GuessType(val)
+	if typ == driver.TypeAny {
+		s.profile.Nulls++
+		return
+	}
+	s.profile.Types[string(typ)]++
+	if layout != "" {
+		s.layouts[layout]++
+	}
+
+	str := valueString(val)
+	s.hll.add(str)
+	s.top.add(str)
+
+	n := utf8.RuneCountInString(str)
+	if !s.lenStarted || n < s.lenMin {
+		s.lenMin = n
+	}
+	if !s.lenStarted || n > s.lenMax {
+		s.lenMax = n
+	}
+	s.lenStarted = true
+	s.lenSum += int64(n)
+
+	if s.strs == 0 || str < s.strMin {
+		s.strMin = str
+	}
+	if s.strs == 0 || str > s.strMax {
+		s.strMax = str
+	}
+	s.strs++
+
+	switch typ {
+	case driver.TypeInt, driver.TypeFloat:
+		f, err := driver.FloatFromInterface(val)
+		if err != nil {
+			return
+		}
+		if s.nums == 0 || f < s.numMin {
+			s.numMin = f
+		}
+		if s.nums == 0 || f > s.numMax {
+			s.numMax = f
+		}
+		s.nums++
+		s.sum += f
+		s.hist.add(f)
+	case driver.TypeTime:
+		t, ok := val.(time.Time)
+		if !ok {
+			var err error
+			if t, err = driver.TimeFromInterface(str, layout); err != nil {
+				return
+			}
+		}
+		if s.times == 0 || t.Before(s.timeMin) {
+			s.timeMin = t
+		}
+		if s.times == 0 || t.After(s.timeMax) {
+			s.timeMax = t
+		}
+		s.times++
+	}
+}
+
+func (s *columnStats) result(opts Options) *ColumnProfile {
+	p := s.profile
+	p.Distinct = s.hll.estimate()
+	if s.strs == 0 {
+		p.Type = driver.TypeAny
+		return p
+	}
+
+	//the type of most values, int is a float as well if there are floats
+	var count int64
+	for typ, n := range p.Types {
+		if n > count || (n == count && typ < string(p.Type)) {
+			p.Type, count = driver.LogicalType(typ), n
+		}
+	}
+	if p.Types[string(driver.TypeInt)] > 0 && p.Types[string(driver.TypeFloat)] > 0 &&
+		(p.Type == driver.TypeInt || p.Type == driver.TypeFloat) {
+		p.Type = driver.TypeFloat
+		count = p.Types[string(driver.TypeInt)] + p.Types[string(driver.TypeFloat)]
+	}
+	p.Confidence = driver.Round(float64(count)/float64(s.strs), 4)
+	if p.Type == driver.TypeTime {
+		var max int64
+		for layout, n := range s.layouts {
+			if n > max || (n == max && layout < p.Layout) {
+				p.Layout, max = layout, n
+			}
+		}
+	}
+
+	switch {
+	case (p.Type == driver.TypeInt || p.Type == driver.TypeFloat) && s.nums > 0:
+		p.Min, p.Max = s.numMin, s.numMax
+		mean := s.sum / float64(s.nums)
+		p.Mean = &mean
+		p.Histogram = s.hist.buckets(opts.Bins, s.numMin, s.numMax)
+	case p.Type == driver.TypeTime && s.times > 0:
+		p.Min, p.Max = s.timeMin, s.timeMax
+	default:
+		p.Min, p.Max = s.strMin, s.strMax
+	}
+
+	p.TopValues = s.top.result()
+	p.Length = &LengthStats{Min: s.lenMin, Max: s.lenMax, Mean: float64(s.lenSum) / float64(s.strs)}
+	return p
+}
@@ -0,0 +1,161 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func newRows(columns []string, rows [][]interface{}) *driver.Table {
+	tbl := driver.NewTable(len(rows))
+	tbl.SetColumns(columns)
+	tbl.SetData(rows)
+	return tbl
+}
+
+func TestProfile(t *testing.T) {
+	rows := newRows([]string{"id", "price", "day", "status"}, [][]interface{}{
+		{"1", 1.5, "2016-01-02", "active"},
+		{"2", "3", "2016-01-05", "inactive"},
+		{"3", 2, "2016-01-03", "active"},
+		{"4", nil, "", "active"},
+	})
+	report, err := Profile(rows, TopK(1), Bins(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != 4 || len(report.Columns) != 4 {
+		t.Fatalf("profiled %d rows of %d columns", report.Rows, len(report.Columns))
+	}
+
+	id := report.Columns[0]
+	if id.Type != driver.TypeInt || id.Confidence != 1 || id.Distinct != 4 || id.Min != 1.0 || id.Max != 4.0 {
+		t.Errorf("id = %+v", id)
+	}
+
+	//int is counted as float with the floats
+	price := report.Columns[1]
+	if price.Type != driver.TypeFloat || price.Nulls != 1 || price.Mean == nil || math.Abs(*price.Mean-6.5/3) > 1e-9 {
+		t.Errorf("price = %+v", price)
+	}
+	if len(price.Histogram) != 2 || price.Histogram[0].Count+price.Histogram[1].Count != 3 {
+		t.Errorf("price histogram = %v", price.Histogram)
+	}
+
+	day := report.Columns[2]
+	if day.Type != driver.TypeTime || day.Layout != "2006-01-02" || day.Nulls != 1 {
+		t.Errorf("day = %+v", day)
+	}
+	min, okMin := day.Min.(time.Time)
+	max, okMax := day.Max.(time.Time)
+	if !okMin || !okMax || min.Format("2006-01-02") != "2016-01-02" || max.Format("2006-01-02") != "2016-01-05" {
+		t.Errorf("day min and max = %v, %v", day.Min, day.Max)
+	}
+
+	status := report.Columns[3]
+	if status.Type != driver.TypeString || status.Distinct != 2 || status.Min != "active" || status.Max != "inactive" {
+		t.Errorf("status = %+v", status)
+	}
+	if len(status.TopValues) != 1 || status.TopValues[0] != (ValueCount{Value: "active", Count: 3}) {
+		t.Errorf("status top values = %v", status.TopValues)
+	}
+	if status.Length == nil || status.Length.Min != 6 || status.Length.Max != 8 {
+		t.Errorf("status length = %+v", status.Length)
+	}
+}
+
+func TestProfileNulls(t *testing.T) {
+	report, err := Profile(newRows([]string{"empty"}, [][]interface{}{{nil}, {""}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := report.Columns[0]; c.Type != driver.TypeAny || c.Nulls != 2 || c.Distinct != 0 || c.Length != nil {
+		t.Errorf("empty = %+v", c)
+	}
+}
+
+func TestProfileWithoutTopK(t *testing.T) {
+	rows := newRows([]string{"v"}, [][]interface{}{{"a"}, {"b"}, {"a"}, {1}})
+	for _, k := range []int{0, -1} {
+		report, err := Profile(rows, TopK(k), Bins(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := report.Columns[0]; len(c.TopValues) != 0 || len(c.Histogram) != 0 || c.Distinct != 3 {
+			t.Errorf("TopK(%d): %+v", k, c)
+		}
+		rows.ResetCurosr()
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	h := newHyperLogLog()
+	for i := 0; i < 100000; i++ {
+		h.add(fmt.Sprintf("value-%d", i%50000))
+	}
+	if est := float64(h.estimate()); math.Abs(est-50000)/50000 > 0.05 {
+		t.Errorf("estimated %v distinct values of 50000", est)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	top := newTopK(2)
+	//a frequent value among many unique ones exceeding the capacity
+	for i := 0; i < 1000; i++ {
+		top.add(fmt.Sprintf("unique-%d", i))
+		if i%10 == 0 {
+			top.add("frequent")
+		}
+	}
+	got := top.result()
+	if len(got) == 0 || got[0].Value != "frequent" || got[0].Count-got[0].Error > 100 || got[0].Count < 100 {
+		t.Errorf("top values = %v", got)
+	}
+	for _, vc := range got {
+		if strings.HasPrefix(vc.Value, "unique-") {
+			t.Errorf("the unique value %v is reported", vc)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+	for i := 0; i < 1000; i++ {
+		h.add(float64(i % 100))
+	}
+	if len(h.centroids) > maxCentroids {
+		t.Errorf("kept %d centroids", len(h.centroids))
+	}
+	buckets := h.buckets(4, 0, 99)
+	var total int64
+	for _, b := range buckets {
+		total += b.Count
+	}
+	if len(buckets) != 4 || total != 1000 || buckets[0].Low != 0 || buckets[3].High != 99 {
+		t.Errorf("buckets = %v", buckets)
+	}
+	if got := h.buckets(4, 5, 5); len(got) != 1 || got[0].Count != 1000 {
+		t.Errorf("buckets of a single value = %v", got)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	report, err := Profile(newRows([]string{"id", "name"}, [][]interface{}{{1, "a"}, {2, "b"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := report.WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"id", "name", "int", "string"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("the table does not mention %q:\n%s", want, buf.String())
+		}
+	}
+}
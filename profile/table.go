@@ -0,0 +1,94 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//max characters of a value printed in the table
+const maxCellWidth = 24
+
+//WriteTable prints the report as a table for the terminal, one column per line,
+//followed by the top values and the histogram of every column.
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "rows: %d\n\n", r.Rows)
+	fmt.Fprintln(tw, "COLUMN\tTYPE\tCONFIDENCE\tNULLS\tDISTINCT\tMIN\tMAX\tMEAN\tLENGTH")
+	for _, c := range r.Columns {
+		mean := ""
+		if c.Mean != nil {
+			mean = fmt.Sprintf("%.4g", *c.Mean)
+		}
+		length := ""
+		if c.Length != nil {
+			length = fmt.Sprintf("%d..%d (%.1f)", c.Length.Min, c.Length.Max, c.Length.Mean)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t%d\t~%d\t%s\t%s\t%s\t%s\n",
+			c.Name, c.Type, c.Confidence*100, c.Nulls, c.Distinct,
+			cell(c.Min), cell(c.Max), mean, length)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, c := range r.Columns {
+		if len(c.TopValues) == 0 && len(c.Histogram) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\n", c.Name)
+		for _, v := range c.TopValues {
+			if v.Error > 0 {
+				fmt.Fprintf(w, "  %-*s %d (at least %d)\n", maxCellWidth, cell(v.Value), v.Count, v.Count-v.Error)
+			} else {
+				fmt.Fprintf(w, "  %-*s %d\n", maxCellWidth, cell(v.Value), v.Count)
+			}
+		}
+		writeHistogram(w, c.Histogram)
+	}
+	return nil
+}
+
+func writeHistogram(w io.Writer, buckets []Bucket) {
+	if len(buckets) == 0 {
+		return
+	}
+	var max int64
+	for _, b := range buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+
+	fmt.Fprintln(w, "  histogram:")
+	for _, b := range buckets {
+		bar := 0
+		if max > 0 {
+			bar = int(b.Count * 40 / max)
+		}
+		fmt.Fprintf(w, "  [%10.4g, %10.4g] %-40s %d\n", b.Low, b.High, strings.Repeat("#", bar), b.Count)
+	}
+}
+
+func cell(val interface{}) string {
+	if val == nil {
+		return ""
+	}
+	var str string
+	switch v := val.(type) {
+	case time.Time:
+		str = v.Format("2006-01-02 15:04:05")
+	case float64:
+		str = fmt.Sprintf("%.6g", v)
+	default:
+		str = fmt.Sprint(v)
+	}
+	str = strings.Replace(str, "\n", " ", -1)
+	str = strings.Replace(str, "\t", " ", -1)
+	if r := []rune(str); len(r) > maxCellWidth {
+		str = string(r[:maxCellWidth-3]) + "..."
+	}
+	return str
+}
@@ -0,0 +1,85 @@
+package profile
+
+import "sort"
+
+//topK finds the most frequent values with the space saving algorithm. It keeps
+//a fixed number of counters, the count of a value may be over estimated by at
+//most the count of the counter it replaced, which is reported as the error.
+type topK struct {
+	k        int
+	counters map[string]*counter
+}
+
+type counter struct {
+	count int64
+	err   int64
+}
+
+//ValueCount is a value and how many times it appears.
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+	//Error is the max over estimation of Count
+	Error int64 `json:"error,omitempty"`
+}
+
+//newTopK returns the top k values, none if k <= 0.
+func newTopK(k int) *topK {
+	if k < 0 {
+		k = 0
+	}
+	return &topK{k: k, counters: make(map[string]*counter)}
+}
+
+//capacity is larger than k to keep the error small for skewed data
+func (t *topK) capacity() int {
+	return t.k * 10
+}
+
+func (t *topK) add(val string) {
+	//no counter to replace, nothing is reported
+	if t.k == 0 {
+		return
+	}
+	if c, ok := t.counters[val]; ok {
+		c.count++
+		return
+	}
+	if len(t.counters) < t.capacity() {
+		t.counters[val] = &counter{count: 1}
+		return
+	}
+
+	//replace the value with the smallest count
+	var minKey string
+	var minCounter *counter
+	for key, c := range t.counters {
+		if minCounter == nil || c.count < minCounter.count {
+			minKey, minCounter = key, c
+		}
+	}
+	delete(t.counters, minKey)
+	t.counters[val] = &counter{count: minCounter.count + 1, err: minCounter.count}
+}
+
+func (t *topK) result() []ValueCount {
+	rslt := make([]ValueCount, 0, len(t.counters))
+	for val, c := range t.counters {
+		//the value may be seen only once if it replaced another one, skip it
+		//to not report a column of unique values as frequent ones
+		if c.err > 0 && c.count-c.err < 2 {
+			continue
+		}
+		rslt = append(rslt, ValueCount{Value: val, Count: c.count, Error: c.err})
+	}
+	sort.Slice(rslt, func(i, j int) bool {
+		if rslt[i].Count != rslt[j].Count {
+			return rslt[i].Count > rslt[j].Count
+		}
+		return rslt[i].Value < rslt[j].Value
+	})
+	if len(rslt) > t.k {
+		rslt = rslt[:t.k]
+	}
+	return rslt
+}
@@ -0,0 +1,289 @@
+package etlx
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//Registry is a set of drivers by phase and name, safe for concurrent use.
+//The package level functions, e.g. ExtractRegister and FindExtract, use
+//DefaultRegistry, the one the drivers register themselves in init. A
+//transaction opens the drivers of DefaultRegistry unless UseRegistry is given,
+//so the tests and services could isolate their drivers.
+type Registry struct {
+	mu      sync.RWMutex
+	drivers EtlDriver
+}
+
+//DefaultRegistry is the registry of the package level functions.
+var DefaultRegistry = NewRegistry()
+
+//NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{drivers: EtlDriver{
+		Extract:   make(map[string]driver.ExtractDriver),
+		Transform: make(map[string]driver.TransformDriver),
+		Load:      make(map[string]driver.LoadDriver),
+		Info: map[string]map[string]driver.DriverInfo{
+			PhaseExtract:   {},
+			PhaseTransform: {},
+			PhaseLoad:      {},
+		},
+	}}
+}
+
+//ExtractRegister makes an extract driver available by the provided name, the
+//metadata of the driver could be given optionally, see DriverInfoOf.
+//If registered twice with the same name of if driver is nil, it panics.
+func (r *Registry) ExtractRegister(name string, drv driver.ExtractDriver, info ...driver.DriverInfo) {
+	if drv == nil {
+		panic("exlx: Register driver is nil")
+	}
+	if !r.register(PhaseExtract, name, drv, info, false) {
+		panic("etlx: duplicated register extract driver:" + name)
+	}
+}
+
+//TransformRegister makes a transform driver available by the provided name.
+func (r *Registry) TransformRegister(name string, drv driver.TransformDriver, info ...driver.DriverInfo) {
+	if drv == nil {
+		panic("exlx: Register driver is nil")
+	}
+	if !r.register(PhaseTransform, name, drv, info, false) {
+		panic("etlx: duplicated register transform driver:" + name)
+	}
+}
+
+//LoadRegister makes a load driver available by the provided name.
+func (r *Registry) LoadRegister(name string, drv driver.LoadDriver, info ...driver.DriverInfo) {
+	if drv == nil {
+		panic("load: Register driver is nil")
+	}
+	if !r.register(PhaseLoad, name, drv, info, false) {
+		panic("load: duplicated register Load driver:" + name)
+	}
+}
+
+//ReplaceExtract registers the extract driver, replacing the one of the name
+//and its metadata if any. It panics if the driver is nil.
+func (r *Registry) ReplaceExtract(name string, drv driver.ExtractDriver, info ...driver.DriverInfo) {
+	if drv == nil {
+		panic("exlx: Register driver is nil")
+	}
+	r.register(PhaseExtract, name, drv, info, true)
+}
+
+//ReplaceTransform registers the transform driver, replacing the one of the name.
+func (r *Registry) ReplaceTransform(name string, drv driver.TransformDriver, info ...driver.DriverInfo) {
+	if drv == nil {
+		panic("exlx: Register driver is nil")
+	}
+	r.register(PhaseTransform, name, drv, info, true)
+}
+
+//ReplaceLoad registers the load driver, replacing the one of the name.
+func (r *Registry) ReplaceLoad(name string, drv driver.LoadDriver, info ...driver.DriverInfo) {
+	if drv == nil {
+		panic("load: Register driver is nil")
+	}
+	r.register(PhaseLoad, name, drv, info, true)
+}
+
+//register adds the driver of the phase, it returns false if the name is
+//registered and replace is false. The first info is kept.
+func (r *Registry) register(phase, name string, drv interface{}, info []driver.DriverInfo, replace bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !replace && r.find(phase, name) != nil {
+		return false
+	}
+	switch phase {
+	case PhaseExtract:
+		r.drivers.Extract[name] = drv.(driver.ExtractDriver)
+	case PhaseTransform:
+		r.drivers.Transform[name] = drv.(driver.TransformDriver)
+	case PhaseLoad:
+		r.drivers.Load[name] = drv.(driver.LoadDriver)
+	}
+	delete(r.drivers.Info[phase], name)
+	if len(info) > 0 {
+		r.drivers.Info[phase][name] = info[0]
+	}
+	return true
+}
+
+//Unregister removes the driver of the phase and its metadata, it returns false
+//if the driver is not found. The transactions opened keep the driver.
+func (r *Registry) Unregister(phase, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.find(phase, name) == nil {
+		return false
+	}
+	switch phase {
+	case PhaseExtract:
+		delete(r.drivers.Extract, name)
+	case PhaseTransform:
+		delete(r.drivers.Transform, name)
+	case PhaseLoad:
+		delete(r.drivers.Load, name)
+	}
+	delete(r.drivers.Info[phase], name)
+	return true
+}
+
+//FindExtract is to find an extractor driver specify by its name, return nil if not found
+func (r *Registry) FindExtract(name string) driver.ExtractDriver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.drivers.Extract[name]
+}
+
+//FindTransform is to find a transformer driver specify by its name, return nil if not found
+func (r *Registry) FindTransform(name string) driver.TransformDriver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.drivers.Transform[name]
+}
+
+//FindLoad is to find a loader driver specify by its name, return nil if not found
+func (r *Registry) FindLoad(name string) driver.LoadDriver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.drivers.Load[name]
+}
+
+//find returns the driver of the phase or nil, the lock is held.
+func (r *Registry) find(phase, name string) interface{} {
+	var drv interface{}
+	switch phase {
+	case PhaseExtract:
+		if d, ok := r.drivers.Extract[name]; ok {
+			drv = d
+		}
+	case PhaseTransform:
+		if d, ok := r.drivers.Transform[name]; ok {
+			drv = d
+		}
+	case PhaseLoad:
+		if d, ok := r.drivers.Load[name]; ok {
+			drv = d
+		}
+	}
+	return drv
+}
+
+func (r *Registry) findDriver(phase, name string) interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.find(phase, name)
+}
+
+//DriverNames returns the sorted names of the drivers registered for the phase.
+func (r *Registry) DriverNames(phase string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{}
+	switch phase {
+	case PhaseExtract:
+		for name := range r.drivers.Extract {
+			names = append(names, name)
+		}
+	case PhaseTransform:
+		for name := range r.drivers.Transform {
+			names = append(names, name)
+		}
+	case PhaseLoad:
+		for name := range r.drivers.Load {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+//DriverInfoOf returns the metadata of the driver given at registration, or by
+//the driver implementing driver.InfoDriver. It is false if the driver is not
+//found, and the info is empty if the driver declares nothing.
+func (r *Registry) DriverInfoOf(phase, name string) (driver.DriverInfo, bool) {
+	r.mu.RLock()
+	drv := r.find(phase, name)
+	info := r.drivers.Info[phase][name]
+	r.mu.RUnlock()
+
+	if drv == nil {
+		return driver.DriverInfo{}, false
+	}
+	if id, ok := drv.(driver.InfoDriver); ok && info.IsEmpty() {
+		info = id.DriverInfo()
+	}
+	return info, true
+}
+
+//DriverEntry is a registered driver listed by ListDrivers.
+type DriverEntry struct {
+	Phase string            `json:"phase"`
+	Name  string            `json:"name"`
+	Info  driver.DriverInfo `json:"info"`
+}
+
+//ListDrivers returns the drivers of the phase, or all the phases if it is
+//empty, which declare all the capabilities. They are sorted by phase and name.
+func (r *Registry) ListDrivers(phase string, caps ...driver.Capability) []DriverEntry {
+	phases := []string{PhaseExtract, PhaseTransform, PhaseLoad}
+	if phase != "" {
+		phases = []string{phase}
+	}
+
+	entries := []DriverEntry{}
+	for _, p := range phases {
+	names:
+		for _, name := range r.DriverNames(p) {
+			info, ok := r.DriverInfoOf(p, name)
+			if !ok {
+				//unregistered meanwhile
+				continue
+			}
+			for _, c := range caps {
+				if !info.Has(c) {
+					continue names
+				}
+			}
+			entries = append(entries, DriverEntry{Phase: p, Name: name, Info: info})
+		}
+	}
+	return entries
+}
+
+//checkCapability returns an error if the driver declares its capabilities but
+//not c. The drivers declaring nothing are supposed to support everything as
+//before the capabilities were introduced.
+func (r *Registry) checkCapability(phase, name string, c driver.Capability, feature string) error {
+	info, ok := r.DriverInfoOf(phase, name)
+	if !ok || len(info.Capabilities) == 0 || info.Has(c) {
+		return nil
+	}
+	return errors.Errorf("etlx: %s driver %s does not support %s", phase, name, feature)
+}
+
+//CommandSchemaOf returns the command schema of the driver, false if the driver
+//is not found or does not implement driver.CommandSchemaDriver.
+func (r *Registry) CommandSchemaOf(phase, name string) (driver.CommandSchema, bool) {
+	sd, ok := r.findDriver(phase, name).(driver.CommandSchemaDriver)
+	if !ok {
+		return driver.CommandSchema{}, false
+	}
+	return sd.CommandSchema(), true
+}
+
+//ValidateCommands validates the commands against the schema of the driver, it
+//returns nil if the driver does not publish its schema.
+func (r *Registry) ValidateCommands(phase, name string, args []driver.Command) error {
+	return validateCommands(r.findDriver(phase, name), phase, name, args)
+}
@@ -0,0 +1,164 @@
+package etlx_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/etlxtest"
+)
+
+//infoExtract describes itself by driver.InfoDriver.
+type infoExtract struct {
+	etlxtest.MockExtract
+}
+
+func (d *infoExtract) DriverInfo() driver.DriverInfo {
+	return driver.DriverInfo{Description: "self", Capabilities: []driver.Capability{driver.CapBatch}}
+}
+
+func infoRegistry() *etlx.Registry {
+	r := etlx.NewRegistry()
+	r.ExtractRegister("nobatch", &etlxtest.MockExtract{},
+		driver.DriverInfo{Description: "no batch", Capabilities: []driver.Capability{driver.CapSchema}})
+	r.ExtractRegister("unknown", &etlxtest.MockExtract{})
+	r.ExtractRegister("self", &infoExtract{})
+	r.ExtractRegister("given", &infoExtract{}, driver.DriverInfo{Description: "given"})
+	r.TransformRegister("filter", etlx.FindTransform("filter"))
+	r.LoadRegister("load", &etlxtest.MockLoad{})
+	return r
+}
+
+func TestDriverInfoOf(t *testing.T) {
+	r := infoRegistry()
+	for _, c := range []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"nobatch", "no batch", true},
+		{"unknown", "", true},
+		{"self", "self", true},
+		//the info given at registration takes precedence
+		{"given", "given", true},
+		{"missing", "", false},
+	} {
+		info, ok := r.DriverInfoOf(etlx.PhaseExtract, c.name)
+		if ok != c.ok || info.Description != c.want {
+			t.Errorf("DriverInfoOf(%s) = %q, %v, want %q, %v", c.name, info.Description, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestListDrivers(t *testing.T) {
+	r := infoRegistry()
+	got := []string{}
+	for _, entry := range r.ListDrivers(etlx.PhaseExtract, driver.CapBatch) {
+		got = append(got, entry.Name)
+	}
+	if want := []string{"self"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListDrivers(batch) = %v, want %v", got, want)
+	}
+
+	entries := r.ListDrivers("")
+	if len(entries) != 6 || entries[0].Phase != etlx.PhaseExtract || entries[len(entries)-1].Phase != etlx.PhaseLoad {
+		t.Errorf("ListDrivers of all the phases = %v", entries)
+	}
+}
+
+func TestBatchCapability(t *testing.T) {
+	r := infoRegistry()
+	for _, c := range []struct {
+		extract string
+		ok      bool
+	}{
+		{"nobatch", false},
+		//the drivers declaring nothing support everything
+		{"unknown", true},
+		{"self", true},
+	} {
+		_, err := etlx.Open(c.extract, "filter", "load", etlx.UseRegistry(r), etlx.BatchEnable("enable", 10))
+		if (err == nil) != c.ok {
+			t.Errorf("Open(%s) in batch mode: %v", c.extract, err)
+		}
+	}
+	if _, err := etlx.Open("nobatch", "filter", "load", etlx.UseRegistry(r)); err != nil {
+		t.Errorf("Open(nobatch) without batch mode: %v", err)
+	}
+}
+
+func TestRegistryIsolation(t *testing.T) {
+	r := etlx.NewRegistry()
+	r.ExtractRegister("isolated", &etlxtest.MockExtract{})
+	r.TransformRegister("filter", etlx.FindTransform("filter"))
+	r.LoadRegister("isolated", &etlxtest.MockLoad{})
+
+	if _, err := etlx.Open("isolated", "filter", "isolated"); err == nil {
+		t.Error("the drivers of the registry are in DefaultRegistry")
+	}
+	if _, err := etlx.Open("isolated", "filter", "isolated", etlx.UseRegistry(r)); err != nil {
+		t.Error(err)
+	}
+	if etlx.FindExtract("isolated") != nil {
+		t.Error("FindExtract found the driver of the registry")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := etlx.NewRegistry()
+	r.ExtractRegister("e", &etlxtest.MockExtract{})
+	defer func() {
+		if recover() == nil {
+			t.Error("registered twice without panic")
+		}
+	}()
+	r.ExtractRegister("e", &etlxtest.MockExtract{})
+}
+
+func TestReplaceUnregister(t *testing.T) {
+	r := etlx.NewRegistry()
+	r.ExtractRegister("e", &etlxtest.MockExtract{})
+	r.TransformRegister("filter", etlx.FindTransform("filter"))
+	r.LoadRegister("l", &etlxtest.MockLoad{})
+
+	//the info is replaced with the driver
+	r.ReplaceExtract("e", &etlxtest.MockExtract{}, driver.DriverInfo{Capabilities: []driver.Capability{driver.CapSchema}})
+	if _, err := etlx.Open("e", "filter", "l", etlx.UseRegistry(r), etlx.BatchEnable("enable", 5)); err == nil {
+		t.Error("the replaced driver without batch opened in batch mode")
+	}
+
+	if !r.Unregister(etlx.PhaseExtract, "e") {
+		t.Error("Unregister(e) = false")
+	}
+	if r.Unregister(etlx.PhaseExtract, "e") {
+		t.Error("Unregister(e) twice = true")
+	}
+	if names := r.DriverNames(etlx.PhaseExtract); len(names) != 0 {
+		t.Errorf("DriverNames = %v after Unregister", names)
+	}
+	if _, ok := r.DriverInfoOf(etlx.PhaseExtract, "e"); ok {
+		t.Error("the info is kept after Unregister")
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := etlx.NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.ReplaceLoad("l", &etlxtest.MockLoad{})
+				if r.FindLoad("l") == nil {
+					t.Error("FindLoad(l) = nil")
+					return
+				}
+				r.ListDrivers("")
+			}
+		}()
+	}
+	wg.Wait()
+}
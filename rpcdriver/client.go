@@ -0,0 +1,625 @@
+package rpcdriver
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//DefaultBatchSize is the number of rows sent across the process boundary at once.
+const DefaultBatchSize = 1024
+
+//Plugin is a running plugin executable whose drivers are registered by Load.
+type Plugin struct {
+	Path string
+	//Drivers are the drivers registered by the plugin.
+	Drivers []DriverDesc
+	//Skipped are the drivers of the plugin registered in the registry already,
+	//e.g. the built in drivers linked into the plugin as well.
+	Skipped []DriverDesc
+
+	batchSize int
+	socket    bool
+	stderr    io.Writer
+
+	cmd      *exec.Cmd
+	client   *rpc.Client
+	registry *etlx.Registry
+	tmpDir   string
+	once     sync.Once
+}
+
+//BatchSize sets the number of rows sent at once, DefaultBatchSize if not set.
+func BatchSize(size int) func(*Plugin) {
+	return func(p *Plugin) {
+		p.batchSize = size
+	}
+}
+
+//UnixSocket talks to the plugin over a unix socket instead of its stdin and
+//stdout, e.g. for the plugins whose libraries write to stdout.
+func UnixSocket() func(*Plugin) {
+	return func(p *Plugin) {
+		p.socket = true
+	}
+}
+
+//Stderr sets the writer of the stderr of the plugin, os.Stderr by default.
+func Stderr(w io.Writer) func(*Plugin) {
+	return func(p *Plugin) {
+		p.stderr = w
+	}
+}
+
+//Load starts the plugin executable and registers its drivers in the registry,
+//etlx.DefaultRegistry if nil. The drivers registered already are kept, the
+//ones of the plugin are skipped. Close stops the plugin and unregisters the
+//drivers.
+func Load(path string, r *etlx.Registry, options ...func(*Plugin)) (*Plugin, error) {
+	if r == nil {
+		r = etlx.DefaultRegistry
+	}
+	p := &Plugin{Path: path, batchSize: DefaultBatchSize, stderr: os.Stderr, registry: r}
+	for _, opt := range options {
+		opt(p)
+	}
+
+	if err := p.start(); err != nil {
+		return nil, fmt.Errorf("rpcdriver: plugin %s: %v", path, err)
+	}
+
+	var desc DescribeReply
+	if err := p.client.Call(serviceName+".Describe", struct{}{}, &desc); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("rpcdriver: plugin %s: %v", path, err)
+	}
+	if desc.Protocol != ProtocolVersion {
+		p.stop()
+		return nil, fmt.Errorf("rpcdriver: plugin %s speaks protocol %d, expected %d", path, desc.Protocol, ProtocolVersion)
+	}
+
+	for _, d := range desc.Drivers {
+		if _, ok := r.DriverInfoOf(d.Phase, d.Name); ok {
+			p.Skipped = append(p.Skipped, d)
+			continue
+		}
+		p.register(d)
+		p.Drivers = append(p.Drivers, d)
+	}
+	return p, nil
+}
+
+//LoadDir loads the executables in the directory as plugins, the hidden files
+//and the files not executable are skipped.
+func LoadDir(dir string, r *etlx.Registry, options ...func(*Plugin)) ([]*Plugin, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plugins := []*Plugin{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || f.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		p, err := Load(filepath.Join(dir, f.Name()), r, options...)
+		if err != nil {
+			for _, loaded := range plugins {
+				loaded.Close()
+			}
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+func (p *Plugin) start() error {
+	p.cmd = exec.Command(p.Path)
+	p.cmd.Stderr = p.stderr
+
+	if !p.socket {
+		stdin, err := p.cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err := p.cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := p.cmd.Start(); err != nil {
+			return err
+		}
+		p.client = jsonrpc.NewClient(pipe{Reader: stdout, WriteCloser: stdin})
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "etlx-plugin")
+	if err != nil {
+		return err
+	}
+	p.tmpDir = dir
+	path := filepath.Join(dir, "plugin.sock")
+	p.cmd.Env = append(os.Environ(), SocketEnv+"="+path)
+	p.cmd.Stdout = p.stderr
+	if err := p.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	//wait for the plugin to listen
+	var conn net.Conn
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			p.stop()
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	p.client = jsonrpc.NewClient(conn)
+	return nil
+}
+
+//pipe is the connection on the stdout and stdin of the plugin.
+type pipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+//stop disconnects the plugin, it exits once its stdin or socket is closed.
+func (p *Plugin) stop() {
+	if p.client != nil {
+		p.client.Close()
+	}
+	if p.cmd.Process != nil {
+		done := make(chan struct{})
+		go func() {
+			p.cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			p.cmd.Process.Kill()
+			<-done
+		}
+	}
+	if p.tmpDir != "" {
+		os.RemoveAll(p.tmpDir)
+	}
+}
+
+//Close unregisters the drivers of the plugin and stops it.
+func (p *Plugin) Close() error {
+	p.once.Do(func() {
+		for _, d := range p.Drivers {
+			p.registry.Unregister(d.Phase, d.Name)
+		}
+		p.stop()
+	})
+	return nil
+}
+
+func (p *Plugin) call(method string, args interface{}, reply interface{}) error {
+	err := p.client.Call(serviceName+"."+method, args, reply)
+	//the errors are strings over rpc, EOT ends the batches of Transaction and
+	//ErrSchemaUnknown skips the schema check
+	if serr, ok := err.(rpc.ServerError); ok {
+		switch string(serr) {
+		case driver.EOT.Error():
+			return driver.EOT
+		case driver.ErrSchemaUnknown.Error():
+			return driver.ErrSchemaUnknown
+		}
+	}
+	return err
+}
+
+//register registers the proxy of the driver, it publishes the command schema
+//only if the driver of the plugin does.
+func (p *Plugin) register(d DriverDesc) {
+	base := remoteDriver{plugin: p, phase: d.Phase, name: d.Name}
+	switch d.Phase {
+	case etlx.PhaseExtract:
+		var drv driver.ExtractDriver = &extractDriver{base}
+		if d.Schema != nil {
+			drv = struct {
+				driver.ExtractDriver
+				commandSchema
+			}{drv, commandSchema(*d.Schema)}
+		}
+		p.registry.ExtractRegister(d.Name, drv, d.Info)
+	case etlx.PhaseTransform:
+		var drv driver.TransformDriver = &transformDriver{base}
+		if d.Schema != nil {
+			drv = struct {
+				driver.TransformDriver
+				commandSchema
+			}{drv, commandSchema(*d.Schema)}
+		}
+		p.registry.TransformRegister(d.Name, drv, d.Info)
+	case etlx.PhaseLoad:
+		var drv driver.LoadDriver = &loadDriver{base}
+		if d.Schema != nil {
+			drv = struct {
+				driver.LoadDriver
+				commandSchema
+			}{drv, commandSchema(*d.Schema)}
+		}
+		p.registry.LoadRegister(d.Name, drv, d.Info)
+	}
+}
+
+type commandSchema driver.CommandSchema
+
+func (s commandSchema) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema(s)
+}
+
+type remoteDriver struct {
+	plugin *Plugin
+	phase  string
+	name   string
+}
+
+func (d remoteDriver) open(name, dataSource string) (handler, OpenReply, error) {
+	var reply OpenReply
+	args := OpenArgs{Phase: d.phase, Driver: d.name, Name: name, DataSource: dataSource}
+	if err := d.plugin.call("Open", args, &reply); err != nil {
+		return handler{}, reply, err
+	}
+	return handler{plugin: d.plugin, id: reply.Handler}, reply, nil
+}
+
+type extractDriver struct{ remoteDriver }
+
+func (d *extractDriver) Open(name, dataSource string) (driver.Extract, error) {
+	h, _, err := d.open(name, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &extractHandler{h}, nil
+}
+
+type transformDriver struct{ remoteDriver }
+
+func (d *transformDriver) Open(name, dataSource string) (driver.Transform, error) {
+	h, reply, err := d.open(name, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Ordered {
+		return &orderedAccumulator{accumulator{&transformHandler{h}}}, nil
+	} else if reply.Accumulator {
+		return &accumulator{&transformHandler{h}}, nil
+	}
+	return &transformHandler{h}, nil
+}
+
+type loadDriver struct{ remoteDriver }
+
+func (d *loadDriver) Open(name, dataSource string) (driver.Load, error) {
+	h, _, err := d.open(name, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &loadHandler{h}, nil
+}
+
+//cmdRef refers to the command kept by the plugin.
+type cmdRef int64
+
+func cmdID(cmd interface{}) (int64, error) {
+	ref, ok := cmd.(cmdRef)
+	if !ok {
+		return 0, fmt.Errorf("rpcdriver: the command %v is not built by the plugin", cmd)
+	}
+	return int64(ref), nil
+}
+
+type handler struct {
+	plugin *Plugin
+	id     int64
+}
+
+func (h handler) Command(args []driver.Command) (interface{}, error) {
+	var id int64
+	if err := h.plugin.call("Command", CommandArgs{Handler: h.id, Args: args}, &id); err != nil {
+		return nil, err
+	}
+	return cmdRef(id), nil
+}
+
+func (h handler) Close() error {
+	return h.plugin.call("Close", HandlerArgs{Handler: h.id}, &struct{}{})
+}
+
+//rows returns the rows of the reply, nil if the plugin returned none.
+func (h handler) rows(reply RowsReply, input *inputPusher) driver.Results {
+	if reply.Rows == 0 {
+		return nil
+	}
+	return &remoteRows{plugin: h.plugin, id: reply.Rows, columns: reply.Columns, schema: reply.Schema, input: input}
+}
+
+//exec pushes the rows to the plugin while the method reads them.
+func (h handler) exec(method string, src driver.Rows, cmd interface{}, offset int64, reply interface{}) (*inputPusher, error) {
+	id, err := cmdID(cmd)
+	if err != nil {
+		return nil, err
+	}
+	input, err := h.plugin.pushInput(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.plugin.call(method, ExecArgs{Handler: h.id, Cmd: id, Input: input.id, Offset: offset}, reply); err != nil {
+		input.wait()
+		return nil, err
+	}
+	return input, nil
+}
+
+type extractHandler struct{ handler }
+
+//SetBatch could not return an error, the error of the plugin is returned by
+//the next call of the handler.
+func (h *extractHandler) SetBatch(limit int64, offset int64) {
+	h.plugin.call("SetBatch", BatchArgs{Handler: h.id, Limit: limit, Offset: offset}, &struct{}{})
+}
+
+func (h *extractHandler) Query(cmd interface{}) (driver.Rows, error) {
+	id, err := cmdID(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var reply RowsReply
+	if err := h.plugin.call("Query", QueryArgs{Handler: h.id, Cmd: id}, &reply); err != nil {
+		return nil, err
+	}
+	rows := h.rows(reply, nil)
+	if rows == nil {
+		return nil, nil
+	}
+	return rows, nil
+}
+
+type transformHandler struct{ handler }
+
+func (h *transformHandler) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	var reply RowsReply
+	input, err := h.exec("Exec", src, cmd, 0, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Rows == 0 {
+		input.wait()
+	}
+	return h.rows(reply, input), nil
+}
+
+//OutputSchema is empty if the transform of the plugin does not declare it.
+func (h *transformHandler) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	id, err := cmdID(cmd)
+	if err != nil {
+		return driver.Schema{}, err
+	}
+	var schema driver.Schema
+	err = h.plugin.call("OutputSchema", SchemaArgs{Handler: h.id, Cmd: id, Schema: in}, &schema)
+	return schema, err
+}
+
+//accumulator is the transform of the plugin implementing driver.Accumulator.
+type accumulator struct{ *transformHandler }
+
+func (a *accumulator) Accumulate(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	return a.accumulate("Accumulate", src, cmd, 0)
+}
+
+func (a *accumulator) accumulate(method string, src driver.Rows, cmd interface{}, offset int64) (driver.Results, error) {
+	var reply RowsReply
+	input, err := a.exec(method, src, cmd, offset, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Rows == 0 {
+		input.wait()
+	}
+	return a.rows(reply, input), nil
+}
+
+//orderedAccumulator is the transform of the plugin implementing
+//driver.OrderedAccumulator.
+type orderedAccumulator struct{ accumulator }
+
+func (a *orderedAccumulator) AccumulateAt(src driver.Rows, cmd interface{}, offset int64) (driver.Results, error) {
+	return a.accumulate("AccumulateAt", src, cmd, offset)
+}
+
+func (a *accumulator) Flush(cmd interface{}) (driver.Results, error) {
+	id, err := cmdID(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var reply RowsReply
+	if err := a.plugin.call("Flush", QueryArgs{Handler: a.id, Cmd: id}, &reply); err != nil {
+		return nil, err
+	}
+	return a.rows(reply, nil), nil
+}
+
+type loadHandler struct{ handler }
+
+func (h *loadHandler) Load(src driver.Results, cmd interface{}) error {
+	input, err := h.exec("Load", src, cmd, 0, &struct{}{})
+	if err != nil {
+		return err
+	}
+	return input.wait()
+}
+
+func (h *loadHandler) QueryFromNextStep() (driver.Rows, error) {
+	var reply RowsReply
+	if err := h.plugin.call("QueryFromNextStep", HandlerArgs{Handler: h.id}, &reply); err != nil {
+		return nil, err
+	}
+	rows := h.rows(reply, nil)
+	if rows == nil {
+		return nil, nil
+	}
+	return rows, nil
+}
+
+//InputSchema is empty if the load of the plugin does not declare it.
+func (h *loadHandler) InputSchema(cmd interface{}) (driver.Schema, error) {
+	id, err := cmdID(cmd)
+	if err != nil {
+		return driver.Schema{}, err
+	}
+	var schema driver.Schema
+	err = h.plugin.call("InputSchema", SchemaArgs{Handler: h.id, Cmd: id}, &schema)
+	return schema, err
+}
+
+//inputPusher sends the rows read from the source to the input of the plugin
+//in batches, in the background.
+type inputPusher struct {
+	id   int64
+	done chan error
+	err  error
+	once sync.Once
+}
+
+func (p *Plugin) pushInput(src driver.Rows) (*inputPusher, error) {
+	args := InputArgs{Columns: src.Columns()}
+	if sr, ok := src.(driver.SchemaRows); ok {
+		args.Schema = sr.Schema()
+	}
+	input := &inputPusher{done: make(chan error, 1)}
+	if err := p.call("OpenInput", args, &input.id); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		input.done <- p.push(input.id, src)
+	}()
+	return input, nil
+}
+
+func (p *Plugin) push(id int64, src driver.Rows) error {
+	columns := len(src.Columns())
+	data := make([][]interface{}, 0, p.batchSize)
+	for {
+		row := make([]interface{}, columns)
+		err := src.Next(row)
+		if err != nil {
+			args := PushArgs{Input: id, Data: encodeRows(data), EOF: err == driver.EOT}
+			if err != driver.EOT {
+				args.Err = err.Error()
+			}
+			if perr := p.call("Push", args, &struct{}{}); perr != nil && err == driver.EOT {
+				err = perr
+			}
+			if err == driver.EOT {
+				return nil
+			}
+			return err
+		}
+
+		data = append(data, row)
+		if len(data) >= p.batchSize {
+			if err := p.call("Push", PushArgs{Input: id, Data: encodeRows(data)}, &struct{}{}); err != nil {
+				return err
+			}
+			data = data[:0]
+		}
+	}
+}
+
+//wait returns the error of pushing the rows once all are pushed. The error
+//of an input closed by the plugin is ignored, as the handler stopped reading
+//the rows and reports its own error.
+func (i *inputPusher) wait() error {
+	if i == nil {
+		return nil
+	}
+	i.once.Do(func() {
+		i.err = <-i.done
+		if i.err != nil && strings.Contains(i.err.Error(), "is closed") {
+			i.err = nil
+		}
+	})
+	return i.err
+}
+
+//remoteRows reads the rows kept by the plugin in batches.
+type remoteRows struct {
+	plugin  *Plugin
+	id      int64
+	columns []string
+	schema  driver.Schema
+	input   *inputPusher
+
+	batch  [][]interface{}
+	pos    int
+	eof    bool
+	closed bool
+}
+
+func (r *remoteRows) Columns() []string {
+	return r.columns
+}
+
+func (r *remoteRows) Schema() driver.Schema {
+	return r.schema
+}
+
+func (r *remoteRows) Next(dst interface{}) error {
+	for r.pos >= len(r.batch) {
+		if r.eof || r.closed {
+			return driver.EOT
+		}
+		var reply NextReply
+		if err := r.plugin.call("Next", NextArgs{Rows: r.id, Max: r.plugin.batchSize}, &reply); err != nil {
+			return err
+		}
+		r.batch, r.pos, r.eof = decodeRows(reply.Data), 0, reply.EOF
+		if r.eof {
+			if err := r.input.wait(); err != nil {
+				return err
+			}
+		}
+	}
+	row := r.batch[r.pos]
+	r.pos++
+	return driver.CopyRow(r.columns, row, dst)
+}
+
+func (r *remoteRows) NextRsltAndIndex(rslt interface{}, index *map[string]interface{}) error {
+	return r.Next(rslt)
+}
+
+//Close releases the rows and the input of the plugin.
+func (r *remoteRows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.plugin.call("CloseRows", CloseArgs{ID: r.id}, &struct{}{})
+	r.input.wait()
+	return err
+}
@@ -0,0 +1,260 @@
+//Package rpcdriver runs extract, transform and load drivers as separate
+//executables, so the drivers needing cgo libraries or written by other teams
+//are not linked into the main binary.
+//
+//A plugin is a program registering its drivers as usual and calling Serve in
+//main, it talks JSON-RPC over its stdin and stdout, or a unix socket:
+//	func main() {
+//		etlx.ExtractRegister("oracle", &oracleDriver{})
+//		if err := rpcdriver.Serve(nil); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+//The host loads the plugins of a directory by LoadDir, which registers a proxy
+//of every driver of the plugins in the registry, so they are opened by
+//Transaction as the drivers linked in. The rows are streamed across the
+//process boundary in batches.
+package rpcdriver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//ProtocolVersion is the version of the messages, a plugin of another version
+//is rejected by Load.
+const ProtocolVersion = 1
+
+//SocketEnv is the environment variable of the unix socket path the plugin
+//listens on, the plugin serves on stdin and stdout if it is empty.
+const SocketEnv = "ETLX_PLUGIN_SOCKET"
+
+//serviceName is the name of the rpc service of the plugins.
+const serviceName = "Plugin"
+
+//DriverDesc describes a driver served by a plugin.
+type DriverDesc struct {
+	Phase  string                `json:"phase"`
+	Name   string                `json:"name"`
+	Info   driver.DriverInfo     `json:"info"`
+	Schema *driver.CommandSchema `json:"schema,omitempty"`
+}
+
+type DescribeReply struct {
+	Protocol int          `json:"protocol"`
+	Drivers  []DriverDesc `json:"drivers"`
+}
+
+type OpenArgs struct {
+	Phase      string `json:"phase"`
+	Driver     string `json:"driver"`
+	Name       string `json:"name"`
+	DataSource string `json:"data_source"`
+}
+
+type OpenReply struct {
+	Handler int64 `json:"handler"`
+	//Accumulator is true if the transform handler implements driver.Accumulator.
+	Accumulator bool `json:"accumulator"`
+	//Ordered is true if it implements driver.OrderedAccumulator.
+	Ordered bool `json:"ordered,omitempty"`
+}
+
+type HandlerArgs struct {
+	Handler int64 `json:"handler"`
+}
+
+type CommandArgs struct {
+	Handler int64            `json:"handler"`
+	Args    []driver.Command `json:"args"`
+}
+
+type BatchArgs struct {
+	Handler int64 `json:"handler"`
+	Limit   int64 `json:"limit"`
+	Offset  int64 `json:"offset"`
+}
+
+type QueryArgs struct {
+	Handler int64 `json:"handler"`
+	Cmd     int64 `json:"cmd"`
+}
+
+//RowsReply refers to the rows kept by the plugin, Rows is 0 if there is none.
+type RowsReply struct {
+	Rows    int64         `json:"rows"`
+	Columns []string      `json:"columns"`
+	Schema  driver.Schema `json:"schema"`
+}
+
+type NextArgs struct {
+	Rows int64 `json:"rows"`
+	Max  int   `json:"max"`
+}
+
+type NextReply struct {
+	Data [][]Value `json:"data"`
+	EOF  bool      `json:"eof"`
+}
+
+type InputArgs struct {
+	Columns []string      `json:"columns"`
+	Schema  driver.Schema `json:"schema"`
+}
+
+//PushArgs sends a batch of the input rows, the last one has EOF or Err set.
+type PushArgs struct {
+	Input int64     `json:"input"`
+	Data  [][]Value `json:"data"`
+	EOF   bool      `json:"eof"`
+	Err   string    `json:"err,omitempty"`
+}
+
+type ExecArgs struct {
+	Handler int64 `json:"handler"`
+	Cmd     int64 `json:"cmd"`
+	Input   int64 `json:"input"`
+	//Offset of the batch passed to driver.OrderedAccumulator by AccumulateAt.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+type SchemaArgs struct {
+	Handler int64         `json:"handler"`
+	Cmd     int64         `json:"cmd"`
+	Schema  driver.Schema `json:"schema"`
+}
+
+type CloseArgs struct {
+	ID int64 `json:"id"`
+}
+
+//Value is a value of a row on the wire. It is encoded as [type, value] to keep
+//the Go type which json loses, the values of maps and slices are plain json.
+type Value struct {
+	V interface{}
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	var typ string
+	var val interface{}
+	switch x := v.V.(type) {
+	case nil:
+		return []byte("null"), nil
+	case bool:
+		typ, val = "b", x
+	case string:
+		typ, val = "s", x
+	case int, int8, int16, int32, int64:
+		typ, val = "i", strconv.FormatInt(reflect.ValueOf(x).Int(), 10)
+	case uint, uint8, uint16, uint32, uint64:
+		typ, val = "u", strconv.FormatUint(reflect.ValueOf(x).Uint(), 10)
+	case float32:
+		typ, val = "f", strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		typ, val = "f", strconv.FormatFloat(x, 'g', -1, 64)
+	case time.Time:
+		typ, val = "t", x.Format(time.RFC3339Nano)
+	case driver.Decimal:
+		typ, val = "d", x.String()
+	case *driver.Decimal:
+		if x == nil {
+			return []byte("null"), nil
+		}
+		typ, val = "d", x.String()
+	case []byte:
+		typ, val = "y", base64.StdEncoding.EncodeToString(x)
+	case driver.Geometry:
+		typ, val = "g", x
+	default:
+		typ, val = "j", x
+	}
+	return json.Marshal([]interface{}{typ, val})
+}
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		v.V = nil
+		return nil
+	}
+	var pair []json.RawMessage
+	if err := json.Unmarshal(b, &pair); err != nil || len(pair) != 2 {
+		return fmt.Errorf("rpcdriver: invalid value %s", b)
+	}
+	var typ string
+	if err := json.Unmarshal(pair[0], &typ); err != nil {
+		return err
+	}
+
+	var str string
+	switch typ {
+	case "b":
+		var x bool
+		err := json.Unmarshal(pair[1], &x)
+		v.V = x
+		return err
+	case "g":
+		var x driver.Geometry
+		err := json.Unmarshal(pair[1], &x)
+		v.V = x
+		return err
+	case "j":
+		var x interface{}
+		err := json.Unmarshal(pair[1], &x)
+		v.V = x
+		return err
+	}
+	if err := json.Unmarshal(pair[1], &str); err != nil {
+		return err
+	}
+
+	var err error
+	switch typ {
+	case "s":
+		v.V = str
+	case "i":
+		v.V, err = strconv.ParseInt(str, 10, 64)
+	case "u":
+		v.V, err = strconv.ParseUint(str, 10, 64)
+	case "f":
+		//NaN and Inf are kept as FormatFloat writes them
+		v.V, err = strconv.ParseFloat(str, 64)
+	case "t":
+		v.V, err = time.Parse(time.RFC3339Nano, str)
+	case "d":
+		v.V, err = driver.ParseDecimal(str)
+	case "y":
+		v.V, err = base64.StdEncoding.DecodeString(str)
+	default:
+		err = fmt.Errorf("rpcdriver: unknown value type %s", typ)
+	}
+	return err
+}
+
+func encodeRows(data [][]interface{}) [][]Value {
+	rows := make([][]Value, len(data))
+	for i, row := range data {
+		rows[i] = make([]Value, len(row))
+		for j, val := range row {
+			rows[i][j] = Value{V: val}
+		}
+	}
+	return rows
+}
+
+func decodeRows(rows [][]Value) [][]interface{} {
+	data := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		data[i] = make([]interface{}, len(row))
+		for j, val := range row {
+			data[i][j] = val.V
+		}
+	}
+	return data
+}
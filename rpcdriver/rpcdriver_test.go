@@ -0,0 +1,304 @@
+package rpcdriver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/etlxtest"
+	"github.com/xingwangc/etlx/rpcdriver"
+	_ "github.com/xingwangc/etlx/transform"
+)
+
+//pluginEnv makes the test binary serve the drivers below as a plugin, the
+//plugins loaded by the tests are the test binary itself.
+const pluginEnv = "ETLX_TEST_PLUGIN"
+
+var columns = []string{"id", "grp", "amount", "at", "dec"}
+
+func data() [][]interface{} {
+	rows := [][]interface{}{}
+	for i := 0; i < 9; i++ {
+		dec, err := driver.ParseDecimal(fmt.Sprintf("%d.10", i))
+		if err != nil {
+			panic(err)
+		}
+		rows = append(rows, []interface{}{int64(i), fmt.Sprintf("g%d", i%3), float64(i) / 2,
+			time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC), dec})
+	}
+	return rows
+}
+
+func input() driver.Rows {
+	return etlxtest.NewRows(columns, data())
+}
+
+func results() driver.Results {
+	return etlxtest.NewRows(columns, data())
+}
+
+type genDriver struct {
+	etlxtest.MockExtract
+}
+
+func (d *genDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{Commands: []driver.CommandSpec{{Name: "rows", Type: "int"}}}
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		etlx.ExtractRegister("gen", &genDriver{etlxtest.MockExtract{Columns: columns, Rows: data()}},
+			driver.DriverInfo{Description: "generator", Capabilities: []driver.Capability{driver.CapBatch}})
+		etlx.TransformRegister("mock", &etlxtest.MockTransform{})
+		etlx.LoadRegister("mock", &etlxtest.MockLoad{})
+		if err := rpcdriver.Serve(nil); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	//the plugins inherit the environment
+	os.Setenv(pluginEnv, "1")
+	os.Exit(m.Run())
+}
+
+func commands(t *testing.T, s string) []driver.Command {
+	var args []driver.Command
+	if err := json.Unmarshal([]byte(s), &args); err != nil {
+		t.Fatal(err)
+	}
+	return args
+}
+
+//loadPlugin loads the test binary as a plugin into a new registry.
+func loadPlugin(t *testing.T, options ...func(*rpcdriver.Plugin)) *etlx.Registry {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := etlx.NewRegistry()
+	p, err := rpcdriver.Load(exe, r, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return r
+}
+
+func TestConformance(t *testing.T) {
+	for _, mode := range []struct {
+		name    string
+		options []func(*rpcdriver.Plugin)
+	}{
+		{"Stdio", []func(*rpcdriver.Plugin){rpcdriver.BatchSize(2)}},
+		{"UnixSocket", []func(*rpcdriver.Plugin){rpcdriver.UnixSocket()}},
+	} {
+		t.Run(mode.name, func(t *testing.T) {
+			r := loadPlugin(t, mode.options...)
+			etlxtest.TestExtract(t, etlxtest.ExtractCase{
+				Driver:     r.FindExtract("gen"),
+				DataSource: "gen",
+				Batch:      true,
+				Rows:       9,
+			})
+			etlxtest.TestTransform(t, etlxtest.TransformCase{
+				Driver:  r.FindTransform("mock"),
+				Input:   input,
+				Ordered: true,
+			})
+			etlxtest.TestTransform(t, etlxtest.TransformCase{
+				Driver:  r.FindTransform("aggregate"),
+				Args:    commands(t, `[{"name":"group_by","type":"list","value":["grp"]},{"name":"aggregate","type":"complex","value":[{"name":"n","type":"string","value":"count(id)"},{"name":"s","type":"string","value":"sum(amount)"}]}]`),
+				BadArgs: commands(t, `[{"name":"aggregate","type":"complex","value":[{"name":"n","type":"string","value":"nosuch(id)"}]}]`),
+				Input:   input,
+			})
+			etlxtest.TestLoad(t, etlxtest.LoadCase{
+				Driver: r.FindLoad("mock"),
+				Input:  results,
+			})
+		})
+	}
+}
+
+func TestDriverInfo(t *testing.T) {
+	r := loadPlugin(t)
+	if info, ok := r.DriverInfoOf(etlx.PhaseExtract, "gen"); !ok || info.Description != "generator" || !info.Has(driver.CapBatch) {
+		t.Errorf("DriverInfoOf(gen) = %v, %v", info, ok)
+	}
+	if _, ok := r.CommandSchemaOf(etlx.PhaseTransform, "sort"); !ok {
+		t.Error("the command schema of sort is not proxied")
+	}
+	if err := r.ValidateCommands(etlx.PhaseExtract, "gen", commands(t, `[{"name":"rowz","type":"int","value":1}]`)); err == nil {
+		t.Error("the unknown command is valid")
+	}
+}
+
+func TestValues(t *testing.T) {
+	r := loadPlugin(t)
+	handler, err := r.FindTransform("mock").Open("mock", "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	cmd, err := handler.Command(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rslt, err := handler.Exec(input(), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := etlxtest.ReadAll(rslt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := data(); !reflect.DeepEqual(got, want) {
+		t.Errorf("the values sent across the plugin = %v, want %v", got, want)
+	}
+}
+
+func TestOrderedAccumulate(t *testing.T) {
+	r := loadPlugin(t)
+	args := commands(t, `[{"name":"group_by","type":"list","value":["grp"]},{"name":"aggregate","type":"complex","value":[{"name":"first","type":"string","value":"first(id)"},{"name":"last","type":"string","value":"last(id)"},{"name":"ids","type":"string","value":"string_agg(id, ',')"}]}]`)
+	aggregate := func(fn func(driver.Transform, interface{}) (driver.Results, error)) [][]interface{} {
+		handler, err := r.FindTransform("aggregate").Open("aggregate", "aggregate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		cmd, err := handler.Command(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rslt, err := fn(handler, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := etlxtest.ReadAll(rslt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//the groups are in the order of the rows first seen
+		sort.Slice(rows, func(i, j int) bool { return rows[i][0].(string) < rows[j][0].(string) })
+		return rows
+	}
+
+	want := aggregate(func(h driver.Transform, cmd interface{}) (driver.Results, error) {
+		return h.Exec(input(), cmd)
+	})
+	//the batches arrive in the reverse order, as the workers of Transaction may
+	got := aggregate(func(h driver.Transform, cmd interface{}) (driver.Results, error) {
+		acc, ok := h.(driver.OrderedAccumulator)
+		if !ok {
+			t.Fatalf("%T is not driver.OrderedAccumulator", h)
+		}
+		rows := data()
+		for start := len(rows) - 3; start >= 0; start -= 3 {
+			if _, err := acc.AccumulateAt(etlxtest.NewRows(columns, rows[start:start+3]), cmd, int64(start)); err != nil {
+				return nil, err
+			}
+		}
+		return acc.Flush(cmd)
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AccumulateAt = %v, want %v", got, want)
+	}
+}
+
+func TestSchemaUnknown(t *testing.T) {
+	r := loadPlugin(t)
+	handler, err := r.FindTransform("pivot").Open("pivot", "pivot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	cmd, err := handler.Command(commands(t, `[{"name":"id","type":"list","value":["id"]},{"name":"key","type":"string","value":"grp"},{"name":"value","type":"string","value":"amount"},{"name":"aggregate","type":"string","value":"sum"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := handler.(driver.SchemaTransform)
+	if !ok {
+		t.Fatalf("%T is not driver.SchemaTransform", handler)
+	}
+	if _, err := st.OutputSchema(driver.NewSchema(columns), cmd); err != driver.ErrSchemaUnknown {
+		t.Errorf("OutputSchema error = %v, want driver.ErrSchemaUnknown", err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	r := loadPlugin(t)
+	load := &etlxtest.MockLoad{}
+	r.LoadRegister("local", load)
+
+	tx, err := etlx.Open("gen", "filter", "local", etlx.UseRegistry(r), etlx.BatchEnable("enable", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.ExtractOpen("e", "e", "gen"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.TransformOpen("t", "t", "filter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.LoadOpen("l", "l", "local"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Exec(nil, commands(t, `[{"name":"filter","type":"string","value":"amount >= 2"}]`), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, rows := load.Loaded(); len(rows) != 5 {
+		t.Errorf("loaded %d rows, want 5", len(rows))
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(dir, "plugin")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, ".hidden")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := etlx.NewRegistry()
+	r.TransformRegister("mock", &etlxtest.MockTransform{})
+	plugins, err := rpcdriver.LoadDir(dir, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 1 {
+		t.Fatalf("loaded %d plugins, want 1", len(plugins))
+	}
+	p := plugins[0]
+	if len(p.Skipped) != 1 || p.Skipped[0].Name != "mock" || p.Skipped[0].Phase != etlx.PhaseTransform {
+		t.Errorf("Skipped = %v, want the mock transform", p.Skipped)
+	}
+	if _, ok := r.DriverInfoOf(etlx.PhaseExtract, "gen"); !ok {
+		t.Error("gen is not registered")
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.DriverInfoOf(etlx.PhaseExtract, "gen"); ok {
+		t.Error("gen is still registered after Close")
+	}
+	if _, ok := r.DriverInfoOf(etlx.PhaseTransform, "mock"); !ok {
+		t.Error("the mock transform registered before is unregistered by Close")
+	}
+}
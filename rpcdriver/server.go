@@ -0,0 +1,533 @@
+package rpcdriver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//Serve serves the drivers of the registry, etlx.DefaultRegistry if nil, to
+//the host which started the plugin. It listens on the unix socket of SocketEnv
+//if it is set, or serves on stdin and stdout, in which case os.Stdout is set
+//to os.Stderr so the outputs of the drivers do not break the messages. It
+//returns once the host disconnects.
+func Serve(r *etlx.Registry) error {
+	if r == nil {
+		r = etlx.DefaultRegistry
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, newService(r)); err != nil {
+		return err
+	}
+
+	if path := os.Getenv(SocketEnv); path != "" {
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+		return nil
+	}
+
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	srv.ServeCodec(jsonrpc.NewServerCodec(stdio{Reader: os.Stdin, Writer: out}))
+	return nil
+}
+
+//stdio is the connection of the plugin on stdin and stdout.
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+func (s stdio) Close() error {
+	return nil
+}
+
+//service is the rpc service of the plugin, the handlers, commands, rows and
+//inputs are referred by the host with the ids.
+type service struct {
+	registry *etlx.Registry
+
+	mu       sync.Mutex
+	lastID   int64
+	handlers map[int64]interface{}
+	cmds     map[int64]interface{}
+	//cmds of the handlers, removed on Close
+	handlerCmds map[int64][]int64
+	rows        map[int64]driver.Rows
+	inputs      map[int64]*inputRows
+	//input consumed by the rows, closed with the rows
+	rowsInput map[int64]int64
+}
+
+func newService(r *etlx.Registry) *service {
+	return &service{
+		registry:    r,
+		handlers:    map[int64]interface{}{},
+		cmds:        map[int64]interface{}{},
+		handlerCmds: map[int64][]int64{},
+		rows:        map[int64]driver.Rows{},
+		inputs:      map[int64]*inputRows{},
+		rowsInput:   map[int64]int64{},
+	}
+}
+
+func (s *service) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *service) Describe(_ struct{}, reply *DescribeReply) error {
+	reply.Protocol = ProtocolVersion
+	reply.Drivers = []DriverDesc{}
+	for _, e := range s.registry.ListDrivers("") {
+		desc := DriverDesc{Phase: e.Phase, Name: e.Name, Info: e.Info}
+		if schema, ok := s.registry.CommandSchemaOf(e.Phase, e.Name); ok {
+			desc.Schema = &schema
+		}
+		reply.Drivers = append(reply.Drivers, desc)
+	}
+	return nil
+}
+
+func (s *service) Open(args OpenArgs, reply *OpenReply) error {
+	var handler interface{}
+	var err error
+	switch args.Phase {
+	case etlx.PhaseExtract:
+		if drv := s.registry.FindExtract(args.Driver); drv != nil {
+			handler, err = drv.Open(args.Name, args.DataSource)
+		}
+	case etlx.PhaseTransform:
+		if drv := s.registry.FindTransform(args.Driver); drv != nil {
+			handler, err = drv.Open(args.Name, args.DataSource)
+		}
+	case etlx.PhaseLoad:
+		if drv := s.registry.FindLoad(args.Driver); drv != nil {
+			handler, err = drv.Open(args.Name, args.DataSource)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("rpcdriver: %s driver %s is not found", args.Phase, args.Driver)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply.Handler = s.nextID()
+	s.handlers[reply.Handler] = handler
+	_, reply.Accumulator = handler.(driver.Accumulator)
+	_, reply.Ordered = handler.(driver.OrderedAccumulator)
+	return nil
+}
+
+func (s *service) handler(id int64) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.handlers[id]
+	if !ok {
+		return nil, fmt.Errorf("rpcdriver: handler %d is not found", id)
+	}
+	return h, nil
+}
+
+func (s *service) cmd(id int64) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cmds[id]
+}
+
+func (s *service) Command(args CommandArgs, reply *int64) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	commander, ok := h.(interface {
+		Command([]driver.Command) (interface{}, error)
+	})
+	if !ok {
+		return fmt.Errorf("rpcdriver: handler %d has no command", args.Handler)
+	}
+	cmd, err := commander.Command(args.Args)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*reply = s.nextID()
+	s.cmds[*reply] = cmd
+	s.handlerCmds[args.Handler] = append(s.handlerCmds[args.Handler], *reply)
+	return nil
+}
+
+func (s *service) SetBatch(args BatchArgs, _ *struct{}) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	ext, ok := h.(driver.Extract)
+	if !ok {
+		return fmt.Errorf("rpcdriver: handler %d is not an extract handler", args.Handler)
+	}
+	ext.SetBatch(args.Limit, args.Offset)
+	return nil
+}
+
+//keepRows keeps the rows for Next, the input is closed with the rows.
+func (s *service) keepRows(rows driver.Rows, input int64, reply *RowsReply) {
+	if rows == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply.Rows = s.nextID()
+	reply.Columns = rows.Columns()
+	if sr, ok := rows.(driver.SchemaRows); ok {
+		reply.Schema = sr.Schema()
+	}
+	s.rows[reply.Rows] = rows
+	if input != 0 {
+		s.rowsInput[reply.Rows] = input
+	}
+}
+
+func (s *service) Query(args QueryArgs, reply *RowsReply) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	ext, ok := h.(driver.Extract)
+	if !ok {
+		return fmt.Errorf("rpcdriver: handler %d is not an extract handler", args.Handler)
+	}
+	rows, err := ext.Query(s.cmd(args.Cmd))
+	if err != nil {
+		return err
+	}
+	s.keepRows(rows, 0, reply)
+	return nil
+}
+
+func (s *service) Next(args NextArgs, reply *NextReply) error {
+	s.mu.Lock()
+	rows, ok := s.rows[args.Rows]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("rpcdriver: rows %d are not found", args.Rows)
+	}
+
+	data := [][]interface{}{}
+	for args.Max <= 0 || len(data) < args.Max {
+		row := make([]interface{}, len(rows.Columns()))
+		if err := rows.Next(row); err == driver.EOT {
+			reply.EOF = true
+			break
+		} else if err != nil {
+			return err
+		}
+		data = append(data, row)
+	}
+	reply.Data = encodeRows(data)
+	if reply.EOF {
+		//the host closes the rows rarely once they are read
+		return s.CloseRows(CloseArgs{ID: args.Rows}, nil)
+	}
+	return nil
+}
+
+func (s *service) CloseRows(args CloseArgs, _ *struct{}) error {
+	s.mu.Lock()
+	rows, ok := s.rows[args.ID]
+	input := s.inputs[s.rowsInput[args.ID]]
+	delete(s.rows, args.ID)
+	delete(s.inputs, s.rowsInput[args.ID])
+	delete(s.rowsInput, args.ID)
+	s.mu.Unlock()
+
+	if input != nil {
+		input.Close()
+	}
+	if !ok {
+		return nil
+	}
+	return rows.Close()
+}
+
+func (s *service) OpenInput(args InputArgs, reply *int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*reply = s.nextID()
+	s.inputs[*reply] = newInputRows(args.Columns, args.Schema)
+	return nil
+}
+
+func (s *service) Push(args PushArgs, _ *struct{}) error {
+	s.mu.Lock()
+	input, ok := s.inputs[args.Input]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("rpcdriver: input %d is closed", args.Input)
+	}
+	return input.push(decodeRows(args.Data), args.EOF, args.Err)
+}
+
+func (s *service) input(id int64) (*inputRows, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	input, ok := s.inputs[id]
+	if !ok {
+		return nil, fmt.Errorf("rpcdriver: input %d is not found", id)
+	}
+	return input, nil
+}
+
+//closeInput stops the pushes to the input once its consumer returned.
+func (s *service) closeInput(id int64) {
+	s.mu.Lock()
+	input := s.inputs[id]
+	delete(s.inputs, id)
+	s.mu.Unlock()
+	if input != nil {
+		input.Close()
+	}
+}
+
+//transform executes the batch by method Exec, Accumulate or AccumulateAt.
+func (s *service) transform(args ExecArgs, reply *RowsReply, method string) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	input, err := s.input(args.Input)
+	if err != nil {
+		return err
+	}
+
+	var rslt driver.Results
+	if acc, ok := h.(driver.OrderedAccumulator); ok && method == "AccumulateAt" {
+		rslt, err = acc.AccumulateAt(input, s.cmd(args.Cmd), args.Offset)
+	} else if acc, ok := h.(driver.Accumulator); ok && method != "Exec" {
+		rslt, err = acc.Accumulate(input, s.cmd(args.Cmd))
+	} else if trans, ok := h.(driver.Transform); ok {
+		rslt, err = trans.Exec(input, s.cmd(args.Cmd))
+	} else {
+		err = fmt.Errorf("rpcdriver: handler %d is not a transform handler", args.Handler)
+	}
+	if err != nil || rslt == nil {
+		s.closeInput(args.Input)
+		return err
+	}
+	//the results could read the input lazily
+	s.keepRows(rslt, args.Input, reply)
+	return nil
+}
+
+func (s *service) Exec(args ExecArgs, reply *RowsReply) error {
+	return s.transform(args, reply, "Exec")
+}
+
+func (s *service) Accumulate(args ExecArgs, reply *RowsReply) error {
+	return s.transform(args, reply, "Accumulate")
+}
+
+func (s *service) AccumulateAt(args ExecArgs, reply *RowsReply) error {
+	return s.transform(args, reply, "AccumulateAt")
+}
+
+func (s *service) Flush(args QueryArgs, reply *RowsReply) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	acc, ok := h.(driver.Accumulator)
+	if !ok {
+		return nil
+	}
+	rslt, err := acc.Flush(s.cmd(args.Cmd))
+	if err != nil {
+		return err
+	}
+	s.keepRows(rslt, 0, reply)
+	return nil
+}
+
+func (s *service) Load(args ExecArgs, _ *struct{}) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	input, err := s.input(args.Input)
+	if err != nil {
+		return err
+	}
+	defer s.closeInput(args.Input)
+
+	load, ok := h.(driver.Load)
+	if !ok {
+		return fmt.Errorf("rpcdriver: handler %d is not a load handler", args.Handler)
+	}
+	return load.Load(input, s.cmd(args.Cmd))
+}
+
+func (s *service) QueryFromNextStep(args HandlerArgs, reply *RowsReply) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	load, ok := h.(driver.Load)
+	if !ok {
+		return fmt.Errorf("rpcdriver: handler %d is not a load handler", args.Handler)
+	}
+	rows, err := load.QueryFromNextStep()
+	if err != nil {
+		return err
+	}
+	s.keepRows(rows, 0, reply)
+	return nil
+}
+
+//OutputSchema returns an empty schema if the handler does not declare it.
+func (s *service) OutputSchema(args SchemaArgs, reply *driver.Schema) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	if st, ok := h.(driver.SchemaTransform); ok {
+		*reply, err = st.OutputSchema(args.Schema, s.cmd(args.Cmd))
+	}
+	return err
+}
+
+//InputSchema returns an empty schema if the handler does not declare it.
+func (s *service) InputSchema(args SchemaArgs, reply *driver.Schema) error {
+	h, err := s.handler(args.Handler)
+	if err != nil {
+		return err
+	}
+	if sl, ok := h.(driver.SchemaLoad); ok {
+		*reply, err = sl.InputSchema(s.cmd(args.Cmd))
+	}
+	return err
+}
+
+func (s *service) Close(args HandlerArgs, _ *struct{}) error {
+	s.mu.Lock()
+	h, ok := s.handlers[args.Handler]
+	delete(s.handlers, args.Handler)
+	for _, id := range s.handlerCmds[args.Handler] {
+		delete(s.cmds, id)
+	}
+	delete(s.handlerCmds, args.Handler)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	if closer, ok := h.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+//inputRows are the rows pushed by the host in batches, read by the transform
+//and load handlers of the plugin while they are pushed.
+type inputRows struct {
+	columns []string
+	schema  driver.Schema
+	batches chan [][]interface{}
+	done    chan struct{}
+	once    sync.Once
+
+	//set before batches is closed
+	err error
+
+	batch [][]interface{}
+	pos   int
+}
+
+func newInputRows(columns []string, schema driver.Schema) *inputRows {
+	if len(columns) == 0 {
+		columns = schema.Columns()
+	}
+	return &inputRows{
+		columns: columns,
+		schema:  schema,
+		batches: make(chan [][]interface{}, 2),
+		done:    make(chan struct{}),
+	}
+}
+
+//push is called by the rpc calls in order, as the host waits for every push.
+func (in *inputRows) push(data [][]interface{}, eof bool, errMsg string) error {
+	if len(data) > 0 {
+		select {
+		case in.batches <- data:
+		case <-in.done:
+			return fmt.Errorf("rpcdriver: the input is closed")
+		}
+	}
+	if errMsg != "" {
+		in.err = fmt.Errorf("%s", errMsg)
+	}
+	if eof || errMsg != "" {
+		close(in.batches)
+	}
+	return nil
+}
+
+func (in *inputRows) Columns() []string {
+	return in.columns
+}
+
+func (in *inputRows) Schema() driver.Schema {
+	return in.schema
+}
+
+func (in *inputRows) Next(dst interface{}) error {
+	for in.pos >= len(in.batch) {
+		select {
+		case batch, ok := <-in.batches:
+			if !ok {
+				if in.err != nil {
+					return in.err
+				}
+				return driver.EOT
+			}
+			in.batch, in.pos = batch, 0
+		case <-in.done:
+			return driver.EOT
+		}
+	}
+	row := in.batch[in.pos]
+	in.pos++
+	return driver.CopyRow(in.columns, row, dst)
+}
+
+func (in *inputRows) NextRsltAndIndex(rslt interface{}, index *map[string]interface{}) error {
+	return in.Next(rslt)
+}
+
+//Close stops the pushes, the rows not read are dropped.
+func (in *inputRows) Close() error {
+	in.once.Do(func() {
+		close(in.done)
+	})
+	return nil
+}
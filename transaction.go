@@ -2,6 +2,8 @@ package etlx
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"runtime"
 	"sync"
 
@@ -35,6 +37,8 @@ type Transaction struct {
 	extractDriver   driver.ExtractDriver
 	transformDriver driver.TransformDriver
 	loadDriver      driver.LoadDriver
+	driverNames     [3]string
+	registry        *Registry
 
 	//Data source name for each phases of the transaction.
 	//Different businesses may have different layout of the dsn.
@@ -55,12 +59,27 @@ type Transaction struct {
 	//Interface to access the transforming results.
 	//When transforming phase complete, this will be transfered to loading phase
 	transformResults driver.Results
+	//resultsMutex guards transformResults set by the batches executed concurrently
+	resultsMutex sync.Mutex
 
 	//Interface to access the loading results if the results is stored in some temporayi
 	//storage.
 	//This only could the be used if there are some transactions depends on the results
 	//of this transaction.
 	loadResults driver.Results
+
+	//schema drift detection, enabled by DetectDrift
+	driftStore  SchemaStore
+	driftJob    string
+	driftPolicy DriftPolicy
+	driftSchema *driver.Schema
+
+	//variables and templates in the data sources and commands, enabled by Expand
+	expander *driver.Expander
+	//provider of the secret://name references in the data sources
+	secrets driver.SecretProvider
+	//the schema check accepts float to decimal, enabled by AllowLossy
+	allowLossy bool
 }
 
 func BatchEnable(ctl string, size int64) func(*Transaction) {
@@ -75,25 +94,48 @@ func BatchEnable(ctl string, size int64) func(*Transaction) {
 	}
 }
 
-//Open init an transaction based on the name of extract, transfrom and load driver.
-func Open(eName, tName, lName string, options ...func(*Transaction)) (*Transaction, error) {
-	driverE, ok := drivers.Extract[eName]
-	if !ok {
-		return nil, fmt.Errorf("etlx: Do not find the Extract driver for name:%s", eName)
+//Expand enables the expansion of the variables and templates in the data
+//sources when the handlers are opened and in the commands when they are
+//validated and executed, see driver.Expander.
+func Expand(e *driver.Expander) func(*Transaction) {
+	return func(t *Transaction) {
+		t.expander = e
 	}
-	driverT, ok := drivers.Transform[tName]
-	if !ok {
-		return nil, fmt.Errorf("etlx: Do not find the Transform driver for name:%s", tName)
+}
+
+//Secrets sets the provider of the secret://name references in the data sources
+//and ${secret.name} of the expander, instead of the one of SetSecretProvider.
+func Secrets(p driver.SecretProvider) func(*Transaction) {
+	return func(t *Transaction) {
+		t.secrets = p
 	}
-	driverL, ok := drivers.Load[lName]
-	if !ok {
-		return nil, fmt.Errorf("etlx: Do not find the Load driver for name:%s", lName)
+}
+
+//UseRegistry opens the drivers of the transaction from the registry instead
+//of DefaultRegistry, which is kept if r is nil.
+func UseRegistry(r *Registry) func(*Transaction) {
+	return func(t *Transaction) {
+		if r != nil {
+			t.registry = r
+		}
 	}
+}
+
+//AllowLossy lets the schema check accept the columns which could only be loaded
+//with loss of precision, e.g. float into decimal, they are logged as warnings.
+//By default they fail the check.
+func AllowLossy() func(*Transaction) {
+	return func(t *Transaction) {
+		t.allowLossy = true
+	}
+}
 
+//Open init an transaction based on the name of extract, transfrom and load driver.
+//The drivers are found in DefaultRegistry, or the one of UseRegistry.
+func Open(eName, tName, lName string, options ...func(*Transaction)) (*Transaction, error) {
 	tsact := &Transaction{
-		extractDriver:   driverE,
-		transformDriver: driverT,
-		loadDriver:      driverL,
+		driverNames: [3]string{eName, tName, lName},
+		registry:    DefaultRegistry,
 	}
 
 	tsact.batchCtl = "disable"
@@ -105,6 +147,33 @@ func Open(eName, tName, lName string, options ...func(*Transaction)) (*Transacti
 		opt(tsact)
 	}
 
+	if tsact.extractDriver = tsact.registry.FindExtract(eName); tsact.extractDriver == nil {
+		return nil, fmt.Errorf("etlx: Do not find the Extract driver for name:%s", eName)
+	}
+	if tsact.transformDriver = tsact.registry.FindTransform(tName); tsact.transformDriver == nil {
+		return nil, fmt.Errorf("etlx: Do not find the Transform driver for name:%s", tName)
+	}
+	if tsact.loadDriver = tsact.registry.FindLoad(lName); tsact.loadDriver == nil {
+		return nil, fmt.Errorf("etlx: Do not find the Load driver for name:%s", lName)
+	}
+
+	if tsact.secrets == nil {
+		tsact.secrets = secretProvider()
+	}
+	if tsact.expander != nil && tsact.expander.Secret == nil && tsact.secrets != nil {
+		e := *tsact.expander
+		e.Secret = tsact.secrets.Secret
+		tsact.expander = &e
+	}
+
+	if tsact.batchCtl == "enable" {
+		for i, phase := range []string{PhaseExtract, PhaseTransform, PhaseLoad} {
+			if err := tsact.registry.checkCapability(phase, tsact.driverNames[i], driver.CapBatch, "batch mode"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return tsact, nil
 }
 
@@ -114,44 +183,82 @@ func (t *Transaction) ExtractOpen(etype, name, dataSource string) error {
 		return fmt.Errorf("Should provide extract name and datasource to init Extract")
 	}
 
+	dataSource, err := t.expand(dataSource)
+	if err != nil {
+		return redactError(fmt.Errorf("etlx: extract %s: %v", name, err), nil)
+	}
+
 	t.extractDsn.phase = etype
 	t.extractDsn.name = name
-	t.extractDsn.dataSource = dataSource
+	return openDriver(PhaseExtract, name, dataSource, t.secrets, func(dsn, redacted string) error {
+		//only the redacted data source is kept, as it could be logged
+		t.extractDsn.dataSource = redacted
 
-	handler, err := t.extractDriver.Open(name, dataSource)
-	t.extractHandler = handler
-	return err
+		handler, err := t.extractDriver.Open(name, dsn)
+		t.extractHandler = handler
+		return err
+	})
 }
 
 //TransformOpen init the transform driver and get the transform handler from driver.
 func (t *Transaction) TransformOpen(ttype, name, dataSource string) error {
+	dataSource, err := t.expand(dataSource)
+	if err != nil {
+		return redactError(fmt.Errorf("etlx: transform %s: %v", name, err), nil)
+	}
+
 	t.transformDsn.phase = ttype
 	t.transformDsn.name = name
-	t.transformDsn.dataSource = dataSource
+	return openDriver(PhaseTransform, name, dataSource, t.secrets, func(dsn, redacted string) error {
+		//only the redacted data source is kept, as it could be logged
+		t.transformDsn.dataSource = redacted
+
+		handler, err := t.transformDriver.Open(name, dsn)
+		t.transformHandler = handler
+		if s, ok := handler.(LoadOpenerSetter); ok && err == nil {
+			s.SetLoadOpener(t.NewLoad)
+		}
+		return err
+	})
+}
 
-	handler, err := t.transformDriver.Open(name, dataSource)
-	t.transformHandler = handler
-	return err
+//NewLoad opens a load handler other than the one of the transaction, e.g. a
+//dead letter, from the registry of the transaction. The data source and the
+//commands are expanded if Expand is enabled, and the secrets are resolved by
+//the provider of the transaction.
+func (t *Transaction) NewLoad(driverName, name, dataSource string, rawArg []driver.Command) (*LoadHandler, error) {
+	dataSource, err := t.expand(dataSource)
+	if err != nil {
+		return nil, redactError(fmt.Errorf("etlx: load %s: %v", name, err), nil)
+	}
+	if t.expander != nil {
+		if rawArg, err = t.expander.ExpandCommands(rawArg); err != nil {
+			return nil, fmt.Errorf("etlx: load %s: %v", name, err)
+		}
+	}
+	return t.registry.newLoad(driverName, name, dataSource, rawArg, t.secrets)
 }
 
 //LoadOpen init the load driver and get the load handler from driver.
 func (t *Transaction) LoadOpen(ltype, name, dataSource string) error {
+	dataSource, err := t.expand(dataSource)
+	if err != nil {
+		return redactError(fmt.Errorf("etlx: load %s: %v", name, err), nil)
+	}
+
 	t.loadDsn.phase = ltype
 	t.loadDsn.name = name
-	t.loadDsn.dataSource = dataSource
-
-	handler, err := t.loadDriver.Open(name, dataSource)
-	t.loadHandler = handler
-	return err
-}
+	return openDriver(PhaseLoad, name, dataSource, t.secrets, func(dsn, redacted string) error {
+		//only the redacted data source is kept, as it could be logged
+		t.loadDsn.dataSource = redacted
 
-func (t *Transaction) extract(args []driver.Command, rows *driver.Rows) error {
-	cmd, err := t.extractHandler.Command(args)
-	if err != nil {
-		fmt.Println("Extract Cmd error:", err)
+		handler, err := t.loadDriver.Open(name, dsn)
+		t.loadHandler = handler
 		return err
-	}
+	})
+}
 
+func (t *Transaction) extract(cmd interface{}, rows *driver.Rows) error {
 	results, err := t.extractHandler.Query(cmd)
 	if err != nil {
 		return err
@@ -163,39 +270,125 @@ func (t *Transaction) extract(args []driver.Command, rows *driver.Rows) error {
 	return nil
 }
 
-func (t *Transaction) transform(args []driver.Command, rows driver.Rows, rslt *driver.Results) error {
-	cmd, err := t.transformHandler.Command(args)
+func (t *Transaction) transform(cmd interface{}, rows driver.Rows, rslt *driver.Results) error {
+	results, err := t.transformHandler.Exec(rows, cmd)
 	if err != nil {
 		return err
 	}
 
-	results, err := t.transformHandler.Exec(rows, cmd)
+	t.resultsMutex.Lock()
+	t.transformResults = results
+	t.resultsMutex.Unlock()
+	*rslt = results
+
+	return nil
+}
+
+func (t *Transaction) load(cmd interface{}, rows driver.Results) error {
+	return t.loadHandler.Load(rows, cmd)
+}
+
+//accumulate feeds one batch to a transform handler implementing driver.Accumulator.
+//rslt is set to nil if the handler has nothing to release for the batch. offset
+//is the number of the rows extracted before the batch.
+func (t *Transaction) accumulate(cmd interface{}, rows driver.Rows, offset int64, rslt *driver.Results) error {
+	acc, ok := t.transformHandler.(driver.Accumulator)
+	if !ok {
+		return t.transform(cmd, rows, rslt)
+	}
+
+	var results driver.Results
+	var err error
+	if ordered, ok := acc.(driver.OrderedAccumulator); ok {
+		results, err = ordered.AccumulateAt(rows, cmd, offset)
+	} else {
+		results, err = acc.Accumulate(rows, cmd)
+	}
 	if err != nil {
 		return err
 	}
 
-	t.transformResults = results
 	*rslt = results
-
 	return nil
 }
 
-func (t *Transaction) load(args []driver.Command, rows driver.Results) error {
-	cmd, err := t.loadHandler.Command(args)
+//flush gets the final results from a transform handler implementing driver.Accumulator
+//and loads them. It does nothing for other handlers.
+func (t *Transaction) flush(transCmd interface{}, loadCmd interface{}) error {
+	acc, ok := t.transformHandler.(driver.Accumulator)
+	if !ok {
+		return nil
+	}
+
+	results, err := acc.Flush(transCmd)
 	if err != nil {
 		return err
 	}
 
-	return t.loadHandler.Load(rows, cmd)
+	t.resultsMutex.Lock()
+	t.transformResults = results
+	t.resultsMutex.Unlock()
+	if results == nil {
+		return nil
+	}
+	return t.load(loadCmd, results)
 }
 
-func (t *Transaction) execTransLoad(rows driver.Rows, transArgs []driver.Command, loadArgs []driver.Command) error {
+//checkSchema checks the schema of the extracted rows through the transform to
+//the load handler before any row is read. It is skipped if any of them does not
+//declare the schema.
+func (t *Transaction) checkSchema(rows driver.Rows, transCmd interface{}, loadCmd interface{}) error {
+	sr, ok := rows.(driver.SchemaRows)
+	if !ok || sr.Schema().IsEmpty() {
+		return nil
+	}
+
+	st, ok := t.transformHandler.(driver.SchemaTransform)
+	if !ok {
+		return nil
+	}
+	schema, err := st.OutputSchema(sr.Schema(), transCmd)
+	if err == driver.ErrSchemaUnknown {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("etlx: transform %s: %v", t.transformDsn.name, err)
+	}
+
+	sl, ok := t.loadHandler.(driver.SchemaLoad)
+	if !ok || schema.IsEmpty() {
+		return nil
+	}
+	expected, err := sl.InputSchema(loadCmd)
+	if err != nil {
+		return err
+	}
+	if !t.allowLossy {
+		if err := schema.Compatible(expected); err != nil {
+			return fmt.Errorf("etlx: load %s: %v", t.loadDsn.name, err)
+		}
+		return nil
+	}
+	warnings, err := schema.CompatibleLossy(expected)
+	if err != nil {
+		return fmt.Errorf("etlx: load %s: %v", t.loadDsn.name, err)
+	}
+	for _, w := range warnings {
+		log.Printf("etlx: load %s: %s", t.loadDsn.name, w)
+	}
+	return nil
+}
+
+func (t *Transaction) execTransLoad(rows driver.Rows, offset int64, transCmd interface{}, loadCmd interface{}) error {
 	rslt := new(driver.Results)
-	err := t.transform(transArgs, rows, rslt)
+	err := t.accumulate(transCmd, rows, offset, rslt)
 	if err != nil {
 		return err
 	}
-	err = t.load(loadArgs, *rslt)
+	if *rslt == nil {
+		return nil
+	}
+	err = t.load(loadCmd, *rslt)
 	if err != nil {
 		return err
 	}
@@ -203,48 +396,203 @@ func (t *Transaction) execTransLoad(rows driver.Rows, transArgs []driver.Command
 	return nil
 }
 
+//expand returns the data source with the variables expanded if Expand is enabled.
+func (t *Transaction) expand(dataSource string) (string, error) {
+	if t.expander == nil {
+		return dataSource, nil
+	}
+	return t.expander.Expand(dataSource)
+}
+
+//expandArgs returns the commands of the phases with the variables expanded if
+//Expand is enabled.
+func (t *Transaction) expandArgs(args ...[]driver.Command) ([][]driver.Command, error) {
+	if t.expander == nil {
+		return args, nil
+	}
+	rslt := make([][]driver.Command, len(args))
+	for i, cmds := range args {
+		expanded, err := t.expander.ExpandCommands(cmds)
+		if err != nil {
+			return nil, fmt.Errorf("etlx: %s %s: %v", []string{PhaseExtract, PhaseTransform, PhaseLoad}[i], t.driverNames[i], err)
+		}
+		rslt[i] = expanded
+	}
+	return rslt, nil
+}
+
+//Validate checks the commands against the schemas of the drivers of the
+//transaction which implement driver.CommandSchemaDriver. It could be called
+//before the handlers are opened, Exec calls it before extracting. The
+//variables are expanded firstly if Expand is enabled.
+func (t *Transaction) Validate(extArgs []driver.Command, transArgs []driver.Command, loadArgs []driver.Command) error {
+	args, err := t.expandArgs(extArgs, transArgs, loadArgs)
+	if err != nil {
+		return err
+	}
+	return t.validate(args[0], args[1], args[2])
+}
+
+func (t *Transaction) validate(extArgs []driver.Command, transArgs []driver.Command, loadArgs []driver.Command) error {
+	if err := validateCommands(t.extractDriver, PhaseExtract, t.driverNames[0], extArgs); err != nil {
+		return err
+	}
+	if err := validateCommands(t.transformDriver, PhaseTransform, t.driverNames[1], transArgs); err != nil {
+		return err
+	}
+	return validateCommands(t.loadDriver, PhaseLoad, t.driverNames[2], loadArgs)
+}
+
+//commands builds the commands of the transform and the load handlers once for
+//all the batches, after the drift check could have changed the transform args.
+func (t *Transaction) commands(transArgs []driver.Command, loadArgs []driver.Command) (interface{}, interface{}, error) {
+	transCmd, err := t.transformHandler.Command(transArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	loadCmd, err := t.loadHandler.Command(loadArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return transCmd, loadCmd, nil
+}
+
+//Exec extracts, transforms and loads the rows. In batch mode the extract
+//handler returns driver.EOT, or io.EOF, past the last batch; any other error
+//aborts the transaction.
 func (t *Transaction) Exec(extArgs []driver.Command, transArgs []driver.Command, loadArgs []driver.Command) error {
+	args, err := t.expandArgs(extArgs, transArgs, loadArgs)
+	if err != nil {
+		return err
+	}
+	extArgs, transArgs, loadArgs = args[0], args[1], args[2]
+	if err := t.validate(extArgs, transArgs, loadArgs); err != nil {
+		return err
+	}
+
+	extCmd, err := t.extractHandler.Command(extArgs)
+	if err != nil {
+		fmt.Println("Extract Cmd error:", err)
+		return err
+	}
+
 	if t.batchCtl == "enable" {
 		wg := sync.WaitGroup{}
-		queue := make(chan driver.Rows, runtime.NumCPU())
+		//a batch is sent with its offset, the goroutines take them in any order
+		type batch struct {
+			rows   driver.Rows
+			offset int64
+		}
+		queue := make(chan batch, runtime.NumCPU())
+
+		var errMu sync.Mutex
+		var batchErr, extractErr error
+		var transCmd, loadCmd interface{}
+		first := true
 
 		for {
+			//stop extracting once any batch failed
+			errMu.Lock()
+			failed := batchErr != nil
+			errMu.Unlock()
+			if failed {
+				break
+			}
+
 			rows := new(driver.Rows)
 			t.FlashBatch()
-			err := t.extract(extArgs, rows)
-			if err != nil {
+			t.batchMutex.Lock()
+			offset := t.offset
+			t.batchMutex.Unlock()
+
+			err := t.extract(extCmd, rows)
+			if err == driver.EOT || err == io.EOF {
+				break
+			} else if err != nil {
+				extractErr = err
 				break
 			}
+			if first {
+				first = false
+				if *rows, err = t.checkDrift(*rows, &transArgs); err != nil {
+					return err
+				}
+				if transCmd, loadCmd, err = t.commands(transArgs, loadArgs); err != nil {
+					return err
+				}
+				if err := t.checkSchema(*rows, transCmd, loadCmd); err != nil {
+					return err
+				}
+			}
 			wg.Add(1)
 			go func() {
-				r := <-queue
-				t.execTransLoad(r, transArgs, loadArgs)
+				b := <-queue
+				if err := t.execTransLoad(b.rows, b.offset, transCmd, loadCmd); err != nil {
+					errMu.Lock()
+					if batchErr == nil {
+						batchErr = err
+					}
+					errMu.Unlock()
+				}
 				wg.Done()
 			}()
-			queue <- *rows
+			queue <- batch{rows: *rows, offset: offset}
 		}
 		wg.Wait()
 
+		//the results of the batches extracted are incomplete, not flushed
+		if extractErr != nil {
+			return extractErr
+		}
+		if batchErr != nil {
+			return batchErr
+		}
+		//nothing was extracted, the commands are built for the flush only
+		if first {
+			if transCmd, loadCmd, err = t.commands(transArgs, loadArgs); err != nil {
+				return err
+			}
+		}
+		if err := t.flush(transCmd, loadCmd); err != nil {
+			return err
+		}
+		return t.saveDrift()
+
 	} else {
 		rows := new(driver.Rows)
 		rslt := new(driver.Results)
 
-		err := t.extract(extArgs, rows)
+		err := t.extract(extCmd, rows)
 		if err != nil {
 			return err
 		}
 
-		err = t.transform(transArgs, *rows, rslt)
+		*rows, err = t.checkDrift(*rows, &transArgs)
 		if err != nil {
 			return err
 		}
-		err = t.load(loadArgs, *rslt)
+
+		transCmd, loadCmd, err := t.commands(transArgs, loadArgs)
+		if err != nil {
+			return err
+		}
+
+		err = t.checkSchema(*rows, transCmd, loadCmd)
+		if err != nil {
+			return err
+		}
+
+		err = t.transform(transCmd, *rows, rslt)
+		if err != nil {
+			return err
+		}
+		err = t.load(loadCmd, *rslt)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return t.saveDrift()
 }
 
 func (t *Transaction) FlashBatch() {
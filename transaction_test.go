@@ -0,0 +1,600 @@
+package etlx_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/etlxtest"
+	"github.com/xingwangc/etlx/memory"
+	_ "github.com/xingwangc/etlx/transform"
+)
+
+//failingExtract returns Err for the batches from offset At.
+type failingExtract struct {
+	etlxtest.MockExtract
+	At  int64
+	Err error
+}
+
+func (d *failingExtract) Open(name, dataSource string) (driver.Extract, error) {
+	h, err := d.MockExtract.Open(name, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &failingExtractor{MockExtractHandler: h.(*etlxtest.MockExtractHandler), drv: d}, nil
+}
+
+type failingExtractor struct {
+	*etlxtest.MockExtractHandler
+	drv *failingExtract
+}
+
+func (e *failingExtractor) Query(cmd interface{}) (driver.Rows, error) {
+	if e.Offset >= e.drv.At {
+		return nil, e.drv.Err
+	}
+	return e.MockExtractHandler.Query(cmd)
+}
+
+func aggregateJob(batch int64) *etlx.Job {
+	return &etlx.Job{
+		Name:    "aggregate",
+		Extract: etlx.Stage{Driver: "extract", DataSource: "in"},
+		Transform: etlx.Stage{Driver: "aggregate", Args: []driver.Command{
+			{Name: "group_by", Type: "array", Value: []interface{}{"group"}},
+			{Name: "aggregate", Type: "command", Value: []driver.Command{
+				{Name: "first", Type: "string", Value: "first(name)"},
+				{Name: "last", Type: "string", Value: "last(name)"},
+				{Name: "names", Type: "string", Value: "string_agg(name, ',')"},
+			}},
+		}},
+		Load:  etlx.Stage{Driver: "load", DataSource: "out"},
+		Batch: batch,
+	}
+}
+
+func aggregateRegistry(ext driver.ExtractDriver, load *etlxtest.MockLoad) *etlx.Registry {
+	r := etlx.NewRegistry()
+	r.ExtractRegister("extract", ext)
+	r.TransformRegister("aggregate", etlx.FindTransform("aggregate"))
+	r.LoadRegister("load", load)
+	return r
+}
+
+func TestBatchOrderedAccumulator(t *testing.T) {
+	rows := [][]interface{}{}
+	for i := 0; i < 50; i++ {
+		rows = append(rows, []interface{}{fmt.Sprint(i % 3), fmt.Sprint(i)})
+	}
+	ext := &etlxtest.MockExtract{Columns: []string{"group", "name"}, Rows: rows}
+
+	want := &etlxtest.MockLoad{}
+	if err := aggregateJob(0).Run(etlx.UseRegistry(aggregateRegistry(ext, want))); err != nil {
+		t.Fatal(err)
+	}
+	_, wantRows := want.Loaded()
+
+	for i := 0; i < 10; i++ {
+		got := &etlxtest.MockLoad{}
+		if err := aggregateJob(1).Run(etlx.UseRegistry(aggregateRegistry(ext, got))); err != nil {
+			t.Fatal(err)
+		}
+		if _, gotRows := got.Loaded(); !reflect.DeepEqual(gotRows, wantRows) {
+			t.Fatalf("batch results %v, want %v", gotRows, wantRows)
+		}
+	}
+}
+
+func TestBatchExtractError(t *testing.T) {
+	extractErr := errors.New("connection lost")
+	ext := &failingExtract{
+		MockExtract: etlxtest.MockExtract{Columns: []string{"group", "name"}, Rows: [][]interface{}{{"a", "1"}, {"b", "2"}, {"a", "3"}}},
+		At:          2,
+		Err:         extractErr,
+	}
+	load := &etlxtest.MockLoad{}
+	if err := aggregateJob(1).Run(etlx.UseRegistry(aggregateRegistry(ext, load))); err != extractErr {
+		t.Fatalf("Run returned %v, want %v", err, extractErr)
+	}
+	if load.Loads() != 0 {
+		t.Errorf("the incomplete results are flushed and loaded %d times", load.Loads())
+	}
+}
+
+func TestBatchExtractEOF(t *testing.T) {
+	//io.EOF ends the batches like driver.EOT
+	ext := &failingExtract{
+		MockExtract: etlxtest.MockExtract{Columns: []string{"group", "name"}, Rows: [][]interface{}{{"a", "1"}, {"b", "2"}, {"a", "3"}}},
+		At:          2,
+		Err:         io.EOF,
+	}
+	load := &etlxtest.MockLoad{}
+	if err := aggregateJob(1).Run(etlx.UseRegistry(aggregateRegistry(ext, load))); err != nil {
+		t.Fatal(err)
+	}
+	if _, rows := load.Loaded(); len(rows) != 2 {
+		t.Errorf("loaded %v, want the groups of the 2 rows before io.EOF", rows)
+	}
+}
+
+//commandLoad counts the commands built by its handlers.
+type commandLoad struct {
+	etlxtest.MockLoad
+	commands int32
+}
+
+func (d *commandLoad) Open(name, dataSource string) (driver.Load, error) {
+	h, err := d.MockLoad.Open(name, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &commandLoader{handler: h, drv: d}, nil
+}
+
+type commandLoader struct {
+	handler driver.Load
+	drv     *commandLoad
+}
+
+func (l *commandLoader) Command(args []driver.Command) (interface{}, error) {
+	atomic.AddInt32(&l.drv.commands, 1)
+	return l.handler.Command(args)
+}
+
+func (l *commandLoader) Load(src driver.Results, cmd interface{}) error {
+	return l.handler.Load(src, cmd)
+}
+
+func (l *commandLoader) QueryFromNextStep() (driver.Rows, error) {
+	return l.handler.QueryFromNextStep()
+}
+
+func (l *commandLoader) Close() error {
+	return l.handler.Close()
+}
+
+func TestBatchCommandOnce(t *testing.T) {
+	rows := [][]interface{}{}
+	for i := 0; i < 10; i++ {
+		rows = append(rows, []interface{}{fmt.Sprint(i % 3), fmt.Sprint(i)})
+	}
+	ext := &etlxtest.MockExtract{Columns: []string{"group", "name"}, Rows: rows}
+	for _, batch := range []int64{0, 2} {
+		load := &commandLoad{}
+		r := aggregateRegistry(ext, &load.MockLoad)
+		r.ReplaceLoad("load", load)
+		r.TransformRegister("filter", etlx.FindTransform("filter"))
+		job := aggregateJob(batch)
+		job.Transform = etlx.Stage{Driver: "filter", Args: []driver.Command{{Name: "filter", Value: "group != '0'"}}}
+		if err := job.Run(etlx.UseRegistry(r)); err != nil {
+			t.Fatal(err)
+		}
+		//the batches are loaded with the same command
+		if n := atomic.LoadInt32(&load.commands); n != 1 || load.Loads() < 1 {
+			t.Errorf("batch %d: the load command is built %d times for %d loads", batch, n, load.Loads())
+		}
+	}
+}
+
+//sourceLoad records the data sources the handlers are opened with.
+type sourceLoad struct {
+	etlxtest.MockLoad
+	mu      sync.Mutex
+	sources []string
+}
+
+func (d *sourceLoad) Open(name, dataSource string) (driver.Load, error) {
+	d.mu.Lock()
+	d.sources = append(d.sources, dataSource)
+	d.mu.Unlock()
+	return d.MockLoad.Open(name, dataSource)
+}
+
+type testSecrets map[string]string
+
+func (s testSecrets) Secret(name string) (string, error) {
+	if val, ok := s[name]; ok {
+		return val, nil
+	}
+	return "", driver.SecretNotFoundError{Name: name}
+}
+
+func TestTransformLoadOpener(t *testing.T) {
+	ext := &etlxtest.MockExtract{Columns: []string{"id"}, Rows: [][]interface{}{{1}, {nil}, {3}}}
+	load := &etlxtest.MockLoad{}
+	//the dead letter is only in the registry of the transaction
+	dead := &sourceLoad{}
+	r := etlx.NewRegistry()
+	r.ExtractRegister("extract", ext)
+	r.TransformRegister("validate", etlx.FindTransform("validate"))
+	r.LoadRegister("load", load)
+	r.LoadRegister("dead", dead)
+
+	job := &etlx.Job{
+		Name:    "validate",
+		Extract: etlx.Stage{Driver: "extract", DataSource: "in"},
+		Transform: etlx.Stage{Driver: "validate", Args: []driver.Command{
+			{Name: "rules", Type: "complex", Value: []driver.Command{
+				{Name: "id_required", Type: "json", Value: map[string]interface{}{"check": "not_null", "column": "id"}},
+			}},
+			{Name: "dead_letter", Type: "json", Value: map[string]interface{}{
+				"driver": "dead", "name": "rejects", "data_source": "rejects_${env}?password=secret://pw"}},
+		}},
+		Load: etlx.Stage{Driver: "load", DataSource: "out"},
+	}
+	err := job.Run(etlx.UseRegistry(r), etlx.Secrets(testSecrets{"pw": "hunter2"}),
+		etlx.Expand(driver.NewExpander(map[string]string{"env": "test"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"rejects_test?password=hunter2"}; !reflect.DeepEqual(dead.sources, want) {
+		t.Errorf("dead letter opened with %v, want %v", dead.sources, want)
+	}
+	if _, rows := dead.Loaded(); len(rows) != 1 {
+		t.Errorf("dead letter loaded %v", rows)
+	}
+	if _, rows := load.Loaded(); len(rows) != 2 {
+		t.Errorf("loaded %v", rows)
+	}
+}
+
+func TestCheckSchemaUnknown(t *testing.T) {
+	store := memory.NewStore()
+	tbl := driver.NewTable(0)
+	tbl.SetSchema(driver.Schema{Fields: []driver.Field{
+		{Name: "region", Type: driver.TypeString},
+		{Name: "month", Type: driver.TypeString},
+		{Name: "amount", Type: driver.TypeInt},
+	}})
+	tbl.AppendData([]interface{}{"n", "jan", 1})
+	tbl.AppendData([]interface{}{"n", "feb", 2})
+	store.SetTable("sales", tbl)
+
+	r := etlx.NewRegistry()
+	r.ExtractRegister("memory", store.ExtractDriver())
+	r.TransformRegister("pivot", etlx.FindTransform("pivot"))
+	r.LoadRegister("memory", store.LoadDriver())
+	job := &etlx.Job{
+		Name:    "pivot",
+		Extract: etlx.Stage{Driver: "memory", DataSource: "sales"},
+		Transform: etlx.Stage{Driver: "pivot", Args: []driver.Command{
+			{Name: "key", Type: "string", Value: "month"},
+			{Name: "value", Type: "string", Value: "amount"},
+		}},
+		Load: etlx.Stage{Driver: "memory", DataSource: "wide"},
+	}
+	if err := job.Run(etlx.UseRegistry(r)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"region", "jan", "feb"}; !reflect.DeepEqual(store.Table("wide").Columns(), want) {
+		t.Errorf("columns %v, want %v", store.Table("wide").Columns(), want)
+	}
+}
+
+//schemaLoad expects the rows of Schema.
+type schemaLoad struct {
+	etlxtest.MockLoad
+	schema driver.Schema
+}
+
+func (d *schemaLoad) Open(name, dataSource string) (driver.Load, error) {
+	h, err := d.MockLoad.Open(name, dataSource)
+	return &schemaLoader{handler: h, schema: d.schema}, err
+}
+
+type schemaLoader struct {
+	handler driver.Load
+	schema  driver.Schema
+}
+
+func (l *schemaLoader) Command(args []driver.Command) (interface{}, error) {
+	return l.handler.Command(args)
+}
+
+func (l *schemaLoader) Load(src driver.Results, cmd interface{}) error {
+	return l.handler.Load(src, cmd)
+}
+
+func (l *schemaLoader) QueryFromNextStep() (driver.Rows, error) {
+	return l.handler.QueryFromNextStep()
+}
+
+func (l *schemaLoader) Close() error {
+	return l.handler.Close()
+}
+
+func (l *schemaLoader) InputSchema(cmd interface{}) (driver.Schema, error) {
+	return l.schema, nil
+}
+
+func TestCheckSchema(t *testing.T) {
+	tbl := driver.NewTable(0)
+	tbl.SetSchema(driver.Schema{Fields: []driver.Field{
+		{Name: "id", Type: driver.TypeInt},
+		{Name: "day", Type: driver.TypeString},
+	}})
+	tbl.AppendData([]interface{}{1, "2024-03-01"})
+	store := memory.NewStore()
+	store.SetTable("in", tbl)
+
+	for _, c := range []struct {
+		field driver.Field
+		ok    bool
+	}{
+		{driver.Field{Name: "day", Type: driver.TypeString}, true},
+		{driver.Field{Name: "day", Type: driver.TypeTime}, false},
+	} {
+		load := &schemaLoad{schema: driver.Schema{Fields: []driver.Field{{Name: "id", Type: driver.TypeFloat}, c.field}}}
+		r := etlx.NewRegistry()
+		r.ExtractRegister("memory", store.ExtractDriver())
+		r.TransformRegister("sort", etlx.FindTransform("sort"))
+		r.LoadRegister("load", load)
+		job := &etlx.Job{
+			Name:    "schema",
+			Extract: etlx.Stage{Driver: "memory", DataSource: "in"},
+			Transform: etlx.Stage{Driver: "sort", Args: []driver.Command{
+				{Name: "order_by", Type: "complex", Value: []driver.Command{{Name: "id", Type: "string", Value: "desc"}}},
+			}},
+			Load: etlx.Stage{Driver: "load", DataSource: "out"},
+		}
+		err := job.Run(etlx.UseRegistry(r))
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.field.Type, err)
+		}
+		if !c.ok && (err == nil || load.Loads() != 0) {
+			t.Errorf("%s: the incompatible schema returned %v and loaded %d times", c.field.Type, err, load.Loads())
+		}
+	}
+}
+
+func TestCheckSchemaLossy(t *testing.T) {
+	tbl := driver.NewTable(0)
+	tbl.SetSchema(driver.Schema{Fields: []driver.Field{{Name: "price", Type: driver.TypeFloat}}})
+	tbl.AppendData([]interface{}{0.1})
+	store := memory.NewStore()
+	store.SetTable("in", tbl)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	for _, lossy := range []bool{false, true} {
+		load := &schemaLoad{schema: driver.Schema{Fields: []driver.Field{{Name: "price", Type: driver.TypeDecimal}}}}
+		r := etlx.NewRegistry()
+		r.ExtractRegister("memory", store.ExtractDriver())
+		r.TransformRegister("filter", etlx.FindTransform("filter"))
+		r.LoadRegister("load", load)
+		job := &etlx.Job{
+			Name:      "lossy",
+			Extract:   etlx.Stage{Driver: "memory", DataSource: "in"},
+			Transform: etlx.Stage{Driver: "filter", Args: []driver.Command{{Name: "filter", Value: "price > 0"}}},
+			Load:      etlx.Stage{Driver: "load", DataSource: "out"},
+		}
+		options := []func(*etlx.Transaction){etlx.UseRegistry(r)}
+		if lossy {
+			options = append(options, etlx.AllowLossy())
+		}
+		err := job.Run(options...)
+		if !lossy && (err == nil || !strings.Contains(err.Error(), "precision could be lost")) {
+			t.Errorf("float to decimal without AllowLossy returned %v", err)
+		}
+		if lossy && (err != nil || load.Loads() != 1) {
+			t.Errorf("float to decimal with AllowLossy returned %v and loaded %d times", err, load.Loads())
+		}
+	}
+	if !strings.Contains(buf.String(), "column price is float but decimal expected") {
+		t.Errorf("the lossy column is not warned: %q", buf.String())
+	}
+}
+
+func TestDriftAutoMapWithoutPassThrough(t *testing.T) {
+	store := etlx.NewFileSchemaStore(t.TempDir())
+	run := func(columns []string, rows [][]interface{}, policy etlx.DriftPolicy) (*etlxtest.MockLoad, error) {
+		load := &etlxtest.MockLoad{}
+		r := etlx.NewRegistry()
+		r.ExtractRegister("extract", &etlxtest.MockExtract{Columns: columns, Rows: rows})
+		r.TransformRegister("transform", &etlxtest.MockTransform{})
+		r.LoadRegister("load", load)
+		job := &etlx.Job{
+			Name:      "drift",
+			Extract:   etlx.Stage{Driver: "extract", DataSource: "in"},
+			Transform: etlx.Stage{Driver: "transform"},
+			Load:      etlx.Stage{Driver: "load", DataSource: "out"},
+		}
+		return load, job.Run(etlx.UseRegistry(r), etlx.DetectDrift(store, "drift", policy))
+	}
+
+	if _, err := run([]string{"id"}, [][]interface{}{{"1"}}, etlx.DriftFail); err != nil {
+		t.Fatal(err)
+	}
+	added := [][]interface{}{{"1", "x"}}
+	if load, err := run([]string{"id", "extra"}, added, etlx.DriftFail); err == nil || load.Loads() != 0 {
+		t.Fatalf("DriftFail returned %v and loaded %d times", err, load.Loads())
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := run([]string{"id", "extra"}, added, etlx.DriftAutoMap); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "could not pass the added columns through, extra are not mapped") {
+		t.Errorf("logged %q", buf.String())
+	}
+}
+
+func TestDriftAutoMapPassThrough(t *testing.T) {
+	store := etlx.NewFileSchemaStore(t.TempDir())
+	run := func(columns []string, rows [][]interface{}, policy etlx.DriftPolicy) (*etlxtest.MockLoad, error) {
+		load := &etlxtest.MockLoad{}
+		r := etlx.NewRegistry()
+		r.ExtractRegister("extract", &etlxtest.MockExtract{Columns: columns, Rows: rows})
+		r.TransformRegister("mapper", etlx.FindTransform("mapper"))
+		r.LoadRegister("load", load)
+		job := &etlx.Job{
+			Name:    "drift",
+			Extract: etlx.Stage{Driver: "extract", DataSource: "in"},
+			Transform: etlx.Stage{Driver: "mapper", Args: []driver.Command{
+				{Name: "id", Type: "json", Value: map[string]interface{}{"column": "id", "type": "int"}},
+			}},
+			Load: etlx.Stage{Driver: "load", DataSource: "out"},
+		}
+		return load, job.Run(etlx.UseRegistry(r), etlx.DetectDrift(store, "drift", policy))
+	}
+
+	if _, err := run([]string{"id", "name"}, [][]interface{}{{"1", "a"}, {"2", "b"}}, etlx.DriftFail); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	//the mapper passes the added column through
+	added := [][]interface{}{{"1", "a", "1.5"}, {"2", "b", "2"}}
+	load, err := run([]string{"id", "name", "extra"}, added, etlx.DriftAutoMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns, rows := load.Loaded(); !reflect.DeepEqual(columns, []string{"id", "extra"}) || len(rows) != 2 {
+		t.Errorf("loaded %v %v, want the columns id and extra", columns, rows)
+	}
+	//the schema drifted is saved for the next run
+	if _, err := run([]string{"id", "name", "extra"}, added, etlx.DriftFail); err != nil {
+		t.Errorf("the schema of the run by DriftAutoMap is not saved: %v", err)
+	}
+
+	_, err = run([]string{"id", "extra"}, [][]interface{}{{"x", "1.5"}}, etlx.DriftFail)
+	if err == nil || !strings.Contains(err.Error(), "removed name") || !strings.Contains(err.Error(), "retyped id(int -> string)") {
+		t.Errorf("DriftFail returned %v, want the removed and retyped columns", err)
+	}
+}
+
+func TestDriftNullThenTyped(t *testing.T) {
+	store := etlx.NewFileSchemaStore(t.TempDir())
+	run := func(rows [][]interface{}) error {
+		r := etlx.NewRegistry()
+		r.ExtractRegister("extract", &etlxtest.MockExtract{Columns: []string{"id", "x"}, Rows: rows})
+		r.TransformRegister("filter", etlx.FindTransform("filter"))
+		r.LoadRegister("load", &etlxtest.MockLoad{})
+		job := &etlx.Job{
+			Name:      "drift",
+			Extract:   etlx.Stage{Driver: "extract", DataSource: "in"},
+			Transform: etlx.Stage{Driver: "filter", Args: []driver.Command{{Name: "filter", Value: "id > 0"}}},
+			Load:      etlx.Stage{Driver: "load", DataSource: "out"},
+		}
+		return job.Run(etlx.UseRegistry(r), etlx.DetectDrift(store, "drift", etlx.DriftFail))
+	}
+
+	//a column of nulls only is of unknown type, not retyped once it has values
+	if err := run([][]interface{}{{"1", nil}, {"2", nil}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([][]interface{}{{"1", "5"}, {"2", nil}}); err != nil {
+		t.Errorf("the column of nulls is reported as drifted: %v", err)
+	}
+	if err := run([][]interface{}{{"1", "a"}, {"2", nil}}); err == nil || !strings.Contains(err.Error(), "retyped x(int -> string)") {
+		t.Errorf("DriftFail returned %v, want x retyped", err)
+	}
+}
+
+func TestExpandJob(t *testing.T) {
+	ext := &etlxtest.MockExtract{Columns: []string{"id"}, Rows: [][]interface{}{{1}, {5}, {9}}}
+	load := &sourceLoad{}
+	r := etlx.NewRegistry()
+	r.ExtractRegister("extract", ext)
+	r.TransformRegister("filter", etlx.FindTransform("filter"))
+	r.LoadRegister("load", load)
+
+	job := &etlx.Job{
+		Name:    "expand",
+		Extract: etlx.Stage{Driver: "extract", DataSource: "orders_${region}"},
+		Transform: etlx.Stage{Driver: "filter", Args: []driver.Command{
+			{Name: "filter", Type: "string", Value: "id > ${min}"},
+		}},
+		Load: etlx.Stage{Driver: "load", DataSource: "{{ .region }}_${run_date | 2006}"},
+	}
+	expander := driver.NewExpander(map[string]string{"region": "cn", "min": "3"})
+	if err := job.Run(etlx.UseRegistry(r), etlx.Expand(expander)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ext.Handlers[0].DataSource; got != "orders_cn" {
+		t.Errorf("extract opened with %s", got)
+	}
+	if want := []string{fmt.Sprintf("cn_%d", expander.RunDate.Year())}; !reflect.DeepEqual(load.sources, want) {
+		t.Errorf("load opened with %v, want %v", load.sources, want)
+	}
+	if _, rows := load.Loaded(); len(rows) != 2 {
+		t.Errorf("loaded %v", rows)
+	}
+
+	job.Extract.DataSource = "orders_${nope}"
+	if err := job.Run(etlx.UseRegistry(r), etlx.Expand(expander)); err == nil {
+		t.Error("an undefined variable should fail the job")
+	}
+}
+
+type unreachableExtract struct{}
+
+func (unreachableExtract) Open(name, dataSource string) (driver.Extract, error) {
+	return nil, fmt.Errorf("could not connect to %s", dataSource)
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	r := etlx.NewRegistry()
+	r.ExtractRegister("unreachable", unreachableExtract{})
+	r.TransformRegister("filter", etlx.FindTransform("filter"))
+	r.LoadRegister("load", &etlxtest.MockLoad{})
+
+	tx, err := etlx.Open("unreachable", "filter", "load", etlx.UseRegistry(r),
+		etlx.Secrets(testSecrets{"pw": "hunter2"}), etlx.Expand(driver.NewExpander(map[string]string{"db": "sales"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.ExtractOpen("x", "x", "pg://u:secret://pw@h/${db}?key=${secret.pw}")
+	if err == nil {
+		t.Fatal("opened the unreachable extract")
+	}
+	if strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "/sales") {
+		t.Errorf("the error is not redacted: %v", err)
+	}
+	//the data sources kept by the transaction are redacted
+	if dump := fmt.Sprintf("%+v", tx); strings.Contains(dump, "u:hunter2") || strings.Contains(dump, "key=hunter2") {
+		t.Errorf("the secret is in the data source of the transaction: %s", dump)
+	}
+	if err := tx.ExtractOpen("x", "x", "pg://secret://missing@h"); err == nil {
+		t.Error("opened with a missing secret")
+	}
+}
+
+func TestUseRegistryNil(t *testing.T) {
+	load := &etlxtest.MockLoad{}
+	etlx.DefaultRegistry.ReplaceExtract("nil_registry_extract", &etlxtest.MockExtract{Columns: []string{"id"}, Rows: [][]interface{}{{1}, {2}}})
+	etlx.DefaultRegistry.ReplaceTransform("nil_registry_transform", &etlxtest.MockTransform{})
+	etlx.DefaultRegistry.ReplaceLoad("nil_registry_load", load)
+
+	job := &etlx.Job{
+		Name:      "nil_registry",
+		Extract:   etlx.Stage{Driver: "nil_registry_extract", DataSource: "in"},
+		Transform: etlx.Stage{Driver: "nil_registry_transform"},
+		Load:      etlx.Stage{Driver: "nil_registry_load", DataSource: "out"},
+	}
+	if err := job.Run(etlx.UseRegistry(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, rows := load.Loaded(); len(rows) != 2 {
+		t.Errorf("loaded %v", rows)
+	}
+}
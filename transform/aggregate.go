@@ -0,0 +1,685 @@
+package transform
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+func init() {
+	etlx.TransformRegister("aggregate", &aggregateDriver{}, driver.DriverInfo{
+		Description:  "group rows and compute aggregations",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema},
+	})
+}
+
+type aggregateDriver struct{}
+
+func (d *aggregateDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "aggregate groups rows by the key columns and computes the aggregations for every group.",
+		Commands: []driver.CommandSpec{
+			{Name: "group_by", Type: "list", Doc: "key columns of the groups"},
+			{Name: "aggregate", Type: "complex", Doc: "output columns", Items: []driver.CommandSpec{
+				{Name: "*", Type: "string", Doc: "function(column), one of count, sum, min, max, avg, count_distinct, first, last, string_agg"},
+			}},
+			{Name: "max_groups", Type: "int", Doc: "groups kept in memory before spilling"},
+			{Name: "spill_dir", Type: "string", Default: "the temporary directory", Doc: "directory of the spilled groups"},
+		},
+	}
+}
+
+func (d *aggregateDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &aggregate{name: name}, nil
+}
+
+//aggregate groups rows by the key columns and computes the aggregations for
+//every group. Commands:
+//	{"name": "group_by", "type": "list", "value": ["region", "city"]}
+//	{"name": "aggregate", "type": "complex", "value": [
+//		{"name": "orders", "type": "string", "value": "count(*)"},
+//		{"name": "amount", "type": "string", "value": "sum(amount)"},
+//		{"name": "names", "type": "string", "value": "string_agg(name, ',')"}
+//	]}
+//	{"name": "max_groups", "type": "int", "value": 100000}
+//	{"name": "spill_dir", "type": "string", "value": "/tmp"}
+//Supported functions are count, sum, min, max, avg, count_distinct, first,
+//last and string_agg. When the groups exceed max_groups they are spilled to
+//files under spill_dir.
+//
+//In batch mode the groups are accumulated across all batches and the results
+//are produced once all batches are extracted. The batches are ordered by their
+//offset, so first, last and string_agg are the same as without batch. Rows of
+//driver.ColumnarRows are aggregated by batches.
+type aggregate struct {
+	name string
+
+	mu    sync.Mutex
+	table *spillTable
+	seq   int64
+}
+
+type aggCommand struct {
+	groupBy   []string
+	aggs      []aggSpec
+	maxGroups int
+	spillDir  string
+}
+
+type aggSpec struct {
+	output string
+	fn     string
+	column string
+	sep    string
+}
+
+//aggGroup is the state of a group, exported fields are gob encoded when spilled.
+type aggGroup struct {
+	Key    []interface{}
+	Values []aggValue
+}
+
+type aggValue struct {
+	Count     int64
+	Sum       float64
+	IntSum    int64
+	IsFloat   bool
+	DecSum    driver.Decimal
+	IsDecimal bool
+	Min       interface{}
+	Max       interface{}
+	First     interface{}
+	FirstSeq  int64
+	Last      interface{}
+	LastSeq   int64
+	HasValue  bool
+	Distinct  map[string]bool
+	Strings   []seqString
+}
+
+type seqString struct {
+	Seq   int64
+	Value string
+}
+
+var aggPattern = regexp.MustCompile(`^\s*(\w+)\s*\((.*)\)\s*$`)
+
+func parseAggSpec(output, src string) (aggSpec, error) {
+	match := aggPattern.FindStringSubmatch(src)
+	if match == nil {
+		return aggSpec{}, fmt.Errorf("aggregate: invalid aggregation %q", src)
+	}
+
+	spec := aggSpec{output: output, fn: strings.ToLower(match[1])}
+	args := strings.TrimSpace(match[2])
+	if strings.HasPrefix(args, "`") {
+		end := strings.Index(args[1:], "`")
+		if end < 0 {
+			return aggSpec{}, fmt.Errorf("aggregate: unterminated ` in %q", src)
+		}
+		spec.column = args[1 : end+1]
+		args = strings.TrimSpace(args[end+2:])
+	} else if pos := strings.Index(args, ","); pos >= 0 {
+		spec.column = strings.TrimSpace(args[:pos])
+		args = args[pos:]
+	} else {
+		spec.column = args
+		args = ""
+	}
+
+	if args != "" {
+		if !strings.HasPrefix(args, ",") {
+			return aggSpec{}, fmt.Errorf("aggregate: invalid arguments in %q", src)
+		}
+		sep := strings.TrimSpace(args[1:])
+		if len(sep) >= 2 && (sep[0] == '\'' || sep[0] == '"') && sep[len(sep)-1] == sep[0] {
+			sep = sep[1 : len(sep)-1]
+		}
+		spec.sep = sep
+	}
+
+	switch spec.fn {
+	case "count":
+	case "sum", "min", "max", "avg", "count_distinct", "first", "last", "string_agg":
+		if spec.column == "" || spec.column == "*" {
+			return aggSpec{}, fmt.Errorf("aggregate: %s should provide a column", spec.fn)
+		}
+	default:
+		return aggSpec{}, fmt.Errorf("aggregate: unsupported function %s", spec.fn)
+	}
+	if spec.fn == "string_agg" && args == "" {
+		spec.sep = ","
+	}
+	return spec, nil
+}
+
+func (a *aggregate) Command(args []driver.Command) (interface{}, error) {
+	cmd := &aggCommand{spillDir: os.TempDir()}
+	for _, arg := range args {
+		switch arg.Name {
+		case "group_by":
+			cols, err := stringList(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.groupBy = cols
+		case "aggregate":
+			items, ok := arg.Value.([]driver.Command)
+			if !ok {
+				return nil, fmt.Errorf("aggregate: command aggregate should be complex")
+			}
+			for _, item := range items {
+				src, err := commandString(item)
+				if err != nil {
+					return nil, err
+				}
+				spec, err := parseAggSpec(item.Name, src)
+				if err != nil {
+					return nil, err
+				}
+				cmd.aggs = append(cmd.aggs, spec)
+			}
+		case "max_groups":
+			limit, err := driver.IntFromInterface(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			cmd.maxGroups = int(limit)
+		case "spill_dir":
+			dir, err := commandString(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.spillDir = dir
+		default:
+			return nil, fmt.Errorf("aggregate: unsupported command %s", arg.Name)
+		}
+	}
+
+	if len(cmd.aggs) == 0 && len(cmd.groupBy) == 0 {
+		return nil, fmt.Errorf("aggregate: should provide group_by or aggregate")
+	}
+	return cmd, nil
+}
+
+func newAggTable(cmd *aggCommand) *spillTable {
+	return newSpillTable(cmd.maxGroups, cmd.spillDir,
+		func() interface{} { return &aggGroup{} },
+		func(dst, src interface{}) { mergeGroup(cmd.aggs, dst.(*aggGroup), src.(*aggGroup)) })
+}
+
+func (a *aggregate) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	aggCmd, ok := cmd.(*aggCommand)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: invalid command %v", cmd)
+	}
+
+	table := newAggTable(aggCmd)
+	var seq int64
+	if err := accumulateGroups(table, aggCmd, src, &seq); err != nil {
+		table.close()
+		return nil, err
+	}
+	return groupResults(table, aggCmd)
+}
+
+func (a *aggregate) Accumulate(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	aggCmd, ok := cmd.(*aggCommand)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: invalid command %v", cmd)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.table == nil {
+		a.table = newAggTable(aggCmd)
+	}
+	return nil, accumulateGroups(a.table, aggCmd, src, &a.seq)
+}
+
+//AccumulateAt accumulates the batch extracted from offset, the rows are
+//ordered by their offset for first, last and string_agg whatever order the
+//batches are accumulated in.
+func (a *aggregate) AccumulateAt(src driver.Rows, cmd interface{}, offset int64) (driver.Results, error) {
+	aggCmd, ok := cmd.(*aggCommand)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: invalid command %v", cmd)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.table == nil {
+		a.table = newAggTable(aggCmd)
+	}
+	seq := offset
+	return nil, accumulateGroups(a.table, aggCmd, src, &seq)
+}
+
+func (a *aggregate) Flush(cmd interface{}) (driver.Results, error) {
+	aggCmd, ok := cmd.(*aggCommand)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: invalid command %v", cmd)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	table := a.table
+	a.table = nil
+	a.seq = 0
+	if table == nil {
+		table = newAggTable(aggCmd)
+	}
+	return groupResults(table, aggCmd)
+}
+
+func (a *aggregate) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.table != nil {
+		a.table.close()
+		a.table = nil
+	}
+	return nil
+}
+
+func columnIndex(columns []string, names []string) ([]int, error) {
+	index := make(map[string]int, len(columns))
+	for i, name := range columns {
+		index[name] = i
+	}
+
+	rslt := make([]int, len(names))
+	for i, name := range names {
+		pos, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("column %s is not in the source", name)
+		}
+		rslt[i] = pos
+	}
+	return rslt, nil
+}
+
+func accumulateGroups(table *spillTable, cmd *aggCommand, src driver.Rows, seq *int64) error {
+	columns := src.Columns()
+	keyIdx, err := columnIndex(columns, cmd.groupBy)
+	if err != nil {
+		return fmt.Errorf("aggregate: %v", err)
+	}
+
+	aggIdx := make([]int, len(cmd.aggs))
+	for i, spec := range cmd.aggs {
+		aggIdx[i] = -1
+		if spec.column == "" || spec.column == "*" {
+			continue
+		}
+		idx, err := columnIndex(columns, []string{spec.column})
+		if err != nil {
+			return fmt.Errorf("aggregate: %v", err)
+		}
+		aggIdx[i] = idx[0]
+	}
+
+	if cr, ok := src.(driver.ColumnarRows); ok {
+		return eachBatch(cr, func(batch *driver.ColumnBatch) error {
+			return accumulateBatch(table, cmd, batch, keyIdx, aggIdx, seq)
+		})
+	}
+
+	return eachRow(src, func(row []interface{}) error {
+		key := make([]interface{}, len(keyIdx))
+		for i, idx := range keyIdx {
+			key[i] = row[idx]
+		}
+
+		entry, err := table.get(keyString(key))
+		if err != nil {
+			return err
+		}
+		group := entry.(*aggGroup)
+		if group.Values == nil {
+			group.Key = key
+			group.Values = make([]aggValue, len(cmd.aggs))
+		}
+
+		*seq++
+		for i, spec := range cmd.aggs {
+			var val interface{}
+			if aggIdx[i] >= 0 {
+				val = row[aggIdx[i]]
+			}
+			if err := group.Values[i].add(spec, val, *seq); err != nil {
+				return fmt.Errorf("aggregate: %s: %v", spec.output, err)
+			}
+		}
+		return nil
+	})
+}
+
+//accumulateBatch is the vectorized accumulateGroups, count, sum and avg of int
+//and float vectors are added from the typed slices without boxing the values.
+func accumulateBatch(table *spillTable, cmd *aggCommand, batch *driver.ColumnBatch, keyIdx, aggIdx []int, seq *int64) error {
+	for row := 0; row < batch.Len(); row++ {
+		key := make([]interface{}, len(keyIdx))
+		for i, idx := range keyIdx {
+			key[i] = batch.Vectors[idx].Value(row)
+		}
+
+		entry, err := table.get(keyString(key))
+		if err != nil {
+			return err
+		}
+		group := entry.(*aggGroup)
+		if group.Values == nil {
+			group.Key = key
+			group.Values = make([]aggValue, len(cmd.aggs))
+		}
+
+		*seq++
+		for i, spec := range cmd.aggs {
+			v := &group.Values[i]
+			if aggIdx[i] < 0 {
+				v.Count++
+				continue
+			}
+			vec := batch.Vectors[aggIdx[i]]
+			if vec.IsNull(row) {
+				continue
+			}
+
+			numeric := spec.fn == "count" || spec.fn == "sum" || spec.fn == "avg"
+			switch {
+			case numeric && vec.Type == driver.TypeInt:
+				v.Count++
+				if spec.fn != "count" {
+					v.addInt(vec.Ints[row])
+				}
+				v.HasValue = true
+			case numeric && vec.Type == driver.TypeFloat:
+				v.Count++
+				if spec.fn != "count" {
+					v.addFloat(vec.Floats[row])
+				}
+				v.HasValue = true
+			default:
+				if err := v.add(spec, vec.Value(row), *seq); err != nil {
+					return fmt.Errorf("aggregate: %s: %v", spec.output, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (v *aggValue) add(spec aggSpec, val interface{}, seq int64) error {
+	if spec.column == "" || spec.column == "*" {
+		v.Count++
+		return nil
+	}
+	if val == nil {
+		return nil
+	}
+
+	v.Count++
+	switch spec.fn {
+	case "sum", "avg":
+		if ival, ok := val.(int64); ok {
+			v.addInt(ival)
+		} else if ival, ok := val.(int); ok {
+			v.addInt(int64(ival))
+		} else if dval, ok := val.(driver.Decimal); ok {
+			v.addDecimal(dval)
+		} else {
+			fval, err := driver.FloatFromInterface(val)
+			if err != nil {
+				return err
+			}
+			v.addFloat(fval)
+		}
+	case "min":
+		if !v.HasValue || compareValues(val, v.Min) < 0 {
+			v.Min = val
+		}
+	case "max":
+		if !v.HasValue || compareValues(val, v.Max) > 0 {
+			v.Max = val
+		}
+	case "first":
+		if !v.HasValue || seq < v.FirstSeq {
+			v.First, v.FirstSeq = val, seq
+		}
+	case "last":
+		if !v.HasValue || seq > v.LastSeq {
+			v.Last, v.LastSeq = val, seq
+		}
+	case "count_distinct":
+		if v.Distinct == nil {
+			v.Distinct = make(map[string]bool)
+		}
+		v.Distinct[keyString([]interface{}{val})] = true
+	case "string_agg":
+		str, err := driver.StringFromInterface(val)
+		if err != nil {
+			return err
+		}
+		v.Strings = append(v.Strings, seqString{Seq: seq, Value: str})
+	}
+	v.HasValue = true
+	return nil
+}
+
+//addInt adds to the sum, it is kept as int until a decimal or float is added.
+func (v *aggValue) addInt(val int64) {
+	switch {
+	case v.IsFloat:
+		v.Sum += float64(val)
+	case v.IsDecimal:
+		v.DecSum = v.DecSum.Add(driver.NewDecimal(val, 0))
+	default:
+		v.IntSum += val
+	}
+}
+
+//addDecimal adds to the sum, it is kept as decimal until a float is added.
+func (v *aggValue) addDecimal(val driver.Decimal) {
+	if v.IsFloat {
+		v.Sum += val.Float64()
+		return
+	}
+	if !v.IsDecimal {
+		v.IsDecimal = true
+		v.DecSum = driver.NewDecimal(v.IntSum, 0)
+	}
+	v.DecSum = v.DecSum.Add(val)
+}
+
+func (v *aggValue) addFloat(val float64) {
+	if !v.IsFloat {
+		v.IsFloat = true
+		v.Sum = v.floatSum()
+	}
+	v.Sum += val
+}
+
+func (v *aggValue) floatSum() float64 {
+	switch {
+	case v.IsFloat:
+		return v.Sum
+	case v.IsDecimal:
+		return v.DecSum.Float64()
+	}
+	return float64(v.IntSum)
+}
+
+func mergeGroup(specs []aggSpec, dst, src *aggGroup) {
+	if dst.Values == nil {
+		*dst = *src
+		return
+	}
+	for i, spec := range specs {
+		dst.Values[i].merge(spec, &src.Values[i])
+	}
+}
+
+//merge merges the value of the same group accumulated apart, e.g. spilled.
+func (d *aggValue) merge(spec aggSpec, s *aggValue) {
+	if !s.HasValue && s.Count == 0 {
+		return
+	}
+	d.Count += s.Count
+	switch spec.fn {
+	case "sum", "avg":
+		switch {
+		case s.IsFloat:
+			d.addFloat(s.Sum)
+		case s.IsDecimal:
+			d.addDecimal(s.DecSum)
+		default:
+			d.addInt(s.IntSum)
+		}
+	case "min":
+		if !d.HasValue || compareValues(s.Min, d.Min) < 0 {
+			d.Min = s.Min
+		}
+	case "max":
+		if !d.HasValue || compareValues(s.Max, d.Max) > 0 {
+			d.Max = s.Max
+		}
+	case "first":
+		if !d.HasValue || s.FirstSeq < d.FirstSeq {
+			d.First, d.FirstSeq = s.First, s.FirstSeq
+		}
+	case "last":
+		if !d.HasValue || s.LastSeq > d.LastSeq {
+			d.Last, d.LastSeq = s.Last, s.LastSeq
+		}
+	case "count_distinct":
+		if d.Distinct == nil {
+			d.Distinct = make(map[string]bool)
+		}
+		for k := range s.Distinct {
+			d.Distinct[k] = true
+		}
+	case "string_agg":
+		d.Strings = append(d.Strings, s.Strings...)
+	}
+	d.HasValue = d.HasValue || s.HasValue
+}
+
+func (v *aggValue) result(spec aggSpec) interface{} {
+	switch spec.fn {
+	case "count":
+		return v.Count
+	case "sum":
+		if !v.HasValue {
+			return nil
+		}
+		if v.IsFloat {
+			return v.Sum
+		}
+		if v.IsDecimal {
+			return v.DecSum
+		}
+		return v.IntSum
+	case "avg":
+		if v.Count == 0 {
+			return nil
+		}
+		return v.floatSum() / float64(v.Count)
+	case "min":
+		return v.Min
+	case "max":
+		return v.Max
+	case "first":
+		return v.First
+	case "last":
+		return v.Last
+	case "count_distinct":
+		return int64(len(v.Distinct))
+	case "string_agg":
+		if !v.HasValue {
+			return nil
+		}
+		sort.SliceStable(v.Strings, func(i, j int) bool { return v.Strings[i].Seq < v.Strings[j].Seq })
+		strs := make([]string, len(v.Strings))
+		for i, s := range v.Strings {
+			strs[i] = s.Value
+		}
+		return strings.Join(strs, spec.sep)
+	}
+	return nil
+}
+
+func groupResults(table *spillTable, cmd *aggCommand) (driver.Results, error) {
+	columns := append([]string{}, cmd.groupBy...)
+	for _, spec := range cmd.aggs {
+		columns = append(columns, spec.output)
+	}
+
+	rslt := newResults(columns)
+	err := table.each(func(key string, entry interface{}) error {
+		group := entry.(*aggGroup)
+		row := make([]interface{}, 0, len(columns))
+		row = append(row, group.Key...)
+		for i, spec := range cmd.aggs {
+			row = append(row, group.Values[i].result(spec))
+		}
+		rslt.AppendData(row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rslt, nil
+}
+
+func (a *aggregate) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	aggCmd, ok := cmd.(*aggCommand)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("aggregate: invalid command %v", cmd)
+	}
+
+	fields, err := schemaFields(in, aggCmd.groupBy)
+	if err != nil {
+		return driver.Schema{}, fmt.Errorf("aggregate: %v", err)
+	}
+
+	for _, spec := range aggCmd.aggs {
+		field := driver.Field{Name: spec.output, Type: driver.TypeAny, Nullable: true}
+		src := driver.Field{Type: driver.TypeAny, Nullable: true}
+		if spec.column != "" && spec.column != "*" {
+			srcFields, err := schemaFields(in, []string{spec.column})
+			if err != nil {
+				return driver.Schema{}, fmt.Errorf("aggregate: %v", err)
+			}
+			src = srcFields[0]
+		}
+
+		switch spec.fn {
+		case "count", "count_distinct":
+			field.Type, field.Nullable = driver.TypeInt, false
+		case "sum":
+			field.Type = driver.TypeFloat
+			if src.Type == driver.TypeInt || src.Type == driver.TypeDecimal {
+				field.Type = src.Type
+			}
+		case "avg":
+			field.Type = driver.TypeFloat
+		case "string_agg":
+			field.Type = driver.TypeString
+		default:
+			field.Type, field.Layout = src.Type, src.Layout
+		}
+		fields = append(fields, field)
+	}
+	return driver.Schema{Fields: fields}, nil
+}
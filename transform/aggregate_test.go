@@ -0,0 +1,151 @@
+package transform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func TestAggregateAccumulateAt(t *testing.T) {
+	batches := [][][]interface{}{
+		{{"a", "1"}, {"b", "2"}},
+		{{"a", "3"}, {"b", "4"}},
+		{{"a", "5"}},
+	}
+	a := &aggregate{}
+	cmd, err := a.Command([]driver.Command{
+		{Name: "group_by", Value: []interface{}{"group"}},
+		{Name: "aggregate", Value: []driver.Command{
+			{Name: "first", Value: "first(name)"},
+			{Name: "last", Value: "last(name)"},
+			{Name: "names", Value: "string_agg(name, ',')"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the batches accumulated in reverse order
+	for i := len(batches) - 1; i >= 0; i-- {
+		src := driver.NewTable(0)
+		src.SetColumns([]string{"group", "name"})
+		src.SetData(batches[i])
+		if _, err := a.AccumulateAt(src, cmd, int64(2*i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rslt, err := a.Flush(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[interface{}][]interface{}{}
+	for {
+		row := make([]interface{}, len(rslt.Columns()))
+		if err := rslt.Next(row); err == driver.EOT {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got[row[0]] = row[1:]
+	}
+	want := map[interface{}][]interface{}{
+		"a": {"1", "5", "1,3,5"},
+		"b": {"2", "4", "2,4"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func aggregateGroups(t *testing.T, args []driver.Command, batches ...[][]interface{}) map[interface{}][]interface{} {
+	a := &aggregate{}
+	cmd, err := a.Command(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, batch := range batches {
+		if _, err := a.Accumulate(newRows([]string{"region", "amount", "name"}, batch), cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rslt, err := a.Flush(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[interface{}][]interface{}{}
+	for _, row := range readRows(t, rslt) {
+		groups[row[0]] = row[1:]
+	}
+	return groups
+}
+
+func TestAggregateFunctions(t *testing.T) {
+	data := [][]interface{}{
+		{"n", int64(1), "a"},
+		{"s", 2.5, "b"},
+		{"e", int64(3), "c"},
+		{"n", int64(4), "d"},
+		{"w", nil, "e"},
+		{"s", "1,000", "b"},
+	}
+	args := func(extra ...driver.Command) []driver.Command {
+		return append([]driver.Command{
+			{Name: "group_by", Value: []interface{}{"region"}},
+			{Name: "aggregate", Value: []driver.Command{
+				{Name: "cnt", Value: "count(*)"},
+				{Name: "sum", Value: "sum(amount)"},
+				{Name: "avg", Value: "avg(amount)"},
+				{Name: "min", Value: "min(amount)"},
+				{Name: "dn", Value: "count_distinct(name)"},
+				{Name: "names", Value: "string_agg(name, '|')"},
+			}},
+		}, extra...)
+	}
+
+	want := map[interface{}][]interface{}{
+		"n": {"2", "5", "2.5", "1", "2", "a|d"},
+		"s": {"2", "1002.5", "501.25", "2.5", "1", "b|b"},
+		"e": {"1", "3", "3", "3", "1", "c"},
+		"w": {"1", "<nil>", "<nil>", "<nil>", "1", "e"},
+	}
+	text := func(groups map[interface{}][]interface{}) map[interface{}][]interface{} {
+		for key, vals := range groups {
+			for i, val := range vals {
+				vals[i] = fmt.Sprint(val)
+			}
+			groups[key] = vals
+		}
+		return groups
+	}
+	if got := text(aggregateGroups(t, args(), data)); !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregate = %v, want %v", got, want)
+	}
+
+	//the groups spilled to disk are merged back
+	dir := t.TempDir()
+	spilled := args(driver.Command{Name: "max_groups", Value: int64(1)}, driver.Command{Name: "spill_dir", Value: dir})
+	if got := text(aggregateGroups(t, spilled, data[:3], data[3:])); !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregate spilled = %v, want %v", got, want)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("the spill files are left: %v, %v", files, err)
+	}
+}
+
+func TestAggregateCommandErrors(t *testing.T) {
+	a := &aggregate{}
+	for _, args := range [][]driver.Command{
+		{{Name: "aggregate", Value: []driver.Command{{Name: "x", Value: "median(amount)"}}}},
+		{{Name: "aggregate", Value: []driver.Command{{Name: "x", Value: "sum amount"}}}},
+		{{Name: "max_groups", Value: "many"}},
+		{{Name: "nosuch", Value: "x"}},
+	} {
+		if _, err := a.Command(args); err == nil {
+			t.Errorf("Command(%v) succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+//TestCommandSchemas checks the schemas of the transforms accept the commands
+//their Command accepts, and reject what it rejects.
+func TestCommandSchemas(t *testing.T) {
+	tests := []struct {
+		driver string
+		cmds   []driver.Command
+		err    string
+	}{
+		{"sort", []driver.Command{{Name: "order_by", Value: []driver.Command{{Name: "a", Value: "asc"}}}, {Name: "max_rows", Value: 10}}, ""},
+		{"sort", []driver.Command{{Name: "oder_by", Value: []driver.Command{{Name: "a", Value: "asc"}}}}, "did you mean order_by?"},
+		{"sort", []driver.Command{{Name: "order_by", Value: []driver.Command{}}, {Name: "max_rows", Value: "lots"}}, "max_rows should be int"},
+		{"dedupe", []driver.Command{{Name: "keep", Value: "middle"}}, "keep should be one of"},
+		{"pivot", []driver.Command{{Name: "key", Value: "k"}, {Name: "value", Value: "v"}, {Name: "max_groups", Value: 2}}, ""},
+		{"pivot", []driver.Command{{Name: "key", Value: "k"}}, "command value is required"},
+		{"aggregate", []driver.Command{{Name: "group_by", Value: []interface{}{"g"}}, {Name: "aggregate", Value: []driver.Command{{Name: "n", Value: "count(*)"}}}}, ""},
+		{"mapper", []driver.Command{{Name: "a", Value: "b"}, {Name: "c", Value: map[string]interface{}{"column": "x"}}}, ""},
+		{"filter", []driver.Command{{Name: "filter", Value: "a > 1"}}, ""},
+	}
+	for _, tt := range tests {
+		err := etlx.ValidateCommands(etlx.PhaseTransform, tt.driver, tt.cmds)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: ValidateCommands(%v) returned %v", tt.driver, tt.cmds, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: ValidateCommands(%v) returned %v, want %q", tt.driver, tt.cmds, err, tt.err)
+		}
+	}
+
+	//the commands are validated before the driver is opened
+	if _, err := etlx.NewTransform("dedupe", "d", "", []driver.Command{{Name: "keep", Value: "middle"}}); err == nil {
+		t.Error("NewTransform should validate the commands")
+	}
+}
@@ -0,0 +1,179 @@
+package transform
+
+import (
+	"fmt"
+
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/expr"
+)
+
+//number of rows read at a time from driver.ColumnarRows
+const columnarBatchSize = 4096
+
+//batchEnv is the expr.Env of a row in a batch, only the columns referenced
+//are boxed.
+type batchEnv struct {
+	batch *driver.ColumnBatch
+	index map[string]int
+	row   int
+}
+
+func newBatchEnv(batch *driver.ColumnBatch) *batchEnv {
+	index := make(map[string]int, len(batch.Columns))
+	for i, name := range batch.Columns {
+		index[name] = i
+	}
+	return &batchEnv{batch: batch, index: index}
+}
+
+func (e *batchEnv) Lookup(name string) (interface{}, bool) {
+	i, ok := e.index[name]
+	if !ok {
+		return nil, false
+	}
+	return e.batch.Vectors[i].Value(e.row), true
+}
+
+//eachBatch reads src by batches until driver.EOT and calls fn with every batch.
+func eachBatch(src driver.ColumnarRows, fn func(batch *driver.ColumnBatch) error) error {
+	for {
+		batch, err := src.NextBatch(columnarBatchSize)
+		if err != nil {
+			if err == driver.EOT {
+				return nil
+			}
+			return err
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+}
+
+//batchSchema returns the schema of the source, or the one of the batch if the
+//source does not declare it.
+func batchSchema(src driver.Rows, batch *driver.ColumnBatch) driver.Schema {
+	if sr, ok := src.(driver.SchemaRows); ok && !sr.Schema().IsEmpty() {
+		return sr.Schema()
+	}
+	if batch != nil {
+		return batch.Schema()
+	}
+	return driver.NewSchema(src.Columns())
+}
+
+//execBatches is the vectorized Exec of filter, only the selected rows are copied.
+func (f *filter) execBatches(src driver.ColumnarRows, progs []*expr.Program) (driver.Results, error) {
+	var rslt *driver.BatchRows
+	err := eachBatch(src, func(batch *driver.ColumnBatch) error {
+		if rslt == nil {
+			rslt = driver.NewBatchRows(batchSchema(src, batch))
+		}
+
+		env := newBatchEnv(batch)
+		sel := make([]int, 0, batch.Len())
+		for i := 0; i < batch.Len(); i++ {
+			env.row = i
+			matched := true
+			for _, prog := range progs {
+				ok, err := prog.EvalBoolEnv(env)
+				if err != nil {
+					return fmt.Errorf("filter: %s: %v", prog, err)
+				}
+				if !ok {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				sel = append(sel, i)
+			}
+		}
+
+		if len(sel) == batch.Len() {
+			rslt.Append(batch)
+		} else {
+			rslt.Append(batch.Select(sel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rslt == nil {
+		rslt = driver.NewBatchRows(batchSchema(src, nil))
+	}
+	return rslt, nil
+}
+
+//execBatches is the vectorized Exec of mapper. A vector already of the type is
+//reused without copy, int to float is converted in the typed slices, and the
+//others are converted value by value as Exec.
+func (m *mapper) execBatches(src driver.ColumnarRows, mappings []mapping) (driver.Results, error) {
+	var rslt *driver.BatchRows
+	err := eachBatch(src, func(batch *driver.ColumnBatch) error {
+		out := &driver.ColumnBatch{
+			Columns: make([]string, len(mappings)),
+			Vectors: make([]*driver.Vector, len(mappings)),
+		}
+		for i, mp := range mappings {
+			vec, ok := batch.Vector(mp.column)
+			if !ok {
+				return fmt.Errorf("mapper: column %s is not in the source", mp.column)
+			}
+			converted, err := mp.convertVector(vec)
+			if err != nil {
+				return fmt.Errorf("mapper: %s: %v", mp.name, err)
+			}
+			out.Columns[i] = mp.name
+			out.Vectors[i] = converted
+		}
+
+		if rslt == nil {
+			rslt = driver.NewBatchRows(out.Schema())
+		}
+		rslt.Append(out)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rslt == nil {
+		columns := make([]string, len(mappings))
+		for i, mp := range mappings {
+			columns[i] = mp.name
+		}
+		rslt = driver.NewBatchRows(driver.NewSchema(columns))
+	}
+	return rslt, nil
+}
+
+func (mp mapping) convertVector(vec *driver.Vector) (*driver.Vector, error) {
+	typ, _ := driver.ParseLogicalType(mp.typ)
+	if typ == driver.TypeAny || (typ == vec.Type && mp.layout == "" && (mp.times == nil || mp.times.Output == nil)) {
+		return vec, nil
+	}
+
+	out := driver.NewVector(typ, vec.Len())
+	if typ == driver.TypeFloat && vec.Type == driver.TypeInt {
+		for i, ival := range vec.Ints {
+			if vec.IsNull(i) {
+				out.AppendNull()
+			} else {
+				out.AppendFloat(float64(ival))
+			}
+		}
+		return out, nil
+	}
+
+	for i := 0; i < vec.Len(); i++ {
+		val, err := mp.convert(vec.Value(i))
+		if err != nil {
+			return nil, err
+		}
+		if err := out.Append(val); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
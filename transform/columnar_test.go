@@ -0,0 +1,77 @@
+package transform
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func columnarData() ([]string, [][]interface{}) {
+	data := [][]interface{}{}
+	for i := 0; i < 1000; i++ {
+		var n interface{} = int64(i)
+		if i%7 == 0 {
+			n = nil
+		}
+		data = append(data, []interface{}{fmt.Sprint(i % 3), n, float64(i) / 2, fmt.Sprint(i)})
+	}
+	return []string{"g", "n", "f", "s"}, data
+}
+
+//TestColumnar checks the transforms give the same results for the rows and
+//the batches of columns.
+func TestColumnar(t *testing.T) {
+	tests := []struct {
+		name     string
+		tr       driver.Transform
+		args     []driver.Command
+		columnar bool
+	}{
+		{"filter", &filter{}, []driver.Command{{Name: "filter", Value: "n > 100 && g == '1'"}}, true},
+		{"mapper", &mapper{}, []driver.Command{
+			{Name: "nf", Value: map[string]interface{}{"column": "n", "type": "float"}},
+			{Name: "s", Value: map[string]interface{}{"column": "s", "type": "int"}},
+			{Name: "g", Value: "g"},
+		}, true},
+		{"aggregate", &aggregate{}, []driver.Command{
+			{Name: "group_by", Value: []interface{}{"g"}},
+			{Name: "aggregate", Value: []driver.Command{
+				{Name: "c", Value: "count(n)"}, {Name: "sn", Value: "sum(n)"},
+				{Name: "af", Value: "avg(f)"}, {Name: "mx", Value: "max(s)"},
+			}},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := tt.tr.Command(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			columns, data := columnarData()
+			want, err := tt.tr.Exec(newRows(columns, data), cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := driver.BatchFromTable(newRows(columnarData()), driver.Schema{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.tr.Exec(driver.NewBatchRows(b.Schema(), b), cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := got.(driver.ColumnarRows); ok != tt.columnar {
+				t.Errorf("the results are columnar: %v, want %v", ok, tt.columnar)
+			}
+			if !reflect.DeepEqual(got.Columns(), want.Columns()) {
+				t.Errorf("columns %v, want %v", got.Columns(), want.Columns())
+			}
+			if g, w := readRows(t, got), readRows(t, want); !reflect.DeepEqual(g, w) {
+				t.Errorf("columnar results differ: %d rows, want %d", len(g), len(w))
+			}
+		})
+	}
+}
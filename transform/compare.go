@@ -0,0 +1,109 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func isNumeric(val interface{}) bool {
+	switch val.(type) {
+	case int, int64, float64, float32, driver.Decimal:
+		return true
+	}
+	return false
+}
+
+//compareValues compares two column values and returns -1, 0 or 1. Numbers are
+//compared by value whatever the type is, time values are compared as time and
+//a string is converted when compared with a number or time. null is the smallest.
+func compareValues(a, b interface{}) int {
+	a, b = driver.DataPreProcess(a), driver.DataPreProcess(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	ai, aok := a.(int64)
+	bi, bok := b.(int64)
+	if aok && bok {
+		return compareOrdered(ai < bi, ai > bi)
+	}
+
+	_, adec := a.(driver.Decimal)
+	_, bdec := b.(driver.Decimal)
+	if adec || bdec {
+		ad, aerr := driver.DecimalFromInterface(a)
+		bd, berr := driver.DecimalFromInterface(b)
+		if aerr == nil && berr == nil {
+			return ad.Cmp(bd)
+		}
+	}
+
+	if isNumeric(a) || isNumeric(b) {
+		af, aerr := driver.FloatFromInterface(a)
+		bf, berr := driver.FloatFromInterface(b)
+		if aerr == nil && berr == nil {
+			return compareOrdered(af < bf, af > bf)
+		}
+	}
+
+	at, aok := a.(time.Time)
+	bt, bok := b.(time.Time)
+	if aok || bok {
+		var err error
+		if !aok {
+			at, err = driver.TimeFromInterface(a, "2006-01-02")
+		} else if !bok {
+			bt, err = driver.TimeFromInterface(b, "2006-01-02")
+		}
+		if err == nil {
+			return compareOrdered(at.Before(bt), at.After(bt))
+		}
+	}
+
+	if ab, ok := a.(bool); ok {
+		if bb, ok := b.(bool); ok {
+			return compareOrdered(!ab && bb, ab && !bb)
+		}
+	}
+
+	as, aerr := driver.StringFromInterface(a)
+	bs, berr := driver.StringFromInterface(b)
+	if aerr != nil || berr != nil {
+		as, bs = fmt.Sprint(a), fmt.Sprint(b)
+	}
+	return strings.Compare(as, bs)
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+//keyString encodes the values as a string which could be used as a map key.
+func keyString(vals []interface{}) string {
+	var buf bytes.Buffer
+	for _, val := range vals {
+		val = driver.DataPreProcess(val)
+		if val == nil {
+			buf.WriteString("<nil>")
+		} else {
+			fmt.Fprintf(&buf, "%T=%v", val, val)
+		}
+		buf.WriteByte(0)
+	}
+	return buf.String()
+}
@@ -0,0 +1,415 @@
+package transform
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+func init() {
+	etlx.TransformRegister("dedupe", &dedupeDriver{}, driver.DriverInfo{
+		Description:  "drop duplicated rows by keys",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema},
+	})
+}
+
+type dedupeDriver struct{}
+
+func (d *dedupeDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &dedupe{name: name}, nil
+}
+
+func (d *dedupeDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "dedupe drops duplicated rows by the key columns, or by the whole row if no key.",
+		Commands: []driver.CommandSpec{
+			{Name: "key", Type: "list", Doc: "key columns"},
+			{Name: "keep", Type: "string", Enum: []string{keepFirst, keepLast, keepMaxBy}, Default: keepFirst, Doc: "which of the duplicated rows is kept"},
+			{Name: "by", Type: "string", Doc: "column compared by max_by"},
+			{Name: "max_keys", Type: "int", Doc: "keys kept in memory before spilling"},
+			{Name: "window", Type: "int", Doc: "only remember the last window keys in memory, keep first only"},
+			{Name: "spill_dir", Type: "string", Default: "the temporary directory", Doc: "directory of the spilled keys"},
+		},
+	}
+}
+
+//dedupe drops duplicated rows. Commands:
+//	{"name": "key", "type": "list", "value": ["id"]}
+//	{"name": "keep", "type": "string", "value": "first"}
+//	{"name": "by", "type": "string", "value": "updated_at"}
+//	{"name": "max_keys", "type": "int", "value": 1000000}
+//	{"name": "spill_dir", "type": "string", "value": "/tmp"}
+//	{"name": "window", "type": "int", "value": 10000}
+//Rows are duplicated when they have the same values of the key columns, or the
+//same hash of the whole row if no key is provided. keep could be first, last
+//or max_by, which keeps the row with the max value of the column by.
+//
+//By default the seen keys are kept until the end of data, and are spilled
+//to files under spill_dir once there are more than max_keys of them. With
+//keep first, the rows are released as soon as they are read and only the
+//hashes of the keys are kept. With keep last or max_by, the row kept for every
+//key is held until the end of data, spilled with its key. The rows kept are
+//then ordered by runs of at most max_keys rows merged from spill_dir, like the
+//sort transform does.
+//
+//With window set, only the last window keys are remembered in memory and
+//rows are released as soon as they are read. Nothing is spilled, so window
+//only supports keep first and could not be used with max_keys or spill_dir.
+//
+//In batch mode the keys are shared by all batches, the rows of all keep are
+//held until the end of data and ordered by the offset of their batch, so the
+//rows kept are the same as without batch. With window set, the rows are
+//released by every batch and the batches are deduplicated in the order they
+//are accumulated.
+type dedupe struct {
+	name string
+
+	mu      sync.Mutex
+	columns []string
+	table   *spillTable
+	keys    *spillSet
+	seen    *windowSet
+	seq     int64
+}
+
+const (
+	keepFirst = "first"
+	keepLast  = "last"
+	keepMaxBy = "max_by"
+)
+
+type dedupeCommand struct {
+	key      []string
+	keep     string
+	by       string
+	maxKeys  int
+	spillDir string
+	window   int
+}
+
+//dedupeEntry is the row kept for a key, exported fields are gob encoded when spilled.
+type dedupeEntry struct {
+	Row []interface{}
+	Seq int64
+}
+
+func (d *dedupe) Command(args []driver.Command) (interface{}, error) {
+	cmd := &dedupeCommand{keep: keepFirst, spillDir: os.TempDir()}
+	spill := false
+	for _, arg := range args {
+		switch arg.Name {
+		case "key":
+			cols, err := stringList(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.key = cols
+		case "keep":
+			keep, err := commandString(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.keep = keep
+		case "by":
+			by, err := commandString(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.by = by
+		case "max_keys", "window":
+			limit, err := driver.IntFromInterface(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			if arg.Name == "window" {
+				cmd.window = int(limit)
+			} else {
+				cmd.maxKeys = int(limit)
+				spill = true
+			}
+		case "spill_dir":
+			dir, err := commandString(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.spillDir = dir
+			spill = true
+		default:
+			return nil, fmt.Errorf("dedupe: unsupported command %s", arg.Name)
+		}
+	}
+
+	switch cmd.keep {
+	case keepFirst, keepLast:
+	case keepMaxBy:
+		if cmd.by == "" {
+			return nil, fmt.Errorf("dedupe: keep max_by should provide the column by")
+		}
+	default:
+		return nil, fmt.Errorf("dedupe: unsupported keep %s", cmd.keep)
+	}
+	if cmd.window > 0 && cmd.keep != keepFirst {
+		return nil, fmt.Errorf("dedupe: window only supports keep first")
+	}
+	if cmd.window > 0 && spill {
+		return nil, fmt.Errorf("dedupe: window is kept in memory, max_keys and spill_dir are not supported")
+	}
+	return cmd, nil
+}
+
+func (d *dedupe) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	dcmd, ok := cmd.(*dedupeCommand)
+	if !ok {
+		return nil, fmt.Errorf("dedupe: invalid command %v", cmd)
+	}
+
+	state := &dedupe{name: d.name}
+	defer state.Close()
+	var seq int64
+	//the first rows are released as they are read
+	rslt, err := state.accumulate(src, dcmd, &seq, dcmd.keep == keepFirst)
+	if err != nil || rslt != nil {
+		return rslt, err
+	}
+	return state.flush(dcmd)
+}
+
+func (d *dedupe) Accumulate(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	dcmd, ok := cmd.(*dedupeCommand)
+	if !ok {
+		return nil, fmt.Errorf("dedupe: invalid command %v", cmd)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.accumulate(src, dcmd, &d.seq, false)
+}
+
+//AccumulateAt accumulates the batch extracted from offset, the rows are
+//ordered by their offset whatever order the batches are accumulated in.
+func (d *dedupe) AccumulateAt(src driver.Rows, cmd interface{}, offset int64) (driver.Results, error) {
+	dcmd, ok := cmd.(*dedupeCommand)
+	if !ok {
+		return nil, fmt.Errorf("dedupe: invalid command %v", cmd)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seq := offset
+	return d.accumulate(src, dcmd, &seq, false)
+}
+
+func (d *dedupe) Flush(cmd interface{}) (driver.Results, error) {
+	dcmd, ok := cmd.(*dedupeCommand)
+	if !ok {
+		return nil, fmt.Errorf("dedupe: invalid command %v", cmd)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dcmd.window > 0 {
+		d.seen = nil
+		return nil, nil
+	}
+	return d.flush(dcmd)
+}
+
+func (d *dedupe) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.table != nil {
+		d.table.close()
+		d.table = nil
+	}
+	if d.keys != nil {
+		d.keys.close()
+		d.keys = nil
+	}
+	d.seen = nil
+	return nil
+}
+
+//accumulate returns the rows released by the window, or by the keys if release
+//is true, otherwise nil and the rows are kept until flush. seq is the number of
+//the rows before src.
+func (d *dedupe) accumulate(src driver.Rows, cmd *dedupeCommand, seq *int64, release bool) (driver.Results, error) {
+	columns := src.Columns()
+	if d.columns == nil {
+		d.columns = columns
+	}
+
+	keyIdx, err := columnIndex(columns, cmd.key)
+	if err != nil {
+		return nil, fmt.Errorf("dedupe: %v", err)
+	}
+	byIdx := -1
+	if cmd.keep == keepMaxBy {
+		idx, err := columnIndex(columns, []string{cmd.by})
+		if err != nil {
+			return nil, fmt.Errorf("dedupe: %v", err)
+		}
+		byIdx = idx[0]
+	}
+
+	var rslt *driver.Table
+	if cmd.window > 0 {
+		rslt = newResults(columns)
+		if d.seen == nil {
+			d.seen = newWindowSet(cmd.window)
+		}
+	} else if release {
+		rslt = newResults(columns)
+		if d.keys == nil {
+			d.keys = newSpillSet(cmd.maxKeys, sha1.Size, cmd.spillDir)
+		}
+	} else if d.table == nil {
+		d.table = newSpillTable(cmd.maxKeys, cmd.spillDir,
+			func() interface{} { return &dedupeEntry{} },
+			func(dst, src interface{}) {
+				keepEntry(cmd, byIdx, dst.(*dedupeEntry), src.(*dedupeEntry))
+			})
+	}
+
+	err = eachRow(src, func(row []interface{}) error {
+		key := dedupeKey(row, keyIdx)
+		*seq++
+
+		if cmd.window > 0 {
+			if d.seen.add(key) {
+				rslt.AppendData(row)
+			}
+			return nil
+		} else if release {
+			added, err := d.keys.add(key)
+			if added {
+				rslt.AppendData(row)
+			}
+			return err
+		}
+
+		entry, err := d.table.get(key)
+		if err != nil {
+			return err
+		}
+		keepEntry(cmd, byIdx, entry.(*dedupeEntry), &dedupeEntry{Row: row, Seq: *seq})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rslt != nil {
+		return rslt, nil
+	}
+	return nil, nil
+}
+
+func (d *dedupe) flush(cmd *dedupeCommand) (driver.Results, error) {
+	table := d.table
+	columns := d.columns
+	d.table, d.columns, d.seq = nil, nil, 0
+
+	if table == nil {
+		return newResults(columns), nil
+	}
+
+	//keep the order of the source by sorting the rows kept on their seq, the
+	//runs are spilled like the keys so the rows are not all held in memory
+	run := &sortRun{
+		cmd:     &sortCommand{keys: []sortKey{{column: "seq"}}, maxRows: cmd.maxKeys, maxFiles: sortFanIn, spillDir: cmd.spillDir},
+		columns: columns,
+	}
+	err := table.each(func(key string, entry interface{}) error {
+		e := entry.(*dedupeEntry)
+		return run.push([]interface{}{e.Seq}, e.Row, e.Seq)
+	})
+	if err != nil {
+		run.close()
+		return nil, err
+	}
+	return run.results()
+}
+
+//keepEntry replaces dst with src if src should be kept according to the command.
+func keepEntry(cmd *dedupeCommand, byIdx int, dst, src *dedupeEntry) {
+	if dst.Row == nil {
+		*dst = *src
+		return
+	}
+
+	replace := false
+	switch cmd.keep {
+	case keepFirst:
+		replace = src.Seq < dst.Seq
+	case keepLast:
+		replace = src.Seq > dst.Seq
+	case keepMaxBy:
+		c := compareValues(src.Row[byIdx], dst.Row[byIdx])
+		replace = c > 0 || (c == 0 && src.Seq < dst.Seq)
+	}
+	if replace {
+		*dst = *src
+	}
+}
+
+//dedupeKey hashes the key columns, or the whole row if no key provided.
+func dedupeKey(row []interface{}, keyIdx []int) string {
+	vals := row
+	if len(keyIdx) > 0 {
+		vals = make([]interface{}, len(keyIdx))
+		for i, idx := range keyIdx {
+			vals[i] = row[idx]
+		}
+	}
+	sum := sha1.Sum([]byte(keyString(vals)))
+	return string(sum[:])
+}
+
+//windowSet remembers the last size keys added.
+type windowSet struct {
+	size  int
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+func newWindowSet(size int) *windowSet {
+	return &windowSet{size: size, order: list.New(), keys: make(map[string]*list.Element)}
+}
+
+//add returns false if the key is already in the window.
+func (w *windowSet) add(key string) bool {
+	if elem, ok := w.keys[key]; ok {
+		w.order.MoveToFront(elem)
+		return false
+	}
+
+	w.keys[key] = w.order.PushFront(key)
+	if w.order.Len() > w.size {
+		oldest := w.order.Back()
+		w.order.Remove(oldest)
+		delete(w.keys, oldest.Value.(string))
+	}
+	return true
+}
+
+func (d *dedupe) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	dcmd, ok := cmd.(*dedupeCommand)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("dedupe: invalid command %v", cmd)
+	}
+
+	names := append([]string{}, dcmd.key...)
+	if dcmd.keep == keepMaxBy {
+		names = append(names, dcmd.by)
+	}
+	if _, err := schemaFields(in, names); err != nil {
+		return driver.Schema{}, fmt.Errorf("dedupe: %v", err)
+	}
+	return in, nil
+}
@@ -0,0 +1,160 @@
+package transform
+
+import (
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+var dedupeColumns = []string{"id", "v", "ts"}
+
+func dedupeData() [][]interface{} {
+	return [][]interface{}{
+		{1, "a", 3}, {2, "b", 1}, {1, "c", 5},
+		{3, "d", 1}, {2, "e", 0}, {3, "d", 1},
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	key := driver.Command{Name: "key", Value: []interface{}{"id"}}
+	tests := []struct {
+		name string
+		args []driver.Command
+		want [][]interface{}
+	}{
+		{"first", []driver.Command{key},
+			[][]interface{}{{1, "a", 3}, {2, "b", 1}, {3, "d", 1}}},
+		{"first spilled", []driver.Command{key, {Name: "max_keys", Value: int64(1)}},
+			[][]interface{}{{1, "a", 3}, {2, "b", 1}, {3, "d", 1}}},
+		{"last", []driver.Command{key, {Name: "keep", Value: "last"}},
+			[][]interface{}{{1, "c", 5}, {2, "e", 0}, {3, "d", 1}}},
+		{"last spilled", []driver.Command{key, {Name: "keep", Value: "last"}, {Name: "max_keys", Value: int64(1)}},
+			[][]interface{}{{1, "c", 5}, {2, "e", 0}, {3, "d", 1}}},
+		{"max_by", []driver.Command{key, {Name: "keep", Value: "max_by"}, {Name: "by", Value: "ts"}},
+			[][]interface{}{{2, "b", 1}, {1, "c", 5}, {3, "d", 1}}},
+		{"whole row", nil,
+			[][]interface{}{{1, "a", 3}, {2, "b", 1}, {1, "c", 5}, {3, "d", 1}, {2, "e", 0}}},
+		{"window", []driver.Command{key, {Name: "window", Value: int64(2)}},
+			[][]interface{}{{1, "a", 3}, {2, "b", 1}, {3, "d", 1}, {2, "e", 0}}},
+	}
+	for _, tt := range tests {
+		d := &dedupe{}
+		cmd, err := d.Command(tt.args)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		rslt, err := d.Exec(newRows(dedupeColumns, dedupeData()), cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := readRows(t, rslt); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDedupeFlushSpilled(t *testing.T) {
+	dir := t.TempDir()
+	data := [][]interface{}{}
+	for i := 0; i < 100; i++ {
+		data = append(data, []interface{}{int64(i % 10), int64(i)})
+	}
+	d := &dedupe{}
+	cmd, err := d.Command([]driver.Command{
+		{Name: "key", Value: []interface{}{"id"}},
+		{Name: "keep", Value: "last"},
+		{Name: "max_keys", Value: int64(3)},
+		{Name: "spill_dir", Value: dir},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rslt, err := d.Exec(newRows([]string{"id", "n"}, data), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the rows kept are merged from the spilled runs, not held in a table
+	if _, ok := rslt.(*driver.Table); ok {
+		t.Error("the rows kept are flushed in memory")
+	}
+	got := readRows(t, rslt)
+	if len(got) != 10 || got[0][1] != int64(90) || got[9][1] != int64(99) {
+		t.Errorf("got %v, want the rows from 90 to 99", got)
+	}
+	rslt.(io.Closer).Close()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d spilled files are left", len(files))
+	}
+}
+
+func TestDedupeBadCommands(t *testing.T) {
+	for _, args := range [][]driver.Command{
+		{{Name: "keep", Value: "max_by"}},
+		{{Name: "keep", Value: "any"}},
+		{{Name: "window", Value: int64(10)}, {Name: "keep", Value: "last"}},
+		{{Name: "window", Value: int64(10)}, {Name: "max_keys", Value: int64(10)}},
+		{{Name: "window", Value: int64(10)}, {Name: "spill_dir", Value: "/tmp"}},
+	} {
+		if _, err := (&dedupe{}).Command(args); err == nil {
+			t.Errorf("%v should be rejected", args)
+		}
+	}
+}
+
+func TestDedupeAccumulateAt(t *testing.T) {
+	key := driver.Command{Name: "key", Value: []interface{}{"id"}}
+	tests := []struct {
+		keep string
+		want [][]interface{}
+	}{
+		{"first", [][]interface{}{{1, "a", 3}, {2, "b", 1}, {3, "d", 1}}},
+		{"last", [][]interface{}{{1, "c", 5}, {2, "e", 0}, {3, "d", 1}}},
+	}
+	for _, tt := range tests {
+		d := &dedupe{}
+		cmd, err := d.Command([]driver.Command{key, {Name: "keep", Value: tt.keep}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		//the batches of 2 rows accumulated in reverse order
+		data := dedupeData()
+		for offset := len(data) - 2; offset >= 0; offset -= 2 {
+			rslt, err := d.AccumulateAt(newRows(dedupeColumns, data[offset:offset+2]), cmd, int64(offset))
+			if err != nil || rslt != nil {
+				t.Fatalf("%s: AccumulateAt returned %v, %v", tt.keep, rslt, err)
+			}
+		}
+		rslt, err := d.Flush(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readRows(t, rslt); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.keep, got, tt.want)
+		}
+	}
+}
+
+func TestSpillSet(t *testing.T) {
+	s := newSpillSet(3, 2, t.TempDir())
+	defer s.close()
+	keys := []string{"kk", "aa", "zz", "bb", "mm", "aa", "cc", "zz", "dd", "kk"}
+	want := []bool{true, true, true, true, true, false, true, false, true, false}
+	for i, key := range keys {
+		added, err := s.add(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != want[i] {
+			t.Errorf("add(%s) = %v, want %v", key, added, want[i])
+		}
+	}
+	if s.file == nil || s.count != 6 {
+		t.Errorf("%d keys spilled", s.count)
+	}
+	if _, err := s.add("too long"); err == nil {
+		t.Error("the key of another size should be rejected")
+	}
+}
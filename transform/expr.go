@@ -0,0 +1,243 @@
+package transform
+
+import (
+	"fmt"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/expr"
+)
+
+func init() {
+	etlx.TransformRegister("filter", &filterDriver{}, driver.DriverInfo{
+		Description:  "keep the rows matching expressions",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema, driver.CapStreaming},
+	})
+	etlx.TransformRegister("compute", &computeDriver{}, driver.DriverInfo{
+		Description:  "add columns computed by expressions",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema, driver.CapStreaming},
+	})
+}
+
+type filterDriver struct{}
+
+func (d *filterDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &filter{name: name}, nil
+}
+
+func (d *filterDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "filter keeps the rows matching all the filter expressions.",
+		Commands: []driver.CommandSpec{
+			{Name: "filter", Type: "string", Doc: "expression the rows should match, could be repeated"},
+		},
+	}
+}
+
+//filter keeps the rows matching all the filter expressions, e.g.
+//	{"name": "filter", "type": "string", "value": "status == 'active' && amount > 0"}
+//Rows of driver.ColumnarRows are filtered by batches and the results are
+//driver.ColumnarRows as well.
+type filter struct {
+	name string
+}
+
+func (f *filter) Command(args []driver.Command) (interface{}, error) {
+	progs := []*expr.Program{}
+	for _, arg := range args {
+		if arg.Name != "filter" {
+			return nil, fmt.Errorf("filter: unsupported command %s", arg.Name)
+		}
+		src, err := commandString(arg)
+		if err != nil {
+			return nil, err
+		}
+		prog, err := expr.Compile(src)
+		if err != nil {
+			return nil, err
+		}
+		progs = append(progs, prog)
+	}
+	return progs, nil
+}
+
+func (f *filter) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	progs, ok := cmd.([]*expr.Program)
+	if !ok {
+		return nil, fmt.Errorf("filter: invalid command %v", cmd)
+	}
+	if cr, ok := src.(driver.ColumnarRows); ok {
+		return f.execBatches(cr, progs)
+	}
+
+	columns := src.Columns()
+	rslt := newResults(columns)
+	err := eachRow(src, func(row []interface{}) error {
+		rowMap, err := driver.ArrayToMap(columns, row)
+		if err != nil {
+			return err
+		}
+		for _, prog := range progs {
+			ok, err := prog.EvalBool(rowMap)
+			if err != nil {
+				return fmt.Errorf("filter: %s: %v", prog, err)
+			}
+			if !ok {
+				return nil
+			}
+		}
+		rslt.AppendData(row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rslt, nil
+}
+
+func (f *filter) Close() error {
+	return nil
+}
+
+type computeDriver struct{}
+
+func (d *computeDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &compute{name: name}, nil
+}
+
+func (d *computeDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "compute adds a column for every command, evaluated in order.",
+		Commands: []driver.CommandSpec{
+			{Name: "*", Type: "string", Doc: "expression of the column named by the command"},
+		},
+	}
+}
+
+//compute adds a column for every command, the name of the command is the
+//column and the value is the expression, e.g.
+//	{"name": "total", "type": "string", "value": "price * qty"}
+//An existing column is replaced when it has the same name. Expressions are
+//evaluated in order, so a later one could use the column computed before.
+type compute struct {
+	name string
+}
+
+type computedColumn struct {
+	name string
+	prog *expr.Program
+}
+
+func (c *compute) Command(args []driver.Command) (interface{}, error) {
+	cols := make([]computedColumn, 0, len(args))
+	for _, arg := range args {
+		if arg.Name == "" {
+			return nil, fmt.Errorf("compute: command should provide the column name")
+		}
+		src, err := commandString(arg)
+		if err != nil {
+			return nil, err
+		}
+		prog, err := expr.Compile(src)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, computedColumn{name: arg.Name, prog: prog})
+	}
+	return cols, nil
+}
+
+func (c *compute) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	cols, ok := cmd.([]computedColumn)
+	if !ok {
+		return nil, fmt.Errorf("compute: invalid command %v", cmd)
+	}
+
+	columns := computedColumns(src.Columns(), cols)
+	rslt := newResults(columns)
+	err := eachRow(src, func(row []interface{}) error {
+		rowMap, err := driver.ArrayToMap(src.Columns(), row)
+		if err != nil {
+			return err
+		}
+		for _, col := range cols {
+			val, err := col.prog.Eval(rowMap)
+			if err != nil {
+				return fmt.Errorf("compute: %s: %v", col.name, err)
+			}
+			rowMap[col.name] = val
+		}
+
+		data, err := driver.MapToArray(columns, rowMap)
+		if err != nil {
+			return err
+		}
+		rslt.AppendData(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rslt, nil
+}
+
+func (c *compute) Close() error {
+	return nil
+}
+
+//computedColumns appends the computed columns which are not in the source.
+func computedColumns(srcColumns []string, cols []computedColumn) []string {
+	columns := append([]string{}, srcColumns...)
+	exist := make(map[string]bool, len(columns))
+	for _, name := range columns {
+		exist[name] = true
+	}
+	for _, col := range cols {
+		if !exist[col.name] {
+			exist[col.name] = true
+			columns = append(columns, col.name)
+		}
+	}
+	return columns
+}
+
+func (f *filter) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	progs, ok := cmd.([]*expr.Program)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("filter: invalid command %v", cmd)
+	}
+	for _, prog := range progs {
+		if _, err := schemaFields(in, prog.Columns()); err != nil {
+			return driver.Schema{}, fmt.Errorf("filter: %s: %v", prog, err)
+		}
+	}
+	return in, nil
+}
+
+//OutputSchema declares the computed columns as TypeAny since the type depends on the values.
+func (c *compute) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	cols, ok := cmd.([]computedColumn)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("compute: invalid command %v", cmd)
+	}
+
+	out := driver.Schema{Fields: append([]driver.Field{}, in.Fields...)}
+	for _, col := range cols {
+		if _, err := schemaFields(out, col.prog.Columns()); err != nil {
+			return driver.Schema{}, fmt.Errorf("compute: %s: %v", col.name, err)
+		}
+
+		field := driver.Field{Name: col.name, Type: driver.TypeAny, Nullable: true}
+		replaced := false
+		for i := range out.Fields {
+			if out.Fields[i].Name == col.name {
+				out.Fields[i] = field
+				replaced = true
+			}
+		}
+		if !replaced {
+			out.Fields = append(out.Fields, field)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package transform
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+var exprColumns = []string{"first", "last", "price", "qty", "status"}
+
+func TestFilter(t *testing.T) {
+	f := &filter{}
+	cmd, err := f.Command([]driver.Command{{Name: "filter", Value: `status == "active" && price * qty > 0`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rslt, err := f.Exec(newRows(exprColumns, [][]interface{}{
+		{"a", "b", 1.5, int64(2), "active"},
+		{"c", "d", "3", 4, "inactive"},
+		{"e", "f", 2.0, 0, "active"},
+	}), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readRows(t, rslt), [][]interface{}{{"a", "b", 1.5, int64(2), "active"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filter = %v, want %v", got, want)
+	}
+
+	for _, args := range [][]driver.Command{
+		{{Name: "where", Value: "price > 1"}},
+		{{Name: "filter", Value: "price >"}},
+		{{Name: "filter", Value: "nosuch(price)"}},
+	} {
+		if _, err := f.Command(args); err == nil {
+			t.Errorf("Command(%v) succeeded", args)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	c := &compute{}
+	cmd, err := c.Command([]driver.Command{
+		{Name: "total", Value: "price * qty"},
+		{Name: "name", Value: "concat(first, ' ', upper(last))"},
+		//the columns computed before are referenced
+		{Name: "flag", Value: "total > 3 ? 'big' : if(total == 0, 'zero', 'small')"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rslt, err := c.Exec(newRows(exprColumns, [][]interface{}{
+		{"a", "b", 1.5, int64(2), "active"},
+		{"c", "d", "3", 4, "inactive"},
+		{"e", "f", 2.0, 0, "active"},
+	}), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]string{}, exprColumns...), "total", "name", "flag"); !reflect.DeepEqual(rslt.Columns(), want) {
+		t.Errorf("Columns = %v, want %v", rslt.Columns(), want)
+	}
+
+	got := [][]interface{}{}
+	for _, row := range readRows(t, rslt) {
+		got = append(got, row[len(exprColumns):])
+	}
+	want := [][]interface{}{
+		{"3", "a B", "small"},
+		{"12", "c D", "big"},
+		{"0", "e F", "zero"},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if fmt.Sprint(got[i][j]) != want[i][j] {
+				t.Errorf("row %d: computed %v, want %v", i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
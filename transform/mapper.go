@@ -0,0 +1,269 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+func init() {
+	etlx.TransformRegister("mapper", &mapperDriver{}, driver.DriverInfo{
+		Description:  "select, rename and convert columns",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema, driver.CapStreaming},
+	})
+}
+
+type mapperDriver struct{}
+
+func (d *mapperDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &mapper{name: name}, nil
+}
+
+func (d *mapperDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "mapper selects, renames and converts columns, every command is an output column in order.",
+		Commands: []driver.CommandSpec{
+			{Name: "*", Type: "string|json",
+				Doc: "the source column, or a json of column, type, layout, layouts, locale, timezone, output_timezone and epoch"},
+		},
+	}
+}
+
+//mapper selects, renames and converts columns. Every command is an output
+//column in order, the name of the command is the column name and the value
+//describes where it comes from:
+//	{"name": "age", "type": "json", "value": {"column": "AGE", "type": "int"}}
+//	{"name": "day", "type": "json", "value": {"column": "day", "type": "time", "layout": "2006/01/02"}}
+//	{"name": "price", "type": "json", "value": {"column": "preis", "type": "float", "locale": "de"}}
+//	{"name": "ts", "type": "json", "value": {"column": "ts", "type": "time",
+//		"layouts": ["02/01/2006 15:04", "2006-01-02"], "timezone": "Asia/Shanghai", "output_timezone": "UTC"}}
+//	{"name": "note", "type": "string", "value": "remark"}
+//The type is the same as driver.StrToType, the value is copied as it is if no
+//type provided. Numbers and times are parsed as written in the locale if it is
+//provided, see driver.LookupLocale. Times are parsed by driver.TimeParser with
+//the layout or layouts in order and then auto detection, the timezone is the
+//one of the values without zone and the epoch ("auto", "unix", "unix_ms" or
+//"excel") decides how numbers are converted. null and empty string are converted to null for all types
+//except string.
+//
+//Rows of driver.ColumnarRows are mapped by batches and the results are
+//driver.ColumnarRows as well.
+type mapper struct {
+	name string
+}
+
+type mapping struct {
+	name   string
+	column string
+	typ    string
+	layout string
+	locale *driver.Locale
+	times  *driver.TimeParser
+}
+
+func (m *mapper) Command(args []driver.Command) (interface{}, error) {
+	mappings := make([]mapping, 0, len(args))
+	for _, arg := range args {
+		if arg.Name == "" {
+			return nil, fmt.Errorf("mapper: command should provide the column name")
+		}
+
+		mp := mapping{name: arg.Name, column: arg.Name}
+		times := driver.NewTimeParser()
+		hasTimeOption := false
+		if str, ok := arg.Value.(string); ok {
+			mp.column = str
+		} else if arg.Value != nil {
+			desc, err := driver.MapFromInterface(arg.Value)
+			if err != nil {
+				return nil, fmt.Errorf("mapper: value of %s should be a column name or a json", arg.Name)
+			}
+			for key, val := range desc {
+				if key == "layouts" {
+					layouts, err := driver.ArrayFromInterface(val)
+					if err != nil {
+						return nil, fmt.Errorf("mapper: layouts of %s should be a list", arg.Name)
+					}
+					for _, layout := range layouts {
+						str, err := driver.StringFromInterface(layout)
+						if err != nil {
+							return nil, fmt.Errorf("mapper: layouts of %s should be strings", arg.Name)
+						}
+						times.Layouts = append(times.Layouts, str)
+					}
+					hasTimeOption = true
+					continue
+				}
+
+				str, err := driver.StringFromInterface(val)
+				if err != nil {
+					return nil, fmt.Errorf("mapper: %s of %s should be a string", key, arg.Name)
+				}
+				switch key {
+				case "column":
+					mp.column = str
+				case "type":
+					mp.typ = str
+				case "layout":
+					mp.layout = str
+				case "locale":
+					if mp.locale, err = driver.LookupLocale(str); err != nil {
+						return nil, fmt.Errorf("mapper: %s: %v", arg.Name, err)
+					}
+				case "timezone", "output_timezone":
+					loc, err := driver.LoadTimezone(str)
+					if err != nil {
+						return nil, fmt.Errorf("mapper: %s of %s: %v", key, arg.Name, err)
+					}
+					if key == "timezone" {
+						times.Location = loc
+					} else {
+						times.Output = loc
+					}
+					hasTimeOption = true
+				case "epoch":
+					if times.Epoch, err = driver.ParseEpoch(str); err != nil {
+						return nil, fmt.Errorf("mapper: %s: %v", arg.Name, err)
+					}
+					hasTimeOption = true
+				default:
+					return nil, fmt.Errorf("mapper: unsupported option %s of %s", key, arg.Name)
+				}
+			}
+		}
+		if _, err := driver.ParseLogicalType(mp.typ); err != nil {
+			return nil, fmt.Errorf("mapper: %s: %v", arg.Name, err)
+		}
+		if mp.typ == "time" {
+			if mp.layout != "" {
+				times.Layouts = append([]string{mp.layout}, times.Layouts...)
+			}
+			times.Locale = mp.locale
+			mp.times = times
+		} else if hasTimeOption {
+			return nil, fmt.Errorf("mapper: layouts, timezone and epoch of %s are only for time", arg.Name)
+		}
+		mappings = append(mappings, mp)
+	}
+	return mappings, nil
+}
+
+func (m *mapper) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	mappings, ok := cmd.([]mapping)
+	if !ok {
+		return nil, fmt.Errorf("mapper: invalid command %v", cmd)
+	}
+	if cr, ok := src.(driver.ColumnarRows); ok {
+		return m.execBatches(cr, mappings)
+	}
+
+	columns := make([]string, len(mappings))
+	srcNames := make([]string, len(mappings))
+	for i, mp := range mappings {
+		columns[i] = mp.name
+		srcNames[i] = mp.column
+	}
+	srcIdx, err := columnIndex(src.Columns(), srcNames)
+	if err != nil {
+		return nil, fmt.Errorf("mapper: %v", err)
+	}
+
+	rslt := newResults(columns)
+	err = eachRow(src, func(row []interface{}) error {
+		data := make([]interface{}, len(mappings))
+		for i, mp := range mappings {
+			val, err := mp.convert(row[srcIdx[i]])
+			if err != nil {
+				return fmt.Errorf("mapper: %s: %v", mp.name, err)
+			}
+			data[i] = val
+		}
+		rslt.AppendData(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rslt, nil
+}
+
+func (mp mapping) convert(val interface{}) (interface{}, error) {
+	if mp.typ == "" || mp.typ == "any" {
+		return val, nil
+	}
+	if val == nil {
+		return nil, nil
+	}
+	if str, ok := val.(string); ok && strings.TrimSpace(str) == "" && mp.typ != "string" {
+		return nil, nil
+	}
+
+	if mp.times != nil {
+		return mp.times.Parse(val)
+	}
+
+	convert := driver.StrToType
+	if mp.locale != nil {
+		convert = mp.locale.Convert
+	}
+	if mp.layout != "" {
+		return convert(mp.typ, val, mp.layout)
+	}
+	return convert(mp.typ, val)
+}
+
+func (m *mapper) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	mappings, ok := cmd.([]mapping)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("mapper: invalid command %v", cmd)
+	}
+
+	fields := make([]driver.Field, len(mappings))
+	for i, mp := range mappings {
+		srcFields, err := schemaFields(in, []string{mp.column})
+		if err != nil {
+			return driver.Schema{}, fmt.Errorf("mapper: %v", err)
+		}
+
+		field := srcFields[0]
+		field.Name = mp.name
+		if mp.typ != "" {
+			field.Type, _ = driver.ParseLogicalType(mp.typ)
+			field.Layout = ""
+		}
+		fields[i] = field
+	}
+	return driver.Schema{Fields: fields}, nil
+}
+
+//PassThrough returns the commands to copy the columns without conversion.
+func (m *mapper) PassThrough(columns []string) []driver.Command {
+	cmds := make([]driver.Command, len(columns))
+	for i, name := range columns {
+		cmds[i] = driver.Command{Name: name, Type: "string", Value: name}
+	}
+	return cmds
+}
+
+func (m *mapper) Close() error {
+	return nil
+}
+
+//MapperCommands returns the commands of the mapper transform which converts
+//every column of the schema to its type, e.g. the one returned by driver.InferSchema.
+func MapperCommands(schema driver.Schema) []driver.Command {
+	cmds := make([]driver.Command, len(schema.Fields))
+	for i, f := range schema.Fields {
+		desc := map[string]interface{}{"column": f.Name}
+		if f.Type != driver.TypeAny {
+			desc["type"] = string(f.Type)
+		}
+		if f.Layout != "" {
+			desc["layout"] = f.Layout
+		}
+		cmds[i] = driver.Command{Name: f.Name, Type: "json", Value: desc}
+	}
+	return cmds
+}
@@ -0,0 +1,93 @@
+package transform
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func TestMapperLocale(t *testing.T) {
+	m := &mapper{}
+	cmd, err := m.Command([]driver.Command{
+		{Name: "price", Value: map[string]interface{}{"column": "preis", "type": "float", "locale": "de"}},
+		{Name: "qty", Value: map[string]interface{}{"column": "menge", "type": "int", "locale": "de_DE"}},
+		{Name: "day", Value: map[string]interface{}{"column": "tag", "type": "time", "locale": "de"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := newRows([]string{"preis", "menge", "tag"}, [][]interface{}{
+		{"1.234,5", "1.000", "2. Januar 2016"},
+		{"0,99", nil, "31.12.2015"},
+	})
+	res, err := m.Exec(src, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{1234.5, int64(1000), time.Date(2016, 1, 2, 0, 0, 0, 0, time.Local)},
+		{0.99, nil, time.Date(2015, 12, 31, 0, 0, 0, 0, time.Local)},
+	}
+	if got := readRows(t, res); !reflect.DeepEqual(got, want) {
+		t.Errorf("mapped %v, want %v", got, want)
+	}
+
+	if _, err := m.Command([]driver.Command{{Name: "price", Value: map[string]interface{}{"column": "preis", "type": "float", "locale": "xx"}}}); err == nil {
+		t.Error("an unknown locale should fail")
+	}
+	cmd, _ = m.Command([]driver.Command{{Name: "price", Value: map[string]interface{}{"column": "preis", "type": "float", "locale": "en"}}})
+	if _, err := m.Exec(newRows([]string{"preis"}, [][]interface{}{{"1.234,5"}}), cmd); err == nil {
+		t.Error("1.234,5 of en should fail")
+	}
+}
+
+func TestMapperCommands(t *testing.T) {
+	columns := []string{"id", "amt", "ok", "day", "name", "blank"}
+	data := [][]interface{}{
+		{"1", "1,234.5", "是", "2016-01-02", "a", ""},
+		{"2", "3e2", "否", "2016-01-03", "b", nil},
+	}
+	schema, err := driver.InferSchema(newRows(columns, data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the commands are written to a job file by etlx infer
+	b, err := json.Marshal(MapperCommands(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args []driver.Command
+	if err := json.Unmarshal(b, &args); err != nil {
+		t.Fatal(err)
+	}
+	m := &mapper{}
+	cmd, err := m.Command(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.Exec(newRows(columns, data), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{int64(1), 1234.5, true, time.Date(2016, 1, 2, 0, 0, 0, 0, time.Local), "a", ""},
+		{int64(2), 300.0, false, time.Date(2016, 1, 3, 0, 0, 0, 0, time.Local), "b", nil},
+	}
+	if got := readRows(t, res); !reflect.DeepEqual(got, want) {
+		t.Errorf("mapped %v, want %v", got, want)
+	}
+
+	out, err := m.OutputSchema(schema, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range out.Fields {
+		if in := schema.Fields[i]; f.Name != in.Name || f.Type != in.Type || f.Nullable != in.Nullable {
+			t.Errorf("OutputSchema field %d = %+v, want %+v", i, f, in)
+		}
+	}
+}
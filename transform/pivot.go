@@ -0,0 +1,558 @@
+package transform
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+func init() {
+	etlx.TransformRegister("pivot", &pivotDriver{}, driver.DriverInfo{
+		Description:  "turn rows of a key into the columns of one row",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema},
+	})
+	etlx.TransformRegister("unpivot", &unpivotDriver{}, driver.DriverInfo{
+		Description:  "turn columns into one row per column",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema, driver.CapStreaming},
+	})
+}
+
+type unpivotDriver struct{}
+
+func (d *unpivotDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &unpivot{name: name}, nil
+}
+
+func (d *unpivotDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "unpivot turns the value columns of a wide row into one row per column.",
+		Commands: []driver.CommandSpec{
+			{Name: "id", Type: "list", Doc: "columns kept in every row"},
+			{Name: "values", Type: "list", Doc: "value columns, all the columns not in id by default"},
+			{Name: "name_column", Type: "string", Default: "name", Doc: "output column of the value column names"},
+			{Name: "value_column", Type: "string", Default: "value", Doc: "output column of the values"},
+			{Name: "skip_null", Type: "bool", Default: false, Doc: "drop the rows of null values"},
+		},
+	}
+}
+
+//unpivot turns the value columns of a wide row into one row per column. Commands:
+//	{"name": "id", "type": "list", "value": ["region"]}
+//	{"name": "values", "type": "list", "value": ["jan", "feb", "mar"]}
+//	{"name": "name_column", "type": "string", "value": "month"}
+//	{"name": "value_column", "type": "string", "value": "amount"}
+//	{"name": "skip_null", "type": "bool", "value": true}
+//All the columns not in id are the value columns if values is not provided.
+//name_column and value_column are "name" and "value" by default.
+type unpivot struct {
+	name string
+}
+
+type unpivotCommand struct {
+	id          []string
+	values      []string
+	nameColumn  string
+	valueColumn string
+	skipNull    bool
+}
+
+func (u *unpivot) Command(args []driver.Command) (interface{}, error) {
+	cmd := &unpivotCommand{nameColumn: "name", valueColumn: "value"}
+	for _, arg := range args {
+		var err error
+		switch arg.Name {
+		case "id":
+			cmd.id, err = stringList(arg)
+		case "values":
+			cmd.values, err = stringList(arg)
+		case "name_column":
+			cmd.nameColumn, err = commandString(arg)
+		case "value_column":
+			cmd.valueColumn, err = commandString(arg)
+		case "skip_null":
+			cmd.skipNull, err = driver.BoolFromInterface(arg.Value)
+		default:
+			err = fmt.Errorf("unpivot: unsupported command %s", arg.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+func (u *unpivot) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	ucmd, ok := cmd.(*unpivotCommand)
+	if !ok {
+		return nil, fmt.Errorf("unpivot: invalid command %v", cmd)
+	}
+
+	columns := src.Columns()
+	idIdx, err := columnIndex(columns, ucmd.id)
+	if err != nil {
+		return nil, fmt.Errorf("unpivot: %v", err)
+	}
+
+	values := ucmd.values
+	if len(values) == 0 {
+		isID := make(map[string]bool, len(ucmd.id))
+		for _, name := range ucmd.id {
+			isID[name] = true
+		}
+		for _, name := range columns {
+			if !isID[name] {
+				values = append(values, name)
+			}
+		}
+	}
+	valueIdx, err := columnIndex(columns, values)
+	if err != nil {
+		return nil, fmt.Errorf("unpivot: %v", err)
+	}
+
+	rslt := newResults(append(append([]string{}, ucmd.id...), ucmd.nameColumn, ucmd.valueColumn))
+	err = eachRow(src, func(row []interface{}) error {
+		for i, idx := range valueIdx {
+			if ucmd.skipNull && row[idx] == nil {
+				continue
+			}
+			data := make([]interface{}, 0, len(idIdx)+2)
+			for _, id := range idIdx {
+				data = append(data, row[id])
+			}
+			data = append(data, values[i], row[idx])
+			rslt.AppendData(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rslt, nil
+}
+
+//OutputSchema declares the type of the value column as the type of the value
+//columns if all of them are the same, TypeAny otherwise.
+func (u *unpivot) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	ucmd, ok := cmd.(*unpivotCommand)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("unpivot: invalid command %v", cmd)
+	}
+
+	fields, err := schemaFields(in, ucmd.id)
+	if err != nil {
+		return driver.Schema{}, fmt.Errorf("unpivot: %v", err)
+	}
+
+	values := ucmd.values
+	if len(values) == 0 {
+		isID := make(map[string]bool, len(ucmd.id))
+		for _, name := range ucmd.id {
+			isID[name] = true
+		}
+		for _, name := range in.Columns() {
+			if !isID[name] {
+				values = append(values, name)
+			}
+		}
+	}
+	valueFields, err := schemaFields(in, values)
+	if err != nil {
+		return driver.Schema{}, fmt.Errorf("unpivot: %v", err)
+	}
+
+	value := driver.Field{Name: ucmd.valueColumn, Type: driver.TypeAny, Nullable: !ucmd.skipNull}
+	for i, f := range valueFields {
+		if i == 0 {
+			value.Type, value.Layout = f.Type, f.Layout
+		} else if f.Type != value.Type {
+			value.Type, value.Layout = driver.TypeAny, ""
+		}
+		if f.Nullable && !ucmd.skipNull {
+			value.Nullable = true
+		}
+	}
+
+	fields = append(fields, driver.Field{Name: ucmd.nameColumn, Type: driver.TypeString}, value)
+	return driver.Schema{Fields: fields}, nil
+}
+
+func (u *unpivot) Close() error {
+	return nil
+}
+
+type pivotDriver struct{}
+
+func (d *pivotDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &pivot{name: name}, nil
+}
+
+func (d *pivotDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "pivot turns the values of the key column into columns.",
+		Commands: []driver.CommandSpec{
+			{Name: "key", Type: "string", Required: true, Doc: "column whose values are the output columns"},
+			{Name: "value", Type: "string", Required: true, Doc: "column of the values"},
+			{Name: "aggregate", Type: "string", Enum: []string{"count", "sum", "min", "max", "avg", "count_distinct", "first", "last", "string_agg"}, Default: "first", Doc: "function of the values of the same id and key"},
+			{Name: "id", Type: "list", Doc: "id columns, all the columns except key and value by default"},
+			{Name: "columns", Type: "list", Doc: "output key columns in order"},
+			{Name: "max_groups", Type: "int", Doc: "ids kept in memory before spilling"},
+			{Name: "spill_dir", Type: "string", Default: "the temporary directory", Doc: "directory of the spilled ids"},
+		},
+	}
+}
+
+//pivot turns the values of the key column into columns. Commands:
+//	{"name": "key", "type": "string", "value": "month"}
+//	{"name": "value", "type": "string", "value": "amount"}
+//	{"name": "aggregate", "type": "string", "value": "sum"}
+//	{"name": "id", "type": "list", "value": ["region"]}
+//	{"name": "columns", "type": "list", "value": ["jan", "feb", "mar"]}
+//	{"name": "max_groups", "type": "int", "value": 100000}
+//	{"name": "spill_dir", "type": "string", "value": "/tmp"}
+//aggregate is used when more than one value for the same id and key, it could
+//be any function of the aggregate transform, first by default.
+//All the columns except key and value are the id columns if id is not provided.
+//The key columns are in the order they are found if columns is not provided,
+//otherwise only the listed ones are output. The rows of the ids are in the
+//order the ids are found. When the ids exceed max_groups they are spilled to
+//files under spill_dir, and the rows are then ordered by runs of at most
+//max_groups rows merged from spill_dir, like the sort transform does.
+//
+//In batch mode all batches are pivoted together and the results are produced
+//once all batches are extracted. The ids, the key columns and the values of
+//first and last are in the order of the offsets of the batches, so the results
+//are the same as without batch.
+type pivot struct {
+	name string
+
+	mu    sync.Mutex
+	state *pivotState
+	seq   int64
+}
+
+type pivotCommand struct {
+	key       string
+	value     string
+	agg       aggSpec
+	id        []string
+	columns   []string
+	maxGroups int
+	spillDir  string
+}
+
+type pivotState struct {
+	id []string
+	//keys is the sequence of the first row of every key column
+	keys  map[string]int64
+	table *spillTable
+}
+
+//pivotGroup is the cells of an id, exported fields are gob encoded when spilled.
+type pivotGroup struct {
+	ID    []interface{}
+	Cells map[string]*aggValue
+	//Seq is the sequence of the first row of the id
+	Seq int64
+}
+
+func (p *pivot) Command(args []driver.Command) (interface{}, error) {
+	cmd := &pivotCommand{spillDir: os.TempDir()}
+	aggFn := "first"
+	for _, arg := range args {
+		var err error
+		switch arg.Name {
+		case "key":
+			cmd.key, err = commandString(arg)
+		case "value":
+			cmd.value, err = commandString(arg)
+		case "aggregate":
+			aggFn, err = commandString(arg)
+		case "id":
+			cmd.id, err = stringList(arg)
+		case "columns":
+			cmd.columns, err = stringList(arg)
+		case "max_groups":
+			var limit int64
+			limit, err = driver.IntFromInterface(arg.Value)
+			cmd.maxGroups = int(limit)
+		case "spill_dir":
+			cmd.spillDir, err = commandString(arg)
+		default:
+			err = fmt.Errorf("pivot: unsupported command %s", arg.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd.key == "" || cmd.value == "" {
+		return nil, fmt.Errorf("pivot: should provide key and value")
+	}
+	agg, err := parseAggSpec("", aggFn+"(`"+cmd.value+"`)")
+	if err != nil {
+		return nil, fmt.Errorf("pivot: %v", err)
+	}
+	cmd.agg = agg
+	return cmd, nil
+}
+
+func (p *pivot) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	pcmd, ok := cmd.(*pivotCommand)
+	if !ok {
+		return nil, fmt.Errorf("pivot: invalid command %v", cmd)
+	}
+
+	state := newPivotState(pcmd)
+	var seq int64
+	if err := state.add(src, pcmd, &seq); err != nil {
+		state.table.close()
+		return nil, err
+	}
+	return state.results(pcmd)
+}
+
+func (p *pivot) Accumulate(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	pcmd, ok := cmd.(*pivotCommand)
+	if !ok {
+		return nil, fmt.Errorf("pivot: invalid command %v", cmd)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == nil {
+		p.state = newPivotState(pcmd)
+	}
+	return nil, p.state.add(src, pcmd, &p.seq)
+}
+
+//AccumulateAt accumulates the batch extracted from offset, the rows are
+//ordered by their offset whatever order the batches are accumulated in.
+func (p *pivot) AccumulateAt(src driver.Rows, cmd interface{}, offset int64) (driver.Results, error) {
+	pcmd, ok := cmd.(*pivotCommand)
+	if !ok {
+		return nil, fmt.Errorf("pivot: invalid command %v", cmd)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == nil {
+		p.state = newPivotState(pcmd)
+	}
+	seq := offset
+	return nil, p.state.add(src, pcmd, &seq)
+}
+
+func (p *pivot) Flush(cmd interface{}) (driver.Results, error) {
+	pcmd, ok := cmd.(*pivotCommand)
+	if !ok {
+		return nil, fmt.Errorf("pivot: invalid command %v", cmd)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.state
+	p.state, p.seq = nil, 0
+	if state == nil {
+		state = newPivotState(pcmd)
+	}
+	return state.results(pcmd)
+}
+
+//OutputSchema could only declare the columns if they are listed by the command
+//columns, otherwise driver.ErrSchemaUnknown is returned since they depend on
+//the data.
+func (p *pivot) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	pcmd, ok := cmd.(*pivotCommand)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("pivot: invalid command %v", cmd)
+	}
+
+	kv, err := schemaFields(in, []string{pcmd.key, pcmd.value})
+	if err != nil {
+		return driver.Schema{}, fmt.Errorf("pivot: %v", err)
+	}
+	if len(pcmd.columns) == 0 {
+		return driver.Schema{}, driver.ErrSchemaUnknown
+	}
+
+	id := pcmd.id
+	if id == nil {
+		for _, name := range in.Columns() {
+			if name != pcmd.key && name != pcmd.value {
+				id = append(id, name)
+			}
+		}
+	}
+	fields, err := schemaFields(in, id)
+	if err != nil {
+		return driver.Schema{}, fmt.Errorf("pivot: %v", err)
+	}
+
+	agg := &aggregate{}
+	aggSchema, err := agg.OutputSchema(in, &aggCommand{aggs: []aggSpec{pcmd.agg}})
+	if err != nil {
+		return driver.Schema{}, fmt.Errorf("pivot: %v", err)
+	}
+	cell := aggSchema.Fields[0]
+	cell.Nullable = true
+	if cell.Type == driver.TypeAny {
+		cell.Type, cell.Layout = kv[1].Type, kv[1].Layout
+	}
+	for _, name := range pcmd.columns {
+		cell.Name = name
+		fields = append(fields, cell)
+	}
+	return driver.Schema{Fields: fields}, nil
+}
+
+func (p *pivot) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != nil {
+		p.state.table.close()
+		p.state = nil
+	}
+	p.seq = 0
+	return nil
+}
+
+func newPivotState(cmd *pivotCommand) *pivotState {
+	state := &pivotState{
+		id:   cmd.id,
+		keys: make(map[string]int64),
+		table: newSpillTable(cmd.maxGroups, cmd.spillDir,
+			func() interface{} { return &pivotGroup{} },
+			func(dst, src interface{}) { mergePivotGroup(cmd.agg, dst.(*pivotGroup), src.(*pivotGroup)) }),
+	}
+	for i, key := range cmd.columns {
+		state.keys[key] = int64(i)
+	}
+	return state
+}
+
+//add adds the rows of src, seq is the number of the rows before src.
+func (s *pivotState) add(src driver.Rows, cmd *pivotCommand, seq *int64) error {
+	columns := src.Columns()
+	if s.id == nil {
+		for _, name := range columns {
+			if name != cmd.key && name != cmd.value {
+				s.id = append(s.id, name)
+			}
+		}
+	}
+
+	idIdx, err := columnIndex(columns, s.id)
+	if err != nil {
+		return fmt.Errorf("pivot: %v", err)
+	}
+	kvIdx, err := columnIndex(columns, []string{cmd.key, cmd.value})
+	if err != nil {
+		return fmt.Errorf("pivot: %v", err)
+	}
+
+	return eachRow(src, func(row []interface{}) error {
+		if row[kvIdx[0]] == nil {
+			return nil
+		}
+		key, err := driver.StringFromInterface(row[kvIdx[0]])
+		if err != nil {
+			return fmt.Errorf("pivot: %v", err)
+		}
+		*seq++
+		if first, ok := s.keys[key]; !ok {
+			//only the listed columns are output if columns provided
+			if len(cmd.columns) > 0 {
+				return nil
+			}
+			s.keys[key] = *seq
+		} else if len(cmd.columns) == 0 && *seq < first {
+			s.keys[key] = *seq
+		}
+
+		id := make([]interface{}, len(idIdx))
+		for i, idx := range idIdx {
+			id[i] = row[idx]
+		}
+		entry, err := s.table.get(keyString(id))
+		if err != nil {
+			return err
+		}
+		group := entry.(*pivotGroup)
+		if group.Cells == nil {
+			group.ID, group.Cells, group.Seq = id, make(map[string]*aggValue), *seq
+		} else if *seq < group.Seq {
+			group.Seq = *seq
+		}
+
+		cell, ok := group.Cells[key]
+		if !ok {
+			cell = &aggValue{}
+			group.Cells[key] = cell
+		}
+		if err := cell.add(cmd.agg, row[kvIdx[1]], *seq); err != nil {
+			return fmt.Errorf("pivot: %s: %v", key, err)
+		}
+		return nil
+	})
+}
+
+func mergePivotGroup(spec aggSpec, dst, src *pivotGroup) {
+	if dst.Cells == nil {
+		*dst = *src
+		return
+	}
+	if src.Seq < dst.Seq {
+		dst.Seq = src.Seq
+	}
+	for key, cell := range src.Cells {
+		if exist, ok := dst.Cells[key]; ok {
+			exist.merge(spec, cell)
+		} else {
+			dst.Cells[key] = cell
+		}
+	}
+}
+
+//results returns a row per id in the order the ids are found. The spilled ids
+//are read back by partition and ordered by runs spilled like the groups, so
+//only a partition and a run must fit in memory.
+func (s *pivotState) results(cmd *pivotCommand) (driver.Results, error) {
+	keys := make([]string, 0, len(s.keys))
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.keys[keys[i]] < s.keys[keys[j]] })
+	columns := append(append([]string{}, s.id...), keys...)
+
+	run := &sortRun{
+		cmd:     &sortCommand{keys: []sortKey{{column: "seq"}}, maxRows: cmd.maxGroups, maxFiles: sortFanIn, spillDir: cmd.spillDir},
+		columns: columns,
+	}
+	err := s.table.each(func(_ string, entry interface{}) error {
+		group := entry.(*pivotGroup)
+		row := make([]interface{}, 0, len(columns))
+		row = append(row, group.ID...)
+		for _, key := range keys {
+			if cell, ok := group.Cells[key]; ok {
+				row = append(row, cell.result(cmd.agg))
+			} else {
+				row = append(row, nil)
+			}
+		}
+		return run.push([]interface{}{group.Seq}, row, group.Seq)
+	})
+	if err != nil {
+		run.close()
+		return nil, err
+	}
+	return run.results()
+}
@@ -0,0 +1,192 @@
+package transform
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+var salesColumns = []string{"region", "month", "amount"}
+
+func salesData() [][]interface{} {
+	return [][]interface{}{
+		{"n", "jan", 1}, {"s", "feb", 4}, {"n", "feb", 2},
+		{"e", "jan", 5}, {"n", "jan", 3}, {"s", "mar", nil}, {"w", nil, 9},
+	}
+}
+
+func TestPivot(t *testing.T) {
+	kv := []driver.Command{{Name: "key", Value: "month"}, {Name: "value", Value: "amount"}}
+	tests := []struct {
+		name    string
+		args    []driver.Command
+		columns []string
+		want    [][]interface{}
+	}{
+		{"first", kv, []string{"region", "jan", "feb", "mar"},
+			[][]interface{}{{"n", 1, 2, nil}, {"s", nil, 4, nil}, {"e", 5, nil, nil}}},
+		{"sum", append(kv, driver.Command{Name: "aggregate", Value: "sum"}), []string{"region", "jan", "feb", "mar"},
+			[][]interface{}{{"n", int64(4), int64(2), nil}, {"s", nil, int64(4), nil}, {"e", int64(5), nil, nil}}},
+		{"columns", append(kv, driver.Command{Name: "columns", Value: []interface{}{"feb", "jan"}}), []string{"region", "feb", "jan"},
+			[][]interface{}{{"n", 2, 1}, {"s", 4, nil}, {"e", nil, 5}}},
+		{"spilled", append(kv, driver.Command{Name: "aggregate", Value: "last"}, driver.Command{Name: "max_groups", Value: int64(1)},
+			driver.Command{Name: "spill_dir", Value: t.TempDir()}), []string{"region", "jan", "feb", "mar"},
+			[][]interface{}{{"n", 3, 2, nil}, {"s", nil, 4, nil}, {"e", 5, nil, nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pivot{}
+			cmd, err := p.Command(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := p.Exec(newRows(salesColumns, salesData()), cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.Columns(), tt.columns) {
+				t.Errorf("columns %v, want %v", res.Columns(), tt.columns)
+			}
+			if got := readRows(t, res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pivoted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPivotAccumulate(t *testing.T) {
+	p := &pivot{}
+	defer p.Close()
+	cmd, err := p.Command([]driver.Command{{Name: "key", Value: "month"}, {Name: "value", Value: "amount"},
+		{Name: "aggregate", Value: "string_agg"}, {Name: "max_groups", Value: int64(2)}, {Name: "spill_dir", Value: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := salesData()
+	for _, batch := range [][][]interface{}{data[:3], data[3:5], data[5:]} {
+		if _, err := p.Accumulate(newRows(salesColumns, batch), cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := p.Flush(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{"n", "1,3", "2", nil}, {"s", nil, "4", nil}, {"e", "5", nil, nil}}
+	if got := readRows(t, res); !reflect.DeepEqual(got, want) {
+		t.Errorf("pivoted %v, want %v", got, want)
+	}
+}
+
+func TestPivotAccumulateAt(t *testing.T) {
+	dir := t.TempDir()
+	p := &pivot{}
+	defer p.Close()
+	cmd, err := p.Command([]driver.Command{{Name: "key", Value: "month"}, {Name: "value", Value: "amount"},
+		{Name: "max_groups", Value: int64(1)}, {Name: "spill_dir", Value: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the batches of 2 rows accumulated in reverse order
+	data := salesData()
+	for offset := 6; offset >= 0; offset -= 2 {
+		end := offset + 2
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := p.AccumulateAt(newRows(salesColumns, data[offset:end]), cmd, int64(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := p.Flush(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the ids spilled are merged from the runs, not held in a table
+	if _, ok := res.(*driver.Table); ok {
+		t.Error("the spilled ids are pivoted in memory")
+	}
+	if want := []string{"region", "jan", "feb", "mar"}; !reflect.DeepEqual(res.Columns(), want) {
+		t.Errorf("Columns = %v, want %v", res.Columns(), want)
+	}
+	want := [][]interface{}{{"n", 1, 2, nil}, {"s", nil, 4, nil}, {"e", 5, nil, nil}}
+	if got := readRows(t, res); !reflect.DeepEqual(got, want) {
+		t.Errorf("pivoted %v, want %v", got, want)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d spilled files are left", len(files))
+	}
+}
+
+func TestPivotOutputSchema(t *testing.T) {
+	in := driver.Schema{Fields: []driver.Field{
+		{Name: "region", Type: driver.TypeString},
+		{Name: "month", Type: driver.TypeString},
+		{Name: "amount", Type: driver.TypeInt},
+	}}
+	p := &pivot{}
+	cmd, err := p.Command([]driver.Command{{Name: "key", Value: "month"}, {Name: "value", Value: "amount"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.OutputSchema(in, cmd); err != driver.ErrSchemaUnknown {
+		t.Errorf("OutputSchema without columns returned %v, want %v", err, driver.ErrSchemaUnknown)
+	}
+
+	cmd, err = p.Command([]driver.Command{{Name: "key", Value: "month"}, {Name: "value", Value: "amount"},
+		{Name: "aggregate", Value: "sum"}, {Name: "columns", Value: []interface{}{"jan", "feb"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := p.OutputSchema(in, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []driver.Field{
+		{Name: "region", Type: driver.TypeString},
+		{Name: "jan", Type: driver.TypeInt, Nullable: true},
+		{Name: "feb", Type: driver.TypeInt, Nullable: true},
+	}
+	if !reflect.DeepEqual(schema.Fields, want) {
+		t.Errorf("schema %v, want %v", schema.Fields, want)
+	}
+
+	cmd, _ = p.Command([]driver.Command{{Name: "key", Value: "day"}, {Name: "value", Value: "amount"}})
+	if _, err := p.OutputSchema(in, cmd); err == nil || err == driver.ErrSchemaUnknown {
+		t.Errorf("OutputSchema of a missing key returned %v", err)
+	}
+}
+
+func TestUnpivot(t *testing.T) {
+	wide := [][]interface{}{{"n", 1, 2}, {"s", 3, nil}}
+	tests := []struct {
+		name string
+		args []driver.Command
+		want [][]interface{}
+	}{
+		{"all", []driver.Command{{Name: "id", Value: []interface{}{"region"}}},
+			[][]interface{}{{"n", "jan", 1}, {"n", "feb", 2}, {"s", "jan", 3}, {"s", "feb", nil}}},
+		{"skip null", []driver.Command{{Name: "id", Value: []interface{}{"region"}}, {Name: "skip_null", Value: true}},
+			[][]interface{}{{"n", "jan", 1}, {"n", "feb", 2}, {"s", "jan", 3}}},
+		{"values", []driver.Command{{Name: "id", Value: []interface{}{"region"}}, {Name: "values", Value: []interface{}{"feb"}}},
+			[][]interface{}{{"n", "feb", 2}, {"s", "feb", nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &unpivot{}
+			cmd, err := u.Command(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := u.Exec(newRows([]string{"region", "jan", "feb"}, wide), cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readRows(t, res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unpivoted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
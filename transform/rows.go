@@ -0,0 +1,79 @@
+//Package transform provides the built-in transform drivers of etlx. Importing
+//the package registers them, e.g.
+//
+//	import _ "github.com/xingwangc/etlx/transform"
+package transform
+
+import (
+	"fmt"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+//newResults returns an empty table of the columns for the results of transforms.
+func newResults(columns []string) *driver.Table {
+	tbl := driver.NewTable(0)
+	tbl.SetColumns(columns)
+	return tbl
+}
+
+//eachRow reads src until driver.EOT and calls fn with every row. Values read
+//from sql as []uint8 are converted to string.
+func eachRow(src driver.Rows, fn func(row []interface{}) error) error {
+	columns := src.Columns()
+	for {
+		row := make([]interface{}, len(columns))
+		if err := src.Next(row); err != nil {
+			if err == driver.EOT {
+				return nil
+			}
+			return err
+		}
+		for i := range row {
+			row[i] = driver.DataPreProcess(row[i])
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+//commandString returns the value of a command which should be a string.
+func commandString(cmd driver.Command) (string, error) {
+	str, err := driver.StringFromInterface(cmd.Value)
+	if err != nil {
+		return "", fmt.Errorf("Command(%s) should have a string value", cmd.Name)
+	}
+	return str, nil
+}
+
+//stringList reads a list command, e.g. {"name": "id", "type": "list", "value": ["a", "b"]}
+func stringList(arg driver.Command) ([]string, error) {
+	items, err := driver.ArrayFromInterface(arg.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Command(%s) should have a list value", arg.Name)
+	}
+
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		str, err := driver.StringFromInterface(item)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+//schemaFields returns the fields of the columns, an error is returned if any of them is missing.
+func schemaFields(in driver.Schema, names []string) ([]driver.Field, error) {
+	fields := make([]driver.Field, len(names))
+	for i, name := range names {
+		f, ok := in.Field(name)
+		if !ok {
+			return nil, fmt.Errorf("column %s is not in the source", name)
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
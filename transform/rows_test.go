@@ -0,0 +1,24 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func newRows(columns []string, rows [][]interface{}) *driver.Table {
+	tbl := newResults(columns)
+	tbl.SetData(rows)
+	return tbl
+}
+
+func readRows(t *testing.T, rows driver.Rows) [][]interface{} {
+	data := [][]interface{}{}
+	if err := eachRow(rows, func(row []interface{}) error {
+		data = append(data, row)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,680 @@
+package transform
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+)
+
+func init() {
+	etlx.TransformRegister("sort", &sortDriver{}, driver.DriverInfo{
+		Description:  "sort rows by columns, spilling to disk",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema},
+	})
+}
+
+type sortDriver struct{}
+
+func (d *sortDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &sorter{name: name}, nil
+}
+
+func (d *sortDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "sort orders rows by multiple columns.",
+		Commands: []driver.CommandSpec{
+			{Name: "order_by", Type: "complex", Required: true, Doc: "columns in order", Items: []driver.CommandSpec{
+				{Name: "*", Type: "string", Doc: "options of the column: asc, desc, nulls first, nulls last, number, time, string, binary, natural, nocase"},
+			}},
+			{Name: "max_rows", Type: "int", Doc: "rows sorted in memory before spilling"},
+			{Name: "max_files", Type: "int", Default: "64", Doc: "spilled runs merged at once"},
+			{Name: "spill_dir", Type: "string", Default: "the temporary directory", Doc: "directory of the spilled runs"},
+		},
+	}
+}
+
+//sorter orders rows by multiple columns. Commands:
+//	{"name": "order_by", "type": "complex", "value": [
+//		{"name": "region", "type": "string", "value": "asc nocase"},
+//		{"name": "amount", "type": "string", "value": "desc number nulls last"},
+//		{"name": "day", "type": "string", "value": "asc time", "arg": "2006/01/02"}
+//	]}
+//	{"name": "max_rows", "type": "int", "value": 1000000}
+//	{"name": "max_files", "type": "int", "value": 64}
+//	{"name": "spill_dir", "type": "string", "value": "/tmp"}
+//The value of an order_by item is a list of options:
+//	asc, desc: direction, asc by default
+//	nulls first, nulls last: null ordering, nulls are last for asc and first for desc by default
+//	number, time, string: convert the values before comparing, the arg is the
+//	layout of time. Without it values are compared by their own types. A value
+//	which could not be converted fails the transform.
+//	binary, natural: collation of strings, binary by default. natural compares
+//	the digits in strings by their values, e.g. a2 < a10.
+//	nocase: compare strings case-insensitively, it could be used with a collation.
+//
+//When there are more than max_rows rows, the sorted runs are written to files
+//under spill_dir and merged when the results are iterated. At most max_files
+//runs are merged at once, more of them are merged into longer runs first. The
+//files are removed once the results are read to the end or closed.
+//
+//The sort is stable. In batch mode all batches are sorted together and the
+//results are produced once all batches are extracted, the rows of the same
+//keys are kept in the order of their offset.
+type sorter struct {
+	name string
+
+	mu  sync.Mutex
+	run *sortRun
+	seq int64
+}
+
+//sortFanIn is the number of runs merged at once by default.
+const sortFanIn = 64
+
+type sortKey struct {
+	column    string
+	desc      bool
+	nullsLast bool
+	typ       string
+	layout    string
+	collation string
+	nocase    bool
+}
+
+type sortCommand struct {
+	keys     []sortKey
+	maxRows  int
+	maxFiles int
+	spillDir string
+}
+
+//sortRow is a row with the converted sort keys and its position in the source
+//which keeps the sort stable, exported fields are gob encoded when spilled.
+type sortRow struct {
+	Keys []interface{}
+	Row  []interface{}
+	Seq  int64
+}
+
+func parseSortKey(arg driver.Command) (sortKey, error) {
+	key := sortKey{column: arg.Name, collation: "binary"}
+	if arg.Value != nil {
+		opts, err := commandString(arg)
+		if err != nil {
+			return key, err
+		}
+
+		fields := strings.Fields(strings.ToLower(opts))
+		nullsSet := false
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "asc":
+				key.desc = false
+			case "desc":
+				key.desc = true
+			case "nulls":
+				if i+1 >= len(fields) || (fields[i+1] != "first" && fields[i+1] != "last") {
+					return key, fmt.Errorf("sort: nulls should be followed by first or last for %s", arg.Name)
+				}
+				i++
+				key.nullsLast = fields[i] == "last"
+				nullsSet = true
+			case "number", "time", "string":
+				key.typ = fields[i]
+			case "binary", "natural":
+				key.collation = fields[i]
+			case "nocase":
+				key.nocase = true
+			default:
+				return key, fmt.Errorf("sort: unsupported option %s for %s", fields[i], arg.Name)
+			}
+		}
+		if !nullsSet {
+			key.nullsLast = !key.desc
+		}
+	} else {
+		key.nullsLast = true
+	}
+
+	key.layout = "2006-01-02"
+	if arg.Arg != nil {
+		var layout string
+		if err := json.Unmarshal(*arg.Arg, &layout); err != nil {
+			return key, fmt.Errorf("sort: arg of %s should be the time layout", arg.Name)
+		}
+		key.layout = layout
+	}
+	return key, nil
+}
+
+func (s *sorter) Command(args []driver.Command) (interface{}, error) {
+	cmd := &sortCommand{maxFiles: sortFanIn, spillDir: os.TempDir()}
+	for _, arg := range args {
+		switch arg.Name {
+		case "order_by":
+			items, ok := arg.Value.([]driver.Command)
+			if !ok {
+				return nil, fmt.Errorf("sort: command order_by should be complex")
+			}
+			for _, item := range items {
+				key, err := parseSortKey(item)
+				if err != nil {
+					return nil, err
+				}
+				cmd.keys = append(cmd.keys, key)
+			}
+		case "max_rows":
+			limit, err := driver.IntFromInterface(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			cmd.maxRows = int(limit)
+		case "max_files":
+			limit, err := driver.IntFromInterface(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			if limit < 2 {
+				return nil, fmt.Errorf("sort: max_files should be at least 2")
+			}
+			cmd.maxFiles = int(limit)
+		case "spill_dir":
+			dir, err := commandString(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.spillDir = dir
+		default:
+			return nil, fmt.Errorf("sort: unsupported command %s", arg.Name)
+		}
+	}
+
+	if len(cmd.keys) == 0 {
+		return nil, fmt.Errorf("sort: should provide order_by")
+	}
+	return cmd, nil
+}
+
+func (s *sorter) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	scmd, ok := cmd.(*sortCommand)
+	if !ok {
+		return nil, fmt.Errorf("sort: invalid command %v", cmd)
+	}
+
+	run := &sortRun{cmd: scmd}
+	var seq int64
+	if err := run.add(src, &seq); err != nil {
+		run.close()
+		return nil, err
+	}
+	return run.results()
+}
+
+func (s *sorter) Accumulate(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	scmd, ok := cmd.(*sortCommand)
+	if !ok {
+		return nil, fmt.Errorf("sort: invalid command %v", cmd)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.run == nil {
+		s.run = &sortRun{cmd: scmd}
+	}
+	return nil, s.run.add(src, &s.seq)
+}
+
+//AccumulateAt accumulates the batch extracted from offset, the rows of the
+//same keys are ordered by their offset whatever order the batches are
+//accumulated in.
+func (s *sorter) AccumulateAt(src driver.Rows, cmd interface{}, offset int64) (driver.Results, error) {
+	scmd, ok := cmd.(*sortCommand)
+	if !ok {
+		return nil, fmt.Errorf("sort: invalid command %v", cmd)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.run == nil {
+		s.run = &sortRun{cmd: scmd}
+	}
+	seq := offset
+	return nil, s.run.add(src, &seq)
+}
+
+func (s *sorter) Flush(cmd interface{}) (driver.Results, error) {
+	scmd, ok := cmd.(*sortCommand)
+	if !ok {
+		return nil, fmt.Errorf("sort: invalid command %v", cmd)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := s.run
+	s.run, s.seq = nil, 0
+	if run == nil {
+		run = &sortRun{cmd: scmd}
+	}
+	return run.results()
+}
+
+func (s *sorter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.run != nil {
+		s.run.close()
+		s.run = nil
+	}
+	s.seq = 0
+	return nil
+}
+
+//sortRun buffers the rows in memory and spills the sorted buffer to a file
+//when it is full.
+type sortRun struct {
+	cmd     *sortCommand
+	columns []string
+	buf     []sortRow
+	files   []*os.File
+}
+
+//add adds the rows of src, seq is the number of the rows before src.
+func (r *sortRun) add(src driver.Rows, seq *int64) error {
+	columns := src.Columns()
+	if r.columns == nil {
+		r.columns = columns
+	}
+
+	keyIdx := make([]string, len(r.cmd.keys))
+	for i, key := range r.cmd.keys {
+		keyIdx[i] = key.column
+	}
+	idx, err := columnIndex(columns, keyIdx)
+	if err != nil {
+		return fmt.Errorf("sort: %v", err)
+	}
+
+	return eachRow(src, func(row []interface{}) error {
+		keys := make([]interface{}, len(idx))
+		for i, pos := range idx {
+			key, err := r.cmd.keys[i].convert(row[pos])
+			if err != nil {
+				return err
+			}
+			keys[i] = key
+		}
+		*seq++
+		return r.push(keys, row, *seq)
+	})
+}
+
+//push adds the row with its converted keys and its position in the source,
+//the buffer is spilled once full.
+func (r *sortRun) push(keys []interface{}, row []interface{}, seq int64) error {
+	r.buf = append(r.buf, sortRow{Keys: keys, Row: row, Seq: seq})
+	if r.cmd.maxRows > 0 && len(r.buf) >= r.cmd.maxRows {
+		return r.spill()
+	}
+	return nil
+}
+
+func (r *sortRun) sortBuf() {
+	sort.Slice(r.buf, func(i, j int) bool {
+		return compareSortRows(r.cmd.keys, &r.buf[i], &r.buf[j]) < 0
+	})
+}
+
+func (r *sortRun) spill() error {
+	r.sortBuf()
+
+	i := 0
+	err := r.write(func() (*sortRow, error) {
+		if i == len(r.buf) {
+			return nil, nil
+		}
+		i++
+		return &r.buf[i-1], nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.buf = nil
+	return nil
+}
+
+//write writes the rows returned by next to a new run file until nil.
+func (r *sortRun) write(next func() (*sortRow, error)) error {
+	file, err := ioutil.TempFile(r.cmd.spillDir, "etlx-sort-")
+	if err != nil {
+		return err
+	}
+	r.files = append(r.files, file)
+
+	w := bufio.NewWriter(file)
+	enc := gob.NewEncoder(w)
+	for {
+		row, err := next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+//results returns the sorted rows, they are merged from the files if spilled.
+func (r *sortRun) results() (driver.Results, error) {
+	if len(r.files) == 0 {
+		r.sortBuf()
+		rslt := newResults(r.columns)
+		for _, row := range r.buf {
+			rslt.AppendData(row.Row)
+		}
+		r.buf = nil
+		return rslt, nil
+	}
+
+	if len(r.buf) > 0 {
+		if err := r.spill(); err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+
+	//merge the first runs into longer ones until there are at most max_files
+	for len(r.files) > r.cmd.maxFiles {
+		merge, err := r.merge(r.cmd.maxFiles)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		err = r.write(merge.pop)
+		merge.Close()
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+	return r.merge(len(r.files))
+}
+
+//merge returns the merge of the first n run files, they are removed from the
+//run and owned by the merge.
+func (r *sortRun) merge(n int) (*mergeRows, error) {
+	merge := &mergeRows{columns: r.columns, files: r.files[:n:n], h: &runHeap{keys: r.cmd.keys}}
+	r.files = append([]*os.File{}, r.files[n:]...)
+	for _, file := range merge.files {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			merge.Close()
+			return nil, err
+		}
+		reader := &runReader{dec: gob.NewDecoder(bufio.NewReader(file))}
+		ok, err := reader.read()
+		if err != nil {
+			merge.Close()
+			return nil, err
+		}
+		if ok {
+			merge.h.readers = append(merge.h.readers, reader)
+		}
+	}
+	heap.Init(merge.h)
+	return merge, nil
+}
+
+func (r *sortRun) close() {
+	for _, file := range r.files {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	r.files = nil
+	r.buf = nil
+}
+
+//convert converts the value to the type of the key, nil is kept as null.
+func (k sortKey) convert(val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+
+	switch k.typ {
+	case "number":
+		fval, err := driver.FloatFromInterface(val)
+		if err != nil {
+			return nil, fmt.Errorf("sort: %v of %s could not be converted to number", val, k.column)
+		}
+		return fval, nil
+	case "time":
+		tval, err := driver.TimeFromInterface(val, k.layout)
+		if err != nil {
+			return nil, fmt.Errorf("sort: %v of %s could not be converted to time of layout %s", val, k.column, k.layout)
+		}
+		return tval, nil
+	case "string":
+		str, err := driver.StringFromInterface(val)
+		if err != nil {
+			return nil, fmt.Errorf("sort: %v of %s could not be converted to string", val, k.column)
+		}
+		val = str
+	}
+
+	if str, ok := val.(string); ok && k.nocase {
+		return strings.ToLower(str), nil
+	}
+	return val, nil
+}
+
+//compareSortRows compares the rows by the keys, then by their position.
+func compareSortRows(keys []sortKey, a, b *sortRow) int {
+	if c := compareSortKeys(keys, a.Keys, b.Keys); c != 0 {
+		return c
+	}
+	return compareOrdered(a.Seq < b.Seq, a.Seq > b.Seq)
+}
+
+func compareSortKeys(keys []sortKey, a, b []interface{}) int {
+	for i, key := range keys {
+		av, bv := a[i], b[i]
+		if av == nil || bv == nil {
+			if av == nil && bv == nil {
+				continue
+			}
+			//null ordering does not depend on the direction
+			if (av == nil) == key.nullsLast {
+				return 1
+			}
+			return -1
+		}
+
+		var c int
+		as, aok := av.(string)
+		bs, bok := bv.(string)
+		if aok && bok && key.collation == "natural" {
+			c = compareNatural(as, bs)
+		} else if aok && bok {
+			c = strings.Compare(as, bs)
+		} else {
+			c = compareValues(av, bv)
+		}
+
+		if c != 0 {
+			if key.desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+//compareNatural compares strings with the digit sequences compared by value.
+func compareNatural(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ar) && j < len(br) {
+		if unicode.IsDigit(ar[i]) && unicode.IsDigit(br[j]) {
+			si, sj := i, j
+			for i < len(ar) && unicode.IsDigit(ar[i]) {
+				i++
+			}
+			for j < len(br) && unicode.IsDigit(br[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ar[si:i]), "0")
+			nb := strings.TrimLeft(string(br[sj:j]), "0")
+			if len(na) != len(nb) {
+				return compareOrdered(len(na) < len(nb), len(na) > len(nb))
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			continue
+		}
+		if ar[i] != br[j] {
+			return compareOrdered(ar[i] < br[j], ar[i] > br[j])
+		}
+		i++
+		j++
+	}
+	return compareOrdered(len(ar)-i < len(br)-j, len(ar)-i > len(br)-j)
+}
+
+type runReader struct {
+	dec *gob.Decoder
+	cur sortRow
+}
+
+//read decodes the next row of the run, false is returned at the end.
+func (r *runReader) read() (bool, error) {
+	r.cur = sortRow{}
+	if err := r.dec.Decode(&r.cur); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type runHeap struct {
+	keys    []sortKey
+	readers []*runReader
+}
+
+func (h *runHeap) Len() int {
+	return len(h.readers)
+}
+
+func (h *runHeap) Less(i, j int) bool {
+	return compareSortRows(h.keys, &h.readers[i].cur, &h.readers[j].cur) < 0
+}
+
+func (h *runHeap) Swap(i, j int) {
+	h.readers[i], h.readers[j] = h.readers[j], h.readers[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	h.readers = append(h.readers, x.(*runReader))
+}
+
+func (h *runHeap) Pop() interface{} {
+	n := len(h.readers)
+	x := h.readers[n-1]
+	h.readers = h.readers[:n-1]
+	return x
+}
+
+//mergeRows is the k-way merge of the spilled runs.
+type mergeRows struct {
+	columns []string
+	files   []*os.File
+	h       *runHeap
+}
+
+func (m *mergeRows) Columns() []string {
+	return m.columns
+}
+
+//pop returns the next row of the runs, nil at the end.
+func (m *mergeRows) pop() (*sortRow, error) {
+	if m.h.Len() == 0 {
+		return nil, nil
+	}
+
+	reader := m.h.readers[0]
+	row := reader.cur
+	ok, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		heap.Fix(m.h, 0)
+	} else {
+		heap.Pop(m.h)
+	}
+	return &row, nil
+}
+
+//Next copies the next row to dst, the run files are removed at the end.
+func (m *mergeRows) Next(dst interface{}) error {
+	row, err := m.pop()
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		m.Close()
+		return driver.EOT
+	}
+	return driver.CopyRow(m.columns, row.Row, dst)
+}
+
+func (m *mergeRows) NextRsltAndIndex(rslt interface{}, index *map[string]interface{}) error {
+	return m.Next(rslt)
+}
+
+//Close removes the run files.
+func (m *mergeRows) Close() error {
+	for _, file := range m.files {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	m.files = nil
+	m.h.readers = nil
+	return nil
+}
+
+func (s *sorter) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	scmd, ok := cmd.(*sortCommand)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("sort: invalid command %v", cmd)
+	}
+
+	for _, key := range scmd.keys {
+		if _, err := schemaFields(in, []string{key.column}); err != nil {
+			return driver.Schema{}, fmt.Errorf("sort: %v", err)
+		}
+	}
+	return in, nil
+}
@@ -0,0 +1,204 @@
+package transform
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+var sortColumns = []string{"name", "amount", "day"}
+
+func sortData() [][]interface{} {
+	return [][]interface{}{
+		{"a10", 3, "2016/01/02"}, {"A2", nil, "2015/12/01"}, {"b", "1,000", "2016/01/01"},
+		{"a1", 3.5, nil}, {"B", 2, "2017/01/01"}, {"c", 3, "2014/01/01"},
+	}
+}
+
+func sortNames(rows [][]interface{}) []interface{} {
+	names := []interface{}{}
+	for _, row := range rows {
+		names = append(names, row[0])
+	}
+	return names
+}
+
+func TestSort(t *testing.T) {
+	layout := json.RawMessage(`"2006/01/02"`)
+	orderBy := func(keys ...driver.Command) driver.Command {
+		return driver.Command{Name: "order_by", Value: keys}
+	}
+	tests := []struct {
+		name string
+		args []driver.Command
+		want []interface{}
+	}{
+		{"binary", []driver.Command{orderBy(driver.Command{Name: "name"})},
+			[]interface{}{"A2", "B", "a1", "a10", "b", "c"}},
+		{"natural nocase", []driver.Command{orderBy(driver.Command{Name: "name", Value: "asc nocase natural"})},
+			[]interface{}{"a1", "A2", "a10", "b", "B", "c"}},
+		{"desc number", []driver.Command{orderBy(driver.Command{Name: "amount", Value: "desc number"}, driver.Command{Name: "name", Value: "asc"})},
+			[]interface{}{"A2", "b", "a1", "a10", "c", "B"}},
+		{"spilled", []driver.Command{orderBy(driver.Command{Name: "amount", Value: "desc number"}, driver.Command{Name: "name", Value: "asc"}),
+			{Name: "max_rows", Value: int64(2)}},
+			[]interface{}{"A2", "b", "a1", "a10", "c", "B"}},
+		{"time nulls first", []driver.Command{orderBy(driver.Command{Name: "day", Value: "asc time nulls first", Arg: &layout}),
+			{Name: "max_rows", Value: int64(4)}},
+			[]interface{}{"a1", "c", "A2", "b", "a10", "B"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &sorter{}
+			cmd, err := d.Command(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := d.Exec(newRows(sortColumns, sortData()), cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Close()
+			if got := sortNames(readRows(t, res)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sorted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortAccumulate(t *testing.T) {
+	d := &sorter{}
+	defer d.Close()
+	cmd, err := d.Command([]driver.Command{{Name: "order_by", Value: []driver.Command{{Name: "name", Value: "desc"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := sortData()
+	for _, batch := range [][][]interface{}{data[:2], data[2:4], data[4:]} {
+		if _, err := d.Accumulate(newRows(sortColumns, batch), cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := d.Flush(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	want := []interface{}{"c", "b", "a10", "a1", "B", "A2"}
+	if got := sortNames(readRows(t, res)); !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted %v, want %v", got, want)
+	}
+}
+
+func TestSortAccumulateAt(t *testing.T) {
+	d := &sorter{}
+	defer d.Close()
+	cmd, err := d.Command([]driver.Command{
+		{Name: "order_by", Value: []driver.Command{{Name: "k"}}},
+		{Name: "max_rows", Value: int64(3)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := [][]interface{}{}
+	for i := 0; i < 20; i++ {
+		data = append(data, []interface{}{int64(i % 2), int64(i)})
+	}
+	//the batches of 4 rows accumulated in reverse order
+	for offset := len(data) - 4; offset >= 0; offset -= 4 {
+		if _, err := d.AccumulateAt(newRows([]string{"k", "n"}, data[offset:offset+4]), cmd, int64(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := d.Flush(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	got := readRows(t, res)
+	for i, row := range got {
+		want := int64(i%10*2 + i/10)
+		if row[1] != want {
+			t.Fatalf("row %d is %v, want n %d in the order of the offsets: %v", i, row, want, got)
+		}
+	}
+}
+
+func TestSortMultiPassMerge(t *testing.T) {
+	dir := t.TempDir()
+	d := &sorter{}
+	cmd, err := d.Command([]driver.Command{
+		{Name: "order_by", Value: []driver.Command{{Name: "k", Value: "desc"}}},
+		{Name: "max_rows", Value: int64(2)},
+		{Name: "max_files", Value: int64(3)},
+		{Name: "spill_dir", Value: dir},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geom := driver.Geometry{Type: "Point", Coordinates: driver.Point{1, 2}}
+	data := [][]interface{}{}
+	for i := 0; i < 25; i++ {
+		data = append(data, []interface{}{int64(i % 5), int64(i), geom})
+	}
+	res, err := d.Exec(newRows([]string{"k", "n", "geom"}, data), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) > 3 {
+		t.Errorf("%d runs are merged at once, want 3 at most", len(files))
+	}
+
+	got := readRows(t, res)
+	if len(got) != 25 {
+		t.Fatalf("got %d rows, want 25", len(got))
+	}
+	for i, row := range got {
+		//the rows of the same keys are in the order of the source
+		if want := int64((4 - i/5) + i%5*5); row[1] != want || !reflect.DeepEqual(row[2], geom) {
+			t.Fatalf("row %d is %v, want n %d and the geometry", i, row, want)
+		}
+	}
+	//the runs are removed at the end without Close
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d run files are left after the end", len(files))
+	}
+}
+
+func TestSortConvertError(t *testing.T) {
+	tests := []struct {
+		column string
+		opts   string
+		row    []interface{}
+	}{
+		{"amount", "number", []interface{}{"a", "many", "2016/01/01"}},
+		{"day", "time", []interface{}{"a", 1, "yesterday"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.opts, func(t *testing.T) {
+			d := &sorter{}
+			cmd, err := d.Command([]driver.Command{{Name: "order_by", Value: []driver.Command{{Name: tt.column, Value: tt.opts}}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.Exec(newRows(sortColumns, append(sortData(), tt.row)), cmd); err == nil {
+				t.Errorf("%v sorted without error", tt.row)
+			}
+		})
+	}
+}
+
+func TestSortBadCommands(t *testing.T) {
+	for _, args := range [][]driver.Command{
+		{{Name: "order_by", Value: "name"}},
+		{{Name: "order_by", Value: []driver.Command{{Name: "name", Value: "sideways"}}}},
+		{{Name: "order_by", Value: []driver.Command{{Name: "name", Value: "asc nulls"}}}},
+		{{Name: "order_by", Value: []driver.Command{{Name: "name"}}}, {Name: "max_files", Value: int64(1)}},
+	} {
+		if _, err := (&sorter{}).Command(args); err == nil {
+			t.Errorf("Command(%v) should fail", args)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package transform
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/xingwangc/etlx/driver"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const spillPartitions = 16
+
+func init() {
+	//column values are encoded as interface{} when spilled
+	gob.Register(time.Time{})
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register(driver.Decimal{})
+	gob.Register(driver.Geometry{})
+	gob.Register(driver.GeometryCollection{})
+	gob.Register(driver.Point{})
+	gob.Register(driver.LineString{})
+	gob.Register(driver.MultiPoint{})
+	gob.Register(driver.Polygon{})
+	gob.Register(driver.MultiLineString{})
+	gob.Register(driver.MultiPolygon{})
+	gob.Register([]string{})
+	gob.Register(map[string]string{})
+	gob.Register(bson.ObjectId(""))
+}
+
+//spillTable is a hash table from a key to a mergeable entry. When the number
+//of entries in memory exceeds the limit, all of them are written into temporary
+//partition files (by hash of the key) and the table continues empty. Iterating
+//the table reads back one partition at a time and merges the entries with the
+//same key, so only a partition must fit in memory.
+type spillTable struct {
+	//max entries kept in memory, 0 means no limit
+	limit int
+	dir   string
+
+	//newEntry returns a pointer to a zero entry which could be gob decoded
+	newEntry func() interface{}
+	//merge merges src into dst, both of them are returned by newEntry
+	merge func(dst, src interface{})
+
+	keys    []string
+	entries map[string]interface{}
+	spills  []*spillFile
+}
+
+type spillFile struct {
+	file *os.File
+	buf  *bufio.Writer
+	enc  *gob.Encoder
+}
+
+func newSpillTable(limit int, dir string, newEntry func() interface{}, merge func(dst, src interface{})) *spillTable {
+	return &spillTable{
+		limit:    limit,
+		dir:      dir,
+		newEntry: newEntry,
+		merge:    merge,
+		entries:  make(map[string]interface{}),
+	}
+}
+
+//get returns the entry of the key, a new one is created if not found.
+func (s *spillTable) get(key string) (interface{}, error) {
+	if entry, ok := s.entries[key]; ok {
+		return entry, nil
+	}
+
+	if s.limit > 0 && len(s.entries) >= s.limit {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := s.newEntry()
+	s.entries[key] = entry
+	s.keys = append(s.keys, key)
+	return entry, nil
+}
+
+func partitionOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % spillPartitions)
+}
+
+//spill writes all entries in memory to the partition files.
+func (s *spillTable) spill() error {
+	if s.spills == nil {
+		s.spills = make([]*spillFile, spillPartitions)
+		for i := range s.spills {
+			file, err := ioutil.TempFile(s.dir, "etlx-spill-")
+			if err != nil {
+				s.close()
+				return err
+			}
+			buf := bufio.NewWriter(file)
+			s.spills[i] = &spillFile{file: file, buf: buf, enc: gob.NewEncoder(buf)}
+		}
+	}
+
+	for _, key := range s.keys {
+		sf := s.spills[partitionOf(key)]
+		if err := sf.enc.Encode(key); err != nil {
+			return err
+		}
+		if err := sf.enc.Encode(s.entries[key]); err != nil {
+			return err
+		}
+	}
+
+	s.keys = nil
+	s.entries = make(map[string]interface{})
+	return nil
+}
+
+//each calls fn for every key with the merged entry, then the table is cleared.
+func (s *spillTable) each(fn func(key string, entry interface{}) error) error {
+	defer s.close()
+
+	if s.spills == nil {
+		for _, key := range s.keys {
+			if err := fn(key, s.entries[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := s.spill(); err != nil {
+		return err
+	}
+	for _, sf := range s.spills {
+		if err := sf.buf.Flush(); err != nil {
+			return err
+		}
+		if _, err := sf.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		keys := []string{}
+		entries := make(map[string]interface{})
+		dec := gob.NewDecoder(bufio.NewReader(sf.file))
+		for {
+			var key string
+			if err := dec.Decode(&key); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			entry := s.newEntry()
+			if err := dec.Decode(entry); err != nil {
+				return err
+			}
+
+			if exist, ok := entries[key]; ok {
+				s.merge(exist, entry)
+			} else {
+				entries[key] = entry
+				keys = append(keys, key)
+			}
+		}
+
+		for _, key := range keys {
+			if err := fn(key, entries[key]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//close removes the partition files and clears the table.
+func (s *spillTable) close() {
+	for _, sf := range s.spills {
+		if sf != nil {
+			sf.file.Close()
+			os.Remove(sf.file.Name())
+		}
+	}
+	s.spills = nil
+	s.keys = nil
+	s.entries = make(map[string]interface{})
+}
+
+//spillSet is a set of keys of the same size, e.g. hashes. When the number of
+//keys in memory exceeds the limit, they are merged into a temporary file of
+//all the keys spilled in order, which is binary searched by add, so only the
+//keys added since the last spill must fit in memory.
+type spillSet struct {
+	//max keys kept in memory, 0 means no limit
+	limit int
+	size  int
+	dir   string
+
+	keys map[string]struct{}
+	//file of the sorted keys spilled and the number of them
+	file  *os.File
+	count int64
+}
+
+func newSpillSet(limit, size int, dir string) *spillSet {
+	return &spillSet{limit: limit, size: size, dir: dir, keys: make(map[string]struct{})}
+}
+
+//add adds the key of the size of the set, it returns false if the key is
+//already in the set.
+func (s *spillSet) add(key string) (bool, error) {
+	found, err := s.contains(key)
+	if err != nil || found {
+		return false, err
+	}
+
+	if s.limit > 0 && len(s.keys) >= s.limit {
+		if err := s.spill(); err != nil {
+			return false, err
+		}
+	}
+	s.keys[key] = struct{}{}
+	return true, nil
+}
+
+//contains returns true if the key is in the set.
+func (s *spillSet) contains(key string) (bool, error) {
+	if len(key) != s.size {
+		return false, fmt.Errorf("the key should be of %d bytes", s.size)
+	}
+	if _, ok := s.keys[key]; ok {
+		return true, nil
+	}
+	return s.spilled(key)
+}
+
+//spilled searches the key in the file.
+func (s *spillSet) spilled(key string) (bool, error) {
+	if s.file == nil {
+		return false, nil
+	}
+
+	buf := make([]byte, s.size)
+	var err error
+	i := sort.Search(int(s.count), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		if _, err = s.file.ReadAt(buf, int64(i)*int64(s.size)); err != nil {
+			return true
+		}
+		return string(buf) >= key
+	})
+	if err != nil || int64(i) == s.count {
+		return false, err
+	}
+	if _, err := s.file.ReadAt(buf, int64(i)*int64(s.size)); err != nil {
+		return false, err
+	}
+	return string(buf) == key, nil
+}
+
+//spill merges the keys in memory and the keys in the file into a new file.
+func (s *spillSet) spill() error {
+	keys := make([]string, 0, len(s.keys))
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	file, err := ioutil.TempFile(s.dir, "etlx-spill-")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	count := int64(0)
+	write := func(key string) {
+		w.WriteString(key)
+		count++
+	}
+
+	if s.file != nil {
+		r := bufio.NewReader(io.NewSectionReader(s.file, 0, s.count*int64(s.size)))
+		buf := make([]byte, s.size)
+		for i := int64(0); i < s.count; i++ {
+			if _, err := io.ReadFull(r, buf); err != nil {
+				file.Close()
+				os.Remove(file.Name())
+				return err
+			}
+			for len(keys) > 0 && keys[0] < string(buf) {
+				write(keys[0])
+				keys = keys[1:]
+			}
+			write(string(buf))
+		}
+	}
+	for _, key := range keys {
+		write(key)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	s.close()
+	s.file, s.count = file, count
+	return nil
+}
+
+//close removes the file and clears the set.
+func (s *spillSet) close() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+	s.file, s.count = nil, 0
+	s.keys = make(map[string]struct{})
+}
@@ -0,0 +1,555 @@
+package transform
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/xingwangc/etlx"
+	"github.com/xingwangc/etlx/driver"
+	"github.com/xingwangc/etlx/expr"
+)
+
+func init() {
+	etlx.TransformRegister("validate", &validateDriver{}, driver.DriverInfo{
+		Description:  "check rows against rules and drop the failed ones",
+		Capabilities: []driver.Capability{driver.CapBatch, driver.CapSchema, driver.CapStreaming},
+	})
+}
+
+//columns appended to the rejected rows sent to the dead letter loader
+const (
+	RejectRuleColumn  = "_rule"
+	RejectErrorColumn = "_error"
+)
+
+type validateDriver struct{}
+
+func (d *validateDriver) CommandSchema() driver.CommandSchema {
+	return driver.CommandSchema{
+		Doc: "validate checks every row with the rules and drops the rows failed.",
+		Commands: []driver.CommandSpec{
+			{Name: "rules", Type: "complex", Items: []driver.CommandSpec{
+				{Name: "*", Type: "json", Doc: "rule of check not_null, type, range, regex, in, unique or expr"},
+			}},
+			{Name: "dead_letter", Type: "json", Doc: "loader of the rejected rows: driver, name, data_source and commands"},
+			{Name: "max_error_rate", Type: "float", Default: 1, Doc: "rate of rejected rows failing the transform"},
+			{Name: "min_rows", Type: "int", Default: defaultMinRows, Doc: "rows checked before the error rate is"},
+			{Name: "max_keys", Type: "int", Default: defaultMaxUniqueKeys, Doc: "keys of a unique rule kept in memory before spilling"},
+			{Name: "spill_dir", Type: "string", Default: "the temporary directory", Doc: "directory of the spilled keys"},
+		},
+	}
+}
+
+func (d *validateDriver) Open(name, dataSource string) (driver.Transform, error) {
+	return &validate{name: name, unique: make(map[string]*spillSet)}, nil
+}
+
+//defaultMaxUniqueKeys is the number of the keys of a unique rule kept in memory
+//if max_keys is not provided.
+const defaultMaxUniqueKeys = 1000000
+
+//defaultMinRows is the number of the rows checked before the error rate is if
+//min_rows is not provided.
+const defaultMinRows = 100
+
+//validate checks every row with the rules and drops the rows failed. Commands:
+//	{"name": "rules", "type": "complex", "value": [
+//		{"name": "id_required", "type": "json", "value": {"check": "not_null", "column": "id"}},
+//		{"name": "age_int", "type": "json", "value": {"check": "type", "column": "age", "type": "int", "convert": true}},
+//		{"name": "age_range", "type": "json", "value": {"check": "range", "column": "age", "min": 0, "max": 150}},
+//		{"name": "phone", "type": "json", "value": {"check": "regex", "column": "phone", "pattern": "^[0-9]{11}$"}},
+//		{"name": "status", "type": "json", "value": {"check": "in", "column": "status", "values": ["active", "closed"]}},
+//		{"name": "id_unique", "type": "json", "value": {"check": "unique", "columns": ["id"]}},
+//		{"name": "period", "type": "json", "value": {"check": "expr", "expr": "end >= start"}}
+//	]}
+//	{"name": "dead_letter", "type": "json", "value": {"driver": "csv", "name": "rejects", "data_source": "rejects.csv", "commands": []}}
+//	{"name": "max_error_rate", "type": "float", "value": 0.05}
+//	{"name": "min_rows", "type": "int", "value": 100}
+//	{"name": "max_keys", "type": "int", "value": 1000000}
+//	{"name": "spill_dir", "type": "string", "value": "/tmp"}
+//The type check uses the same type string as driver.StrToType with an optional
+//layout for time, and replaces the value with the converted one if convert is
+//true. null values only fail the not_null check.
+//
+//A rejected row is sent to the dead letter loader with two more columns: the
+//name of the first failed rule and the error message. The dead letter is
+//opened by the transaction, from its registry with its expander and secret
+//provider, or by etlx.NewLoad without a transaction. Exec fails as soon as the
+//rate of rejected rows exceeds max_error_rate, which aborts the transaction.
+//The rate is checked by every row once min_rows rows are checked, so a few bad
+//rows at the start do not abort it. The rows rejected before the abort are
+//still sent to the dead letter. Rates and unique keys are counted across all
+//batches in batch mode.
+//
+//The key of a row is added to its unique rules only if the row passes all the
+//rules, so a rejected row does not reject the rows with the same key after it.
+//The hashes of the keys are kept, and spilled to files under spill_dir once a
+//rule has more than max_keys of them.
+type validate struct {
+	name string
+
+	mu       sync.Mutex
+	total    int64
+	rejected int64
+	unique   map[string]*spillSet
+	dead     *etlx.LoadHandler
+	openLoad etlx.LoadOpener
+}
+
+type validateCommand struct {
+	rules        []*rule
+	deadLetter   *deadLetterConfig
+	maxErrorRate float64
+	minRows      int64
+	maxKeys      int
+	spillDir     string
+}
+
+type deadLetterConfig struct {
+	Driver     string           `json:"driver"`
+	Name       string           `json:"name"`
+	DataSource string           `json:"data_source"`
+	Commands   []driver.Command `json:"commands"`
+}
+
+type rule struct {
+	name    string
+	check   string
+	column  string
+	columns []string
+	typ     string
+	layout  string
+	convert bool
+	min     interface{}
+	max     interface{}
+	pattern *regexp.Regexp
+	values  []interface{}
+	prog    *expr.Program
+}
+
+func parseRule(arg driver.Command) (*rule, error) {
+	m, err := driver.MapFromInterface(arg.Value)
+	if err != nil {
+		return nil, fmt.Errorf("validate: rule %s should be a json", arg.Name)
+	}
+
+	r := &rule{name: arg.Name}
+	str := func(key string) string {
+		s, _ := driver.StringFromInterface(m[key])
+		return s
+	}
+	r.check = str("check")
+	r.column = str("column")
+	r.typ = str("type")
+	r.layout = str("layout")
+	r.convert, _ = driver.BoolFromInterface(m["convert"])
+	r.min, r.max = m["min"], m["max"]
+
+	needColumn := true
+	switch r.check {
+	case "not_null":
+	case "type":
+		if r.typ == "" {
+			return nil, fmt.Errorf("validate: rule %s should provide the type", r.name)
+		}
+	case "range":
+		if r.min == nil && r.max == nil {
+			return nil, fmt.Errorf("validate: rule %s should provide min or max", r.name)
+		}
+	case "regex":
+		r.pattern, err = regexp.Compile(str("pattern"))
+		if err != nil {
+			return nil, fmt.Errorf("validate: rule %s: %v", r.name, err)
+		}
+	case "in":
+		r.values, err = driver.ArrayFromInterface(m["values"])
+		if err != nil {
+			return nil, fmt.Errorf("validate: rule %s should provide the values", r.name)
+		}
+	case "unique":
+		needColumn = false
+		r.columns, err = stringList(driver.Command{Name: r.name, Value: m["columns"]})
+		if err != nil && r.column == "" {
+			return nil, fmt.Errorf("validate: rule %s should provide the columns", r.name)
+		}
+		if len(r.columns) == 0 {
+			r.columns = []string{r.column}
+		}
+	case "expr":
+		needColumn = false
+		r.prog, err = expr.Compile(str("expr"))
+		if err != nil {
+			return nil, fmt.Errorf("validate: rule %s: %v", r.name, err)
+		}
+	default:
+		return nil, fmt.Errorf("validate: unsupported check %q of rule %s", r.check, r.name)
+	}
+
+	if needColumn && r.column == "" {
+		return nil, fmt.Errorf("validate: rule %s should provide the column", r.name)
+	}
+	return r, nil
+}
+
+func (v *validate) Command(args []driver.Command) (interface{}, error) {
+	cmd := &validateCommand{maxErrorRate: 1, minRows: defaultMinRows, maxKeys: defaultMaxUniqueKeys, spillDir: os.TempDir()}
+	for _, arg := range args {
+		switch arg.Name {
+		case "rules":
+			items, ok := arg.Value.([]driver.Command)
+			if !ok {
+				return nil, fmt.Errorf("validate: command rules should be complex")
+			}
+			for _, item := range items {
+				r, err := parseRule(item)
+				if err != nil {
+					return nil, err
+				}
+				cmd.rules = append(cmd.rules, r)
+			}
+		case "dead_letter":
+			//decode again to get the commands in the json as driver.Command
+			b, err := json.Marshal(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			cfg := &deadLetterConfig{}
+			if err := json.Unmarshal(b, cfg); err != nil {
+				return nil, fmt.Errorf("validate: invalid dead_letter: %v", err)
+			}
+			cmd.deadLetter = cfg
+		case "max_error_rate":
+			rate, err := driver.FloatFromInterface(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			cmd.maxErrorRate = rate
+		case "min_rows":
+			min, err := driver.IntFromInterface(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			cmd.minRows = min
+		case "max_keys":
+			limit, err := driver.IntFromInterface(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			cmd.maxKeys = int(limit)
+		case "spill_dir":
+			dir, err := commandString(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.spillDir = dir
+		default:
+			return nil, fmt.Errorf("validate: unsupported command %s", arg.Name)
+		}
+	}
+	return cmd, nil
+}
+
+func (v *validate) Exec(src driver.Rows, cmd interface{}) (driver.Results, error) {
+	vcmd, ok := cmd.(*validateCommand)
+	if !ok {
+		return nil, fmt.Errorf("validate: invalid command %v", cmd)
+	}
+
+	columns := src.Columns()
+	rslt := newResults(columns)
+	rejects := newResults(append(append([]string{}, columns...), RejectRuleColumn, RejectErrorColumn))
+
+	var rateErr error
+	err := eachRow(src, func(row []interface{}) error {
+		rowMap, err := driver.ArrayToMap(columns, row)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range vcmd.rules {
+			if err := v.check(vcmd, r, rowMap); err != nil {
+				rejects.AppendData(append(row, r.name, err.Error()))
+				rateErr = v.count(vcmd, true)
+				return rateErr
+			}
+		}
+		if r, err := v.addKeys(vcmd, rowMap); err != nil {
+			return err
+		} else if r != nil {
+			//another row of the key passed since it was checked
+			rejects.AppendData(append(row, r.name, duplicated(r, rowMap).Error()))
+			rateErr = v.count(vcmd, true)
+			return rateErr
+		}
+		if rateErr = v.count(vcmd, false); rateErr != nil {
+			return rateErr
+		}
+
+		data, err := driver.MapToArray(columns, rowMap)
+		if err != nil {
+			return err
+		}
+		rslt.AppendData(data)
+		return nil
+	})
+	if err != nil && err != rateErr {
+		return nil, err
+	}
+
+	if err := v.reject(vcmd, rejects); err != nil {
+		return nil, err
+	}
+	if rateErr != nil {
+		return nil, rateErr
+	}
+	return rslt, nil
+}
+
+//count counts the row checked and returns the error once the rate of the
+//rejected rows exceeds max_error_rate, after min_rows rows are checked.
+func (v *validate) count(cmd *validateCommand, rejected bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.total++
+	if rejected {
+		v.rejected++
+	}
+	if v.total < cmd.minRows {
+		return nil
+	}
+	rate := float64(v.rejected) / float64(v.total)
+	if rate > cmd.maxErrorRate {
+		return fmt.Errorf("validate: error rate %.4f(%d/%d) exceeds %.4f", rate, v.rejected, v.total, cmd.maxErrorRate)
+	}
+	return nil
+}
+
+//reject loads the rejected rows to the dead letter.
+func (v *validate) reject(cmd *validateCommand, rejects *driver.Table) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(rejects.GetData()) > 0 && cmd.deadLetter != nil {
+		if v.dead == nil {
+			open := v.openLoad
+			if open == nil {
+				open = etlx.NewLoad
+			}
+			cfg := cmd.deadLetter
+			dead, err := open(cfg.Driver, cfg.Name, cfg.DataSource, cfg.Commands)
+			if err != nil {
+				return fmt.Errorf("validate: open dead letter: %v", err)
+			}
+			v.dead = dead
+		}
+		if err := v.dead.Run(rejects); err != nil {
+			return fmt.Errorf("validate: load dead letter: %v", err)
+		}
+	}
+	return nil
+}
+
+//SetLoadOpener sets the opener of the dead letter, see etlx.LoadOpenerSetter.
+func (v *validate) SetLoadOpener(open etlx.LoadOpener) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.openLoad = open
+}
+
+//uniqueKey returns the values of the columns of the unique rule and their hash.
+func uniqueKey(r *rule, row map[string]interface{}) ([]interface{}, string) {
+	key := make([]interface{}, len(r.columns))
+	for i, name := range r.columns {
+		key[i] = row[name]
+	}
+	sum := sha1.Sum([]byte(keyString(key)))
+	return key, string(sum[:])
+}
+
+func duplicated(r *rule, row map[string]interface{}) error {
+	key, _ := uniqueKey(r, row)
+	return fmt.Errorf("%v of %v is duplicated", key, r.columns)
+}
+
+//keys returns the keys of the unique rule, v.mu should be locked.
+func (v *validate) keys(cmd *validateCommand, r *rule) *spillSet {
+	keys, ok := v.unique[r.name]
+	if !ok {
+		keys = newSpillSet(cmd.maxKeys, sha1.Size, cmd.spillDir)
+		v.unique[r.name] = keys
+	}
+	return keys
+}
+
+//addKeys adds the keys of the row passed to its unique rules. It returns the
+//rule whose key is added already, none of the keys is added then.
+func (v *validate) addKeys(cmd *validateCommand, row map[string]interface{}) (*rule, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	hashes := []string{}
+	for _, r := range cmd.rules {
+		if r.check != "unique" {
+			continue
+		}
+		_, hash := uniqueKey(r, row)
+		if found, err := v.keys(cmd, r).contains(hash); err != nil || found {
+			return r, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	i := 0
+	for _, r := range cmd.rules {
+		if r.check != "unique" {
+			continue
+		}
+		if _, err := v.keys(cmd, r).add(hashes[i]); err != nil {
+			return nil, err
+		}
+		i++
+	}
+	return nil, nil
+}
+
+//check returns the error if the row fails the rule. The value in row may be
+//replaced with the converted one. The key of a unique rule is only checked,
+//it is added by addKeys once the row passes all the rules.
+func (v *validate) check(cmd *validateCommand, r *rule, row map[string]interface{}) error {
+	val := row[r.column]
+	switch r.check {
+	case "not_null":
+		if val == nil {
+			return fmt.Errorf("%s is null", r.column)
+		}
+	case "type":
+		if val == nil {
+			return nil
+		}
+		var converted interface{}
+		var err error
+		if r.layout != "" {
+			converted, err = driver.StrToType(r.typ, val, r.layout)
+		} else {
+			converted, err = driver.StrToType(r.typ, val)
+		}
+		if err != nil {
+			return fmt.Errorf("%s(%v) is not %s", r.column, val, r.typ)
+		}
+		if r.convert {
+			row[r.column] = converted
+		}
+	case "range":
+		if val == nil {
+			return nil
+		}
+		if r.min != nil && compareValues(val, r.min) < 0 {
+			return fmt.Errorf("%s(%v) is less than %v", r.column, val, r.min)
+		}
+		if r.max != nil && compareValues(val, r.max) > 0 {
+			return fmt.Errorf("%s(%v) is greater than %v", r.column, val, r.max)
+		}
+	case "regex":
+		if val == nil {
+			return nil
+		}
+		str, err := driver.StringFromInterface(val)
+		if err != nil || !r.pattern.MatchString(str) {
+			return fmt.Errorf("%s(%v) does not match %s", r.column, val, r.pattern)
+		}
+	case "in":
+		if val == nil {
+			return nil
+		}
+		for _, item := range r.values {
+			if compareValues(val, item) == 0 {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s(%v) is not in %v", r.column, val, r.values)
+	case "unique":
+		_, hash := uniqueKey(r, row)
+		v.mu.Lock()
+		found, err := v.keys(cmd, r).contains(hash)
+		v.mu.Unlock()
+		if err != nil {
+			return err
+		} else if found {
+			return duplicated(r, row)
+		}
+	case "expr":
+		ok, err := r.prog.EvalBool(row)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is false", r.prog)
+		}
+	}
+	return nil
+}
+
+//OutputSchema marks the columns checked by not_null as not nullable and
+//changes the type of the columns converted by type checks.
+func (v *validate) OutputSchema(in driver.Schema, cmd interface{}) (driver.Schema, error) {
+	vcmd, ok := cmd.(*validateCommand)
+	if !ok {
+		return driver.Schema{}, fmt.Errorf("validate: invalid command %v", cmd)
+	}
+
+	out := driver.Schema{Fields: append([]driver.Field{}, in.Fields...)}
+	index := make(map[string]int, len(out.Fields))
+	for i, f := range out.Fields {
+		index[f.Name] = i
+	}
+
+	for _, r := range vcmd.rules {
+		names := r.columns
+		switch r.check {
+		case "expr":
+			names = r.prog.Columns()
+		case "unique":
+		default:
+			names = []string{r.column}
+		}
+		if _, err := schemaFields(in, names); err != nil {
+			return driver.Schema{}, fmt.Errorf("validate: rule %s: %v", r.name, err)
+		}
+
+		switch r.check {
+		case "not_null":
+			out.Fields[index[r.column]].Nullable = false
+		case "type":
+			if r.convert {
+				typ, err := driver.ParseLogicalType(r.typ)
+				if err != nil {
+					return driver.Schema{}, fmt.Errorf("validate: rule %s: %v", r.name, err)
+				}
+				out.Fields[index[r.column]].Type = typ
+				out.Fields[index[r.column]].Layout = ""
+			}
+		}
+	}
+	return out, nil
+}
+
+func (v *validate) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, keys := range v.unique {
+		keys.close()
+	}
+	v.unique = make(map[string]*spillSet)
+	if v.dead != nil {
+		err := v.dead.Handler.Close()
+		v.dead = nil
+		return err
+	}
+	return nil
+}
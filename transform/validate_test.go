@@ -0,0 +1,103 @@
+package transform
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xingwangc/etlx/driver"
+)
+
+func ruleCommand(name string, value map[string]interface{}) driver.Command {
+	return driver.Command{Name: name, Value: value}
+}
+
+func TestValidate(t *testing.T) {
+	src := newRows([]string{"id", "age", "start", "end"}, [][]interface{}{
+		{1, "x", 1, 2},
+		{nil, "20", 1, 2},
+		{1, "20", 1, 2},
+		{2, "200", 1, 2},
+		{1, "3", 1, 2},
+		{3, "4", 3, 2},
+		{3, "5", 1, 2},
+	})
+	v := openValidate(t)
+	cmd, err := v.Command([]driver.Command{{Name: "rules", Value: []driver.Command{
+		ruleCommand("id_required", map[string]interface{}{"check": "not_null", "column": "id"}),
+		ruleCommand("id_unique", map[string]interface{}{"check": "unique", "columns": []interface{}{"id"}}),
+		ruleCommand("age_int", map[string]interface{}{"check": "type", "column": "age", "type": "int", "convert": true}),
+		ruleCommand("age_range", map[string]interface{}{"check": "range", "column": "age", "min": 0.0, "max": 150.0}),
+		ruleCommand("period", map[string]interface{}{"check": "expr", "expr": "end >= start"}),
+	}}, {Name: "max_keys", Value: int64(1)}, {Name: "spill_dir", Value: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rslt, err := v.Exec(src, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the keys of the rows rejected by the other rules are not added
+	want := [][]interface{}{{1, int64(20), 1, 2}, {3, int64(5), 1, 2}}
+	if got := readRows(t, rslt); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateErrorRate(t *testing.T) {
+	v := openValidate(t)
+	cmd, err := v.Command([]driver.Command{{Name: "rules", Value: []driver.Command{
+		ruleCommand("id_required", map[string]interface{}{"check": "not_null", "column": "id"}),
+	}}, {Name: "max_error_rate", Value: 0.4}, {Name: "min_rows", Value: int64(5)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the rate of the first rows is not checked before min_rows
+	if _, err := v.Exec(newRows([]string{"id"}, [][]interface{}{{nil}, {1}, {2}}), cmd); err != nil {
+		t.Fatal(err)
+	}
+	//the rate is counted across the batches
+	if _, err := v.Exec(newRows([]string{"id"}, [][]interface{}{{3}, {4}, {5}}), cmd); err != nil {
+		t.Fatal(err)
+	}
+	//the rate is checked by every row, the batch is aborted at its third row
+	bad := [][]interface{}{}
+	for i := 0; i < 10; i++ {
+		bad = append(bad, []interface{}{nil})
+	}
+	_, err = v.Exec(newRows([]string{"id"}, bad), cmd)
+	if err == nil || !strings.Contains(err.Error(), "error rate 0.4444(4/9)") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestValidateMinRows(t *testing.T) {
+	v := openValidate(t)
+	cmd, err := v.Command([]driver.Command{{Name: "rules", Value: []driver.Command{
+		ruleCommand("id_required", map[string]interface{}{"check": "not_null", "column": "id"}),
+	}}, {Name: "max_error_rate", Value: 0.1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//1 of 2 rows is not a rate before the default min_rows
+	rslt, err := v.Exec(newRows([]string{"id"}, [][]interface{}{{nil}, {1}}), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readRows(t, rslt); len(got) != 1 {
+		t.Errorf("got %v, want the row of id 1", got)
+	}
+}
+
+func openValidate(t *testing.T) *validate {
+	h, err := (&validateDriver{}).Open("validate", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.(*validate)
+}